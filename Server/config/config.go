@@ -36,6 +36,40 @@ type Config struct {
 	UsosAccessTokenURL  string
 
 	GeminiAPIKey string `mapstructure:"GEMINI_API_KEY"`
+
+	// Dostawca AI: gemini | openai (Ollama, llama.cpp...) | fake (testy/offline)
+	LLMProvider string `mapstructure:"LLM_PROVIDER"`
+	LLMModel    string `mapstructure:"LLM_MODEL"`    // pusty = domyślny model dostawcy
+	LLMBaseURL  string `mapstructure:"LLM_BASE_URL"` // np. http://localhost:11434/v1
+	LLMAPIKey   string `mapstructure:"LLM_API_KEY"`  // dla gemini domyślnie GEMINI_API_KEY
+
+	// Kolejka generowania AI
+	GenerationWorkers          int `mapstructure:"GENERATION_WORKERS"`
+	GenerationMaxActivePerUser int `mapstructure:"GENERATION_MAX_ACTIVE_PER_USER"`
+
+	// Limity zużycia AI per rola, np. "student:daily_requests=30,daily_tokens=300000;admin:unlimited"
+	AIQuotas string `mapstructure:"AI_QUOTAS"`
+
+	// Model embeddingów do wyszukiwania: pusty = domyślny model dostawcy AI, "local" = lokalny model haszujący
+	EmbeddingModel string `mapstructure:"EMBEDDING_MODEL"`
+
+	// Dzienny limit tokenów czatu z tutorem AI na użytkownika (0 = tylko limity AI_QUOTAS)
+	TutorDailyTokens int64 `mapstructure:"TUTOR_DAILY_TOKENS"`
+
+	// Wstępna ocena treści przez AI przed moderacją
+	ScreeningEnabled bool `mapstructure:"SCREENING_ENABLED"`
+
+	// Magazyn przesłanych materiałów źródłowych
+	StorageDir          string `mapstructure:"STORAGE_DIR"`
+	SourceMaxFileSizeMB int64  `mapstructure:"SOURCE_MAX_FILE_SIZE_MB"`
+
+	// Załączniki multimedialne fiszek i pytań (w tym samym katalogu STORAGE_DIR)
+	MediaMaxFileSizeMB int64  `mapstructure:"MEDIA_MAX_FILE_SIZE_MB"`
+	MediaURLSecret     string `mapstructure:"MEDIA_URL_SECRET"`      // pusty = SESSION_SECRET
+	MediaURLTTLMinutes int    `mapstructure:"MEDIA_URL_TTL_MINUTES"` // czas ważności podpisanych adresów
+
+	// Import talii Anki (.apkg)
+	AnkiMaxFileSizeMB int64 `mapstructure:"ANKI_MAX_FILE_SIZE_MB"`
 }
 
 // LoadConfig wczytuje konfigurację z pliku .env w danym folderze
@@ -50,6 +84,24 @@ func LoadConfig(path string) (config Config, err error) {
 	viper.SetConfigType("env")
 	viper.AutomaticEnv()
 
+	// Wartości domyślne (pozwalają też nadpisać klucze zmiennymi środowiskowymi)
+	viper.SetDefault("LLM_PROVIDER", "gemini")
+	viper.SetDefault("LLM_MODEL", "")
+	viper.SetDefault("LLM_BASE_URL", "")
+	viper.SetDefault("LLM_API_KEY", "")
+	viper.SetDefault("GENERATION_WORKERS", 2)
+	viper.SetDefault("GENERATION_MAX_ACTIVE_PER_USER", 3)
+	viper.SetDefault("AI_QUOTAS", "student:daily_requests=30,daily_tokens=300000,monthly_tokens=3000000;admin:unlimited")
+	viper.SetDefault("EMBEDDING_MODEL", "")
+	viper.SetDefault("TUTOR_DAILY_TOKENS", 50000)
+	viper.SetDefault("SCREENING_ENABLED", false)
+	viper.SetDefault("STORAGE_DIR", "./storage")
+	viper.SetDefault("SOURCE_MAX_FILE_SIZE_MB", 20)
+	viper.SetDefault("MEDIA_MAX_FILE_SIZE_MB", 5)
+	viper.SetDefault("MEDIA_URL_SECRET", "")
+	viper.SetDefault("MEDIA_URL_TTL_MINUTES", 60)
+	viper.SetDefault("ANKI_MAX_FILE_SIZE_MB", 100)
+
 	err = viper.ReadInConfig()
 	if err != nil {
 		// Jeśli pliku nie ma, ten błąd pojawi się jako pierwszy
@@ -1,10 +1,14 @@
 package db
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/skni-kod/InfQuizyTor/Server/config"
 	"github.com/skni-kod/InfQuizyTor/Server/models"
 	"gorm.io/driver/postgres"
@@ -32,6 +36,11 @@ func InitDB(cfg config.Config) {
 		&models.Topic{},
 		&models.Flashcard{},
 		&models.QuizQuestion{},
+		&models.ContentTag{},
+		&models.QuizAnswer{},
+		&models.AnswerGrading{},
+		&models.ContentRevision{},
+		&models.TopicNote{},
 		&models.CalendarLayer{},
 		&models.CalendarEvent{},
 		&models.QuizNode{},
@@ -39,15 +48,105 @@ func InitDB(cfg config.Config) {
 		&models.UserProgress{},
 		&models.Achievement{},
 		&models.UserAchievement{},
+		&models.GenerationJob{},
+		&models.SourceMaterial{},
+		&models.SourceMaterialText{},
+		&models.MediaAttachment{},
+		&models.TutorConversation{},
+		&models.TutorMessage{},
+		&models.AIUsage{},
+		&models.PromptTemplate{},
+		&models.UserSubject{},
+		&models.ContentEmbedding{},
 	)
 	if err != nil {
 		log.Fatalf("Błąd automigracji: %v", err)
 	}
+	// Dawny unikalny indeks na samym sha256 dzielił jeden rekord materiału między przesyłających
+	if err := db.Exec("DROP INDEX IF EXISTS idx_source_materials_sha256").Error; err != nil {
+		log.Fatalf("Błąd automigracji: %v", err)
+	}
+	// Treści usuniętych wcześniej tematów miały status 'deleted' zamiast DeletedAt
+	for _, table := range []string{"flashcards", "quiz_questions", "topic_notes"} {
+		if err := db.Exec(fmt.Sprintf(`UPDATE %s x SET deleted_at = t.deleted_at FROM topics t
+			WHERE t.id = x.topic_id AND x.status = 'deleted' AND x.deleted_at IS NULL`, table)).Error; err != nil {
+			log.Fatalf("Błąd automigracji: %v", err)
+		}
+	}
+
+	if err := setupFullTextSearch(db); err != nil {
+		log.Fatalf("Błąd konfiguracji wyszukiwania pełnotekstowego: %v", err)
+	}
 
 	UserRepository = NewGormUserRepository(db)
 	log.Println("Repozytorium użytkowników pomyślnie zainicjowane.")
 }
 
+// setupFullTextSearch tworzy konfiguracje wyszukiwania i utrzymywane przez bazę kolumny search_vector.
+//
+// polish_search lematyzuje słowa słownikiem polish_ispell (pliki polish.dict, polish.affix i polish.stop
+// z hunspell-pl w katalogu tsearch_data serwera). Bez słownika działa jak "simple".
+// polish_unaccent usuwa polskie znaki, aby "rownanie" znajdowało "równanie".
+// Wektor i zapytanie łączą obie konfiguracje.
+func setupFullTextSearch(db *gorm.DB) error {
+	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS unaccent").Error; err != nil {
+		return err
+	}
+	err := db.Exec(`DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_ts_dict WHERE dictname = 'polish_ispell') THEN
+		BEGIN
+			CREATE TEXT SEARCH DICTIONARY polish_ispell (TEMPLATE = ispell, DictFile = polish, AffFile = polish, StopWords = polish);
+		EXCEPTION WHEN OTHERS THEN
+			RAISE NOTICE 'Brak słownika polish (hunspell-pl): %', SQLERRM;
+		END;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'polish_search') THEN
+		CREATE TEXT SEARCH CONFIGURATION polish_search (COPY = simple);
+		IF EXISTS (SELECT 1 FROM pg_ts_dict WHERE dictname = 'polish_ispell') THEN
+			ALTER TEXT SEARCH CONFIGURATION polish_search
+				ALTER MAPPING FOR asciiword, asciihword, hword_asciipart, word, hword, hword_part WITH polish_ispell, simple;
+		END IF;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'polish_unaccent') THEN
+		CREATE TEXT SEARCH CONFIGURATION polish_unaccent (COPY = simple);
+		ALTER TEXT SEARCH CONFIGURATION polish_unaccent
+			ALTER MAPPING FOR asciiword, asciihword, hword_asciipart, word, hword, hword_part WITH unaccent, simple;
+	END IF;
+END $$`).Error
+	if err != nil {
+		return err
+	}
+
+	var stemming bool
+	db.Raw(`SELECT EXISTS (SELECT 1 FROM pg_ts_config_map m
+		JOIN pg_ts_config c ON c.oid = m.mapcfg JOIN pg_ts_dict d ON d.oid = m.mapdict
+		WHERE c.cfgname = 'polish_search' AND d.dictname = 'polish_ispell')`).Scan(&stemming)
+	if !stemming {
+		log.Println("OSTRZEŻENIE: wyszukiwanie pełnotekstowe działa bez polskiej lematyzacji (brak słownika polish_ispell).")
+	}
+
+	vectors := map[string]string{
+		"topics": `to_tsvector('polish_search', name) || to_tsvector('polish_unaccent', name)`,
+		"flashcards": `setweight(to_tsvector('polish_search', question), 'A') || setweight(to_tsvector('polish_search', answer), 'B') ||
+			to_tsvector('polish_unaccent', question || ' ' || answer)`,
+		"quiz_questions": `setweight(to_tsvector('polish_search', question_text), 'A') || to_tsvector('polish_unaccent', question_text)`,
+		"topic_notes":    `setweight(to_tsvector('polish_search', body), 'B') || to_tsvector('polish_unaccent', body)`,
+	}
+	for table, expr := range vectors {
+		stmts := []string{
+			fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (%s) STORED", table, expr),
+			fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_%s_search_vector ON %s USING GIN (search_vector)", table, table),
+		}
+		for _, stmt := range stmts {
+			if err := db.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
 func NewGormUserRepository(db *gorm.DB) *GormUserRepository {
 	return &GormUserRepository{DB: db}
 }
@@ -136,6 +235,14 @@ func (r *GormUserRepository) GetSubjects() ([]models.Subject, error) {
 	}
 	return subjects, nil
 }
+func (r *GormUserRepository) GetSubjectByID(id uint) (*models.Subject, error) {
+	var subject models.Subject
+	if err := r.DB.First(&subject, id).Error; err != nil {
+		return nil, err
+	}
+	return &subject, nil
+}
+
 func (r *GormUserRepository) GetSubjectByUsosID(usosID string) (*models.Subject, error) {
 	var subject models.Subject
 	if err := r.DB.Where("usos_id = ?", usosID).First(&subject).Error; err != nil {
@@ -145,21 +252,224 @@ func (r *GormUserRepository) GetSubjectByUsosID(usosID string) (*models.Subject,
 }
 func (r *GormUserRepository) GetTopicsBySubjectID(subjectID uint) ([]models.Topic, error) {
 	var topics []models.Topic
-	if err := r.DB.Where("subject_id = ?", subjectID).Find(&topics).Error; err != nil {
+	if err := r.DB.Where("subject_id = ?", subjectID).Order("position, id").Find(&topics).Error; err != nil {
 		return nil, err
 	}
 	return topics, nil
 }
 func (r *GormUserRepository) GetGraphByCourseUsosID(usosID string) ([]models.QuizNode, error) {
+	return r.GetQuizNodes(usosID, models.QuizNodePublished)
+}
+
+// GetQuizNodes zwraca węzły grafu kursu o podanym statusie
+func (r *GormUserRepository) GetQuizNodes(usosID, status string) ([]models.QuizNode, error) {
 	var nodes []models.QuizNode
-	if err := r.DB.Where("usos_course_id = ?", usosID).Find(&nodes).Error; err != nil {
+	if err := r.DB.Where("usos_course_id = ? AND status = ?", usosID, status).Order("id").Find(&nodes).Error; err != nil {
 		return nil, err
 	}
 	return nodes, nil
 }
 
+// GraphNodeDraft to węzeł grafu do zapisania: temat i tematy, od których zależy
+type GraphNodeDraft struct {
+	TopicID   uint
+	Title     string
+	DependsOn []uint // ID tematów
+	Rationale string
+}
+
+// ReplaceDraftQuizNodes zastępuje wersję roboczą grafu kursu nowymi węzłami.
+// Zależności między tematami są przekładane na ID utworzonych węzłów.
+func (r *GormUserRepository) ReplaceDraftQuizNodes(usosID string, drafts []GraphNodeDraft) ([]models.QuizNode, error) {
+	var nodes []models.QuizNode
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("usos_course_id = ? AND status = ?", usosID, models.QuizNodeDraft).
+			Delete(&models.QuizNode{}).Error; err != nil {
+			return err
+		}
+
+		nodes = make([]models.QuizNode, len(drafts))
+		nodeByTopic := make(map[uint]uint, len(drafts))
+		for i, d := range drafts {
+			topicID := d.TopicID
+			nodes[i] = models.QuizNode{
+				UsosCourseID: usosID,
+				Title:        d.Title,
+				TopicID:      &topicID,
+				Status:       models.QuizNodeDraft,
+				Rationale:    d.Rationale,
+			}
+			if err := tx.Create(&nodes[i]).Error; err != nil {
+				return err
+			}
+			nodeByTopic[d.TopicID] = nodes[i].ID
+		}
+
+		for i, d := range drafts {
+			deps := make(pq.Int64Array, 0, len(d.DependsOn))
+			for _, topicID := range d.DependsOn {
+				deps = append(deps, int64(nodeByTopic[topicID]))
+			}
+			nodes[i].Dependencies = deps
+			if err := tx.Model(&nodes[i]).Update("dependencies", deps).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nodes, err
+}
+
+// PublishDraftQuizNodes zastępuje opublikowany graf kursu wersją roboczą. Zwraca liczbę opublikowanych węzłów.
+func (r *GormUserRepository) PublishDraftQuizNodes(usosID string) (int64, error) {
+	var published int64
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var drafts int64
+		if err := tx.Model(&models.QuizNode{}).Where("usos_course_id = ? AND status = ?", usosID, models.QuizNodeDraft).
+			Count(&drafts).Error; err != nil || drafts == 0 {
+			return err
+		}
+		if err := tx.Where("usos_course_id = ? AND status = ?", usosID, models.QuizNodePublished).
+			Delete(&models.QuizNode{}).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&models.QuizNode{}).Where("usos_course_id = ? AND status = ?", usosID, models.QuizNodeDraft).
+			Update("status", models.QuizNodePublished)
+		published = res.RowsAffected
+		return res.Error
+	})
+	return published, err
+}
+
+func (r *GormUserRepository) GetTopicByID(id uint) (*models.Topic, error) {
+	var topic models.Topic
+	if err := r.DB.First(&topic, id).Error; err != nil {
+		return nil, err
+	}
+	return &topic, nil
+}
+
+// topicSiblings zawęża zapytanie do tematów przedmiotu o podanym rodzicu (nil = najwyższy poziom)
+func topicSiblings(q *gorm.DB, subjectID uint, parentID *uint) *gorm.DB {
+	q = q.Where("subject_id = ?", subjectID)
+	if parentID == nil {
+		return q.Where("parent_topic_id IS NULL")
+	}
+	return q.Where("parent_topic_id = ?", *parentID)
+}
+
+// TopicUpdate to zmiany tematu; nil oznacza brak zmiany. MoveParent przenosi temat pod ParentTopicID
+// (nil = na najwyższy poziom).
+type TopicUpdate struct {
+	Name          *string
+	MoveParent    bool
+	ParentTopicID *uint
+	Position      *int
+}
+
+// UpdateTopic zapisuje zmiany tematu. Przy zmianie rodzica lub pozycji wstawia temat na podaną pozycję
+// (domyślnie na koniec) i numeruje tematy o tym samym rodzicu od nowa: 0, 1, 2...
+func (r *GormUserRepository) UpdateTopic(topic *models.Topic, upd TopicUpdate) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if upd.Name != nil {
+			topic.Name = *upd.Name
+			if err := tx.Model(topic).Update("name", topic.Name).Error; err != nil {
+				return err
+			}
+		}
+		if !upd.MoveParent && upd.Position == nil {
+			return nil
+		}
+		if upd.MoveParent {
+			topic.ParentTopicID = upd.ParentTopicID
+		}
+
+		var siblings []models.Topic
+		if err := topicSiblings(tx, topic.SubjectID, topic.ParentTopicID).Where("id <> ?", topic.ID).
+			Order("position, id").Find(&siblings).Error; err != nil {
+			return err
+		}
+		at := len(siblings)
+		if upd.Position != nil && *upd.Position < at {
+			at = *upd.Position
+		}
+		ordered := make([]models.Topic, 0, len(siblings)+1)
+		ordered = append(ordered, siblings[:at]...)
+		ordered = append(ordered, *topic)
+		ordered = append(ordered, siblings[at:]...)
+
+		for i, t := range ordered {
+			if t.ID == topic.ID {
+				topic.Position = i
+				if err := tx.Model(topic).Updates(map[string]interface{}{
+					"parent_topic_id": topic.ParentTopicID,
+					"position":        i,
+				}).Error; err != nil {
+					return err
+				}
+			} else if t.Position != i {
+				if err := tx.Model(&models.Topic{}).Where("id = ?", t.ID).Update("position", i).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteTopic usuwa (miękko) temat wraz z podtematami oraz ich fiszki, pytania i podsumowania.
+// Wszystkie dostają ten sam DeletedAt, a treści zachowują swój status, więc usunięcie można cofnąć.
+// Zwraca ID usuniętych tematów.
+func (r *GormUserRepository) DeleteTopic(id uint) ([]uint, error) {
+	var ids []uint
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw(`WITH RECURSIVE subtree AS (
+				SELECT id FROM topics WHERE id = ? AND deleted_at IS NULL
+				UNION ALL
+				SELECT t.id FROM topics t JOIN subtree ON t.parent_topic_id = subtree.id WHERE t.deleted_at IS NULL
+			) SELECT id FROM subtree`, id).Scan(&ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		now := time.Now()
+		if err := tx.Model(&models.Topic{}).Where("id IN ?", ids).Update("deleted_at", now).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{&models.Flashcard{}, &models.QuizQuestion{}, &models.TopicNote{}} {
+			if err := tx.Model(model).Where("topic_id IN ?", ids).Update("deleted_at", now).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// GetTopicsByIDs zwraca tematy wraz z przedmiotami
+func (r *GormUserRepository) GetTopicsByIDs(ids []uint) ([]models.Topic, error) {
+	var topics []models.Topic
+	if len(ids) == 0 {
+		return topics, nil
+	}
+	if err := r.DB.Preload("Subject").Where("id IN ?", ids).Find(&topics).Error; err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
 // --- Metody Tworzenia ---
-func (r *GormUserRepository) CreateTopic(topic *models.Topic) error { return r.DB.Create(topic).Error }
+// CreateTopic zapisuje temat na końcu listy tematów o tym samym rodzicu
+func (r *GormUserRepository) CreateTopic(topic *models.Topic) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := topicSiblings(tx, topic.SubjectID, topic.ParentTopicID).Model(&models.Topic{}).
+			Select("COALESCE(MAX(position) + 1, 0)").Scan(&topic.Position).Error; err != nil {
+			return err
+		}
+		return tx.Create(topic).Error
+	})
+}
 func (r *GormUserRepository) CreateFlashcard(fc *models.Flashcard) error {
 	return r.DB.Create(fc).Error
 }
@@ -209,9 +519,88 @@ func (r *GormUserRepository) CreateCalendarLayer(l *models.CalendarLayer) error
 }
 
 // --- Metody Moderacji ---
-func (r *GormUserRepository) GetPendingFlashcards() ([]models.Flashcard, error) {
+
+// ContentFilter zawęża listy fiszek i pytań według metadanych (models.ContentMetadata)
+type ContentFilter struct {
+	Tags       []string // element musi mieć wszystkie podane tagi
+	Difficulty string
+	BloomLevel string
+}
+
+// Kolumny z poziomem trudności: dla pytań obliczony ze statystyk odpowiedzi ma pierwszeństwo przed ustawionym przez autora
+const (
+	flashcardDifficulty = "difficulty"
+	questionDifficulty  = "COALESCE(NULLIF(computed_difficulty, ''), difficulty)"
+)
+
+// IsEmpty mówi, czy filtr niczego nie zawęża
+func (f ContentFilter) IsEmpty() bool {
+	return len(f.Tags) == 0 && f.Difficulty == "" && f.BloomLevel == ""
+}
+
+func (f ContentFilter) apply(q *gorm.DB, difficulty string) *gorm.DB {
+	if len(f.Tags) > 0 {
+		q = q.Where("tags @> ?", pq.StringArray(f.Tags))
+	}
+	if f.Difficulty != "" {
+		q = q.Where(difficulty+" = ?", f.Difficulty)
+	}
+	if f.BloomLevel != "" {
+		q = q.Where("bloom_level = ?", f.BloomLevel)
+	}
+	return q
+}
+
+// sql zwraca warunki filtra dla zapytań z nazwanymi parametrami @tags, @difficulty i @bloom_level (tabela z aliasem x;
+// kolumny trudności nie są kwalifikowane aliasem, więc zapytanie nie może ich mieć w innych tabelach)
+func (f ContentFilter) sql(difficulty string) string {
+	var sb strings.Builder
+	if len(f.Tags) > 0 {
+		sb.WriteString(" AND x.tags @> @tags")
+	}
+	if f.Difficulty != "" {
+		sb.WriteString(" AND " + difficulty + " = @difficulty")
+	}
+	if f.BloomLevel != "" {
+		sb.WriteString(" AND x.bloom_level = @bloom_level")
+	}
+	return sb.String()
+}
+
+// ModerationFilter zawęża i sortuje kolejkę moderacji według wstępnej oceny AI i metadanych treści
+type ModerationFilter struct {
+	MinScore *int
+	MaxScore *int
+	Unscored bool   // tylko elementy jeszcze nieocenione
+	Sort     string // "score" (najsłabsze najpierw), "-score" (najlepsze najpierw), domyślnie kolejność dodania
+	Content  ContentFilter
+}
+
+func (f ModerationFilter) apply(q *gorm.DB) *gorm.DB {
+	if f.MinScore != nil {
+		q = q.Where("screening_score >= ?", *f.MinScore)
+	}
+	if f.MaxScore != nil {
+		q = q.Where("screening_score <= ?", *f.MaxScore)
+	}
+	if f.Unscored {
+		q = q.Where("screening_score IS NULL")
+	}
+	switch f.Sort {
+	case "score":
+		q = q.Order("screening_score ASC NULLS LAST").Order("id")
+	case "-score":
+		q = q.Order("screening_score DESC NULLS LAST").Order("id")
+	default:
+		q = q.Order("id")
+	}
+	return q
+}
+
+func (r *GormUserRepository) GetPendingFlashcards(filter ModerationFilter) ([]models.Flashcard, error) {
 	var f []models.Flashcard
-	if err := r.DB.Where("status = ?", "pending").Find(&f).Error; err != nil {
+	q := filter.Content.apply(r.DB.Where("status = ?", "pending"), flashcardDifficulty)
+	if err := filter.apply(q).Find(&f).Error; err != nil {
 		return nil, err
 	}
 	return f, nil
@@ -219,20 +608,408 @@ func (r *GormUserRepository) GetPendingFlashcards() ([]models.Flashcard, error)
 func (r *GormUserRepository) SetFlashcardStatus(id uint, status string) error {
 	return r.DB.Model(&models.Flashcard{}).Where("id = ?", id).Update("status", status).Error
 }
-func (r *GormUserRepository) GetApprovedFlashcardsByTopic(topicID uint) ([]models.Flashcard, error) {
+func (r *GormUserRepository) GetPendingQuizQuestions(filter ModerationFilter) ([]models.QuizQuestion, error) {
+	var q []models.QuizQuestion
+	query := filter.Content.apply(r.DB.Where("status = ?", "pending"), questionDifficulty)
+	if err := filter.apply(query).Find(&q).Error; err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// SetQuizQuestionStatus zmienia status pytania. Zatwierdzenie pytania zatwierdza też
+// oczekujące wyjaśnienia opcji, które moderator przejrzał razem z nim.
+func (r *GormUserRepository) SetQuizQuestionStatus(id uint, status string) error {
+	updates := map[string]interface{}{"status": status}
+	if status == "approved" {
+		updates["explanation_status"] = gorm.Expr("CASE WHEN explanation_status = 'pending' THEN 'approved' ELSE explanation_status END")
+	}
+	return r.DB.Model(&models.QuizQuestion{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// GetPendingExplanations zwraca zatwierdzone pytania z wyjaśnieniami czekającymi na moderację
+func (r *GormUserRepository) GetPendingExplanations(filter ModerationFilter) ([]models.QuizQuestion, error) {
+	var q []models.QuizQuestion
+	query := filter.Content.apply(r.DB.Where("status = ? AND explanation_status = ?", "approved", "pending"), questionDifficulty)
+	err := filter.apply(query).Find(&q).Error
+	return q, err
+}
+
+// SetQuizQuestionExplanations zapisuje wyjaśnienia opcji ze statusem. Gdy onlyIfStatus nie jest puste,
+// zapis następuje tylko przy jednym z podanych obecnych statusów wyjaśnień (zwraca false, gdy nic nie zmieniono).
+func (r *GormUserRepository) SetQuizQuestionExplanations(id uint, explanations []string, status string, onlyIfStatus ...string) (bool, error) {
+	q := r.DB.Model(&models.QuizQuestion{}).Where("id = ?", id)
+	if len(onlyIfStatus) > 0 {
+		q = q.Where("COALESCE(explanation_status, '') IN ?", onlyIfStatus)
+	}
+	res := q.Updates(map[string]interface{}{
+		"option_explanations": pq.StringArray(explanations),
+		"explanation_status":  status,
+	})
+	return res.RowsAffected > 0, res.Error
+}
+
+// SetExplanationStatus zatwierdza lub odrzuca oczekujące wyjaśnienia pytania
+func (r *GormUserRepository) SetExplanationStatus(id uint, status string) (bool, error) {
+	res := r.DB.Model(&models.QuizQuestion{}).Where("id = ? AND explanation_status = ?", id, "pending").
+		Update("explanation_status", status)
+	return res.RowsAffected > 0, res.Error
+}
+func (r *GormUserRepository) GetFlashcard(id uint) (*models.Flashcard, error) {
+	var f models.Flashcard
+	if err := r.DB.First(&f, id).Error; err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+func (r *GormUserRepository) GetQuizQuestion(id uint) (*models.QuizQuestion, error) {
+	var q models.QuizQuestion
+	if err := r.DB.First(&q, id).Error; err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+func (r *GormUserRepository) GetFlashcardsByIDs(ids []uint) ([]models.Flashcard, error) {
+	var f []models.Flashcard
+	if len(ids) == 0 {
+		return f, nil
+	}
+	if err := r.DB.Where("id IN ?", ids).Find(&f).Error; err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+func (r *GormUserRepository) GetQuizQuestionsByIDs(ids []uint) ([]models.QuizQuestion, error) {
+	var q []models.QuizQuestion
+	if len(ids) == 0 {
+		return q, nil
+	}
+	if err := r.DB.Where("id IN ?", ids).Find(&q).Error; err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// GetActiveFlashcardsByTopic zwraca fiszki tematu oczekujące i zatwierdzone (do wykrywania duplikatów)
+func (r *GormUserRepository) GetActiveFlashcardsByTopic(topicID uint) ([]models.Flashcard, error) {
+	var f []models.Flashcard
+	if err := r.DB.Where("topic_id = ? AND status IN ?", topicID, []string{"pending", "approved"}).Find(&f).Error; err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+func (r *GormUserRepository) GetActiveQuizQuestionsByTopic(topicID uint) ([]models.QuizQuestion, error) {
+	var q []models.QuizQuestion
+	if err := r.DB.Where("topic_id = ? AND status IN ?", topicID, []string{"pending", "approved"}).Find(&q).Error; err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// --- Metody Wstępnej Oceny AI ---
+
+// claimUnscreened przejmuje do oceny najwyżej limit oczekujących, jeszcze nieocenionych elementów tabeli
+// i zwiększa ich licznik prób. Przejęcie jest jednym zapytaniem UPDATE (z SKIP LOCKED), a znacznik
+// screening_claimed_at sprawia, że inne workery pomijają element, dopóki przejęcie nie wygaśnie (lease).
+func claimUnscreened(db *gorm.DB, table string, dest interface{}, limit, maxAttempts int, lease time.Duration) error {
+	now := time.Now()
+	var ids []uint
+	err := db.Raw(fmt.Sprintf(`UPDATE %[1]s SET screening_attempts = screening_attempts + 1, screening_claimed_at = @now
+		WHERE id IN (
+			SELECT id FROM %[1]s
+			WHERE status = 'pending' AND deleted_at IS NULL AND screening_score IS NULL AND screening_attempts < @max_attempts
+				AND (screening_claimed_at IS NULL OR screening_claimed_at < @expired)
+			ORDER BY id LIMIT @limit
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id`, table),
+		map[string]interface{}{"now": now, "expired": now.Add(-lease), "max_attempts": maxAttempts, "limit": limit}).
+		Scan(&ids).Error
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	return db.Where("id IN ?", ids).Order("id").Find(dest).Error
+}
+
+func (r *GormUserRepository) ClaimUnscreenedFlashcards(limit, maxAttempts int, lease time.Duration) ([]models.Flashcard, error) {
+	var f []models.Flashcard
+	if err := claimUnscreened(r.DB, "flashcards", &f, limit, maxAttempts, lease); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+func (r *GormUserRepository) ClaimUnscreenedQuizQuestions(limit, maxAttempts int, lease time.Duration) ([]models.QuizQuestion, error) {
+	var q []models.QuizQuestion
+	if err := claimUnscreened(r.DB, "quiz_questions", &q, limit, maxAttempts, lease); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+func (r *GormUserRepository) ClaimUnscreenedTopicNotes(limit, maxAttempts int, lease time.Duration) ([]models.TopicNote, error) {
+	var n []models.TopicNote
+	if err := claimUnscreened(r.DB, "topic_notes", &n, limit, maxAttempts, lease); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// ReleaseScreeningClaim zwalnia przejęcie elementu, którego nie udało się ocenić - kolejna próba
+// nie musi czekać na wygaśnięcie przejęcia
+func (r *GormUserRepository) ReleaseScreeningClaim(model interface{}, id uint) error {
+	return r.DB.Model(model).Where("id = ?", id).Update("screening_claimed_at", nil).Error
+}
+
+// SaveScreening zapisuje ocenę elementu (model: &models.Flashcard{}, &models.QuizQuestion{} lub &models.TopicNote{}).
+// Przy reject == true element oczekujący zostaje od razu odrzucony.
+func (r *GormUserRepository) SaveScreening(model interface{}, id uint, score int, reasons []string, reject bool) error {
+	updates := map[string]interface{}{
+		"screening_score":   score,
+		"screening_reasons": pq.StringArray(reasons),
+	}
+	if reject {
+		updates["status"] = "rejected"
+	}
+	return r.DB.Model(model).Where("id = ? AND status = ?", id, "pending").Updates(updates).Error
+}
+
+// GetAutoRejectThresholdForTopic zwraca próg automatycznego odrzucania przedmiotu, do którego należy temat
+func (r *GormUserRepository) GetAutoRejectThresholdForTopic(topicID uint) (*int, error) {
+	var subject models.Subject
+	err := r.DB.Joins("JOIN topics ON topics.subject_id = subjects.id").
+		Where("topics.id = ?", topicID).
+		First(&subject).Error
+	if err != nil {
+		return nil, err
+	}
+	return subject.AutoRejectBelow, nil
+}
+
+func (r *GormUserRepository) SetSubjectAutoRejectThreshold(usosID string, threshold *int) (bool, error) {
+	res := r.DB.Model(&models.Subject{}).Where("usos_id = ?", usosID).Update("auto_reject_below", threshold)
+	return res.RowsAffected > 0, res.Error
+}
+
+// swapMediaAttachments zamienia załączniki dwóch elementów tego samego typu
+func swapMediaAttachments(tx *gorm.DB, itemType string, a, b uint) error {
+	return tx.Exec(`UPDATE media_attachments SET item_id = CASE WHEN item_id = @a THEN @b ELSE @a END
+		WHERE item_type = @type AND item_id IN (@a, @b)`,
+		map[string]interface{}{"type": itemType, "a": a, "b": b}).Error
+}
+
+// errMergeConflict przerywa transakcję scalenia, gdy cel zmieniono w międzyczasie
+var errMergeConflict = errors.New("element docelowy zmieniono w międzyczasie")
+
+// mergeInto zapisuje nowy stan celu scalenia i oznacza duplikat jako scalony. Gdy cel przejmuje sformułowanie
+// duplikatu (keepDuplicate), przejmuje też jego załączniki, a swoje dotychczasowe oddaje scalonemu duplikatowi.
+// Zmiana treści celu (rev != nil) zwiększa jego wersję i trafia do historii zmian - zapis udaje się tylko,
+// gdy wersja w bazie jest równa version. Zwraca false przy konflikcie wersji.
+func (r *GormUserRepository) mergeInto(model interface{}, itemType string, duplicateID, targetID uint, version int,
+	updates map[string]interface{}, keepDuplicate bool, rev *models.ContentRevision) (bool, error) {
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if keepDuplicate {
+			if err := swapMediaAttachments(tx, itemType, duplicateID, targetID); err != nil {
+				return err
+			}
+		}
+		query := tx.Model(model).Where("id = ?", targetID)
+		if rev != nil {
+			updates["version"] = gorm.Expr("version + 1")
+			updates["updated_by_usos_id"] = rev.EditorUsosID
+			query = query.Where("version = ?", version)
+		}
+		res := query.Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errMergeConflict
+		}
+		if rev != nil {
+			rev.ItemID, rev.Version = targetID, version+1
+			if err := tx.Create(rev).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(model).Where("id = ?", duplicateID).Updates(map[string]interface{}{
+			"status":          "merged",
+			"duplicate_of_id": targetID,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(model).
+			Where("duplicate_of_id = ? AND id <> ?", duplicateID, targetID).
+			Update("duplicate_of_id", targetID).Error
+	})
+	if errors.Is(err, errMergeConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// MergeFlashcard scala duplikat z fiszką docelową: cel dostaje wybrane sformułowanie i połączone materiały
+// źródłowe, duplikat otrzymuje status 'merged', a inne fiszki wskazujące na duplikat wskazują odtąd na cel.
+// Szczegóły (załączniki, historia zmian, konflikt wersji) opisuje mergeInto.
+func (r *GormUserRepository) MergeFlashcard(duplicateID uint, target *models.Flashcard, keepDuplicate bool, rev *models.ContentRevision) (bool, error) {
+	return r.mergeInto(&models.Flashcard{}, models.SearchItemFlashcard, duplicateID, target.ID, target.Version, map[string]interface{}{
+		"question":            target.Question,
+		"answer":              target.Answer,
+		"question_format":     target.QuestionFormat.Format,
+		"question_language":   target.QuestionFormat.Language,
+		"answer_format":       target.AnswerFormat.Format,
+		"answer_language":     target.AnswerFormat.Language,
+		"source_material_ids": target.SourceMaterialIDs,
+		"tags":                target.Tags,
+		"difficulty":          target.Difficulty,
+		"bloom_level":         target.BloomLevel,
+	}, keepDuplicate, rev)
+}
+
+// MergeQuizQuestion działa jak MergeFlashcard dla pytań quizowych
+func (r *GormUserRepository) MergeQuizQuestion(duplicateID uint, target *models.QuizQuestion, keepDuplicate bool, rev *models.ContentRevision) (bool, error) {
+	// Aktualizacja mapą pomija serializer pola, więc kryteria kodujemy do JSON ręcznie
+	rubric, err := json.Marshal(target.Rubric)
+	if err != nil {
+		return false, err
+	}
+	var payload interface{}
+	if target.Payload != nil {
+		data, err := json.Marshal(target.Payload)
+		if err != nil {
+			return false, err
+		}
+		payload = string(data)
+	}
+	return r.mergeInto(&models.QuizQuestion{}, models.SearchItemQuizQuestion, duplicateID, target.ID, target.Version, map[string]interface{}{
+		"question_text":             target.QuestionText,
+		"options":                   target.Options,
+		"correct_option_index":      target.CorrectOptionIndex,
+		"question_type":             target.QuestionType,
+		"reference_answer":          target.ReferenceAnswer,
+		"rubric":                    string(rubric),
+		"payload":                   payload,
+		"question_format":           target.QuestionFormat.Format,
+		"question_language":         target.QuestionFormat.Language,
+		"options_format":            target.OptionsFormat.Format,
+		"options_language":          target.OptionsFormat.Language,
+		"reference_answer_format":   target.ReferenceAnswerFormat.Format,
+		"reference_answer_language": target.ReferenceAnswerFormat.Language,
+		"option_explanations":       target.OptionExplanations,
+		"explanation_status":        target.ExplanationStatus,
+		"source_material_ids":       target.SourceMaterialIDs,
+		"tags":                      target.Tags,
+		"difficulty":                target.Difficulty,
+		"bloom_level":               target.BloomLevel,
+	}, keepDuplicate, rev)
+}
+
+func (r *GormUserRepository) GetApprovedFlashcardsByTopic(topicID uint, filter ContentFilter) ([]models.Flashcard, error) {
 	var f []models.Flashcard
-	if err := r.DB.Where("topic_id = ? AND status = ?", topicID, "approved").Find(&f).Error; err != nil {
+	if err := filter.apply(r.DB.Where("topic_id = ? AND status = ?", topicID, "approved"), flashcardDifficulty).Find(&f).Error; err != nil {
 		return nil, err
 	}
 	return f, nil
 }
-func (r *GormUserRepository) GetApprovedQuizQuestionsByTopic(topicID uint) ([]models.QuizQuestion, error) {
+
+// GetApprovedFlashcardsByTopics zwraca zatwierdzone fiszki kilku tematów (np. do eksportu przedmiotu)
+func (r *GormUserRepository) GetApprovedFlashcardsByTopics(topicIDs []uint, filter ContentFilter) ([]models.Flashcard, error) {
+	var f []models.Flashcard
+	if len(topicIDs) == 0 {
+		return f, nil
+	}
+	if err := filter.apply(r.DB.Where("topic_id IN ? AND status = ?", topicIDs, "approved"), flashcardDifficulty).
+		Order("topic_id, id").Find(&f).Error; err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// GetRandomApprovedQuizQuestions losuje najwyżej limit zatwierdzonych pytań z podanych tematów spełniających filtr
+// (questionTypes puste = pytania wszystkich typów)
+func (r *GormUserRepository) GetRandomApprovedQuizQuestions(topicIDs []uint, filter ContentFilter, questionTypes []string, limit int) ([]models.QuizQuestion, error) {
 	var q []models.QuizQuestion
-	if err := r.DB.Where("topic_id = ? AND status = ?", topicID, "approved").Find(&q).Error; err != nil {
+	if len(topicIDs) == 0 {
+		return q, nil
+	}
+	query := filter.apply(r.DB.Where("topic_id IN ? AND status = ?", topicIDs, "approved"), questionDifficulty)
+	if len(questionTypes) > 0 {
+		query = query.Where("question_type IN ?", questionTypes)
+	}
+	if err := query.Order("random()").Limit(limit).Find(&q).Error; err != nil {
 		return nil, err
 	}
 	return q, nil
 }
+
+func (r *GormUserRepository) GetApprovedQuizQuestionsByTopic(topicID uint, filter ContentFilter) ([]models.QuizQuestion, error) {
+	var q []models.QuizQuestion
+	if err := filter.apply(r.DB.Where("topic_id = ? AND status = ?", topicID, "approved"), questionDifficulty).Find(&q).Error; err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// --- Metody Podsumowań (TopicNote) ---
+func (r *GormUserRepository) CreateTopicNote(n *models.TopicNote) error {
+	return r.DB.Create(n).Error
+}
+func (r *GormUserRepository) GetTopicNote(id uint) (*models.TopicNote, error) {
+	var n models.TopicNote
+	if err := r.DB.First(&n, id).Error; err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+func (r *GormUserRepository) GetTopicNotesByIDs(ids []uint) ([]models.TopicNote, error) {
+	var n []models.TopicNote
+	if len(ids) == 0 {
+		return n, nil
+	}
+	if err := r.DB.Where("id IN ?", ids).Find(&n).Error; err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+func (r *GormUserRepository) GetPendingTopicNotes() ([]models.TopicNote, error) {
+	var n []models.TopicNote
+	if err := r.DB.Where("status = ?", "pending").Order("created_at").Find(&n).Error; err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+func (r *GormUserRepository) SetTopicNoteStatus(id uint, status string) error {
+	return r.DB.Model(&models.TopicNote{}).Where("id = ?", id).Update("status", status).Error
+}
+
+// GetApprovedTopicNotesByTopic zwraca zatwierdzone podsumowania, od najnowszego
+func (r *GormUserRepository) GetApprovedTopicNotesByTopic(topicID uint) ([]models.TopicNote, error) {
+	var n []models.TopicNote
+	if err := r.DB.Where("topic_id = ? AND status = ?", topicID, "approved").Order("updated_at DESC").Find(&n).Error; err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// UpdateTopicNote zapisuje nową treść podsumowania i zwiększa wersję. Zapis udaje się tylko,
+// gdy wersja w bazie jest równa expectedVersion - zwraca false, jeśli ktoś zmienił notatkę w międzyczasie.
+// Zmieniona treść oczekująca na moderację jest oceniana przez AI od nowa.
+func (r *GormUserRepository) UpdateTopicNote(id uint, expectedVersion int, body, status, editorUsosID string) (bool, error) {
+	updates := map[string]interface{}{
+		"body":               body,
+		"status":             status,
+		"version":            gorm.Expr("version + 1"),
+		"updated_by_usos_id": editorUsosID,
+	}
+	if status == "pending" {
+		updates["screening_score"] = nil
+		updates["screening_reasons"] = nil
+		updates["screening_attempts"] = 0
+		updates["screening_claimed_at"] = nil
+	}
+	res := r.DB.Model(&models.TopicNote{}).
+		Where("id = ? AND version = ?", id, expectedVersion).
+		Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
 func (r *GormUserRepository) GetUserTokenByUsosID(usosID string) (*models.Token, error) {
 	var token models.Token
 	// Używamy gorm.First, które zwróci gorm.ErrRecordNotFound, jeśli nie znajdzie rekordu.
@@ -241,3 +1018,856 @@ func (r *GormUserRepository) GetUserTokenByUsosID(usosID string) (*models.Token,
 	}
 	return &token, nil
 }
+
+// --- Metody Materiałów Źródłowych ---
+
+// CreateSourceMaterial zapisuje materiał, chyba że ten sam użytkownik przesłał już plik o tym samym SHA-256.
+// W obu przypadkach m zostaje wypełnione danymi rekordu z bazy. Inni użytkownicy dostają własne rekordy
+// wskazujące na ten sam plik w magazynie.
+func (r *GormUserRepository) CreateSourceMaterial(m *models.SourceMaterial) error {
+	conflict := clause.OnConflict{Columns: []clause.Column{{Name: "sha256"}, {Name: "created_by_usos_id"}}, DoNothing: true}
+	if err := r.DB.Clauses(conflict).Create(m).Error; err != nil {
+		return err
+	}
+	return r.DB.Where("sha256 = ? AND created_by_usos_id = ?", m.SHA256, m.CreatedByUsosID).First(m).Error
+}
+
+func (r *GormUserRepository) GetSourceMaterial(id uint) (*models.SourceMaterial, error) {
+	var m models.SourceMaterial
+	if err := r.DB.First(&m, id).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetSourceMaterials zwraca materiały o podanych ID (kolejność nie jest gwarantowana)
+func (r *GormUserRepository) GetSourceMaterials(ids []int64) ([]models.SourceMaterial, error) {
+	var m []models.SourceMaterial
+	if len(ids) == 0 {
+		return m, nil
+	}
+	if err := r.DB.Where("id IN ?", ids).Find(&m).Error; err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetSourceMaterialText zwraca zapisany tekst stron pliku o podanym SHA-256
+func (r *GormUserRepository) GetSourceMaterialText(sha256 string) (*models.SourceMaterialText, error) {
+	var t models.SourceMaterialText
+	if err := r.DB.Where("sha256 = ?", sha256).First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SaveSourceMaterialText zapisuje tekst stron pliku; istniejący zapis dla tej zawartości pozostaje bez zmian
+func (r *GormUserRepository) SaveSourceMaterialText(t *models.SourceMaterialText) error {
+	return r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(t).Error
+}
+
+// DeleteUnreferencedSourceMaterials usuwa materiały o podanych ID, do których nie odwołuje się żadne zadanie
+// generowania ani treść. Zwraca SHA-256 zawartości, na które nie wskazuje już żaden materiał
+// (ich pliki i zapisany tekst można usunąć).
+func (r *GormUserRepository) DeleteUnreferencedSourceMaterials(ids []int64) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var orphaned []string
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		var hashes []string
+		if err := tx.Raw(`DELETE FROM source_materials m WHERE m.id IN @ids
+				AND NOT EXISTS (SELECT 1 FROM generation_jobs WHERE m.id = ANY(source_material_ids))
+				AND NOT EXISTS (SELECT 1 FROM flashcards WHERE m.id = ANY(source_material_ids))
+				AND NOT EXISTS (SELECT 1 FROM quiz_questions WHERE m.id = ANY(source_material_ids))
+				AND NOT EXISTS (SELECT 1 FROM topic_notes WHERE m.id = ANY(source_material_ids))
+			RETURNING m.sha256`, map[string]interface{}{"ids": ids}).Scan(&hashes).Error; err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+		if err := tx.Raw(`SELECT DISTINCT h FROM unnest(CAST(@hashes AS text[])) AS h
+			WHERE NOT EXISTS (SELECT 1 FROM source_materials WHERE sha256 = h)`,
+			map[string]interface{}{"hashes": pq.StringArray(hashes)}).Scan(&orphaned).Error; err != nil {
+			return err
+		}
+		if len(orphaned) == 0 {
+			return nil
+		}
+		return tx.Where("sha256 IN ?", orphaned).Delete(&models.SourceMaterialText{}).Error
+	})
+	return orphaned, err
+}
+
+// --- Metody Załączników Multimedialnych ---
+
+// CreateMediaAttachment zapisuje załącznik na końcu listy załączników elementu
+func (r *GormUserRepository) CreateMediaAttachment(m *models.MediaAttachment) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.MediaAttachment{}).
+			Where("item_type = ? AND item_id = ?", m.ItemType, m.ItemID).
+			Select("COALESCE(MAX(position)+1, 0)").Scan(&m.Position).Error; err != nil {
+			return err
+		}
+		return tx.Create(m).Error
+	})
+}
+
+func (r *GormUserRepository) GetMediaAttachment(id uint) (*models.MediaAttachment, error) {
+	var m models.MediaAttachment
+	if err := r.DB.First(&m, id).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetMediaAttachments zwraca załączniki podanych elementów jednego typu (w kolejności pozycji)
+func (r *GormUserRepository) GetMediaAttachments(itemType string, itemIDs []uint) ([]models.MediaAttachment, error) {
+	var m []models.MediaAttachment
+	if len(itemIDs) == 0 {
+		return m, nil
+	}
+	if err := r.DB.Where("item_type = ? AND item_id IN ?", itemType, itemIDs).
+		Order("item_id, position, id").Find(&m).Error; err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (r *GormUserRepository) CountMediaAttachments(itemType string, itemID uint) (int64, error) {
+	var n int64
+	err := r.DB.Model(&models.MediaAttachment{}).Where("item_type = ? AND item_id = ?", itemType, itemID).Count(&n).Error
+	return n, err
+}
+
+func (r *GormUserRepository) DeleteMediaAttachments(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.DB.Where("id IN ?", ids).Delete(&models.MediaAttachment{}).Error
+}
+
+// --- Metody Metadanych Treści ---
+
+// SetContentMetadata zapisuje tagi, poziom trudności i kategorię Blooma fiszki lub pytania (model: &models.Flashcard{} lub &models.QuizQuestion{})
+func (r *GormUserRepository) SetContentMetadata(model interface{}, id uint, m models.ContentMetadata) error {
+	return r.DB.Model(model).Where("id = ?", id).Updates(map[string]interface{}{
+		"tags":        m.Tags,
+		"difficulty":  m.Difficulty,
+		"bloom_level": m.BloomLevel,
+	}).Error
+}
+
+// GetContentTags zwraca kuratorowaną listę tagów (alfabetycznie)
+func (r *GormUserRepository) GetContentTags() ([]models.ContentTag, error) {
+	var t []models.ContentTag
+	err := r.DB.Order("name").Find(&t).Error
+	return t, err
+}
+
+// SaveContentTag dodaje tag do listy kuratorowanej albo aktualizuje opis istniejącego
+func (r *GormUserRepository) SaveContentTag(t *models.ContentTag) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"description", "updated_at"}),
+	}).Create(t).Error
+}
+
+// DeleteContentTag usuwa tag z listy kuratorowanej (treści zachowują go jako tag dowolny)
+func (r *GormUserRepository) DeleteContentTag(name string) (bool, error) {
+	res := r.DB.Where("name = ?", name).Delete(&models.ContentTag{})
+	return res.RowsAffected > 0, res.Error
+}
+
+// TagUsage to liczba zatwierdzonych fiszek i pytań z danym tagiem
+type TagUsage struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// GetTagUsage zwraca najczęściej używane tagi zatwierdzonych treści przedmiotów (nil = wszystkie przedmioty)
+func (r *GormUserRepository) GetTagUsage(subjectIDs []uint, limit int) ([]TagUsage, error) {
+	if subjectIDs != nil && len(subjectIDs) == 0 {
+		return nil, nil
+	}
+	where := ""
+	args := map[string]interface{}{"limit": limit}
+	if subjectIDs != nil {
+		where = " AND t.subject_id IN @subjects"
+		args["subjects"] = subjectIDs
+	}
+	var u []TagUsage
+	err := r.DB.Raw(`SELECT tag AS name, COUNT(*) AS count FROM (
+			SELECT unnest(x.tags) AS tag FROM flashcards x JOIN topics t ON t.id = x.topic_id AND t.deleted_at IS NULL
+			WHERE x.status = 'approved'`+where+`
+			UNION ALL
+			SELECT unnest(x.tags) AS tag FROM quiz_questions x JOIN topics t ON t.id = x.topic_id AND t.deleted_at IS NULL
+			WHERE x.status = 'approved'`+where+`
+		) tags GROUP BY tag ORDER BY count DESC, tag LIMIT @limit`, args).Scan(&u).Error
+	return u, err
+}
+
+// --- Metody Zapisów na Przedmioty ---
+
+func (r *GormUserRepository) EnrollUserInSubject(userUsosID string, subjectID uint) error {
+	return r.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.UserSubject{UserUsosID: userUsosID, SubjectID: subjectID}).Error
+}
+
+func (r *GormUserRepository) GetUserSubjectIDs(userUsosID string) ([]uint, error) {
+	var ids []uint
+	err := r.DB.Model(&models.UserSubject{}).Where("user_usos_id = ?", userUsosID).Pluck("subject_id", &ids).Error
+	return ids, err
+}
+
+// --- Metody Indeksu Wyszukiwania ---
+
+// EmbeddingCandidate to zatwierdzona treść, której wektor trzeba (ponownie) policzyć
+type EmbeddingCandidate struct {
+	ItemType  string
+	ItemID    uint
+	TopicID   uint
+	SubjectID uint
+	Text      string
+}
+
+// Tekst indeksowany dla każdego typu treści; ten sam wzór służy do wykrywania zmian (md5)
+const (
+	flashcardSearchText    = "f.question || E'\\n' || f.answer"
+	quizQuestionSearchText = "q.question_text || E'\\n' || array_to_string(q.options, E'\\n')"
+	topicNoteSearchText    = "n.body"
+)
+
+// GetStaleEmbeddingCandidates zwraca zatwierdzone treści bez wektora dla danego modelu
+// lub takie, których tekst albo temat zmienił się od ostatniego przeliczenia
+func (r *GormUserRepository) GetStaleEmbeddingCandidates(model string, limit int) ([]EmbeddingCandidate, error) {
+	part := func(itemType, table, alias, text string) string {
+		return fmt.Sprintf(`SELECT '%[1]s' AS item_type, %[3]s.id AS item_id, %[3]s.topic_id, t.subject_id, %[4]s AS text
+			FROM %[2]s %[3]s
+			JOIN topics t ON t.id = %[3]s.topic_id
+			LEFT JOIN content_embeddings e ON e.item_type = '%[1]s' AND e.item_id = %[3]s.id
+			WHERE %[3]s.status = 'approved' AND %[3]s.deleted_at IS NULL AND t.deleted_at IS NULL
+			  AND (e.id IS NULL OR e.model <> @model OR e.topic_id <> %[3]s.topic_id OR e.content_hash <> md5(%[4]s))`,
+			itemType, table, alias, text)
+	}
+	query := part(models.SearchItemFlashcard, "flashcards", "f", flashcardSearchText) +
+		" UNION ALL " + part(models.SearchItemQuizQuestion, "quiz_questions", "q", quizQuestionSearchText) +
+		" UNION ALL " + part(models.SearchItemTopicNote, "topic_notes", "n", topicNoteSearchText) +
+		" LIMIT @limit"
+
+	var c []EmbeddingCandidate
+	err := r.DB.Raw(query, map[string]interface{}{"model": model, "limit": limit}).Scan(&c).Error
+	return c, err
+}
+
+func (r *GormUserRepository) UpsertContentEmbedding(e *models.ContentEmbedding) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "item_type"}, {Name: "item_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"topic_id", "subject_id", "model", "content_hash", "vector", "updated_at"}),
+	}).Create(e).Error
+}
+
+// DeleteStaleContentEmbeddings usuwa wektory treści, które nie są już zatwierdzone, i zwraca liczbę usuniętych
+func (r *GormUserRepository) DeleteStaleContentEmbeddings() (int64, error) {
+	var total int64
+	for itemType, table := range map[string]string{
+		models.SearchItemFlashcard:    "flashcards",
+		models.SearchItemQuizQuestion: "quiz_questions",
+		models.SearchItemTopicNote:    "topic_notes",
+	} {
+		res := r.DB.Exec(fmt.Sprintf(`DELETE FROM content_embeddings e WHERE e.item_type = ?
+			AND NOT EXISTS (SELECT 1 FROM %s x WHERE x.id = e.item_id AND x.status = 'approved' AND x.deleted_at IS NULL)`, table), itemType)
+		if res.Error != nil {
+			return total, res.Error
+		}
+		total += res.RowsAffected
+	}
+	return total, nil
+}
+
+func (r *GormUserRepository) GetContentEmbeddings(model string) ([]models.ContentEmbedding, error) {
+	var e []models.ContentEmbedding
+	err := r.DB.Where("model = ?", model).Find(&e).Error
+	return e, err
+}
+
+// TextSearchFilter opisuje zapytanie wyszukiwania pełnotekstowego
+type TextSearchFilter struct {
+	Query      string
+	SubjectIDs []uint        // nil = wszystkie przedmioty
+	Types      []string      // puste = wszystkie typy (models.SearchItem*)
+	Statuses   []string      // statusy treści; tematy są zwracane, gdy lista zawiera "approved"
+	Content    ContentFilter // filtr metadanych - gdy ustawiony, zwracane są tylko fiszki i pytania
+	Limit      int
+	Offset     int
+}
+
+// TextSearchRow to jedno trafienie wyszukiwania pełnotekstowego.
+// Snippet to fragment treści z HTML-owo zakodowanym tekstem i trafieniami w <mark>.
+type TextSearchRow struct {
+	ItemType      string
+	ItemID        uint
+	TopicID       uint
+	TopicName     string
+	SubjectUsosID string
+	SubjectName   string
+	Status        string
+	Title         string
+	Rank          float64
+	Snippet       string
+	Total         int64
+}
+
+// textSearchSources opisuje, skąd brać trafienia danego typu: tabela, status, tytuł i treść do fragmentu
+// oraz kolumna poziomu trudności (pusta = typ bez metadanych treści)
+var textSearchSources = []struct {
+	itemType, table, topicID, status, title, body, difficulty string
+}{
+	{models.SearchItemTopic, "topics", "x.id", "'approved'", "x.name", "x.name", ""},
+	{models.SearchItemFlashcard, "flashcards", "x.topic_id", "x.status", "x.question", "x.question || E'\\n' || x.answer", flashcardDifficulty},
+	{models.SearchItemQuizQuestion, "quiz_questions", "x.topic_id", "x.status", "x.question_text", "x.question_text", questionDifficulty},
+	{models.SearchItemTopicNote, "topic_notes", "x.topic_id", "x.status", "left(x.body, 300)", "x.body", ""},
+}
+
+// FullTextSearch wyszukuje tematy i treści (polska lematyzacja + wyszukiwanie bez polskich znaków),
+// sortuje po trafności i zwraca jedną stronę wyników wraz z łączną liczbą trafień (Total w każdym wierszu)
+func (r *GormUserRepository) FullTextSearch(f TextSearchFilter) ([]TextSearchRow, error) {
+	if f.SubjectIDs != nil && len(f.SubjectIDs) == 0 {
+		return nil, nil
+	}
+	wanted := func(list []string, v string) bool {
+		for _, x := range list {
+			if x == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	var parts []string
+	for _, s := range textSearchSources {
+		if len(f.Types) > 0 && !wanted(f.Types, s.itemType) {
+			continue
+		}
+		if s.itemType == models.SearchItemTopic && !wanted(f.Statuses, "approved") {
+			continue
+		}
+		if !f.Content.IsEmpty() && s.difficulty == "" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf(`SELECT '%s' AS item_type, x.id AS item_id, %s AS topic_id, %s AS status,
+			%s AS title, %s AS body, ts_rank(x.search_vector, q.query) AS rank
+			FROM %s x, q WHERE x.deleted_at IS NULL AND x.search_vector @@ q.query%s`,
+			s.itemType, s.topicID, s.status, s.title, s.body, s.table, f.Content.sql(s.difficulty)))
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	where := "h.status IN @statuses"
+	args := map[string]interface{}{"q": f.Query, "statuses": f.Statuses, "limit": f.Limit, "offset": f.Offset,
+		"tags": pq.StringArray(f.Content.Tags), "difficulty": f.Content.Difficulty, "bloom_level": f.Content.BloomLevel}
+	if f.SubjectIDs != nil {
+		where += " AND t.subject_id IN @subjects"
+		args["subjects"] = f.SubjectIDs
+	}
+
+	query := `WITH q AS (
+			SELECT websearch_to_tsquery('polish_search', @q) || websearch_to_tsquery('polish_unaccent', @q) AS query
+		), hits AS (` + strings.Join(parts, " UNION ALL ") + `)
+		SELECT h.item_type, h.item_id, h.topic_id, t.name AS topic_name, s.usos_id AS subject_usos_id, s.name AS subject_name,
+			h.status, h.title, h.rank, COUNT(*) OVER () AS total,
+			ts_headline('polish_search',
+				replace(replace(replace(h.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), q.query,
+				'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=25, MinWords=8, FragmentDelimiter=" … "') AS snippet
+		FROM hits h
+		JOIN topics t ON t.id = h.topic_id AND t.deleted_at IS NULL
+		JOIN subjects s ON s.id = t.subject_id
+		CROSS JOIN q
+		WHERE ` + where + `
+		ORDER BY h.rank DESC, h.item_type, h.item_id
+		LIMIT @limit OFFSET @offset`
+
+	var rows []TextSearchRow
+	err := r.DB.Raw(query, args).Scan(&rows).Error
+	return rows, err
+}
+
+// --- Metody Szablonów Promptów ---
+
+// promptTemplateScope zawęża zapytanie do szablonu globalnego (subjectID == nil) lub przedmiotu
+func promptTemplateScope(q *gorm.DB, name string, subjectID *uint) *gorm.DB {
+	q = q.Where("name = ?", name)
+	if subjectID == nil {
+		return q.Where("subject_id IS NULL")
+	}
+	return q.Where("subject_id = ?", *subjectID)
+}
+
+// GetLatestPromptTemplate zwraca najnowszą wersję szablonu (gorm.ErrRecordNotFound, gdy go brak)
+func (r *GormUserRepository) GetLatestPromptTemplate(name string, subjectID *uint) (*models.PromptTemplate, error) {
+	var t models.PromptTemplate
+	if err := promptTemplateScope(r.DB, name, subjectID).Order("version DESC").First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *GormUserRepository) GetPromptTemplateVersions(name string, subjectID *uint) ([]models.PromptTemplate, error) {
+	var t []models.PromptTemplate
+	if err := promptTemplateScope(r.DB, name, subjectID).Order("version DESC").Find(&t).Error; err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetCurrentPromptTemplates zwraca najnowszą wersję każdego szablonu (globalnego i przedmiotowego)
+func (r *GormUserRepository) GetCurrentPromptTemplates() ([]models.PromptTemplate, error) {
+	var t []models.PromptTemplate
+	err := r.DB.Raw(`SELECT DISTINCT ON (name, subject_id) * FROM prompt_templates
+		ORDER BY name, subject_id NULLS FIRST, version DESC`).Scan(&t).Error
+	return t, err
+}
+
+// CreatePromptTemplateVersion zapisuje t jako kolejną wersję szablonu (ustawia t.Version)
+func (r *GormUserRepository) CreatePromptTemplateVersion(t *models.PromptTemplate) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		// Blokada na czas transakcji, aby równoległe edycje nie nadały tego samego numeru wersji
+		key := t.Name
+		if t.SubjectID != nil {
+			key = fmt.Sprintf("%s:%d", t.Name, *t.SubjectID)
+		}
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "prompt_template:"+key).Error; err != nil {
+			return err
+		}
+
+		var latest int
+		if err := promptTemplateScope(tx.Model(&models.PromptTemplate{}), t.Name, t.SubjectID).
+			Select("COALESCE(MAX(version), 0)").Scan(&latest).Error; err != nil {
+			return err
+		}
+		t.Version = latest + 1
+		return tx.Create(t).Error
+	})
+}
+
+// --- Metody Czatu z Tutorem ---
+
+func (r *GormUserRepository) CreateTutorConversation(conv *models.TutorConversation) error {
+	return r.DB.Create(conv).Error
+}
+
+func (r *GormUserRepository) GetTutorConversation(id uint) (*models.TutorConversation, error) {
+	var conv models.TutorConversation
+	if err := r.DB.First(&conv, id).Error; err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// GetTutorConversations zwraca rozmowy użytkownika w temacie, od ostatnio aktywnej
+func (r *GormUserRepository) GetTutorConversations(userUsosID string, topicID uint) ([]models.TutorConversation, error) {
+	var convs []models.TutorConversation
+	err := r.DB.Where("user_usos_id = ? AND topic_id = ?", userUsosID, topicID).
+		Order("updated_at DESC").Find(&convs).Error
+	return convs, err
+}
+
+// GetTutorMessages zwraca wiadomości rozmowy w kolejności chronologicznej
+// (limit > 0 ogranicza wynik do ostatnich limit wiadomości)
+func (r *GormUserRepository) GetTutorMessages(conversationID uint, limit int) ([]models.TutorMessage, error) {
+	var msgs []models.TutorMessage
+	q := r.DB.Where("conversation_id = ?", conversationID).Order("id DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	if err := q.Find(&msgs).Error; err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return msgs, nil
+}
+
+// AppendTutorExchange zapisuje pytanie i odpowiedź tutora oraz odświeża czas ostatniej aktywności rozmowy
+func (r *GormUserRepository) AppendTutorExchange(conversationID uint, question, answer *models.TutorMessage) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		question.ConversationID, answer.ConversationID = conversationID, conversationID
+		if err := tx.Create(question).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(answer).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.TutorConversation{}).Where("id = ?", conversationID).
+			Update("updated_at", time.Now()).Error
+	})
+}
+
+// DeleteTutorConversation usuwa rozmowę wraz z wiadomościami
+func (r *GormUserRepository) DeleteTutorConversation(id uint) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("conversation_id = ?", id).Delete(&models.TutorMessage{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.TutorConversation{}, id).Error
+	})
+}
+
+// --- Metody Historii Zmian ---
+
+// SaveContentEdit zapisuje edycję fiszki lub pytania (model: &models.Flashcard{} lub &models.QuizQuestion{})
+// razem z wpisem historii i zwiększa wersję elementu. Zapis udaje się tylko, gdy wersja w bazie jest równa
+// expectedVersion - zwraca false, jeśli ktoś zmienił element w międzyczasie.
+func (r *GormUserRepository) SaveContentEdit(model interface{}, id uint, expectedVersion int, updates map[string]interface{}, rev *models.ContentRevision) (bool, error) {
+	saved := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		updates["version"] = gorm.Expr("version + 1")
+		updates["updated_by_usos_id"] = rev.EditorUsosID
+		res := tx.Model(model).Where("id = ? AND version = ?", id, expectedVersion).Updates(updates)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		rev.ItemID, rev.Version = id, expectedVersion+1
+		if err := tx.Create(rev).Error; err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	return saved, err
+}
+
+// GetContentRevisions zwraca historię zmian elementu (od najnowszej)
+func (r *GormUserRepository) GetContentRevisions(itemType string, itemID uint) ([]models.ContentRevision, error) {
+	var revs []models.ContentRevision
+	if err := r.DB.Where("item_type = ? AND item_id = ?", itemType, itemID).
+		Order("version DESC").Find(&revs).Error; err != nil {
+		return nil, err
+	}
+	return revs, nil
+}
+
+// --- Metody Odpowiedzi na Pytania ---
+
+// CreateQuizAnswer zapisuje odpowiedź ucznia wraz z jej pierwszą oceną (grading może być nil)
+func (r *GormUserRepository) CreateQuizAnswer(answer *models.QuizAnswer, grading *models.AnswerGrading) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(answer).Error; err != nil {
+			return err
+		}
+		if grading == nil {
+			return nil
+		}
+		return addAnswerGrading(tx, answer, grading)
+	})
+}
+
+// AddAnswerGrading zapisuje kolejną ocenę odpowiedzi i ustawia ją jako obowiązującą
+func (r *GormUserRepository) AddAnswerGrading(answer *models.QuizAnswer, grading *models.AnswerGrading) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		return addAnswerGrading(tx, answer, grading)
+	})
+}
+
+func addAnswerGrading(tx *gorm.DB, answer *models.QuizAnswer, grading *models.AnswerGrading) error {
+	grading.AnswerID = answer.ID
+	if err := tx.Create(grading).Error; err != nil {
+		return err
+	}
+	answer.Score, answer.MaxScore = grading.Score, grading.MaxScore
+	answer.GradingStatus, answer.LatestGradingID = models.AnswerGraded, &grading.ID
+	return tx.Model(answer).Updates(map[string]interface{}{
+		"score":             answer.Score,
+		"max_score":         answer.MaxScore,
+		"grading_status":    answer.GradingStatus,
+		"latest_grading_id": answer.LatestGradingID,
+	}).Error
+}
+
+// RefreshQuestionDifficulty oblicza poziom trudności pytania ze średniego wyniku pierwszych odpowiedzi uczniów:
+// co najmniej easyRate - "easy", poniżej hardRate - "hard", pomiędzy - "medium". Przy mniej niż minAnswers
+// ocenionych odpowiedziach poziom obliczony jest czyszczony.
+func (r *GormUserRepository) RefreshQuestionDifficulty(questionID uint, minAnswers int, easyRate, hardRate float64) error {
+	return r.DB.Exec(`UPDATE quiz_questions q SET computed_difficulty = CASE
+			WHEN s.n < @min THEN ''
+			WHEN s.rate >= @easy THEN @easy_level
+			WHEN s.rate < @hard THEN @hard_level
+			ELSE @medium_level END
+		FROM (
+			SELECT COUNT(*) AS n, COALESCE(AVG(a.score::float / a.max_score), 0) AS rate FROM (
+				SELECT DISTINCT ON (user_usos_id) score, max_score FROM quiz_answers
+				WHERE question_id = @id AND grading_status = @graded AND max_score > 0
+				ORDER BY user_usos_id, created_at, id
+			) a
+		) s
+		WHERE q.id = @id`, map[string]interface{}{
+		"id": questionID, "min": minAnswers, "easy": easyRate, "hard": hardRate, "graded": models.AnswerGraded,
+		"easy_level": models.DifficultyEasy, "medium_level": models.DifficultyMedium, "hard_level": models.DifficultyHard,
+	}).Error
+}
+
+func (r *GormUserRepository) GetQuizAnswer(id uint) (*models.QuizAnswer, error) {
+	var a models.QuizAnswer
+	if err := r.DB.First(&a, id).Error; err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// GetQuizAnswers zwraca odpowiedzi użytkownika na pytanie (najnowsze najpierw)
+func (r *GormUserRepository) GetQuizAnswers(userUsosID string, questionID uint) ([]models.QuizAnswer, error) {
+	var a []models.QuizAnswer
+	if err := r.DB.Where("user_usos_id = ? AND question_id = ?", userUsosID, questionID).
+		Order("created_at DESC, id DESC").Find(&a).Error; err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetAnswerGradings zwraca historię ocen odpowiedzi (najnowsze najpierw)
+func (r *GormUserRepository) GetAnswerGradings(answerID uint) ([]models.AnswerGrading, error) {
+	var g []models.AnswerGrading
+	if err := r.DB.Where("answer_id = ?", answerID).Order("created_at DESC, id DESC").Find(&g).Error; err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// --- Metody Zużycia AI ---
+
+func (r *GormUserRepository) CreateAIUsage(u *models.AIUsage) error {
+	return r.DB.Create(u).Error
+}
+
+// SumAIUsage zwraca liczbę zapytań i tokenów użytkownika od podanej chwili
+func (r *GormUserRepository) SumAIUsage(userUsosID string, since time.Time) (requests, tokens int64, err error) {
+	var row struct {
+		Requests int64
+		Tokens   int64
+	}
+	err = r.DB.Model(&models.AIUsage{}).
+		Select("COUNT(*) AS requests, COALESCE(SUM(total_tokens), 0) AS tokens").
+		Where("user_usos_id = ? AND created_at >= ?", userUsosID, since).
+		Scan(&row).Error
+	return row.Requests, row.Tokens, err
+}
+
+// SumAIUsageByTask zwraca liczbę tokenów zużytych przez użytkownika w zadaniu danego typu od podanej chwili
+func (r *GormUserRepository) SumAIUsageByTask(userUsosID, task string, since time.Time) (int64, error) {
+	var tokens int64
+	err := r.DB.Model(&models.AIUsage{}).
+		Select("COALESCE(SUM(total_tokens), 0)").
+		Where("user_usos_id = ? AND task = ? AND created_at >= ?", userUsosID, task, since).
+		Scan(&tokens).Error
+	return tokens, err
+}
+
+// AIUsageReportRow to zagregowane zużycie AI jednego użytkownika lub przedmiotu
+type AIUsageReportRow struct {
+	Key              string `json:"key"`
+	Name             string `json:"name"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens"`
+}
+
+// AIUsageReport agreguje zużycie w przedziale [from, to) per użytkownik ("user") lub przedmiot ("subject")
+func (r *GormUserRepository) AIUsageReport(groupBy string, from, to time.Time) ([]AIUsageReportRow, error) {
+	const sums = "COUNT(*) AS requests, COALESCE(SUM(ai_usage.prompt_tokens), 0) AS prompt_tokens, " +
+		"COALESCE(SUM(ai_usage.completion_tokens), 0) AS completion_tokens, COALESCE(SUM(ai_usage.total_tokens), 0) AS total_tokens"
+
+	q := r.DB.Table("ai_usage").Where("ai_usage.created_at >= ? AND ai_usage.created_at < ?", from, to)
+	switch groupBy {
+	case "user":
+		q = q.Select("ai_usage.user_usos_id AS key, COALESCE(MAX(users.first_name || ' ' || users.last_name), '') AS name, " + sums).
+			Joins("LEFT JOIN users ON users.usos_id = ai_usage.user_usos_id").
+			Group("ai_usage.user_usos_id")
+	case "subject":
+		q = q.Select("COALESCE(subjects.usos_id, '') AS key, COALESCE(MAX(subjects.name), '') AS name, " + sums).
+			Joins("LEFT JOIN subjects ON subjects.id = ai_usage.subject_id").
+			Group("subjects.usos_id")
+	default:
+		return nil, fmt.Errorf("nieznane grupowanie: %s", groupBy)
+	}
+
+	var rows []AIUsageReportRow
+	if err := q.Order("total_tokens DESC").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// --- Metody Zadań Generowania ---
+
+func (r *GormUserRepository) CreateGenerationJob(job *models.GenerationJob) error {
+	return r.DB.Create(job).Error
+}
+
+func (r *GormUserRepository) GetGenerationJob(id uint) (*models.GenerationJob, error) {
+	var job models.GenerationJob
+	if err := r.DB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CountActiveGenerationJobs zwraca liczbę zadań użytkownika, które czekają lub są w trakcie
+func (r *GormUserRepository) CountActiveGenerationJobs(userUsosID string) (int64, error) {
+	var count int64
+	err := r.DB.Model(&models.GenerationJob{}).
+		Where("created_by_usos_id = ? AND status IN ?", userUsosID, []string{models.GenerationJobQueued, models.GenerationJobRunning}).
+		Count(&count).Error
+	return count, err
+}
+
+// CreateGenerationJobWithinLimit zapisuje zadanie, o ile użytkownik ma mniej niż maxActive zadań
+// oczekujących lub trwających. Zwraca false (bez zapisu), gdy limit jest wyczerpany.
+func (r *GormUserRepository) CreateGenerationJobWithinLimit(job *models.GenerationJob, maxActive int) (bool, error) {
+	created := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		// Blokada na czas transakcji, aby równoległe zgłoszenia nie przekroczyły limitu razem
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "generation_jobs:"+job.CreatedByUsosID).Error; err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.Model(&models.GenerationJob{}).
+			Where("created_by_usos_id = ? AND status IN ?", job.CreatedByUsosID, []string{models.GenerationJobQueued, models.GenerationJobRunning}).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active >= int64(maxActive) {
+			return nil
+		}
+		created = true
+		return tx.Create(job).Error
+	})
+	return created && err == nil, err
+}
+
+// ClaimNextGenerationJob atomowo przejmuje najstarsze oczekujące zadanie (nil, jeśli brak)
+func (r *GormUserRepository) ClaimNextGenerationJob() (*models.GenerationJob, error) {
+	var job models.GenerationJob
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", models.GenerationJobQueued).
+			Order("id").
+			First(&job).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		job.Status = models.GenerationJobRunning
+		job.Attempts++
+		job.StartedAt = &now
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":     job.Status,
+			"attempts":   job.Attempts,
+			"started_at": now,
+			"updated_at": now,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RecordGenerationJobChunk dopisuje do zadania ID treści zapisanych z jednej porcji materiałów
+// i zwiększa licznik ukończonych porcji. Działa także dla zadania anulowanego w międzyczasie,
+// aby zapisane już treści nie zniknęły z jego wyniku.
+func (r *GormUserRepository) RecordGenerationJobChunk(jobID uint, completedChunks int, flashcardIDs, quizQuestionIDs, topicNoteIDs []int64, rejected []string) error {
+	return r.DB.Exec(`UPDATE generation_jobs SET
+			flashcard_ids = COALESCE(flashcard_ids, '{}') || CAST(@flashcards AS integer[]),
+			quiz_question_ids = COALESCE(quiz_question_ids, '{}') || CAST(@questions AS integer[]),
+			topic_note_ids = COALESCE(topic_note_ids, '{}') || CAST(@notes AS integer[]),
+			rejected_items = COALESCE(rejected_items, '{}') || CAST(@rejected AS text[]),
+			completed_chunks = @chunks, updated_at = @now
+		WHERE id = @id`, map[string]interface{}{
+		"id": jobID, "chunks": completedChunks, "now": time.Now(),
+		"flashcards": pq.Int64Array(flashcardIDs), "questions": pq.Int64Array(quizQuestionIDs),
+		"notes": pq.Int64Array(topicNoteIDs), "rejected": pq.StringArray(rejected),
+	}).Error
+}
+
+// FinishGenerationJob zapisuje status i błąd zadania, o ile nie zostało ono w międzyczasie anulowane.
+// ID treści są dopisywane na bieżąco przez RecordGenerationJobChunk.
+func (r *GormUserRepository) FinishGenerationJob(job *models.GenerationJob) error {
+	return r.DB.Model(&models.GenerationJob{}).
+		Where("id = ? AND status = ?", job.ID, models.GenerationJobRunning).
+		Updates(map[string]interface{}{
+			"status":      job.Status,
+			"error":       job.Error,
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// CancelGenerationJob anuluje zadanie oczekujące lub trwające. Zwraca false, jeśli zadanie już się zakończyło.
+func (r *GormUserRepository) CancelGenerationJob(id uint) (bool, error) {
+	res := r.DB.Model(&models.GenerationJob{}).
+		Where("id = ? AND status IN ?", id, []string{models.GenerationJobQueued, models.GenerationJobRunning}).
+		Updates(map[string]interface{}{
+			"status":      models.GenerationJobCancelled,
+			"finished_at": time.Now(),
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+// RetryGenerationJobWithinLimit ponownie kolejkuje zadanie zakończone błędem lub anulowane, o ile jego autor
+// ma mniej niż maxActive zadań oczekujących lub trwających (pod tą samą blokadą co CreateGenerationJobWithinLimit).
+// Zwraca retried=false, gdy zadanie nie jest zakończone błędem ani anulowane, oraz limited=true, gdy limit
+// jest wyczerpany. Treści zapisane wcześniej zostają w wyniku zadania - generowanie wznawia się
+// od pierwszej nieukończonej porcji.
+func (r *GormUserRepository) RetryGenerationJobWithinLimit(job *models.GenerationJob, maxActive int) (retried, limited bool, err error) {
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "generation_jobs:"+job.CreatedByUsosID).Error; err != nil {
+			return err
+		}
+		var active int64
+		if err := tx.Model(&models.GenerationJob{}).
+			Where("created_by_usos_id = ? AND status IN ?", job.CreatedByUsosID, []string{models.GenerationJobQueued, models.GenerationJobRunning}).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active >= int64(maxActive) {
+			limited = true
+			return nil
+		}
+		res := tx.Model(&models.GenerationJob{}).
+			Where("id = ? AND status IN ?", job.ID, []string{models.GenerationJobFailed, models.GenerationJobCancelled}).
+			Updates(map[string]interface{}{
+				"status":      models.GenerationJobQueued,
+				"error":       "",
+				"started_at":  nil,
+				"finished_at": nil,
+			})
+		retried = res.RowsAffected > 0
+		return res.Error
+	})
+	if err != nil {
+		return false, false, err
+	}
+	return retried, limited, nil
+}
+
+// TouchGenerationJob odnotowuje, że zadanie jest nadal wykonywane (updated_at służy jako znak życia workera)
+func (r *GormUserRepository) TouchGenerationJob(id uint) error {
+	return r.DB.Model(&models.GenerationJob{}).
+		Where("id = ? AND status = ?", id, models.GenerationJobRunning).
+		Update("updated_at", time.Now()).Error
+}
+
+// RequeueStaleGenerationJobs przywraca do kolejki trwające zadania, których worker nie dał znaku życia
+// od staleBefore (przerwane restartem lub awarią serwera). Zadania wykonywane przez inne działające
+// instancje są regularnie odświeżane (TouchGenerationJob), więc nie zostaną przejęte.
+func (r *GormUserRepository) RequeueStaleGenerationJobs(staleBefore time.Time) (int64, error) {
+	res := r.DB.Model(&models.GenerationJob{}).
+		Where("status = ? AND updated_at < ?", models.GenerationJobRunning, staleBefore).
+		Update("status", models.GenerationJobQueued)
+	return res.RowsAffected, res.Error
+}
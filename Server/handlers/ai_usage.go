@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+)
+
+// checkAIQuota sprawdza limit zużycia AI użytkownika. Przy przekroczeniu odpowiada 429
+// ze szczegółami limitu i zwraca false.
+func checkAIQuota(c *gin.Context, userUsosID string) bool {
+	return respondQuotaCheck(c, services.CheckAIQuota(userUsosID))
+}
+
+// respondQuotaCheck przekłada wynik sprawdzenia limitu na odpowiedź (429 przy *QuotaExceededError)
+func respondQuotaCheck(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var quotaErr *services.QuotaExceededError
+	if !errors.As(err, &quotaErr) {
+		utils.SendInternalError(c, err)
+		return false
+	}
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":     "quota_exceeded",
+		"message":   quotaErr.Error(),
+		"period":    quotaErr.Period,
+		"kind":      quotaErr.Kind,
+		"limit":     quotaErr.Limit,
+		"used":      quotaErr.Used,
+		"resets_at": quotaErr.ResetsAt,
+	})
+	return false
+}
+
+// HandleGetMyAIUsage zwraca zużycie AI zalogowanego użytkownika w bieżącym dniu i miesiącu wraz z limitami
+func HandleGetMyAIUsage(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+
+	summary, err := services.GetAIUsageSummary(userUsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, summary)
+}
+
+// HandleGetAIUsageReport zwraca zużycie AI pogrupowane według użytkowników lub przedmiotów.
+// Parametry: group_by=user|subject, from i to w formacie RRRR-MM-DD (domyślnie bieżący miesiąc, "to" włącznie).
+func HandleGetAIUsageReport(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "user")
+	if groupBy != "user" && groupBy != "subject" {
+		utils.SendError(c, http.StatusBadRequest, "Parametr group_by musi mieć wartość user lub subject")
+		return
+	}
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := from.AddDate(0, 1, 0)
+	if s := c.Query("from"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, now.Location())
+		if err != nil {
+			utils.SendError(c, http.StatusBadRequest, "Nieprawidłowa data from (oczekiwano RRRR-MM-DD)")
+			return
+		}
+		from = t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.ParseInLocation("2006-01-02", s, now.Location())
+		if err != nil {
+			utils.SendError(c, http.StatusBadRequest, "Nieprawidłowa data to (oczekiwano RRRR-MM-DD)")
+			return
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		utils.SendError(c, http.StatusBadRequest, "Data to nie może być wcześniejsza niż from")
+		return
+	}
+
+	rows, err := db.UserRepository.AIUsageReport(groupBy, from, to)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if rows == nil {
+		rows = []db.AIUsageReportRow{}
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"group_by": groupBy,
+		"from":     from.Format("2006-01-02"),
+		"to":       to.AddDate(0, 0, -1).Format("2006-01-02"),
+		"rows":     rows,
+	})
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// HandleImportAnkiDeck importuje talię Anki (.apkg z pola formularza "file") do tematu jako fiszki oczekujące
+// na moderację. Opcjonalnie: question_field i answer_field (nazwy pól notatki) oraz tags (tagi każdej fiszki).
+// Odpowiedź zawiera raport importu z pominiętymi notatkami i obrazami.
+func HandleImportAnkiDeck(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	topic := loadAccessibleTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+
+	// Zapas na nagłówki i pozostałe pola formularza - właściwy limit paczki sprawdza usługa importu
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.AnkiMaxPackageSize+1<<20)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.SendError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Paczka przekracza limit %d MB", services.AnkiMaxPackageSize>>20))
+			return
+		}
+		utils.SendError(c, http.StatusBadRequest, "Brak pliku w polu 'file'")
+		return
+	}
+	if fh.Size > services.AnkiMaxPackageSize {
+		utils.SendError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Paczka przekracza limit %d MB", services.AnkiMaxPackageSize>>20))
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, services.AnkiMaxPackageSize+1))
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	extra, err := services.NormalizeContentMetadata(models.ContentMetadata{Tags: formList(c, "tags")})
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe tagi: "+err.Error())
+		return
+	}
+
+	report, err := services.ImportAnkiPackage(data, services.AnkiImportOptions{
+		TopicID:       topic.ID,
+		UserUsosID:    userUsosID,
+		FileName:      fh.Filename,
+		QuestionField: strings.TrimSpace(c.PostForm("question_field")),
+		AnswerField:   strings.TrimSpace(c.PostForm("answer_field")),
+		Tags:          extra.Tags,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAnkiImportRateLimited):
+			utils.SendError(c, http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, services.ErrUnsupportedAnkiPackage):
+			utils.SendError(c, http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, services.ErrInvalidAnkiPackage), errors.Is(err, services.ErrTooManyAnkiNotes):
+			utils.SendError(c, http.StatusBadRequest, err.Error())
+		default:
+			log.Printf("HandleImportAnkiDeck: import przerwany po %d fiszkach: %v", report.Imported, err)
+			utils.SendInternalError(c, err)
+		}
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Zaimportowano %d z %d notatek. Fiszki czekają na moderację.", report.Imported, report.Notes),
+		"report":  report,
+	})
+}
+
+// HandleExportTopicAnki eksportuje zatwierdzone fiszki tematu i jego podtematów jako talię Anki (.apkg).
+// Obsługuje te same filtry co treść tematu (?tags, ?difficulty, ?bloom_level).
+func HandleExportTopicAnki(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	topic := loadAccessibleTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+	filter, ok := parseContentFilter(c)
+	if !ok {
+		return
+	}
+	subject, err := db.UserRepository.GetSubjectByID(topic.SubjectID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	topics, err := db.UserRepository.GetTopicsBySubjectID(subject.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	sendAnkiPackage(c, subject, topicSubtree(topics, topic.ID), topics, filter, topic.Name)
+}
+
+// HandleExportSubjectAnki eksportuje zatwierdzone fiszki wszystkich tematów przedmiotu jako talię Anki (.apkg)
+// z podtalią dla każdego tematu
+func HandleExportSubjectAnki(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	subject, err := db.UserRepository.GetSubjectByUsosID(c.Param("usos_id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono przedmiotu")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return
+	}
+	if !checkSubjectAccess(c, userUsosID, subject.ID) {
+		return
+	}
+	filter, ok := parseContentFilter(c)
+	if !ok {
+		return
+	}
+	topics, err := db.UserRepository.GetTopicsBySubjectID(subject.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	sendAnkiPackage(c, subject, topics, topics, filter, subject.Name)
+}
+
+// topicSubtree zwraca temat rootID i wszystkie jego podtematy (w kolejności tematów przedmiotu)
+func topicSubtree(topics []models.Topic, rootID uint) []models.Topic {
+	parents := make(map[uint]*uint, len(topics))
+	for _, t := range topics {
+		parents[t.ID] = t.ParentTopicID
+	}
+	var out []models.Topic
+	for _, t := range topics {
+		// Idziemy w górę drzewa; limit kroków chroni przed cyklem w danych
+		for id, steps := &t.ID, 0; id != nil && steps <= len(topics); id, steps = parents[*id], steps+1 {
+			if *id == rootID {
+				out = append(out, t)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// ankiDeckNames zwraca nazwy talii tematów w postaci "Przedmiot::Temat::Podtemat"
+func ankiDeckNames(subject *models.Subject, topics []models.Topic) map[uint]string {
+	byID := make(map[uint]models.Topic, len(topics))
+	for _, t := range topics {
+		byID[t.ID] = t
+	}
+	// "::" rozdziela poziomy talii w Anki, więc nie może wystąpić w nazwie
+	clean := func(s string) string { return strings.ReplaceAll(strings.TrimSpace(s), "::", ":") }
+	names := make(map[uint]string, len(topics))
+	for _, t := range topics {
+		path := []string{clean(t.Name)}
+		for p, steps := t.ParentTopicID, 0; p != nil && steps < len(topics); steps++ {
+			parent, ok := byID[*p]
+			if !ok {
+				break
+			}
+			path = append([]string{clean(parent.Name)}, path...)
+			p = parent.ParentTopicID
+		}
+		names[t.ID] = clean(subject.Name) + "::" + strings.Join(path, "::")
+	}
+	return names
+}
+
+// sendAnkiPackage wysyła paczkę .apkg z zatwierdzonymi fiszkami tematów (po jednej talii na temat).
+// allTopics to wszystkie tematy przedmiotu - potrzebne do pełnych nazw talii podtematów.
+func sendAnkiPackage(c *gin.Context, subject *models.Subject, topics, allTopics []models.Topic, filter db.ContentFilter, fileName string) {
+	ids := make([]uint, len(topics))
+	for i, t := range topics {
+		ids[i] = t.ID
+	}
+	flashcards, err := db.UserRepository.GetApprovedFlashcardsByTopics(ids, filter)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	byTopic := make(map[uint][]models.Flashcard)
+	for _, fc := range flashcards {
+		byTopic[fc.TopicID] = append(byTopic[fc.TopicID], fc)
+	}
+	names := ankiDeckNames(subject, allTopics)
+	var decks []services.AnkiDeck
+	for _, t := range topics {
+		if len(byTopic[t.ID]) > 0 {
+			decks = append(decks, services.AnkiDeck{Name: names[t.ID], Flashcards: byTopic[t.ID]})
+		}
+	}
+	if len(decks) == 0 {
+		utils.SendError(c, http.StatusNotFound, "Brak zatwierdzonych fiszek do eksportu")
+		return
+	}
+
+	data, err := services.ExportAnkiPackage(decks)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": ankiFileName(fileName)}))
+	c.Data(http.StatusOK, "application/octet-stream", data)
+}
+
+// ankiFileName tworzy nazwę pliku paczki z nazwy tematu lub przedmiotu
+func ankiFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = "fiszki"
+	}
+	return name + ".apkg"
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+)
+
+// wantsRenderedHTML sprawdza, czy klient poprosił o HTML wyrenderowany przez serwer (?render=html)
+func wantsRenderedHTML(c *gin.Context) bool {
+	return c.Query("render") == "html"
+}
+
+// HandleRenderContent zwraca podgląd pola w podanym formacie: tekst po oczyszczeniu (taki, jaki zostałby
+// zapisany) i jego HTML. Błąd walidacji zwraca 400 z tym samym komunikatem co zapis treści.
+func HandleRenderContent(c *gin.Context) {
+	var req struct {
+		Text   string             `json:"text"`
+		Format models.FieldFormat `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	text, format, err := services.SanitizeContent(req.Text, req.Format)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowa treść: "+err.Error())
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"text":   text,
+		"format": format,
+		"html":   services.RenderContentHTML(text, format),
+	})
+}
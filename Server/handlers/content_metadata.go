@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+)
+
+// tagUsageLimit to liczba najczęściej używanych tagów zwracanych przez HandleGetTags
+const tagUsageLimit = 50
+
+// parseContentFilter odczytuje filtr metadanych: ?tags=a,b (element musi mieć wszystkie), ?difficulty=, ?bloom_level=.
+// W razie błędu wysyła odpowiedź i zwraca ok == false.
+func parseContentFilter(c *gin.Context) (filter db.ContentFilter, ok bool) {
+	for _, v := range c.QueryArray("tags") {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				filter.Tags = append(filter.Tags, tag)
+			}
+		}
+	}
+	filter.Difficulty = strings.TrimSpace(c.Query("difficulty"))
+	filter.BloomLevel = strings.TrimSpace(c.Query("bloom_level"))
+	filter, err := services.NormalizeContentFilter(filter)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowy filtr: "+err.Error())
+		return filter, false
+	}
+	return filter, true
+}
+
+// contentMetadataRequest to metadane w treści żądania; pominięte pola pozostają bez zmian
+type contentMetadataRequest struct {
+	Tags       *[]string `json:"tags"`
+	Difficulty *string   `json:"difficulty"`
+	BloomLevel *string   `json:"bloom_level"`
+}
+
+// apply nakłada zmiany na metadane i je normalizuje
+func (r contentMetadataRequest) apply(m models.ContentMetadata) (models.ContentMetadata, error) {
+	if r.Tags != nil {
+		m.Tags = *r.Tags
+	}
+	if r.Difficulty != nil {
+		m.Difficulty = *r.Difficulty
+	}
+	if r.BloomLevel != nil {
+		m.BloomLevel = *r.BloomLevel
+	}
+	return services.NormalizeContentMetadata(m)
+}
+
+// setContentMetadata zapisuje metadane elementu i odsyła je w odpowiedzi
+func setContentMetadata(c *gin.Context, model interface{}, id uint, current models.ContentMetadata) {
+	var req contentMetadataRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	m, err := req.apply(current)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := db.UserRepository.SetContentMetadata(model, id, m); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"id": id, "tags": m.Tags, "difficulty": m.Difficulty, "bloom_level": m.BloomLevel})
+}
+
+// HandleSetFlashcardMetadata zmienia tagi, poziom trudności i kategorię Blooma fiszki
+// (autor przed zatwierdzeniem, moderator zawsze). Metadane nie zmieniają wersji treści.
+func HandleSetFlashcardMetadata(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	fc := loadFlashcard(c)
+	if fc == nil || !canEditContent(c, userUsosID, fc.CreatedByUsosID, fc.Status) {
+		return
+	}
+	setContentMetadata(c, &models.Flashcard{}, fc.ID, fc.ContentMetadata)
+}
+
+// HandleSetQuizQuestionMetadata zmienia tagi, poziom trudności i kategorię Blooma pytania quizowego
+func HandleSetQuizQuestionMetadata(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	q := loadQuizQuestion(c)
+	if q == nil || !canEditContent(c, userUsosID, q.CreatedByUsosID, q.Status) {
+		return
+	}
+	setContentMetadata(c, &models.QuizQuestion{}, q.ID, q.ContentMetadata)
+}
+
+// HandleGetTags zwraca kuratorowaną listę tagów oraz najczęściej używane tagi treści
+// z przedmiotów użytkownika, a także dozwolone poziomy trudności i kategorie Blooma
+func HandleGetTags(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	curated, err := db.UserRepository.GetContentTags()
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	subjectIDs, err := searchSubjectScope(userUsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	popular, err := db.UserRepository.GetTagUsage(subjectIDs, tagUsageLimit)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if curated == nil {
+		curated = []models.ContentTag{}
+	}
+	if popular == nil {
+		popular = []db.TagUsage{}
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"curated":      curated,
+		"popular":      popular,
+		"difficulties": services.ContentDifficulties,
+		"bloom_levels": services.BloomLevels,
+	})
+}
+
+// HandleSaveContentTag dodaje tag do listy kuratorowanej lub zmienia jego opis
+func HandleSaveContentTag(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req struct {
+		Description string `json:"description"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+			return
+		}
+	}
+	name, err := services.NormalizeTag(c.Param("name"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	description := strings.TrimSpace(req.Description)
+	if len([]rune(description)) > services.MaxTagDescriptionChars {
+		utils.SendError(c, http.StatusBadRequest, fmt.Sprintf("Opis jest za długi (maksimum %d znaków)", services.MaxTagDescriptionChars))
+		return
+	}
+	tag := &models.ContentTag{Name: name, Description: description, CreatedByUsosID: userUsosID}
+	if err := db.UserRepository.SaveContentTag(tag); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Tag %q zapisany", name), "name": name, "description": description})
+}
+
+// HandleDeleteContentTag usuwa tag z listy kuratorowanej. Treści zachowują go jako tag dowolny.
+func HandleDeleteContentTag(c *gin.Context) {
+	name, err := services.NormalizeTag(c.Param("name"))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	ok, err := db.UserRepository.DeleteContentTag(name)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !ok {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono tagu")
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Tag %q usunięty z listy", name)})
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// loadQuizQuestion pobiera pytanie z parametru :id. W razie błędu wysyła odpowiedź i zwraca nil.
+func loadQuizQuestion(c *gin.Context) *models.QuizQuestion {
+	questionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID pytania")
+		return nil
+	}
+	q, err := db.UserRepository.GetQuizQuestion(uint(questionID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono pytania")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return nil
+	}
+	return q
+}
+
+// hideUnapprovedExplanations usuwa z pytań wyjaśnienia, które nie przeszły jeszcze moderacji
+func hideUnapprovedExplanations(questions []models.QuizQuestion) {
+	for i := range questions {
+		if questions[i].ExplanationStatus != "approved" {
+			questions[i].OptionExplanations = nil
+		}
+	}
+}
+
+// HandleExplainQuizQuestion zwraca wyjaśnienia opcji zatwierdzonego pytania. Gdy pytanie ich nie ma,
+// generuje je przez AI i zapisuje do moderacji (odpowiedź 202). Administrator może wymusić ponowne
+// wygenerowanie odrzuconych wyjaśnień parametrem ?regenerate=true.
+func HandleExplainQuizQuestion(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	q := loadQuizQuestion(c)
+	if q == nil {
+		return
+	}
+	if q.Status != "approved" {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono pytania")
+		return
+	}
+	if !services.HasOptionExplanations(q.QuestionType) {
+		utils.SendError(c, http.StatusBadRequest, "Wyjaśnienia opcji dotyczą tylko pytań jednokrotnego i wielokrotnego wyboru")
+		return
+	}
+	topic, err := db.UserRepository.GetTopicByID(q.TopicID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !checkSubjectAccess(c, userUsosID, topic.SubjectID) {
+		return
+	}
+
+	regenerate := c.Query("regenerate") == "true" && isAdmin(userUsosID)
+	switch {
+	case q.ExplanationStatus == "approved" && !regenerate:
+		utils.SendSuccess(c, http.StatusOK, gin.H{"status": q.ExplanationStatus, "explanations": q.OptionExplanations})
+		return
+	case q.ExplanationStatus == "pending":
+		utils.SendSuccess(c, http.StatusAccepted, gin.H{"status": q.ExplanationStatus, "message": "Wyjaśnienie czeka na zatwierdzenie przez moderatora"})
+		return
+	case q.ExplanationStatus == "rejected" && !regenerate:
+		utils.SendError(c, http.StatusNotFound, "Wyjaśnienie tego pytania zostało odrzucone przez moderatora")
+		return
+	}
+	if !checkAIQuota(c, userUsosID) {
+		return
+	}
+
+	explanations, err := services.GenerateExplanations(c.Request.Context(), userUsosID, q)
+	if err != nil {
+		utils.SendError(c, http.StatusBadGateway, "Błąd generowania wyjaśnienia: "+err.Error())
+		return
+	}
+	// Warunkowy zapis - jeśli ktoś w międzyczasie wygenerował wyjaśnienie, zostaje to pierwsze
+	if _, err := db.UserRepository.SetQuizQuestionExplanations(q.ID, explanations, "pending", "", q.ExplanationStatus); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusAccepted, gin.H{"status": "pending", "message": "Wyjaśnienie wygenerowane - czeka na zatwierdzenie przez moderatora"})
+}
+
+// HandleUpdateQuizExplanations zapisuje wyjaśnienia opcji poprawione przez autora pytania lub moderatora.
+// Wyjaśnienia autora wracają do moderacji, moderatora są od razu zatwierdzone. Pusta lista usuwa wyjaśnienia.
+func HandleUpdateQuizExplanations(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	q := loadQuizQuestion(c)
+	if q == nil {
+		return
+	}
+	admin := isAdmin(userUsosID)
+	if q.CreatedByUsosID != userUsosID && !admin {
+		utils.SendError(c, http.StatusForbidden, "Wyjaśnienia może edytować tylko autor pytania lub moderator")
+		return
+	}
+	if !services.HasOptionExplanations(q.QuestionType) {
+		utils.SendError(c, http.StatusBadRequest, "Wyjaśnienia opcji dotyczą tylko pytań jednokrotnego i wielokrotnego wyboru")
+		return
+	}
+
+	var req struct {
+		Explanations []string `json:"explanations"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	explanations, err := services.NormalizeExplanations(req.Explanations, len(q.Options))
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	status := "pending"
+	switch {
+	case len(explanations) == 0:
+		status = ""
+	case admin:
+		status = "approved"
+	}
+	if _, err := db.UserRepository.SetQuizQuestionExplanations(q.ID, explanations, status); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"status": status, "explanations": explanations})
+}
+
+// HandleGetPendingExplanations zwraca zatwierdzone pytania z wyjaśnieniami czekającymi na moderację
+func HandleGetPendingExplanations(c *gin.Context) {
+	filter, ok := parseModerationFilter(c)
+	if !ok {
+		return
+	}
+	questions, err := db.UserRepository.GetPendingExplanations(filter)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if questions == nil {
+		questions = []models.QuizQuestion{}
+	}
+	utils.SendSuccess(c, http.StatusOK, questions)
+}
+
+func HandleApproveExplanation(c *gin.Context) {
+	setExplanationStatus(c, "approved", "zatwierdzone")
+}
+
+func HandleRejectExplanation(c *gin.Context) {
+	setExplanationStatus(c, "rejected", "odrzucone")
+}
+
+func setExplanationStatus(c *gin.Context, status, label string) {
+	questionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID pytania")
+		return
+	}
+	ok, err := db.UserRepository.SetExplanationStatus(uint(questionID), status)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !ok {
+		utils.SendError(c, http.StatusNotFound, "Pytanie nie ma wyjaśnień czekających na moderację")
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Wyjaśnienia pytania %d %s", questionID, label)})
+}
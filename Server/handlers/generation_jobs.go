@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// isAdmin sprawdza, czy użytkownik ma rolę administratora
+func isAdmin(userUsosID string) bool {
+	user, err := db.UserRepository.GetUserByUsosID(userUsosID)
+	return err == nil && user.Role == "admin"
+}
+
+// loadOwnedGenerationJob pobiera zadanie z parametru :id i sprawdza, czy należy do użytkownika (lub admina).
+// W razie błędu wysyła odpowiedź i zwraca nil.
+func loadOwnedGenerationJob(c *gin.Context) *models.GenerationJob {
+	userUsosID := c.MustGet("user_usos_id").(string)
+
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID zadania")
+		return nil
+	}
+
+	job, err := db.UserRepository.GetGenerationJob(uint(jobID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono zadania")
+			return nil
+		}
+		utils.SendInternalError(c, err)
+		return nil
+	}
+
+	if job.CreatedByUsosID != userUsosID && !isAdmin(userUsosID) {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono zadania")
+		return nil
+	}
+	return job
+}
+
+// nonNilIDs zamienia nil na pustą tablicę, aby w JSON zawsze trafiało [] zamiast null
+func nonNilIDs(ids []int64) []int64 {
+	if ids == nil {
+		return []int64{}
+	}
+	return ids
+}
+
+func generationJobResponse(job *models.GenerationJob) gin.H {
+	rejected := []string(job.RejectedItems)
+	if rejected == nil {
+		rejected = []string{}
+	}
+
+	return gin.H{
+		"id":                job.ID,
+		"topic_id":          job.TopicID,
+		"type":              job.Type,
+		"status":            job.Status,
+		"error":             job.Error,
+		"attempts":          job.Attempts,
+		"flashcard_ids":     nonNilIDs(job.FlashcardIDs),
+		"quiz_question_ids": nonNilIDs(job.QuizQuestionIDs),
+		"topic_note_ids":    nonNilIDs(job.TopicNoteIDs),
+		"rejected":          rejected,
+		"params":            job.Params,
+		"created_at":        job.CreatedAt,
+		"started_at":        job.StartedAt,
+		"finished_at":       job.FinishedAt,
+	}
+}
+
+// HandleGetGenerationJob zwraca status zadania oraz ID utworzonych treści lub błąd
+func HandleGetGenerationJob(c *gin.Context) {
+	job := loadOwnedGenerationJob(c)
+	if job == nil {
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, generationJobResponse(job))
+}
+
+// HandleCancelGenerationJob anuluje zadanie oczekujące lub trwające
+func HandleCancelGenerationJob(c *gin.Context) {
+	job := loadOwnedGenerationJob(c)
+	if job == nil {
+		return
+	}
+
+	ok, err := services.GenerationWorkers.Cancel(job.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !ok {
+		utils.SendError(c, http.StatusConflict, "Zadanie zostało już zakończone")
+		return
+	}
+
+	job, err = db.UserRepository.GetGenerationJob(job.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, generationJobResponse(job))
+}
+
+// HandleRetryGenerationJob ponownie kolejkuje zadanie zakończone błędem lub anulowane
+func HandleRetryGenerationJob(c *gin.Context) {
+	job := loadOwnedGenerationJob(c)
+	if job == nil {
+		return
+	}
+	if !checkAIQuota(c, job.CreatedByUsosID) {
+		return
+	}
+
+	ok, err := services.GenerationWorkers.Retry(job)
+	if errors.Is(err, services.ErrTooManyActiveJobs) {
+		utils.SendError(c, http.StatusTooManyRequests, "Masz już zbyt wiele trwających zadań generowania. Poczekaj na ich zakończenie.")
+		return
+	}
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !ok {
+		utils.SendError(c, http.StatusConflict, "Można ponowić tylko zadanie zakończone błędem lub anulowane")
+		return
+	}
+
+	job, err = db.UserRepository.GetGenerationJob(job.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusAccepted, generationJobResponse(job))
+}
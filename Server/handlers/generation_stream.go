@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+)
+
+// HandleContentUploadStream generuje treści synchronicznie i przesyła je klientowi jako Server-Sent Events:
+//   - "item"  - kolejna poprawna fiszka/pytanie odczytane z częściowej odpowiedzi modelu,
+//   - "done"  - ID zapisanych (oczekujących na moderację) treści oraz elementy odrzucone przez walidację,
+//   - "error" - komunikat błędu; strumień jest wtedy zamykany.
+//
+// Generowanie jest rejestrowane jako zadanie i wlicza się do limitu aktywnych zadań użytkownika.
+// Zamknięcie karty przeglądarki anuluje kontekst żądania i przerywa generowanie.
+func HandleContentUploadStream(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+
+	input := parseGenerationForm(c, userUsosID)
+	if input == nil {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Wyłącza buforowanie w Nginx
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	result, err := services.GenerationWorkers.Stream(ctx, *input, func(index int, item json.RawMessage) error {
+		c.SSEvent("item", gin.H{
+			"index": index,
+			"type":  input.Type,
+			"item":  item,
+		})
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if err != nil {
+		// Materiały zlecenia, które nie zostało zapisane jako zadanie, nie są nikomu potrzebne
+		services.Materials.Discard(input.SourceMaterialIDs)
+		if ctx.Err() != nil {
+			log.Printf("HandleContentUploadStream: klient %s przerwał generowanie", userUsosID)
+			return
+		}
+		log.Printf("HandleContentUploadStream: błąd generowania: %v", err)
+		message := err.Error()
+		switch {
+		case errors.Is(err, services.ErrTooManyActiveJobs):
+			message = "Masz już zbyt wiele trwających zadań generowania. Poczekaj na ich zakończenie."
+		case errors.Is(err, context.Canceled):
+			message = "Generowanie zostało anulowane."
+		}
+		c.SSEvent("error", gin.H{"error": message})
+		c.Writer.Flush()
+		return
+	}
+
+	rejected := result.Rejected
+	if rejected == nil {
+		rejected = []string{}
+	}
+
+	c.SSEvent("done", gin.H{
+		"message":           "Treści pomyślnie wygenerowane i wysłane do moderacji.",
+		"flashcard_ids":     nonNilIDs(result.FlashcardIDs),
+		"quiz_question_ids": nonNilIDs(result.QuizQuestionIDs),
+		"topic_note_ids":    nonNilIDs(result.TopicNoteIDs),
+		"rejected":          rejected,
+	})
+	c.Writer.Flush()
+}
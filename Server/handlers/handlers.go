@@ -1,8 +1,8 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,7 +14,7 @@ import (
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
-	"github.com/google/generative-ai-go/genai"
+	"github.com/lib/pq"
 	"github.com/skni-kod/InfQuizyTor/Server/db" // Importuj pakiet db
 	"github.com/skni-kod/InfQuizyTor/Server/models"
 	"github.com/skni-kod/InfQuizyTor/Server/services"
@@ -22,12 +22,59 @@ import (
 )
 
 func HandleGetPendingFlashcards(c *gin.Context) {
-	flashcards, err := db.UserRepository.GetPendingFlashcards()
+	filter, ok := parseModerationFilter(c)
+	if !ok {
+		return
+	}
+
+	flashcards, err := db.UserRepository.GetPendingFlashcards(filter)
 	if err != nil {
 		utils.SendError(c, http.StatusInternalServerError, "Błąd pobierania oczekujących fiszek: "+err.Error())
 		return
 	}
-	utils.SendSuccess(c, http.StatusOK, flashcards)
+
+	// Dołącz odnośniki do materiałów źródłowych, aby moderator mógł porównać fiszkę ze slajdem
+	lists := make([]pq.Int64Array, len(flashcards))
+	for i, fc := range flashcards {
+		lists[i] = fc.SourceMaterialIDs
+	}
+	refs, err := resolveSourceMaterials(lists...)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	// oraz oryginałów, jeśli fiszka wygląda na duplikat
+	var originalIDs []uint
+	for _, fc := range flashcards {
+		if fc.DuplicateOfID != nil {
+			originalIDs = append(originalIDs, *fc.DuplicateOfID)
+		}
+	}
+	originals, err := db.UserRepository.GetFlashcardsByIDs(originalIDs)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if err := attachFlashcardMedia(flashcards); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	originalByID := make(map[uint]*models.Flashcard, len(originals))
+	for i := range originals {
+		originalByID[originals[i].ID] = &originals[i]
+	}
+
+	result := make([]pendingFlashcard, len(flashcards))
+	for i, fc := range flashcards {
+		result[i] = pendingFlashcard{Flashcard: fc, Sources: refs(fc.SourceMaterialIDs)}
+		if fc.DuplicateOfID != nil {
+			result[i].DuplicateOf = originalByID[*fc.DuplicateOfID]
+		}
+		if wantsRenderedHTML(c) {
+			services.RenderFlashcard(&result[i].Flashcard)
+		}
+	}
+	utils.SendSuccess(c, http.StatusOK, result)
 }
 
 // HandleApproveFlashcard (Pełna implementacja)
@@ -43,6 +90,7 @@ func HandleApproveFlashcard(c *gin.Context) {
 		utils.SendError(c, http.StatusInternalServerError, "Błąd zatwierdzania fiszki: "+err.Error())
 		return
 	}
+	services.NotifySearchIndex()
 
 	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Fiszka %d zatwierdzona", flashcardID)})
 }
@@ -60,6 +108,7 @@ func HandleRejectFlashcard(c *gin.Context) {
 		utils.SendError(c, http.StatusInternalServerError, "Błąd odrzucania fiszki: "+err.Error())
 		return
 	}
+	services.NotifySearchIndex()
 
 	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Fiszka %d odrzucona", flashcardID)})
 }
@@ -388,27 +437,59 @@ func HandleGetTopicContent(c *gin.Context) {
 		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID tematu")
 		return
 	}
+	filter, ok := parseContentFilter(c)
+	if !ok {
+		return
+	}
 
 	// Pobierz zatwierdzone fiszki
-	flashcards, err := db.UserRepository.GetApprovedFlashcardsByTopic(uint(topicID))
+	flashcards, err := db.UserRepository.GetApprovedFlashcardsByTopic(uint(topicID), filter)
 	if err != nil {
 		utils.SendError(c, http.StatusInternalServerError, "Błąd pobierania fiszek: "+err.Error())
 		return
 	}
 
 	// Pobierz zatwierdzone pytania quizowe
-	questions, err := db.UserRepository.GetApprovedQuizQuestionsByTopic(uint(topicID))
+	questions, err := db.UserRepository.GetApprovedQuizQuestionsByTopic(uint(topicID), filter)
 	if err != nil {
 		utils.SendError(c, http.StatusInternalServerError, "Błąd pobierania pytań quizu: "+err.Error())
 		return
 	}
+	hideUnapprovedExplanations(questions)
+	hideAnswerKeys(questions)
+	if err := attachFlashcardMedia(flashcards); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if err := attachQuizQuestionMedia(questions); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if wantsRenderedHTML(c) {
+		for i := range flashcards {
+			services.RenderFlashcard(&flashcards[i])
+		}
+		for i := range questions {
+			services.RenderQuizQuestion(&questions[i])
+		}
+	}
 
-	// TODO: Pobierz podsumowanie (SourceNote)
+	// Pobierz zatwierdzone podsumowania (najnowsze jako główne)
+	notes, err := db.UserRepository.GetApprovedTopicNotesByTopic(uint(topicID))
+	if err != nil {
+		utils.SendError(c, http.StatusInternalServerError, "Błąd pobierania podsumowań: "+err.Error())
+		return
+	}
+	summary := ""
+	if len(notes) > 0 {
+		summary = notes[0].Body
+	}
 
 	utils.SendSuccess(c, http.StatusOK, gin.H{
 		"flashcards":     flashcards,
 		"quiz_questions": questions,
-		"summary":        "To jest podsumowanie z bazy danych (TODO)",
+		"summary":        summary,
+		"notes":          notes,
 	})
 }
 func HandleGetSubjects(c *gin.Context) {
@@ -468,9 +549,13 @@ func HandleSyncSubjects(c *gin.Context) {
 	for _, termCourses := range usosCourses.CourseEditions {
 		for _, course := range termCourses {
 			// Użyj funkcji z db.go, aby znaleźć lub utworzyć wpis
-			_, err := db.UserRepository.FindOrCreateSubjectByUsosID(course.CourseID, course.CourseName.PL)
+			subject, err := db.UserRepository.FindOrCreateSubjectByUsosID(course.CourseID, course.CourseName.PL)
 			if err != nil {
 				log.Printf("Błąd synchronizacji przedmiotu %s (ID: %s): %v", course.CourseName.PL, course.CourseID, err)
+				continue
+			}
+			if err := db.UserRepository.EnrollUserInSubject(userUsosID, subject.ID); err != nil {
+				log.Printf("Błąd zapisu użytkownika %s na przedmiot %s: %v", userUsosID, course.CourseID, err)
 			}
 		}
 	}
@@ -663,184 +748,238 @@ func HandleGetDashboardLeaderboard(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, ranking)
 }
-func HandleContentUpload(c *gin.Context) {
-	userUsosIDValue, _ := c.Get("user_usos_id")
-	userUsosID := userUsosIDValue.(string)
+
+// formList odczytuje pole formularza podane wielokrotnie lub jako lista rozdzielona przecinkami
+func formList(c *gin.Context, key string) []string {
+	var out []string
+	for _, v := range c.PostFormArray(key) {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+// formInt odczytuje opcjonalne pole liczbowe formularza (0, gdy go brak)
+func formInt(c *gin.Context, key string) (int, error) {
+	v := strings.TrimSpace(c.PostForm(key))
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// parseGenerationParams odczytuje i sprawdza parametry generowania z formularza
+// (count, difficulty, language, option_count, question_types, question_styles, focus_keywords)
+func parseGenerationParams(c *gin.Context, genType string) (models.GenerationParams, error) {
+	var params models.GenerationParams
+	var err error
+	if params.Count, err = formInt(c, "count"); err != nil {
+		return params, fmt.Errorf("nieprawidłowa liczba elementów")
+	}
+	if params.OptionCount, err = formInt(c, "option_count"); err != nil {
+		return params, fmt.Errorf("nieprawidłowa liczba opcji")
+	}
+	params.Difficulty = strings.TrimSpace(c.PostForm("difficulty"))
+	params.Language = strings.TrimSpace(c.PostForm("language"))
+	params.QuestionTypes = formList(c, "question_types")
+	params.QuestionStyles = formList(c, "question_styles")
+	params.FocusKeywords = formList(c, "focus_keywords")
+	return services.NormalizeGenerationParams(genType, params)
+}
+
+// parseGenerationForm odczytuje formularz generowania (type, notes, topic_id, parametry, files/images).
+// W razie błędu wysyła odpowiedź i zwraca nil.
+func parseGenerationForm(c *gin.Context, userUsosID string) *services.GenerationInput {
+	if !checkAIQuota(c, userUsosID) {
+		return nil
+	}
 
 	form, err := c.MultipartForm()
 	if err != nil {
 		utils.SendError(c, http.StatusBadRequest, "Błąd parsowania formularza: "+err.Error())
-		return
+		return nil
 	}
 
-	genType := form.Value["type"][0]
-	notes := form.Value["notes"][0]
-	topicIDStr := form.Value["topic_id"][0]
-	topicID, err := strconv.ParseUint(topicIDStr, 10, 32)
+	genType := c.PostForm("type")
+	notes := c.PostForm("notes")
+	topicID, err := strconv.ParseUint(c.PostForm("topic_id"), 10, 32)
 	if err != nil {
 		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID tematu")
-		return
+		return nil
+	}
+
+	switch genType {
+	case "flashcards", "quiz", "summary":
+	default:
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowy typ generowania")
+		return nil
+	}
+
+	params, err := parseGenerationParams(c, genType)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe parametry generowania: "+err.Error())
+		return nil
+	}
+
+	if loadAccessibleTopicByID(c, userUsosID, uint(topicID)) == nil {
+		return nil
+	}
+
+	// Limit sprawdzamy przed zapisaniem plików, żeby odrzucone zlecenie nie zostawiało materiałów
+	// (Submit i Stream sprawdzają go ponownie atomowo przy zapisie zadania)
+	active, err := db.UserRepository.CountActiveGenerationJobs(userUsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return nil
+	}
+	if active >= int64(services.GenerationWorkers.MaxActivePerUser) {
+		utils.SendError(c, http.StatusTooManyRequests, "Masz już zbyt wiele trwających zadań generowania. Poczekaj na ich zakończenie.")
+		return nil
 	}
 
-	files := form.File["images"]
-	var parts []genai.Part
+	// "images" zostaje dla zgodności ze starszym frontendem
+	files := append(form.File["images"], form.File["files"]...)
+	var attachments []services.Attachment
+	var materialIDs []int64
 
 	for _, file := range files {
 		log.Printf("Przetwarzanie pliku: %s", file.Filename)
+		if file.Size > services.Materials.MaxSize {
+			services.Materials.Discard(materialIDs)
+			utils.SendError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Plik %s przekracza limit %d MB", file.Filename, services.Materials.MaxSize>>20))
+			return nil
+		}
 		openedFile, err := file.Open()
 		if err != nil {
+			services.Materials.Discard(materialIDs)
 			utils.SendError(c, http.StatusInternalServerError, "Błąd otwierania pliku")
-			return
+			return nil
 		}
-		defer openedFile.Close()
-
 		fileBytes, err := io.ReadAll(openedFile)
+		openedFile.Close()
 		if err != nil {
+			services.Materials.Discard(materialIDs)
 			utils.SendError(c, http.StatusInternalServerError, "Błąd czytania pliku")
-			return
+			return nil
 		}
 
-		mimeType := http.DetectContentType(fileBytes)
-		if strings.HasSuffix(file.Filename, ".png") {
-			mimeType = "image/png"
-		}
-		if strings.HasSuffix(file.Filename, ".jpg") || strings.HasSuffix(file.Filename, ".jpeg") {
-			mimeType = "image/jpeg"
+		material, err := services.Materials.Save(file.Filename, fileBytes, userUsosID)
+		if err != nil {
+			services.Materials.Discard(materialIDs)
+			switch {
+			case errors.Is(err, services.ErrUnsupportedFormat):
+				utils.SendError(c, http.StatusUnsupportedMediaType, err.Error())
+			case errors.Is(err, services.ErrMaterialTooLarge):
+				utils.SendError(c, http.StatusRequestEntityTooLarge, err.Error())
+			default:
+				utils.SendInternalError(c, err)
+			}
+			return nil
 		}
 
-		parts = append(parts, genai.ImageData(mimeType, fileBytes))
+		attachments = append(attachments, services.Attachment{Name: file.Filename, MIMEType: material.MIMEType, Data: fileBytes})
+		materialIDs = append(materialIDs, int64(material.ID))
 	}
 
-	prompt := buildGenerationPrompt(genType, notes)
+	return &services.GenerationInput{
+		Type:              genType,
+		Notes:             notes,
+		TopicID:           uint(topicID),
+		UserUsosID:        userUsosID,
+		Attachments:       attachments,
+		SourceMaterialIDs: materialIDs,
+		Params:            params,
+	}
+}
 
-	geminiResponse, err := services.GeminiService.GenerateContent(context.Background(), prompt, parts)
-	if err != nil {
-		utils.SendError(c, http.StatusInternalServerError, err.Error())
+func HandleContentUpload(c *gin.Context) {
+	userUsosIDValue, _ := c.Get("user_usos_id")
+	userUsosID := userUsosIDValue.(string)
+
+	input := parseGenerationForm(c, userUsosID)
+	if input == nil {
 		return
 	}
 
-	// Zapis do bazy danych
-	err = saveGeneratedContent(geminiResponse, genType, uint(topicID), userUsosID)
+	job, err := services.GenerationWorkers.Submit(*input)
 	if err != nil {
-		utils.SendError(c, http.StatusInternalServerError, "Błąd zapisu wygenerowanych treści: "+err.Error())
+		services.Materials.Discard(input.SourceMaterialIDs)
+		if errors.Is(err, services.ErrTooManyActiveJobs) {
+			utils.SendError(c, http.StatusTooManyRequests, "Masz już zbyt wiele trwających zadań generowania. Poczekaj na ich zakończenie.")
+			return
+		}
+		utils.SendInternalError(c, err)
 		return
 	}
 
-	utils.SendSuccess(c, http.StatusCreated, gin.H{
-		"message": "Treści pomyślnie wygenerowane i wysłane do moderacji.",
+	utils.SendSuccess(c, http.StatusAccepted, gin.H{
+		"message": "Zadanie generowania przyjęte. Treści trafią do moderacji po jego zakończeniu.",
+		"job_id":  job.ID,
+		"status":  job.Status,
 	})
 }
 
-// saveGeneratedContent parsuje JSON z Gemini i zapisuje w DB
-func saveGeneratedContent(jsonResponse, genType string, topicID uint, userUsosID string) error {
-	// Usuwamy znaczniki Markdown, jeśli AI je dodało
-	jsonResponse = strings.TrimPrefix(jsonResponse, "```json\n")
-	jsonResponse = strings.TrimSuffix(jsonResponse, "\n```")
-
-	switch genType {
-	case "flashcards":
-		var flashcards []models.GeneratedFlashcard
-		if err := json.Unmarshal([]byte(jsonResponse), &flashcards); err != nil {
-			return fmt.Errorf("błąd parsowania JSON fiszek: %w", err)
-		}
-		for _, fc := range flashcards {
-			dbModel := models.Flashcard{
-				TopicID:         topicID,
-				Question:        fc.Question,
-				Answer:          fc.Answer,
-				Status:          "pending",
-				CreatedByUsosID: userUsosID,
-			}
-			if err := db.UserRepository.CreateFlashcard(&dbModel); err != nil {
-				log.Printf("Błąd zapisu fiszki do DB: %v", err)
-			}
-		}
-
-	case "quiz":
-		var questions []models.GeneratedQuizQuestion
-		if err := json.Unmarshal([]byte(jsonResponse), &questions); err != nil {
-			return fmt.Errorf("błąd parsowania JSON quizu: %w", err)
-		}
-		for _, q := range questions {
-			dbModel := models.QuizQuestion{
-				TopicID:            topicID,
-				QuestionText:       q.Question,
-				Options:            q.Options,
-				CorrectOptionIndex: q.CorrectIndex,
-				Status:             "pending",
-				CreatedByUsosID:    userUsosID,
-			}
-			if err := db.UserRepository.CreateQuizQuestion(&dbModel); err != nil {
-				log.Printf("Błąd zapisu pytania do DB: %v", err)
-			}
-		}
-	}
-	return nil
-}
-
 // HandleManualFlashcard (do ręcznego dodawania fiszek)
 func HandleManualFlashcard(c *gin.Context) {
 	userUsosIDValue, _ := c.Get("user_usos_id")
 	userUsosID := userUsosIDValue.(string)
 
 	var req struct {
-		TopicID  uint   `json:"topicId" binding:"required"`
-		Question string `json:"question" binding:"required"`
-		Answer   string `json:"answer" binding:"required"`
+		TopicID        uint               `json:"topicId" binding:"required"`
+		Question       string             `json:"question" binding:"required"`
+		Answer         string             `json:"answer" binding:"required"`
+		QuestionFormat models.FieldFormat `json:"question_format"`
+		AnswerFormat   models.FieldFormat `json:"answer_format"`
+		contentMetadataRequest
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
 		return
 	}
+	snapshot, err := services.NormalizeFlashcardSnapshot(models.RevisionSnapshot{
+		Question:       req.Question,
+		Answer:         req.Answer,
+		QuestionFormat: req.QuestionFormat,
+		AnswerFormat:   req.AnswerFormat,
+	})
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowa fiszka: "+err.Error())
+		return
+	}
+	metadata, err := req.contentMetadataRequest.apply(models.ContentMetadata{})
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowa fiszka: "+err.Error())
+		return
+	}
 
 	flashcard := &models.Flashcard{
 		TopicID:         req.TopicID,
-		Question:        req.Question,
-		Answer:          req.Answer,
 		Status:          "pending", // Ręczne też muszą być zatwierdzone
 		CreatedByUsosID: userUsosID,
+		ContentMetadata: metadata,
 	}
+	services.ApplyFlashcardSnapshot(flashcard, snapshot)
 
-	if err := db.UserRepository.CreateFlashcard(flashcard); err != nil {
+	dupIndex, err := services.NewDuplicateIndex(db.UserRepository, req.TopicID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if err := services.CreateFlashcardChecked(dupIndex, flashcard); err != nil {
 		utils.SendError(c, http.StatusInternalServerError, "Błąd zapisu fiszki: "+err.Error())
 		return
 	}
 
-	utils.SendSuccess(c, http.StatusCreated, gin.H{"message": "Fiszka wysłana do moderacji."})
-}
-
-// buildGenerationPrompt tworzy instrukcję dla AI
-func buildGenerationPrompt(genType string, notes string) string {
-	basePrompt := fmt.Sprintf(
-		"Jesteś ekspertem akademickim. Przeanalizuj poniższe materiały (slajdy i notatki). Twoim zadaniem jest wygenerowanie materiałów do nauki. Zwracasz TYLKO format JSON.\n\nMateriały dodatkowe (tekst):\n%s\n\n",
-		notes,
-	)
-
-	switch genType {
-	case "flashcards":
-		return basePrompt + `Wygeneruj 10 fiszek. Użyj DOKŁADNIE tego formatu JSON:
-[
-  {"question": "...", "answer": "..."},
-  {"question": "...", "answer": "..."}
-]`
-	case "quiz":
-		return basePrompt + `Wygeneruj 5 pytań quizowych (wielokrotnego wyboru, 4 opcje, 1 poprawna). Użyj DOKŁADNIE tego formatu JSON:
-[
-  {
-    "question": "...",
-    "options": ["Opcja A", "Opcja B", "Opcja C", "Opcja D"],
-    "correctIndex": 0
-  }
-]`
-	case "summary":
-		return basePrompt + `Wygeneruj podsumowanie (kluczowe punkty) w formacie Markdown. Użyj DOKŁADNIE tego formatu JSON:
-{
-  "summary": "### Nagłówek 1\n- Punkt 1\n- Punkt 2\n\n### Nagłówek 2\n- Punkt 3"
-}`
-	default:
-		return basePrompt + "Wygeneruj proste podsumowanie tekstu."
+	response := gin.H{"message": "Fiszka wysłana do moderacji."}
+	if flashcard.DuplicateOfID != nil {
+		response["message"] = "Fiszka wysłana do moderacji. Uwaga: jest bardzo podobna do istniejącej fiszki."
+		response["duplicate_of_id"] = *flashcard.DuplicateOfID
 	}
+	utils.SendSuccess(c, http.StatusCreated, response)
 }
 
 func HandleGetAllUserGroups(c *gin.Context) {
@@ -923,8 +1062,9 @@ func HandleApiProxy(c *gin.Context) {
 }
 
 type CreateTopicRequest struct {
-	SubjectID uint   `json:"subject_id" binding:"required"`
-	Name      string `json:"name" binding:"required"`
+	SubjectID     uint   `json:"subject_id" binding:"required"`
+	Name          string `json:"name" binding:"required"`
+	ParentTopicID *uint  `json:"parent_topic_id"` // opcjonalny temat nadrzędny (podtemat)
 }
 
 func HandleCreateTopic(c *gin.Context) {
@@ -937,8 +1077,16 @@ func HandleCreateTopic(c *gin.Context) {
 		return
 	}
 
+	if req.ParentTopicID != nil && *req.ParentTopicID == 0 {
+		req.ParentTopicID = nil
+	}
+	if req.ParentTopicID != nil && !checkTopicParent(c, req.SubjectID, 0, *req.ParentTopicID) {
+		return
+	}
+
 	topic := &models.Topic{
 		SubjectID:       req.SubjectID,
+		ParentTopicID:   req.ParentTopicID,
 		Name:            req.Name,
 		CreatedByUsosID: userUsosID,
 	}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+)
+
+// loadGraphSubject pobiera przedmiot z parametru :usos_id wraz z jego tematami.
+// W razie błędu wysyła odpowiedź i zwraca ok = false.
+func loadGraphSubject(c *gin.Context) (*models.Subject, []models.Topic, bool) {
+	subject, ok := promptTemplateSubject(c, c.Param("usos_id"))
+	if !ok {
+		return nil, nil, false
+	}
+	topics, err := db.UserRepository.GetTopicsBySubjectID(subject.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return nil, nil, false
+	}
+	return subject, topics, true
+}
+
+// sendGraphError odpowiada 422 ze szczegółami dla niepoprawnego grafu, a 502 dla innych błędów modelu
+func sendGraphError(c *gin.Context, err error) {
+	var graphErr *services.GraphValidationError
+	if errors.As(err, &graphErr) {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": graphErr.Error(), "problems": graphErr.Problems})
+		return
+	}
+	utils.SendError(c, http.StatusBadGateway, "Błąd generowania grafu: "+err.Error())
+}
+
+// HandleGenerateCourseGraph generuje przez AI graf wymagań między tematami przedmiotu
+// (opcjonalnie na podstawie sylabusa) i zapisuje go jako wersję roboczą do przejrzenia
+func HandleGenerateCourseGraph(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req struct {
+		Syllabus string `json:"syllabus"`
+	}
+	// Sylabus jest opcjonalny - puste ciało zapytania też jest poprawne
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	subject, topics, ok := loadGraphSubject(c)
+	if !ok {
+		return
+	}
+	if len(topics) < 2 {
+		utils.SendError(c, http.StatusBadRequest, "Przedmiot musi mieć co najmniej 2 tematy")
+		return
+	}
+	if !checkAIQuota(c, userUsosID) {
+		return
+	}
+
+	drafts, err := services.GenerateKnowledgeGraph(c.Request.Context(), userUsosID, subject, topics, req.Syllabus)
+	if err != nil {
+		sendGraphError(c, err)
+		return
+	}
+	nodes, err := db.UserRepository.ReplaceDraftQuizNodes(subject.UsosID, drafts)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusCreated, nodes)
+}
+
+// HandleGetCourseGraphDraft zwraca wersję roboczą grafu przedmiotu
+func HandleGetCourseGraphDraft(c *gin.Context) {
+	subject, ok := promptTemplateSubject(c, c.Param("usos_id"))
+	if !ok {
+		return
+	}
+	nodes, err := db.UserRepository.GetQuizNodes(subject.UsosID, models.QuizNodeDraft)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if nodes == nil {
+		nodes = []models.QuizNode{}
+	}
+	utils.SendSuccess(c, http.StatusOK, nodes)
+}
+
+// HandleUpdateCourseGraphDraft zastępuje wersję roboczą grafu poprawioną przez prowadzącego
+// (zależności podawane jako ID tematów; graf musi pozostać acykliczny)
+func HandleUpdateCourseGraphDraft(c *gin.Context) {
+	var req struct {
+		Nodes []services.GraphEdgeProposal `json:"nodes" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	subject, topics, ok := loadGraphSubject(c)
+	if !ok {
+		return
+	}
+
+	drafts, err := services.BuildGraphDrafts(topics, req.Nodes)
+	if err != nil {
+		sendGraphError(c, err)
+		return
+	}
+	nodes, err := db.UserRepository.ReplaceDraftQuizNodes(subject.UsosID, drafts)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, nodes)
+}
+
+// HandlePublishCourseGraph publikuje wersję roboczą grafu w miejsce obecnego grafu przedmiotu
+func HandlePublishCourseGraph(c *gin.Context) {
+	subject, ok := promptTemplateSubject(c, c.Param("usos_id"))
+	if !ok {
+		return
+	}
+	published, err := db.UserRepository.PublishDraftQuizNodes(subject.UsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if published == 0 {
+		utils.SendError(c, http.StatusNotFound, "Brak wersji roboczej grafu do opublikowania")
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"published": published})
+}
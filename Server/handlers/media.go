@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// mediaItem to właściciel załączników (fiszka lub pytanie) - tyle, ile potrzeba do sprawdzenia uprawnień
+type mediaItem struct {
+	Type      string
+	ID        uint
+	CreatedBy string
+	Status    string
+}
+
+// loadMediaItem pobiera fiszkę lub pytanie z parametru :id. W razie błędu wysyła odpowiedź i zwraca nil.
+func loadMediaItem(c *gin.Context, itemType string) *mediaItem {
+	if itemType == models.SearchItemFlashcard {
+		fc := loadFlashcard(c)
+		if fc == nil {
+			return nil
+		}
+		return &mediaItem{Type: itemType, ID: fc.ID, CreatedBy: fc.CreatedByUsosID, Status: fc.Status}
+	}
+	q := loadQuizQuestion(c)
+	if q == nil {
+		return nil
+	}
+	return &mediaItem{Type: itemType, ID: q.ID, CreatedBy: q.CreatedByUsosID, Status: q.Status}
+}
+
+// loadMediaOwner pobiera właściciela istniejącego załącznika. W razie błędu wysyła odpowiedź i zwraca nil.
+func loadMediaOwner(c *gin.Context, m *models.MediaAttachment) *mediaItem {
+	item := &mediaItem{Type: m.ItemType, ID: m.ItemID}
+	var err error
+	if m.ItemType == models.SearchItemFlashcard {
+		var fc *models.Flashcard
+		if fc, err = db.UserRepository.GetFlashcard(m.ItemID); err == nil {
+			item.CreatedBy, item.Status = fc.CreatedByUsosID, fc.Status
+		}
+	} else {
+		var q *models.QuizQuestion
+		if q, err = db.UserRepository.GetQuizQuestion(m.ItemID); err == nil {
+			item.CreatedBy, item.Status = q.CreatedByUsosID, q.Status
+		}
+	}
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono załącznika")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return nil
+	}
+	return item
+}
+
+// uploadMedia przyjmuje plik z pola formularza "file" (i opcjonalny "alt_text") jako załącznik elementu
+func uploadMedia(c *gin.Context, itemType string) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	item := loadMediaItem(c, itemType)
+	if item == nil || !canEditContent(c, userUsosID, item.CreatedBy, item.Status) {
+		return
+	}
+
+	// Zapas na nagłówki formularza - właściwy limit pliku sprawdza MediaStore
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.Media.MaxSize+1<<20)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.SendError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Plik przekracza limit %d MB", services.Media.MaxSize>>20))
+			return
+		}
+		utils.SendError(c, http.StatusBadRequest, "Brak pliku w polu 'file'")
+		return
+	}
+	if fh.Size > services.Media.MaxSize {
+		utils.SendError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("Plik przekracza limit %d MB", services.Media.MaxSize>>20))
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, services.Media.MaxSize+1))
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	m, err := services.Media.Upload(item.Type, item.ID, fh.Filename, c.PostForm("alt_text"), data, userUsosID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMediaTooLarge):
+			utils.SendError(c, http.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, services.ErrUnsupportedMedia):
+			utils.SendError(c, http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, services.ErrInvalidMediaContent), errors.Is(err, services.ErrTooManyAttachments):
+			utils.SendError(c, http.StatusBadRequest, err.Error())
+		default:
+			utils.SendInternalError(c, err)
+		}
+		return
+	}
+	utils.SendSuccess(c, http.StatusCreated, m)
+}
+
+// listMedia zwraca załączniki elementu z podpisanymi adresami
+func listMedia(c *gin.Context, itemType string) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	item := loadMediaItem(c, itemType)
+	if item == nil || !canViewContent(c, userUsosID, item.CreatedBy, item.Status) {
+		return
+	}
+	attachments, err := db.UserRepository.GetMediaAttachments(item.Type, []uint{item.ID})
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if attachments == nil {
+		attachments = []models.MediaAttachment{}
+	}
+	services.Media.SignAll(attachments)
+	utils.SendSuccess(c, http.StatusOK, attachments)
+}
+
+// HandleUploadFlashcardMedia dodaje obraz do fiszki (autor przed zatwierdzeniem, moderator zawsze)
+func HandleUploadFlashcardMedia(c *gin.Context) { uploadMedia(c, models.SearchItemFlashcard) }
+
+// HandleGetFlashcardMedia zwraca załączniki fiszki
+func HandleGetFlashcardMedia(c *gin.Context) { listMedia(c, models.SearchItemFlashcard) }
+
+// HandleUploadQuizQuestionMedia dodaje obraz do pytania quizowego
+func HandleUploadQuizQuestionMedia(c *gin.Context) { uploadMedia(c, models.SearchItemQuizQuestion) }
+
+// HandleGetQuizQuestionMedia zwraca załączniki pytania quizowego
+func HandleGetQuizQuestionMedia(c *gin.Context) { listMedia(c, models.SearchItemQuizQuestion) }
+
+// HandleDeleteMedia usuwa załącznik (te same uprawnienia co edycja jego fiszki lub pytania)
+func HandleDeleteMedia(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	m := loadMediaAttachment(c)
+	if m == nil {
+		return
+	}
+	item := loadMediaOwner(c, m)
+	if item == nil || !canEditContent(c, userUsosID, item.CreatedBy, item.Status) {
+		return
+	}
+	if err := services.Media.Delete(*m); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Załącznik %d usunięty", m.ID)})
+}
+
+func loadMediaAttachment(c *gin.Context) *models.MediaAttachment {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID załącznika")
+		return nil
+	}
+	m, err := db.UserRepository.GetMediaAttachment(uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono załącznika")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return nil
+	}
+	return m
+}
+
+// HandleServeMedia zwraca plik załącznika pod podpisanym adresem (/media/:id?expires=...&sig=...).
+// Endpoint nie wymaga sesji - dostęp daje ważny podpis wystawiony przez API.
+func HandleServeMedia(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono załącznika")
+		return
+	}
+	m, err := db.UserRepository.GetMediaAttachment(uint(id))
+	if err != nil || !services.Media.Verify(m, c.Query("expires"), c.Query("sig")) {
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("HandleServeMedia: %v", err)
+		}
+		// Ta sama odpowiedź dla braku załącznika i złego podpisu - nie zdradzamy, które ID istnieją
+		utils.SendError(c, http.StatusForbidden, "Adres załącznika jest nieprawidłowy lub wygasł")
+		return
+	}
+	data, err := services.Media.Read(m)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	maxAge := 0
+	if exp, err := strconv.ParseInt(c.Query("expires"), 10, 64); err == nil {
+		maxAge = int(time.Until(time.Unix(exp, 0)).Seconds())
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": m.OriginalName}))
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", max(maxAge, 0)))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	c.Data(http.StatusOK, m.MIMEType, data)
+}
+
+// attachMedia wczytuje załączniki elementów jednego typu i zwraca je pogrupowane według ID elementu (z podpisanymi adresami)
+func attachMedia(itemType string, ids []uint) (map[uint][]models.MediaAttachment, error) {
+	attachments, err := db.UserRepository.GetMediaAttachments(itemType, ids)
+	if err != nil {
+		return nil, err
+	}
+	services.Media.SignAll(attachments)
+	byItem := make(map[uint][]models.MediaAttachment)
+	for _, m := range attachments {
+		byItem[m.ItemID] = append(byItem[m.ItemID], m)
+	}
+	return byItem, nil
+}
+
+// attachFlashcardMedia uzupełnia Attachments fiszek
+func attachFlashcardMedia(flashcards []models.Flashcard) error {
+	ids := make([]uint, len(flashcards))
+	for i := range flashcards {
+		ids[i] = flashcards[i].ID
+	}
+	byItem, err := attachMedia(models.SearchItemFlashcard, ids)
+	if err != nil {
+		return err
+	}
+	for i := range flashcards {
+		flashcards[i].Attachments = byItem[flashcards[i].ID]
+	}
+	return nil
+}
+
+// attachQuizQuestionMedia uzupełnia Attachments pytań
+func attachQuizQuestionMedia(questions []models.QuizQuestion) error {
+	ids := make([]uint, len(questions))
+	for i := range questions {
+		ids[i] = questions[i].ID
+	}
+	byItem, err := attachMedia(models.SearchItemQuizQuestion, ids)
+	if err != nil {
+		return err
+	}
+	for i := range questions {
+		questions[i].Attachments = byItem[questions[i].ID]
+	}
+	return nil
+}
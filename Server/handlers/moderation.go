@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// Elementy kolejki moderacji: treść wraz z materiałami źródłowymi i (dla duplikatów) oryginałem
+
+type pendingFlashcard struct {
+	models.Flashcard
+	Sources     []sourceMaterialRef
+	DuplicateOf *models.Flashcard
+}
+
+type pendingQuizQuestion struct {
+	models.QuizQuestion
+	Sources     []sourceMaterialRef
+	DuplicateOf *models.QuizQuestion
+}
+
+type pendingTopicNote struct {
+	models.TopicNote
+	Sources []sourceMaterialRef
+}
+
+// parseModerationFilter odczytuje parametry kolejki moderacji:
+// ?sort=score|-score, ?min_score=, ?max_score= (0-100), ?unscored=true oraz filtr metadanych (parseContentFilter).
+// W razie błędu wysyła odpowiedź i zwraca ok == false.
+func parseModerationFilter(c *gin.Context) (filter db.ModerationFilter, ok bool) {
+	switch sort := c.Query("sort"); sort {
+	case "", "score", "-score":
+		filter.Sort = sort
+	default:
+		utils.SendError(c, http.StatusBadRequest, `Parametr "sort" musi mieć wartość "score" lub "-score"`)
+		return filter, false
+	}
+
+	for param, dest := range map[string]**int{"min_score": &filter.MinScore, "max_score": &filter.MaxScore} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 || value > 100 {
+			utils.SendError(c, http.StatusBadRequest, fmt.Sprintf("Parametr %q musi być liczbą od 0 do 100", param))
+			return filter, false
+		}
+		*dest = &value
+	}
+
+	filter.Unscored = c.Query("unscored") == "true"
+	filter.Content, ok = parseContentFilter(c)
+	return filter, ok
+}
+
+// HandleSetSubjectAutoReject ustawia (lub wyłącza, gdy "threshold" == null) próg automatycznego
+// odrzucania treści przedmiotu na podstawie wstępnej oceny AI
+func HandleSetSubjectAutoReject(c *gin.Context) {
+	var req struct {
+		Threshold *int `json:"threshold"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	if req.Threshold != nil && (*req.Threshold < 0 || *req.Threshold > 100) {
+		utils.SendError(c, http.StatusBadRequest, "Próg musi być liczbą od 0 do 100")
+		return
+	}
+
+	ok, err := db.UserRepository.SetSubjectAutoRejectThreshold(c.Param("usos_id"), req.Threshold)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !ok {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono przedmiotu")
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": "Próg automatycznego odrzucania zapisany", "threshold": req.Threshold})
+}
+
+// mergeRequest wskazuje, z czym scalić duplikat i którego sformułowania użyć.
+// Domyślnie celem jest oryginał wykryty przy zapisie, a zachowane zostaje jego sformułowanie.
+type mergeRequest struct {
+	TargetID uint   `json:"target_id"`
+	Keep     string `json:"keep"` // "original" (domyślnie) lub "duplicate"
+}
+
+// parseMergeRequest odczytuje :id oraz treść żądania scalenia. W razie błędu wysyła odpowiedź i zwraca ok == false.
+func parseMergeRequest(c *gin.Context) (duplicateID uint, req mergeRequest, ok bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID")
+		return 0, req, false
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+			return 0, req, false
+		}
+	}
+	switch req.Keep {
+	case "":
+		req.Keep = "original"
+	case "original", "duplicate":
+	default:
+		utils.SendError(c, http.StatusBadRequest, `Pole "keep" musi mieć wartość "original" lub "duplicate"`)
+		return 0, req, false
+	}
+	return uint(id), req, true
+}
+
+// mergeTargetID ustala cel scalenia i sprawdza, czy scalenie ma sens. W razie błędu wysyła odpowiedź.
+func mergeTargetID(c *gin.Context, duplicateID uint, status string, detected *uint, requested uint) (uint, bool) {
+	if status == "merged" {
+		utils.SendError(c, http.StatusConflict, "Ten element został już scalony")
+		return 0, false
+	}
+	target := requested
+	if target == 0 && detected != nil {
+		target = *detected
+	}
+	if target == 0 {
+		utils.SendError(c, http.StatusBadRequest, "Element nie jest oznaczony jako duplikat - podaj target_id")
+		return 0, false
+	}
+	if target == duplicateID {
+		utils.SendError(c, http.StatusBadRequest, "Nie można scalić elementu z samym sobą")
+		return 0, false
+	}
+	return target, true
+}
+
+// mergeTargetLookupOK obsługuje błąd pobrania elementu docelowego
+func mergeTargetLookupOK(c *gin.Context, err error) bool {
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono elementu docelowego")
+			return false
+		}
+		utils.SendInternalError(c, err)
+		return false
+	}
+	return true
+}
+
+// mergeTargetValid sprawdza, czy cel pochodzi z tego samego tematu i nadal jest aktywny, a sformułowanie
+// przejmowane od duplikatu (keep == "duplicate") nie zostało wcześniej odrzucone
+func mergeTargetValid(c *gin.Context, req mergeRequest, duplicateStatus, targetStatus string, sameTopic bool) bool {
+	if req.Keep == "duplicate" && duplicateStatus != "pending" {
+		utils.SendError(c, http.StatusConflict, "Sformułowanie można przejąć tylko od duplikatu oczekującego na moderację")
+		return false
+	}
+	if !sameTopic {
+		utils.SendError(c, http.StatusBadRequest, "Można scalać tylko elementy z tego samego tematu")
+		return false
+	}
+	if targetStatus == "merged" || targetStatus == "rejected" {
+		utils.SendError(c, http.StatusConflict, "Element docelowy został odrzucony lub scalony")
+		return false
+	}
+	return true
+}
+
+// unionIDs łączy listy ID bez powtórzeń, zachowując kolejność
+func unionIDs(a, b pq.Int64Array) pq.Int64Array {
+	seen := make(map[int64]bool, len(a)+len(b))
+	out := pq.Int64Array{}
+	for _, id := range append(append(pq.Int64Array{}, a...), b...) {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// HandleMergeFlashcard scala fiszkę-duplikat z oryginałem (lub wskazaną fiszką), zachowując lepsze sformułowanie.
+// Dostępne tylko dla moderatorów: przejęcie sformułowania duplikatu zmienia treść celu bez ponownej moderacji
+// (także zatwierdzonego), dlatego zmiana trafia do historii celu.
+func HandleMergeFlashcard(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	duplicateID, req, ok := parseMergeRequest(c)
+	if !ok {
+		return
+	}
+
+	duplicate, err := db.UserRepository.GetFlashcard(duplicateID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono fiszki")
+			return
+		}
+		utils.SendInternalError(c, err)
+		return
+	}
+	targetID, ok := mergeTargetID(c, duplicate.ID, duplicate.Status, duplicate.DuplicateOfID, req.TargetID)
+	if !ok {
+		return
+	}
+	target, err := db.UserRepository.GetFlashcard(targetID)
+	if !mergeTargetLookupOK(c, err) || !mergeTargetValid(c, req, duplicate.Status, target.Status, target.TopicID == duplicate.TopicID) {
+		return
+	}
+
+	before := services.FlashcardSnapshot(target)
+	if req.Keep == "duplicate" {
+		target.Question = duplicate.Question
+		target.Answer = duplicate.Answer
+		target.QuestionFormat = duplicate.QuestionFormat
+		target.AnswerFormat = duplicate.AnswerFormat
+	}
+	target.SourceMaterialIDs = unionIDs(target.SourceMaterialIDs, duplicate.SourceMaterialIDs)
+	target.ContentMetadata = services.MergeContentMetadata(target.ContentMetadata, duplicate.ContentMetadata)
+
+	rev := services.MergeRevision(models.SearchItemFlashcard, before, services.FlashcardSnapshot(target), userUsosID, duplicate.ID)
+	saved, err := db.UserRepository.MergeFlashcard(duplicate.ID, target, req.Keep == "duplicate", rev)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !saved {
+		utils.SendError(c, http.StatusConflict, "Element docelowy został zmieniony w międzyczasie - odśwież i spróbuj ponownie")
+		return
+	}
+	if rev != nil {
+		target.Version = rev.Version
+	}
+	services.NotifySearchIndex()
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"message":   fmt.Sprintf("Fiszka %d scalona z fiszką %d", duplicate.ID, target.ID),
+		"flashcard": target,
+	})
+}
+
+// HandleMergeQuizQuestion scala pytanie-duplikat z oryginałem (lub wskazanym pytaniem); zasady jak w HandleMergeFlashcard
+func HandleMergeQuizQuestion(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	duplicateID, req, ok := parseMergeRequest(c)
+	if !ok {
+		return
+	}
+
+	duplicate, err := db.UserRepository.GetQuizQuestion(duplicateID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono pytania")
+			return
+		}
+		utils.SendInternalError(c, err)
+		return
+	}
+	targetID, ok := mergeTargetID(c, duplicate.ID, duplicate.Status, duplicate.DuplicateOfID, req.TargetID)
+	if !ok {
+		return
+	}
+	target, err := db.UserRepository.GetQuizQuestion(targetID)
+	if !mergeTargetLookupOK(c, err) || !mergeTargetValid(c, req, duplicate.Status, target.Status, target.TopicID == duplicate.TopicID) {
+		return
+	}
+
+	before := services.QuizQuestionSnapshot(target)
+	if req.Keep == "duplicate" {
+		target.QuestionText = duplicate.QuestionText
+		target.Options = duplicate.Options
+		target.QuestionType = duplicate.QuestionType
+		target.CorrectOptionIndex = duplicate.CorrectOptionIndex
+		target.ReferenceAnswer = duplicate.ReferenceAnswer
+		target.Rubric = duplicate.Rubric
+		target.Payload = duplicate.Payload
+		target.QuestionFormat = duplicate.QuestionFormat
+		target.OptionsFormat = duplicate.OptionsFormat
+		target.ReferenceAnswerFormat = duplicate.ReferenceAnswerFormat
+		target.OptionExplanations = duplicate.OptionExplanations
+		target.ExplanationStatus = duplicate.ExplanationStatus
+	}
+	target.SourceMaterialIDs = unionIDs(target.SourceMaterialIDs, duplicate.SourceMaterialIDs)
+	target.ContentMetadata = services.MergeContentMetadata(target.ContentMetadata, duplicate.ContentMetadata)
+
+	rev := services.MergeRevision(models.SearchItemQuizQuestion, before, services.QuizQuestionSnapshot(target), userUsosID, duplicate.ID)
+	saved, err := db.UserRepository.MergeQuizQuestion(duplicate.ID, target, req.Keep == "duplicate", rev)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !saved {
+		utils.SendError(c, http.StatusConflict, "Element docelowy został zmieniony w międzyczasie - odśwież i spróbuj ponownie")
+		return
+	}
+	if rev != nil {
+		target.Version = rev.Version
+	}
+	services.NotifySearchIndex()
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("Pytanie %d scalone z pytaniem %d", duplicate.ID, target.ID),
+		"question": target,
+	})
+}
+
+func HandleGetPendingQuizQuestions(c *gin.Context) {
+	filter, ok := parseModerationFilter(c)
+	if !ok {
+		return
+	}
+
+	questions, err := db.UserRepository.GetPendingQuizQuestions(filter)
+	if err != nil {
+		utils.SendError(c, http.StatusInternalServerError, "Błąd pobierania oczekujących pytań: "+err.Error())
+		return
+	}
+
+	lists := make([]pq.Int64Array, len(questions))
+	var originalIDs []uint
+	for i, q := range questions {
+		lists[i] = q.SourceMaterialIDs
+		if q.DuplicateOfID != nil {
+			originalIDs = append(originalIDs, *q.DuplicateOfID)
+		}
+	}
+	refs, err := resolveSourceMaterials(lists...)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	originals, err := db.UserRepository.GetQuizQuestionsByIDs(originalIDs)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if err := attachQuizQuestionMedia(questions); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	originalByID := make(map[uint]*models.QuizQuestion, len(originals))
+	for i := range originals {
+		originalByID[originals[i].ID] = &originals[i]
+	}
+
+	result := make([]pendingQuizQuestion, len(questions))
+	for i, q := range questions {
+		result[i] = pendingQuizQuestion{QuizQuestion: q, Sources: refs(q.SourceMaterialIDs)}
+		if q.DuplicateOfID != nil {
+			result[i].DuplicateOf = originalByID[*q.DuplicateOfID]
+		}
+		if wantsRenderedHTML(c) {
+			services.RenderQuizQuestion(&result[i].QuizQuestion)
+		}
+	}
+	utils.SendSuccess(c, http.StatusOK, result)
+}
+
+func HandleApproveQuizQuestion(c *gin.Context) {
+	setQuizQuestionStatus(c, "approved", "zatwierdzone")
+}
+
+func HandleRejectQuizQuestion(c *gin.Context) {
+	setQuizQuestionStatus(c, "rejected", "odrzucone")
+}
+
+func setQuizQuestionStatus(c *gin.Context, status, label string) {
+	questionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID pytania")
+		return
+	}
+
+	if err := db.UserRepository.SetQuizQuestionStatus(uint(questionID), status); err != nil {
+		utils.SendError(c, http.StatusInternalServerError, "Błąd zmiany statusu pytania: "+err.Error())
+		return
+	}
+	services.NotifySearchIndex()
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Pytanie %d %s", questionID, label)})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// promptTemplateName odczytuje nazwę szablonu z URL; przy nieznanej nazwie odpowiada 404 i zwraca ""
+func promptTemplateName(c *gin.Context) string {
+	name := c.Param("name")
+	if !services.IsPromptTemplateName(name) {
+		utils.SendError(c, http.StatusNotFound, "Nieznany szablon promptu")
+		return ""
+	}
+	return name
+}
+
+// promptTemplateSubject zamienia USOS ID przedmiotu na jego ID (nil dla szablonu globalnego).
+// Przy nieznanym przedmiocie odpowiada 404 i zwraca ok = false.
+func promptTemplateSubject(c *gin.Context, usosID string) (subject *models.Subject, ok bool) {
+	if usosID == "" {
+		return nil, true
+	}
+	subject, err := db.UserRepository.GetSubjectByUsosID(usosID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono przedmiotu")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return nil, false
+	}
+	return subject, true
+}
+
+func subjectIDPtr(subject *models.Subject) *uint {
+	if subject == nil {
+		return nil
+	}
+	return &subject.ID
+}
+
+// HandleGetPromptTemplates zwraca obowiązujące wersje szablonów globalnych i nadpisań przedmiotów
+func HandleGetPromptTemplates(c *gin.Context) {
+	templates, err := db.UserRepository.GetCurrentPromptTemplates()
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"names": services.PromptTemplateNames, "templates": templates})
+}
+
+// HandleGetPromptTemplateVersions zwraca historię wersji szablonu (?subject_usos_id= dla nadpisania przedmiotu)
+func HandleGetPromptTemplateVersions(c *gin.Context) {
+	name := promptTemplateName(c)
+	if name == "" {
+		return
+	}
+	subject, ok := promptTemplateSubject(c, c.Query("subject_usos_id"))
+	if !ok {
+		return
+	}
+
+	versions, err := db.UserRepository.GetPromptTemplateVersions(name, subjectIDPtr(subject))
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, versions)
+}
+
+// HandleUpdatePromptTemplate zapisuje nową wersję szablonu (globalnego lub nadpisania przedmiotu)
+func HandleUpdatePromptTemplate(c *gin.Context) {
+	name := promptTemplateName(c)
+	if name == "" {
+		return
+	}
+	var req struct {
+		Body          string `json:"body" binding:"required"`
+		SubjectUsosID string `json:"subject_usos_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	if err := services.ValidatePromptTemplate(name, req.Body); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Błąd w szablonie: "+err.Error())
+		return
+	}
+	subject, ok := promptTemplateSubject(c, req.SubjectUsosID)
+	if !ok {
+		return
+	}
+
+	t := &models.PromptTemplate{
+		Name:            name,
+		SubjectID:       subjectIDPtr(subject),
+		Body:            req.Body,
+		CreatedByUsosID: c.MustGet("user_usos_id").(string),
+	}
+	if err := db.UserRepository.CreatePromptTemplateVersion(t); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusCreated, t)
+}
+
+// HandlePreviewPromptTemplate wypełnia szablon przykładowymi lub podanymi zmiennymi, nie zapisując go.
+// Bez "body" używa szablonu obowiązującego dla przedmiotu (lub globalnego).
+func HandlePreviewPromptTemplate(c *gin.Context) {
+	name := promptTemplateName(c)
+	if name == "" {
+		return
+	}
+	var req struct {
+		Body          *string `json:"body"`
+		SubjectUsosID string  `json:"subject_usos_id"`
+		TopicName     string  `json:"topic_name"`
+		Notes         string  `json:"notes"`
+		Documents     string  `json:"documents"`
+		models.GenerationParams
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	subject, ok := promptTemplateSubject(c, req.SubjectUsosID)
+	if !ok {
+		return
+	}
+
+	var version int
+	body := ""
+	if req.Body != nil {
+		body = *req.Body
+	} else {
+		var subjectID uint
+		if subject != nil {
+			subjectID = subject.ID
+		}
+		t, err := services.ResolvePromptTemplate(name, subjectID)
+		if err != nil {
+			utils.SendInternalError(c, err)
+			return
+		}
+		body, version = t.Body, t.Version
+	}
+
+	params, err := services.NormalizeGenerationParams(name, req.GenerationParams)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe parametry generowania: "+err.Error())
+		return
+	}
+	data := services.PromptDataFor(name, params)
+	data.Notes, data.Documents, data.TopicName = req.Notes, req.Documents, req.TopicName
+	if subject != nil {
+		data.SubjectName = subject.Name
+	}
+
+	tmpl, err := services.ParsePromptTemplate(name, body)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Błąd w szablonie: "+err.Error())
+		return
+	}
+	rendered, err := services.RenderPromptTemplate(tmpl, data)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Błąd wypełniania szablonu: "+err.Error())
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"prompt": rendered, "version": version})
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// hideAnswerKeys usuwa z pytań klucze odpowiedzi sprawdzanych na serwerze (widoczne tylko po odpowiedzi):
+// odpowiedź wzorcową i kryteria oceny pytań otwartych, Payload pozostałych typów i kolejność kroków
+func hideAnswerKeys(questions []models.QuizQuestion) {
+	for i := range questions {
+		questions[i].ReferenceAnswer = ""
+		questions[i].Rubric = nil
+		questions[i].Payload = nil
+		services.ShuffleForStudent(&questions[i])
+	}
+}
+
+// HandleCreateQuizQuestion zapisuje pytanie utworzone ręcznie przez użytkownika (do moderacji).
+// Wymagane pola zależą od typu pytania (services.NormalizeQuizSnapshot): np. pytanie jednokrotnego wyboru
+// wymaga opcji i poprawnej odpowiedzi, otwarte - odpowiedzi wzorcowej i kryteriów oceny, pozostałe - pola payload.
+func HandleCreateQuizQuestion(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req struct {
+		Question        string                  `json:"question" binding:"required"`
+		QuestionType    string                  `json:"question_type"`
+		Options         []string                `json:"options"`
+		CorrectIndex    int                     `json:"correct_index"`
+		ReferenceAnswer string                  `json:"reference_answer"`
+		Rubric          []models.RubricPoint    `json:"rubric"`
+		Payload         *models.QuestionPayload `json:"payload"`
+
+		QuestionFormat        models.FieldFormat `json:"question_format"`
+		OptionsFormat         models.FieldFormat `json:"options_format"`
+		ReferenceAnswerFormat models.FieldFormat `json:"reference_answer_format"`
+		contentMetadataRequest
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	topic := loadAccessibleTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+
+	if req.QuestionType == "" {
+		req.QuestionType = models.QuestionTypeSingleChoice
+	}
+	snapshot, err := services.NormalizeQuizSnapshot(req.QuestionType, models.RevisionSnapshot{
+		Question:        req.Question,
+		Options:         req.Options,
+		CorrectIndex:    req.CorrectIndex,
+		ReferenceAnswer: req.ReferenceAnswer,
+		Rubric:          req.Rubric,
+		Payload:         req.Payload,
+
+		QuestionFormat:        req.QuestionFormat,
+		OptionsFormat:         req.OptionsFormat,
+		ReferenceAnswerFormat: req.ReferenceAnswerFormat,
+	})
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe pytanie: "+err.Error())
+		return
+	}
+	metadata, err := req.contentMetadataRequest.apply(models.ContentMetadata{})
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe pytanie: "+err.Error())
+		return
+	}
+	q := &models.QuizQuestion{
+		TopicID:         topic.ID,
+		QuestionType:    req.QuestionType,
+		Status:          "pending",
+		CreatedByUsosID: userUsosID,
+		ContentMetadata: metadata,
+	}
+	services.ApplyQuizSnapshot(q, snapshot)
+
+	dupIndex, err := services.NewDuplicateIndex(db.UserRepository, topic.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if err := services.CreateQuizQuestionChecked(dupIndex, q); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	response := gin.H{"message": "Pytanie wysłane do moderacji.", "id": q.ID}
+	if q.DuplicateOfID != nil {
+		response["message"] = "Pytanie wysłane do moderacji. Uwaga: jest bardzo podobne do istniejącego pytania."
+		response["duplicate_of_id"] = *q.DuplicateOfID
+	}
+	utils.SendSuccess(c, http.StatusCreated, response)
+}
+
+// answerResponse opisuje wynik odpowiedzi wraz z kluczem odpowiedzi. Dla pytań otwartych dołącza
+// informację zwrotną, spełnione kryteria i odpowiedź wzorcową.
+func answerResponse(a *models.QuizAnswer, q *models.QuizQuestion, g *models.AnswerGrading) gin.H {
+	resp := gin.H{
+		"answer_id":      a.ID,
+		"question_id":    a.QuestionID,
+		"question_type":  a.QuestionType,
+		"grading_status": a.GradingStatus,
+		"score":          a.Score,
+		"max_score":      a.MaxScore,
+	}
+	if q.QuestionType != models.QuestionTypeOpen {
+		resp["correct"] = a.Score == a.MaxScore
+		switch q.QuestionType {
+		case models.QuestionTypeSingleChoice:
+			resp["correct_index"] = q.CorrectOptionIndex
+		case models.QuestionTypeOrdering:
+			// Poprawna kolejność jako indeksy kroków w kolejności, w jakiej uczeń je widział
+			perm := services.OrderingPermutation(q)
+			order := make([]int, len(perm))
+			for shown, idx := range perm {
+				order[idx] = shown
+			}
+			resp["correct_order"] = order
+		default:
+			resp["payload"] = q.Payload
+		}
+		return resp
+	}
+	resp["reference_answer"] = q.ReferenceAnswer
+	if g == nil {
+		return resp
+	}
+	matched := make([]gin.H, 0, len(g.MatchedPoints))
+	for _, i := range g.MatchedPoints {
+		if i >= 0 && int(i) < len(q.Rubric) {
+			matched = append(matched, gin.H{"index": i, "text": q.Rubric[i].Text, "points": q.Rubric[i].Points})
+		}
+	}
+	resp["feedback"] = g.Feedback
+	resp["matched_points"] = matched
+	resp["rubric"] = q.Rubric
+	resp["grading_id"] = g.ID
+	return resp
+}
+
+// HandleAnswerQuizQuestion zapisuje i ocenia odpowiedź na zatwierdzone pytanie. Pytania zamknięte są sprawdzane
+// od razu (pola odpowiedzi zależą od typu: selected_option, selected_options, answer_bool, value i unit, order
+// lub blanks), odpowiedzi otwarte ocenia model AI według kryteriów pytania.
+func HandleAnswerQuizQuestion(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req struct {
+		Answer         string `json:"answer"`
+		SelectedOption *int   `json:"selected_option"`
+		models.AnswerResponse
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	q := loadQuizQuestion(c)
+	if q == nil {
+		return
+	}
+	if q.Status != "approved" {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono pytania")
+		return
+	}
+	topic, err := db.UserRepository.GetTopicByID(q.TopicID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !checkSubjectAccess(c, userUsosID, topic.SubjectID) {
+		return
+	}
+
+	answer := &models.QuizAnswer{
+		UserUsosID:   userUsosID,
+		QuestionID:   q.ID,
+		TopicID:      q.TopicID,
+		QuestionType: q.QuestionType,
+	}
+
+	if q.QuestionType != models.QuestionTypeOpen && q.QuestionType != models.QuestionTypeSingleChoice {
+		response, score, maxScore, err := services.ScoreQuizResponse(q, req.AnswerResponse)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidAnswer) {
+				utils.SendError(c, http.StatusBadRequest, err.Error())
+			} else {
+				utils.SendInternalError(c, err)
+			}
+			return
+		}
+		answer.Response = response
+		answer.Score, answer.MaxScore, answer.GradingStatus = score, maxScore, models.AnswerGraded
+		if err := db.UserRepository.CreateQuizAnswer(answer, nil); err != nil {
+			utils.SendInternalError(c, err)
+			return
+		}
+		services.RefreshQuestionDifficulty(q.ID)
+		utils.SendSuccess(c, http.StatusCreated, answerResponse(answer, q, nil))
+		return
+	}
+
+	if q.QuestionType == models.QuestionTypeSingleChoice {
+		if req.SelectedOption == nil || *req.SelectedOption < 0 || *req.SelectedOption >= len(q.Options) {
+			utils.SendError(c, http.StatusBadRequest, "Wybierz jedną z opcji odpowiedzi")
+			return
+		}
+		answer.SelectedOption = req.SelectedOption
+		answer.MaxScore, answer.GradingStatus = 1, models.AnswerGraded
+		if *req.SelectedOption == q.CorrectOptionIndex {
+			answer.Score = 1
+		}
+		if err := db.UserRepository.CreateQuizAnswer(answer, nil); err != nil {
+			utils.SendInternalError(c, err)
+			return
+		}
+		services.RefreshQuestionDifficulty(q.ID)
+		utils.SendSuccess(c, http.StatusCreated, answerResponse(answer, q, nil))
+		return
+	}
+
+	answer.AnswerText = strings.TrimSpace(req.Answer)
+	if answer.AnswerText == "" {
+		utils.SendError(c, http.StatusBadRequest, "Odpowiedź nie może być pusta")
+		return
+	}
+	if len([]rune(answer.AnswerText)) > services.MaxOpenAnswerChars {
+		utils.SendError(c, http.StatusBadRequest, fmt.Sprintf("Odpowiedź jest za długa (maksimum %d znaków)", services.MaxOpenAnswerChars))
+		return
+	}
+	if !checkAIQuota(c, userUsosID) {
+		return
+	}
+
+	answer.MaxScore = services.RubricMaxScore(q.Rubric)
+	grading, gradeErr := services.GradeOpenAnswer(c.Request.Context(), userUsosID, q, answer.AnswerText)
+	if gradeErr != nil {
+		// Odpowiedź zostaje zapisana, aby można było zlecić jej ponowną ocenę
+		answer.GradingStatus = models.AnswerGradingFailed
+	}
+	if err := db.UserRepository.CreateQuizAnswer(answer, grading); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if gradeErr != nil {
+		utils.SendError(c, http.StatusBadGateway, fmt.Sprintf("Błąd oceny odpowiedzi (odpowiedź %d zapisana - można zlecić ponowną ocenę): %v", answer.ID, gradeErr))
+		return
+	}
+	services.RefreshQuestionDifficulty(q.ID)
+	utils.SendSuccess(c, http.StatusCreated, answerResponse(answer, q, grading))
+}
+
+// loadQuizAnswer pobiera odpowiedź z parametru :id dostępną dla użytkownika (autor odpowiedzi lub moderator).
+// W razie błędu wysyła odpowiedź i zwraca nil.
+func loadQuizAnswer(c *gin.Context, userUsosID string) *models.QuizAnswer {
+	answerID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID odpowiedzi")
+		return nil
+	}
+	answer, err := db.UserRepository.GetQuizAnswer(uint(answerID))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.SendInternalError(c, err)
+		return nil
+	}
+	if err != nil || (answer.UserUsosID != userUsosID && !isAdmin(userUsosID)) {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono odpowiedzi")
+		return nil
+	}
+	return answer
+}
+
+// HandleGetQuizAnswer zwraca odpowiedź z historią jej ocen (surowe odpowiedzi modelu widzi tylko moderator)
+func HandleGetQuizAnswer(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	answer := loadQuizAnswer(c, userUsosID)
+	if answer == nil {
+		return
+	}
+	q, err := db.UserRepository.GetQuizQuestion(answer.QuestionID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	gradings, err := db.UserRepository.GetAnswerGradings(answer.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !isAdmin(userUsosID) {
+		for i := range gradings {
+			gradings[i].RawResponse = ""
+		}
+	}
+	if gradings == nil {
+		gradings = []models.AnswerGrading{}
+	}
+
+	var latest *models.AnswerGrading
+	for i := range gradings {
+		if answer.LatestGradingID != nil && gradings[i].ID == *answer.LatestGradingID {
+			latest = &gradings[i]
+		}
+	}
+	resp := answerResponse(answer, q, latest)
+	resp["answer"] = answer.AnswerText
+	resp["selected_option"] = answer.SelectedOption
+	resp["response"] = answer.Response
+	resp["created_at"] = answer.CreatedAt
+	resp["gradings"] = gradings
+	utils.SendSuccess(c, http.StatusOK, resp)
+}
+
+// HandleGetQuizQuestionAnswers zwraca odpowiedzi zalogowanego użytkownika na pytanie
+func HandleGetQuizQuestionAnswers(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	questionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID pytania")
+		return
+	}
+	answers, err := db.UserRepository.GetQuizAnswers(userUsosID, uint(questionID))
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if answers == nil {
+		answers = []models.QuizAnswer{}
+	}
+	utils.SendSuccess(c, http.StatusOK, answers)
+}
+
+// HandleRegradeQuizAnswer ocenia odpowiedź otwartą ponownie obowiązującą wersją szablonu i modelu.
+// Moderator może ocenić ponownie każdą odpowiedź, autor - tylko taką, której ocena się nie powiodła.
+func HandleRegradeQuizAnswer(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	answer := loadQuizAnswer(c, userUsosID)
+	if answer == nil {
+		return
+	}
+	if answer.QuestionType != models.QuestionTypeOpen {
+		utils.SendError(c, http.StatusBadRequest, "Ponowna ocena dotyczy tylko odpowiedzi na pytania otwarte")
+		return
+	}
+	if answer.GradingStatus != models.AnswerGradingFailed && !isAdmin(userUsosID) {
+		utils.SendError(c, http.StatusForbidden, "Ponowną ocenę ocenionej odpowiedzi może zlecić tylko moderator")
+		return
+	}
+	q, err := db.UserRepository.GetQuizQuestion(answer.QuestionID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !checkAIQuota(c, userUsosID) {
+		return
+	}
+
+	grading, err := services.GradeOpenAnswer(c.Request.Context(), userUsosID, q, answer.AnswerText)
+	if err != nil {
+		utils.SendError(c, http.StatusBadGateway, "Błąd oceny odpowiedzi: "+err.Error())
+		return
+	}
+	if err := db.UserRepository.AddAnswerGrading(answer, grading); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	services.RefreshQuestionDifficulty(q.ID)
+	utils.SendSuccess(c, http.StatusOK, answerResponse(answer, q, grading))
+}
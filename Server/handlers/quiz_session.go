@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+)
+
+const (
+	defaultQuizSessionQuestions = 10
+	maxQuizSessionQuestions     = 50
+)
+
+// HandleStartQuizSession losuje zestaw zatwierdzonych pytań z tematu i jego podtematów do rozwiązania.
+// Filtry jak w treści tematu (?tags, ?difficulty, ?bloom_level) oraz ?question_types=a,b i ?count=
+// (domyślnie 10, najwyżej 50). Klucze odpowiedzi są ukryte - odpowiedzi sprawdza POST /quiz-questions/:id/answer.
+func HandleStartQuizSession(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	topic := loadAccessibleTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+	filter, ok := parseContentFilter(c)
+	if !ok {
+		return
+	}
+
+	count := defaultQuizSessionQuestions
+	if v := c.Query("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQuizSessionQuestions {
+			utils.SendError(c, http.StatusBadRequest, fmt.Sprintf("Liczba pytań musi być z zakresu 1-%d", maxQuizSessionQuestions))
+			return
+		}
+		count = n
+	}
+	var questionTypes []string
+	for _, v := range c.QueryArray("question_types") {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t == "" {
+				continue
+			}
+			if _, ok := services.QuestionTypes[t]; !ok {
+				utils.SendError(c, http.StatusBadRequest, fmt.Sprintf("Nieznany typ pytania %q", t))
+				return
+			}
+			questionTypes = append(questionTypes, t)
+		}
+	}
+
+	topics, err := db.UserRepository.GetTopicsBySubjectID(topic.SubjectID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	subtree := topicSubtree(topics, topic.ID)
+	topicIDs := make([]uint, len(subtree))
+	for i, t := range subtree {
+		topicIDs[i] = t.ID
+	}
+
+	questions, err := db.UserRepository.GetRandomApprovedQuizQuestions(topicIDs, filter, questionTypes, count)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if len(questions) == 0 {
+		utils.SendError(c, http.StatusNotFound, "Brak zatwierdzonych pytań spełniających kryteria")
+		return
+	}
+	hideUnapprovedExplanations(questions)
+	hideAnswerKeys(questions)
+	if err := attachQuizQuestionMedia(questions); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if wantsRenderedHTML(c) {
+		for i := range questions {
+			services.RenderQuizQuestion(&questions[i])
+		}
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"topic_id":       topic.ID,
+		"quiz_questions": questions,
+	})
+}
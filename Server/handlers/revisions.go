@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// loadFlashcard pobiera fiszkę z parametru :id. W razie błędu wysyła odpowiedź i zwraca nil.
+func loadFlashcard(c *gin.Context) *models.Flashcard {
+	flashcardID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID fiszki")
+		return nil
+	}
+	fc, err := db.UserRepository.GetFlashcard(uint(flashcardID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono fiszki")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return nil
+	}
+	return fc
+}
+
+// canEditContent sprawdza, czy użytkownik może edytować treść: autor przed zatwierdzeniem, moderator zawsze.
+// W razie braku uprawnień wysyła odpowiedź i zwraca false.
+func canEditContent(c *gin.Context, userUsosID, createdBy, status string) bool {
+	if isAdmin(userUsosID) {
+		return true
+	}
+	if createdBy != userUsosID {
+		utils.SendError(c, http.StatusForbidden, "Treść może edytować tylko jej autor lub moderator")
+		return false
+	}
+	if status != "pending" {
+		utils.SendError(c, http.StatusForbidden, "Autor może edytować treść tylko przed jej zatwierdzeniem")
+		return false
+	}
+	return true
+}
+
+// canViewContent sprawdza, czy użytkownik widzi historię treści: zatwierdzonej - każdy,
+// pozostałej - autor i moderator. W razie braku dostępu wysyła 404 i zwraca false.
+func canViewContent(c *gin.Context, userUsosID, createdBy, status string) bool {
+	if status == "approved" || createdBy == userUsosID || isAdmin(userUsosID) {
+		return true
+	}
+	utils.SendError(c, http.StatusNotFound, "Nie znaleziono treści")
+	return false
+}
+
+// sendEditError odpowiada 400 dla pustej zmiany, 409 dla konfliktu wersji i 500 dla pozostałych błędów
+func sendEditError(c *gin.Context, err error, currentVersion int) {
+	switch {
+	case errors.Is(err, services.ErrNoRevisionChanges):
+		utils.SendError(c, http.StatusBadRequest, "Brak zmian do zapisania")
+	case errors.Is(err, services.ErrRevisionConflict):
+		utils.SendError(c, http.StatusConflict, fmt.Sprintf("Treść została zmieniona w międzyczasie (aktualna wersja: %d)", currentVersion))
+	default:
+		utils.SendInternalError(c, err)
+	}
+}
+
+// revertRequest to zapytanie o przywrócenie wcześniejszej wersji
+type revertRequest struct {
+	ToVersion int    `json:"to_version" binding:"required"`
+	Version   int    `json:"version" binding:"required"` // aktualna wersja znana klientowi
+	Reason    string `json:"reason"`
+}
+
+// revertSnapshot zwraca stan elementu w wersji req.ToVersion. W razie błędu wysyła odpowiedź i zwraca ok = false.
+func revertSnapshot(c *gin.Context, itemType string, itemID uint, currentVersion int, req revertRequest) (models.RevisionSnapshot, bool) {
+	if req.ToVersion < 1 || req.ToVersion >= currentVersion {
+		utils.SendError(c, http.StatusBadRequest, fmt.Sprintf("Można przywrócić tylko wcześniejszą wersję (1-%d)", currentVersion-1))
+		return models.RevisionSnapshot{}, false
+	}
+	revs, err := db.UserRepository.GetContentRevisions(itemType, itemID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return models.RevisionSnapshot{}, false
+	}
+	snapshot, ok := services.SnapshotAtVersion(revs, req.ToVersion)
+	if !ok {
+		utils.SendError(c, http.StatusNotFound, fmt.Sprintf("Brak historii wersji %d", req.ToVersion))
+		return models.RevisionSnapshot{}, false
+	}
+	return snapshot, true
+}
+
+func revertReason(req revertRequest) string {
+	if req.Reason == "" {
+		return fmt.Sprintf("Przywrócenie wersji %d", req.ToVersion)
+	}
+	return fmt.Sprintf("Przywrócenie wersji %d: %s", req.ToVersion, req.Reason)
+}
+
+// sendRevisions zwraca aktualną wersję elementu i historię jego zmian
+func sendRevisions(c *gin.Context, itemType string, itemID uint, version int) {
+	revs, err := db.UserRepository.GetContentRevisions(itemType, itemID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if revs == nil {
+		revs = []models.ContentRevision{}
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"version": version, "revisions": revs})
+}
+
+// HandleUpdateFlashcard zapisuje poprawioną fiszkę (autor przed zatwierdzeniem, moderator zawsze).
+// Pole "version" chroni przed nadpisaniem cudzych zmian; każda zmiana trafia do historii.
+func HandleUpdateFlashcard(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req struct {
+		Question       *string             `json:"question"`
+		Answer         *string             `json:"answer"`
+		QuestionFormat *models.FieldFormat `json:"question_format"`
+		AnswerFormat   *models.FieldFormat `json:"answer_format"`
+		Version        int                 `json:"version" binding:"required"`
+		Reason         string              `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	fc := loadFlashcard(c)
+	if fc == nil || !canEditContent(c, userUsosID, fc.CreatedByUsosID, fc.Status) {
+		return
+	}
+
+	after := services.FlashcardSnapshot(fc)
+	if req.Question != nil {
+		after.Question = *req.Question
+	}
+	if req.Answer != nil {
+		after.Answer = *req.Answer
+	}
+	if req.QuestionFormat != nil {
+		after.QuestionFormat = *req.QuestionFormat
+	}
+	if req.AnswerFormat != nil {
+		after.AnswerFormat = *req.AnswerFormat
+	}
+	after, err := services.NormalizeFlashcardSnapshot(after)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowa fiszka: "+err.Error())
+		return
+	}
+
+	edit := services.ContentEdit{EditorUsosID: userUsosID, Reason: req.Reason, ExpectedVersion: req.Version}
+	rev, err := services.EditFlashcard(fc, after, edit)
+	if err != nil {
+		sendEditError(c, err, fc.Version)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, rev)
+}
+
+// HandleGetFlashcardRevisions zwraca historię zmian fiszki
+func HandleGetFlashcardRevisions(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	fc := loadFlashcard(c)
+	if fc == nil || !canViewContent(c, userUsosID, fc.CreatedByUsosID, fc.Status) {
+		return
+	}
+	sendRevisions(c, models.SearchItemFlashcard, fc.ID, fc.Version)
+}
+
+// HandleRevertFlashcard przywraca wcześniejszą wersję fiszki (jako nową zmianę w historii)
+func HandleRevertFlashcard(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req revertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	fc := loadFlashcard(c)
+	if fc == nil || !canEditContent(c, userUsosID, fc.CreatedByUsosID, fc.Status) {
+		return
+	}
+	snapshot, ok := revertSnapshot(c, models.SearchItemFlashcard, fc.ID, fc.Version, req)
+	if !ok {
+		return
+	}
+
+	edit := services.ContentEdit{EditorUsosID: userUsosID, Reason: revertReason(req), ExpectedVersion: req.Version, RevertedFromVersion: &req.ToVersion}
+	rev, err := services.EditFlashcard(fc, snapshot, edit)
+	if err != nil {
+		sendEditError(c, err, fc.Version)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, rev)
+}
+
+// HandleUpdateQuizQuestion zapisuje poprawione pytanie quizowe (autor przed zatwierdzeniem, moderator zawsze).
+// Pominięte pola pozostają bez zmian; typu pytania nie można zmienić.
+func HandleUpdateQuizQuestion(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req struct {
+		Question        *string                 `json:"question"`
+		Options         []string                `json:"options"`
+		CorrectIndex    *int                    `json:"correct_index"`
+		ReferenceAnswer *string                 `json:"reference_answer"`
+		Rubric          *[]models.RubricPoint   `json:"rubric"`
+		Payload         *models.QuestionPayload `json:"payload"`
+		Version         int                     `json:"version" binding:"required"`
+		Reason          string                  `json:"reason"`
+
+		QuestionFormat        *models.FieldFormat `json:"question_format"`
+		OptionsFormat         *models.FieldFormat `json:"options_format"`
+		ReferenceAnswerFormat *models.FieldFormat `json:"reference_answer_format"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	q := loadQuizQuestion(c)
+	if q == nil || !canEditContent(c, userUsosID, q.CreatedByUsosID, q.Status) {
+		return
+	}
+
+	after := services.QuizQuestionSnapshot(q)
+	if req.Question != nil {
+		after.Question = *req.Question
+	}
+	if req.Options != nil {
+		after.Options = req.Options
+	}
+	if req.CorrectIndex != nil {
+		after.CorrectIndex = *req.CorrectIndex
+	}
+	if req.ReferenceAnswer != nil {
+		after.ReferenceAnswer = *req.ReferenceAnswer
+	}
+	if req.Rubric != nil {
+		after.Rubric = *req.Rubric
+	}
+	if req.Payload != nil {
+		after.Payload = req.Payload
+	}
+	if req.QuestionFormat != nil {
+		after.QuestionFormat = *req.QuestionFormat
+	}
+	if req.OptionsFormat != nil {
+		after.OptionsFormat = *req.OptionsFormat
+	}
+	if req.ReferenceAnswerFormat != nil {
+		after.ReferenceAnswerFormat = *req.ReferenceAnswerFormat
+	}
+	after, err := services.NormalizeQuizSnapshot(q.QuestionType, after)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe pytanie: "+err.Error())
+		return
+	}
+
+	edit := services.ContentEdit{EditorUsosID: userUsosID, Reason: req.Reason, ExpectedVersion: req.Version}
+	rev, err := services.EditQuizQuestion(q, after, edit)
+	if err != nil {
+		sendEditError(c, err, q.Version)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, rev)
+}
+
+// HandleGetQuizQuestionRevisions zwraca historię zmian pytania. Uczniowie nie widzą w niej
+// kluczy odpowiedzi sprawdzanych na serwerze: odpowiedzi wzorcowej i kryteriów pytań otwartych, Payload
+// ani opcji pytań o kolejność (zapisanych w poprawnej kolejności).
+func HandleGetQuizQuestionRevisions(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	q := loadQuizQuestion(c)
+	if q == nil || !canViewContent(c, userUsosID, q.CreatedByUsosID, q.Status) {
+		return
+	}
+	if q.QuestionType == models.QuestionTypeSingleChoice || q.CreatedByUsosID == userUsosID || isAdmin(userUsosID) {
+		sendRevisions(c, models.SearchItemQuizQuestion, q.ID, q.Version)
+		return
+	}
+
+	revs, err := db.UserRepository.GetContentRevisions(models.SearchItemQuizQuestion, q.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	for i := range revs {
+		revs[i].Before.ReferenceAnswer, revs[i].Before.Rubric, revs[i].Before.Payload = "", nil, nil
+		revs[i].After.ReferenceAnswer, revs[i].After.Rubric, revs[i].After.Payload = "", nil, nil
+		ordering := q.QuestionType == models.QuestionTypeOrdering
+		if ordering {
+			revs[i].Before.Options, revs[i].After.Options = nil, nil
+		}
+		visible := revs[i].Changes[:0]
+		for _, ch := range revs[i].Changes {
+			if ordering && ch.Field == "options" {
+				continue
+			}
+			if ch.Field != "reference_answer" && ch.Field != "rubric" && ch.Field != "reference_answer_format" && ch.Field != "payload" {
+				visible = append(visible, ch)
+			}
+		}
+		revs[i].Changes = visible
+	}
+	if revs == nil {
+		revs = []models.ContentRevision{}
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"version": q.Version, "revisions": revs})
+}
+
+// HandleRevertQuizQuestion przywraca wcześniejszą wersję pytania (jako nową zmianę w historii)
+func HandleRevertQuizQuestion(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req revertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	q := loadQuizQuestion(c)
+	if q == nil || !canEditContent(c, userUsosID, q.CreatedByUsosID, q.Status) {
+		return
+	}
+	snapshot, ok := revertSnapshot(c, models.SearchItemQuizQuestion, q.ID, q.Version, req)
+	if !ok {
+		return
+	}
+
+	edit := services.ContentEdit{EditorUsosID: userUsosID, Reason: revertReason(req), ExpectedVersion: req.Version, RevertedFromVersion: &req.ToVersion}
+	rev, err := services.EditQuizQuestion(q, snapshot, edit)
+	if err != nil {
+		sendEditError(c, err, q.Version)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, rev)
+}
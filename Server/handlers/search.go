@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 50
+	searchSnippetChars = 240
+	searchQueryTimeout = 15 * time.Second
+)
+
+type searchTopicRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type searchSubjectRef struct {
+	UsosID string `json:"usos_id"`
+	Name   string `json:"name"`
+}
+
+type searchResult struct {
+	Type    string           `json:"type"`
+	ID      uint             `json:"id"`
+	Score   float64          `json:"score"`
+	Title   string           `json:"title"`
+	Snippet string           `json:"snippet"`
+	Topic   searchTopicRef   `json:"topic"`
+	Subject searchSubjectRef `json:"subject"`
+}
+
+// searchSubjectScope zwraca ID przedmiotów, w których użytkownik może wyszukiwać
+// (nil dla administratora = wszystkie przedmioty)
+func searchSubjectScope(userUsosID string) ([]uint, error) {
+	if isAdmin(userUsosID) {
+		return nil, nil
+	}
+	ids, err := db.UserRepository.GetUserSubjectIDs(userUsosID)
+	if ids == nil {
+		ids = []uint{}
+	}
+	return ids, err
+}
+
+// parseSearchQuery odczytuje parametry q i limit. W razie błędu wysyła odpowiedź i zwraca ok = false.
+func parseSearchQuery(c *gin.Context) (query string, limit int, ok bool) {
+	query = strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		utils.SendError(c, http.StatusBadRequest, "Parametr q jest wymagany")
+		return "", 0, false
+	}
+	limit = searchDefaultLimit
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > searchMaxLimit {
+			utils.SendError(c, http.StatusBadRequest, "Parametr limit musi być z zakresu 1-"+strconv.Itoa(searchMaxLimit))
+			return "", 0, false
+		}
+		limit = n
+	}
+	return query, limit, true
+}
+
+// HandleSearch wyszukuje semantycznie zatwierdzone fiszki, pytania i podsumowania
+// w przedmiotach, na które zapisany jest użytkownik
+func HandleSearch(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	query, limit, ok := parseSearchQuery(c)
+	if !ok {
+		return
+	}
+	if services.Search == nil {
+		utils.SendError(c, http.StatusServiceUnavailable, "Wyszukiwanie jest niedostępne")
+		return
+	}
+	if services.Search.UsesAI() && !checkAIQuota(c, userUsosID) {
+		return
+	}
+
+	subjectIDs, err := searchSubjectScope(userUsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), searchQueryTimeout)
+	defer cancel()
+	ctx = services.WithAIUsageOwner(ctx, services.AIUsageOwner{UserUsosID: userUsosID})
+	hits, err := services.Search.Query(ctx, query, subjectIDs, limit)
+	if err != nil {
+		utils.SendError(c, http.StatusBadGateway, "Błąd wyszukiwania: "+err.Error())
+		return
+	}
+
+	results, err := buildSearchResults(hits)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"query": query, "results": results})
+}
+
+// buildSearchResults wczytuje treści i tematy trafień. Pomija treści, które w międzyczasie
+// przestały być zatwierdzone.
+func buildSearchResults(hits []services.SearchHit) ([]searchResult, error) {
+	var flashcardIDs, questionIDs, noteIDs, topicIDs []uint
+	for _, h := range hits {
+		switch h.ItemType {
+		case models.SearchItemFlashcard:
+			flashcardIDs = append(flashcardIDs, h.ItemID)
+		case models.SearchItemQuizQuestion:
+			questionIDs = append(questionIDs, h.ItemID)
+		case models.SearchItemTopicNote:
+			noteIDs = append(noteIDs, h.ItemID)
+		}
+		topicIDs = append(topicIDs, h.TopicID)
+	}
+
+	type content struct{ title, snippet string }
+	contents := make(map[string]content, len(hits))
+	key := func(itemType string, id uint) string { return itemType + ":" + strconv.FormatUint(uint64(id), 10) }
+
+	flashcards, err := db.UserRepository.GetFlashcardsByIDs(flashcardIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, fc := range flashcards {
+		if fc.Status == "approved" {
+			contents[key(models.SearchItemFlashcard, fc.ID)] = content{fc.Question, fc.Answer}
+		}
+	}
+	questions, err := db.UserRepository.GetQuizQuestionsByIDs(questionIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if q.Status == "approved" {
+			// Opcje pytania o kolejność zapisane są w poprawnej kolejności - pokazujemy je przetasowane
+			services.ShuffleForStudent(&q)
+			contents[key(models.SearchItemQuizQuestion, q.ID)] = content{q.QuestionText, strings.Join(q.Options, " · ")}
+		}
+	}
+	notes, err := db.UserRepository.GetTopicNotesByIDs(noteIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range notes {
+		if n.Status == "approved" {
+			contents[key(models.SearchItemTopicNote, n.ID)] = content{noteTitle(n.Body), n.Body}
+		}
+	}
+
+	topics, err := db.UserRepository.GetTopicsByIDs(topicIDs)
+	if err != nil {
+		return nil, err
+	}
+	topicByID := make(map[uint]models.Topic, len(topics))
+	for _, t := range topics {
+		topicByID[t.ID] = t
+	}
+
+	results := make([]searchResult, 0, len(hits))
+	for _, h := range hits {
+		item, ok := contents[key(h.ItemType, h.ItemID)]
+		if !ok {
+			continue
+		}
+		topic := topicByID[h.TopicID]
+		results = append(results, searchResult{
+			Type:    h.ItemType,
+			ID:      h.ItemID,
+			Score:   h.Score,
+			Title:   item.title,
+			Snippet: snippet(item.snippet, searchSnippetChars),
+			Topic:   searchTopicRef{ID: topic.ID, Name: topic.Name},
+			Subject: searchSubjectRef{UsosID: topic.Subject.UsosID, Name: topic.Subject.Name},
+		})
+	}
+	return results, nil
+}
+
+// noteTitle zwraca pierwszą niepustą linię podsumowania bez znaczników nagłówka Markdown
+func noteTitle(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		if line = strings.TrimSpace(strings.TrimLeft(line, "# ")); line != "" {
+			return snippet(line, 120)
+		}
+	}
+	return ""
+}
+
+func snippet(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return s
+}
+
+// textSearchTypes to typy wyników wyszukiwania pełnotekstowego (parametr type)
+var textSearchTypes = []string{models.SearchItemTopic, models.SearchItemFlashcard, models.SearchItemQuizQuestion, models.SearchItemTopicNote}
+
+// textSearchStatuses to statusy, po których może filtrować administrator (parametr status)
+var textSearchStatuses = []string{"pending", "approved", "rejected", "merged"}
+
+type textSearchResult struct {
+	Type    string           `json:"type"`
+	ID      uint             `json:"id"`
+	Rank    float64          `json:"rank"`
+	Title   string           `json:"title"`
+	Snippet string           `json:"snippet"`
+	Status  string           `json:"status"`
+	Topic   searchTopicRef   `json:"topic"`
+	Subject searchSubjectRef `json:"subject"`
+}
+
+// parseSearchList odczytuje listę wartości rozdzielonych przecinkami i sprawdza, czy należą do allowed.
+// W razie błędu wysyła odpowiedź i zwraca ok = false.
+func parseSearchList(c *gin.Context, param string, allowed []string) (values []string, ok bool) {
+	for _, v := range strings.Split(c.Query(param), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !containsValue(allowed, v) {
+			utils.SendError(c, http.StatusBadRequest, "Parametr "+param+" musi być jednym z: "+strings.Join(allowed, ", "))
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
+
+func containsValue(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePositiveInt odczytuje dodatni parametr liczbowy nie większy niż max (def, gdy brak)
+func parsePositiveInt(c *gin.Context, param string, def, max int) (int, bool) {
+	s := c.Query(param)
+	if s == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > max {
+		utils.SendError(c, http.StatusBadRequest, "Parametr "+param+" musi być z zakresu 1-"+strconv.Itoa(max))
+		return 0, false
+	}
+	return n, true
+}
+
+// HandleFullTextSearch wyszukuje pełnotekstowo (z polską odmianą i bez względu na polskie znaki)
+// tematy, fiszki, pytania i podsumowania. Filtry: subject (USOS ID), type, status (tylko administrator),
+// metadane fiszek i pytań (tags, difficulty, bloom_level - wtedy pomijane są tematy i podsumowania),
+// stronicowanie: page, per_page.
+func HandleFullTextSearch(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		utils.SendError(c, http.StatusBadRequest, "Parametr q jest wymagany")
+		return
+	}
+	page, ok := parsePositiveInt(c, "page", 1, 1000)
+	if !ok {
+		return
+	}
+	perPage, ok := parsePositiveInt(c, "per_page", searchDefaultLimit, searchMaxLimit)
+	if !ok {
+		return
+	}
+	types, ok := parseSearchList(c, "type", textSearchTypes)
+	if !ok {
+		return
+	}
+	content, ok := parseContentFilter(c)
+	if !ok {
+		return
+	}
+
+	statuses := []string{"approved"}
+	if c.Query("status") != "" {
+		if !isAdmin(userUsosID) {
+			utils.SendError(c, http.StatusForbidden, "Filtrowanie po statusie jest dostępne tylko dla moderatorów")
+			return
+		}
+		if c.Query("status") == "all" {
+			statuses = textSearchStatuses
+		} else if statuses, ok = parseSearchList(c, "status", textSearchStatuses); !ok {
+			return
+		}
+	}
+
+	subjectIDs, err := searchSubjectScope(userUsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if usosID := c.Query("subject"); usosID != "" {
+		subject, ok := promptTemplateSubject(c, usosID)
+		if !ok {
+			return
+		}
+		if subjectIDs != nil && !containsUint(subjectIDs, subject.ID) {
+			utils.SendError(c, http.StatusForbidden, "Nie jesteś zapisany na ten przedmiot")
+			return
+		}
+		subjectIDs = []uint{subject.ID}
+	}
+
+	rows, err := db.UserRepository.FullTextSearch(db.TextSearchFilter{
+		Query:      query,
+		SubjectIDs: subjectIDs,
+		Types:      types,
+		Statuses:   statuses,
+		Content:    content,
+		Limit:      perPage,
+		Offset:     (page - 1) * perPage,
+	})
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	var total int64
+	results := make([]textSearchResult, 0, len(rows))
+	for _, r := range rows {
+		total = r.Total
+		title := r.Title
+		if r.ItemType == models.SearchItemTopicNote {
+			title = noteTitle(r.Title)
+		}
+		results = append(results, textSearchResult{
+			Type:    r.ItemType,
+			ID:      r.ItemID,
+			Rank:    r.Rank,
+			Title:   title,
+			Snippet: r.Snippet,
+			Status:  r.Status,
+			Topic:   searchTopicRef{ID: r.TopicID, Name: r.TopicName},
+			Subject: searchSubjectRef{UsosID: r.SubjectUsosID, Name: r.SubjectName},
+		})
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"query":    query,
+		"page":     page,
+		"per_page": perPage,
+		"total":    total,
+		"results":  results,
+	})
+}
+
+func containsUint(list []uint, v uint) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
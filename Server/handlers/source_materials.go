@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// sourceMaterialRef to odnośnik do materiału źródłowego pokazywany przy treściach w kolejce moderacji
+type sourceMaterialRef struct {
+	ID       uint
+	Name     string
+	MIMEType string
+	URL      string
+}
+
+// resolveSourceMaterials pobiera jednym zapytaniem materiały wskazywane przez wszystkie podane listy ID
+// i zwraca funkcję zamieniającą listę ID na odnośniki.
+func resolveSourceMaterials(lists ...pq.Int64Array) (func(pq.Int64Array) []sourceMaterialRef, error) {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, list := range lists {
+		for _, id := range list {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	materials, err := db.UserRepository.GetSourceMaterials(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]models.SourceMaterial, len(materials))
+	for _, m := range materials {
+		byID[int64(m.ID)] = m
+	}
+
+	return func(list pq.Int64Array) []sourceMaterialRef {
+		refs := make([]sourceMaterialRef, 0, len(list))
+		for _, id := range list {
+			if m, ok := byID[id]; ok {
+				refs = append(refs, sourceMaterialRef{
+					ID:       m.ID,
+					Name:     m.Name,
+					MIMEType: m.MIMEType,
+					URL:      fmt.Sprintf("/api/source-materials/%d", m.ID),
+				})
+			}
+		}
+		return refs
+	}, nil
+}
+
+// HandleGetSourceMaterial zwraca zawartość materiału źródłowego (dla przesyłającego i moderatorów)
+func HandleGetSourceMaterial(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+
+	materialID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID materiału")
+		return
+	}
+
+	material, err := db.UserRepository.GetSourceMaterial(uint(materialID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono materiału")
+			return
+		}
+		utils.SendInternalError(c, err)
+		return
+	}
+	if material.CreatedByUsosID != userUsosID && !isAdmin(userUsosID) {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono materiału")
+		return
+	}
+
+	data, err := services.Materials.Read(material)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": material.Name}))
+	c.Header("Cache-Control", "private, max-age=86400")
+	// Przesłany plik (np. HTML podpisany jako tekst) nie może wykonać skryptów w domenie aplikacji
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	c.Data(http.StatusOK, material.MIMEType, data)
+}
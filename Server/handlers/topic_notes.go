@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// loadTopicNote pobiera podsumowanie z parametru :id. W razie błędu wysyła odpowiedź i zwraca nil.
+func loadTopicNote(c *gin.Context) *models.TopicNote {
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID podsumowania")
+		return nil
+	}
+
+	note, err := db.UserRepository.GetTopicNote(uint(noteID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono podsumowania")
+			return nil
+		}
+		utils.SendInternalError(c, err)
+		return nil
+	}
+	return note
+}
+
+// HandleGetTopicNote zwraca podsumowanie. Niezatwierdzone widzi tylko autor i moderator.
+func HandleGetTopicNote(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+
+	note := loadTopicNote(c)
+	if note == nil {
+		return
+	}
+	if note.Status != "approved" && note.CreatedByUsosID != userUsosID && !isAdmin(userUsosID) {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono podsumowania")
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, note)
+}
+
+// HandleUpdateTopicNote zapisuje nową wersję podsumowania (autor lub moderator).
+// Edycja autora wraca do moderacji; pole "version" chroni przed nadpisaniem cudzych zmian.
+func HandleUpdateTopicNote(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+
+	var req struct {
+		Body    string `json:"body" binding:"required"`
+		Version int    `json:"version" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		utils.SendError(c, http.StatusBadRequest, "Treść podsumowania nie może być pusta")
+		return
+	}
+
+	note := loadTopicNote(c)
+	if note == nil {
+		return
+	}
+
+	moderator := isAdmin(userUsosID)
+	if note.CreatedByUsosID != userUsosID && !moderator {
+		utils.SendError(c, http.StatusForbidden, "Tylko autor lub moderator może edytować podsumowanie")
+		return
+	}
+
+	status := note.Status
+	if !moderator {
+		status = "pending"
+	}
+
+	ok, err := db.UserRepository.UpdateTopicNote(note.ID, req.Version, body, status, userUsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if !ok {
+		utils.SendError(c, http.StatusConflict, fmt.Sprintf("Podsumowanie zostało zmienione w międzyczasie (aktualna wersja: %d)", note.Version))
+		return
+	}
+	services.NotifySearchIndex()
+	if status == "pending" {
+		services.NotifyScreening()
+	}
+
+	note, err = db.UserRepository.GetTopicNote(note.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, note)
+}
+
+func HandleGetPendingTopicNotes(c *gin.Context) {
+	notes, err := db.UserRepository.GetPendingTopicNotes()
+	if err != nil {
+		utils.SendError(c, http.StatusInternalServerError, "Błąd pobierania oczekujących podsumowań: "+err.Error())
+		return
+	}
+
+	lists := make([]pq.Int64Array, len(notes))
+	for i, n := range notes {
+		lists[i] = n.SourceMaterialIDs
+	}
+	refs, err := resolveSourceMaterials(lists...)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	result := make([]pendingTopicNote, len(notes))
+	for i, n := range notes {
+		result[i] = pendingTopicNote{TopicNote: n, Sources: refs(n.SourceMaterialIDs)}
+	}
+	utils.SendSuccess(c, http.StatusOK, result)
+}
+
+func HandleApproveTopicNote(c *gin.Context) {
+	setTopicNoteStatus(c, "approved", "zatwierdzone")
+}
+
+func HandleRejectTopicNote(c *gin.Context) {
+	setTopicNoteStatus(c, "rejected", "odrzucone")
+}
+
+func setTopicNoteStatus(c *gin.Context, status, label string) {
+	noteID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID podsumowania")
+		return
+	}
+
+	if err := db.UserRepository.SetTopicNoteStatus(uint(noteID), status); err != nil {
+		utils.SendError(c, http.StatusInternalServerError, "Błąd zmiany statusu podsumowania: "+err.Error())
+		return
+	}
+	services.NotifySearchIndex()
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": fmt.Sprintf("Podsumowanie %d %s", noteID, label)})
+}
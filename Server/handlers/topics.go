@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// topicTreeNode to temat w drzewie tematów przedmiotu
+type topicTreeNode struct {
+	ID              uint             `json:"id"`
+	Name            string           `json:"name"`
+	Position        int              `json:"position"`
+	ParentTopicID   *uint            `json:"parent_topic_id"`
+	CreatedByUsosID string           `json:"created_by_usos_id"`
+	Children        []*topicTreeNode `json:"children"`
+}
+
+// buildTopicTree układa tematy (posortowane po pozycji) w drzewo. Temat z nieistniejącym rodzicem trafia na najwyższy poziom.
+func buildTopicTree(topics []models.Topic) []*topicTreeNode {
+	nodes := make(map[uint]*topicTreeNode, len(topics))
+	for _, t := range topics {
+		nodes[t.ID] = &topicTreeNode{
+			ID:              t.ID,
+			Name:            t.Name,
+			Position:        t.Position,
+			ParentTopicID:   t.ParentTopicID,
+			CreatedByUsosID: t.CreatedByUsosID,
+			Children:        []*topicTreeNode{},
+		}
+	}
+	roots := []*topicTreeNode{}
+	for _, t := range topics {
+		if t.ParentTopicID != nil {
+			if parent, ok := nodes[*t.ParentTopicID]; ok {
+				parent.Children = append(parent.Children, nodes[t.ID])
+				continue
+			}
+		}
+		roots = append(roots, nodes[t.ID])
+	}
+	return roots
+}
+
+// checkTopicParent sprawdza, czy parentID jest tematem tego samego przedmiotu i nie leży w poddrzewie
+// tematu topicID (0 dla nowego tematu). W razie błędu wysyła odpowiedź i zwraca false.
+func checkTopicParent(c *gin.Context, subjectID, topicID, parentID uint) bool {
+	topics, err := db.UserRepository.GetTopicsBySubjectID(subjectID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return false
+	}
+	parents := make(map[uint]*uint, len(topics))
+	for _, t := range topics {
+		parents[t.ID] = t.ParentTopicID
+	}
+	if _, ok := parents[parentID]; !ok {
+		utils.SendError(c, http.StatusBadRequest, "Temat nadrzędny nie istnieje w tym przedmiocie")
+		return false
+	}
+	// Idziemy w górę od nowego rodzica - jeśli trafimy na przenoszony temat, powstałby cykl
+	for id, steps := &parentID, 0; id != nil && steps <= len(topics); id, steps = parents[*id], steps+1 {
+		if topicID != 0 && *id == topicID {
+			utils.SendError(c, http.StatusBadRequest, "Temat nie może być podtematem samego siebie ani swojego podtematu")
+			return false
+		}
+	}
+	return true
+}
+
+// loadOwnedTopic pobiera temat z parametru :id, który może zmieniać użytkownik (autor tematu lub administrator).
+// W razie błędu wysyła odpowiedź i zwraca nil.
+func loadOwnedTopic(c *gin.Context, userUsosID string) *models.Topic {
+	topicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID tematu")
+		return nil
+	}
+	topic, err := db.UserRepository.GetTopicByID(uint(topicID))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono tematu")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return nil
+	}
+	if topic.CreatedByUsosID != userUsosID && !isAdmin(userUsosID) {
+		utils.SendError(c, http.StatusForbidden, "Temat może zmieniać tylko jego autor lub administrator")
+		return nil
+	}
+	return topic
+}
+
+// HandleUpdateTopic zmienia nazwę, rodzica lub pozycję tematu. "parent_topic_id": 0 przenosi temat
+// na najwyższy poziom; "position" to miejsce wśród tematów o tym samym rodzicu (liczone od 0).
+func HandleUpdateTopic(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	var req struct {
+		Name          *string `json:"name"`
+		ParentTopicID *uint   `json:"parent_topic_id"`
+		Position      *int    `json:"position"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	topic := loadOwnedTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+
+	upd := db.TopicUpdate{Position: req.Position}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			utils.SendError(c, http.StatusBadRequest, "Nazwa tematu nie może być pusta")
+			return
+		}
+		upd.Name = &name
+	}
+	if req.Position != nil && *req.Position < 0 {
+		utils.SendError(c, http.StatusBadRequest, "Pozycja nie może być ujemna")
+		return
+	}
+	if req.ParentTopicID != nil {
+		upd.MoveParent = true
+		if *req.ParentTopicID != 0 {
+			if !checkTopicParent(c, topic.SubjectID, topic.ID, *req.ParentTopicID) {
+				return
+			}
+			upd.ParentTopicID = req.ParentTopicID
+		}
+	}
+
+	if err := db.UserRepository.UpdateTopic(topic, upd); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if upd.Name != nil {
+		services.NotifySearchIndex()
+	}
+	utils.SendSuccess(c, http.StatusOK, topic)
+}
+
+// HandleDeleteTopic usuwa temat wraz z podtematami i ich treściami (usunięcie miękkie)
+func HandleDeleteTopic(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	topic := loadOwnedTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+	ids, err := db.UserRepository.DeleteTopic(topic.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono tematu")
+			return
+		}
+		utils.SendInternalError(c, err)
+		return
+	}
+	// Załączniki fiszek i pytań zostają - usunięcie tematu jest miękkie i można je cofnąć
+	services.NotifySearchIndex()
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"message":           fmt.Sprintf("Temat %d usunięty", topic.ID),
+		"deleted_topic_ids": ids,
+	})
+}
+
+// HandleGetTopicTree zwraca drzewo tematów przedmiotu (tematy i podtematy w kolejności pozycji).
+// Dostęp mają tylko osoby zapisane na przedmiot.
+func HandleGetTopicTree(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	subject, err := db.UserRepository.GetSubjectByUsosID(c.Param("usos_id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono przedmiotu")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return
+	}
+	if !checkSubjectAccess(c, userUsosID, subject.ID) {
+		return
+	}
+	topics, err := db.UserRepository.GetTopicsBySubjectID(subject.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, buildTopicTree(topics))
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/skni-kod/InfQuizyTor/Server/services"
+	"github.com/skni-kod/InfQuizyTor/Server/utils"
+	"gorm.io/gorm"
+)
+
+// loadAccessibleTopic pobiera temat z parametru :id i sprawdza, czy użytkownik jest zapisany na jego przedmiot
+// (administrator ma dostęp do wszystkich tematów). W razie błędu wysyła odpowiedź i zwraca nil.
+func loadAccessibleTopic(c *gin.Context, userUsosID string) *models.Topic {
+	topicID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID tematu")
+		return nil
+	}
+	return loadAccessibleTopicByID(c, userUsosID, uint(topicID))
+}
+
+// loadAccessibleTopicByID wczytuje temat o podanym ID i sprawdza dostęp użytkownika do jego przedmiotu.
+// W razie błędu wysyła odpowiedź i zwraca nil.
+func loadAccessibleTopicByID(c *gin.Context, userUsosID string, topicID uint) *models.Topic {
+	topic, err := db.UserRepository.GetTopicByID(topicID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono tematu")
+		} else {
+			utils.SendInternalError(c, err)
+		}
+		return nil
+	}
+	if !checkSubjectAccess(c, userUsosID, topic.SubjectID) {
+		return nil
+	}
+	return topic
+}
+
+// checkSubjectAccess sprawdza, czy użytkownik jest zapisany na przedmiot (administrator ma dostęp do wszystkich).
+// W razie braku dostępu wysyła odpowiedź i zwraca false.
+func checkSubjectAccess(c *gin.Context, userUsosID string, subjectID uint) bool {
+	subjectIDs, err := searchSubjectScope(userUsosID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return false
+	}
+	if subjectIDs != nil && !containsUint(subjectIDs, subjectID) {
+		utils.SendError(c, http.StatusForbidden, "Nie jesteś zapisany na przedmiot tego tematu")
+		return false
+	}
+	return true
+}
+
+// loadOwnedTutorConversation pobiera rozmowę z parametru :id należącą do użytkownika.
+// W razie błędu wysyła odpowiedź i zwraca nil.
+func loadOwnedTutorConversation(c *gin.Context, userUsosID string) *models.TutorConversation {
+	convID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe ID rozmowy")
+		return nil
+	}
+	conv, err := db.UserRepository.GetTutorConversation(uint(convID))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		utils.SendInternalError(c, err)
+		return nil
+	}
+	if conv == nil || conv.UserUsosID != userUsosID {
+		utils.SendError(c, http.StatusNotFound, "Nie znaleziono rozmowy")
+		return nil
+	}
+	return conv
+}
+
+// HandleAskTutor zadaje pytanie tutorowi AI tematu. Bez conversation_id rozpoczyna nową rozmowę.
+// Odpowiedź opiera się wyłącznie na zatwierdzonych treściach i materiałach tematu (z cytowaniami).
+func HandleAskTutor(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	topic := loadAccessibleTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+
+	var req struct {
+		Message        string `json:"message" binding:"required"`
+		ConversationID *uint  `json:"conversation_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.SendError(c, http.StatusBadRequest, "Nieprawidłowe dane: "+err.Error())
+		return
+	}
+	question := strings.TrimSpace(req.Message)
+	if question == "" || utf8.RuneCountInString(question) > services.TutorMaxQuestionChars {
+		utils.SendError(c, http.StatusBadRequest, "Pytanie musi mieć od 1 do "+strconv.Itoa(services.TutorMaxQuestionChars)+" znaków")
+		return
+	}
+	if !respondQuotaCheck(c, services.CheckTutorQuota(userUsosID)) {
+		return
+	}
+
+	var conv *models.TutorConversation
+	var history []models.TutorMessage
+	if req.ConversationID != nil {
+		existing, err := db.UserRepository.GetTutorConversation(*req.ConversationID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			utils.SendInternalError(c, err)
+			return
+		}
+		if existing == nil || existing.UserUsosID != userUsosID || existing.TopicID != topic.ID {
+			utils.SendError(c, http.StatusNotFound, "Nie znaleziono rozmowy")
+			return
+		}
+		conv = existing
+		if history, err = db.UserRepository.GetTutorMessages(conv.ID, services.TutorHistoryMessages); err != nil {
+			utils.SendInternalError(c, err)
+			return
+		}
+	}
+
+	answer, err := services.AskTutor(c.Request.Context(), userUsosID, topic, history, question)
+	if err != nil {
+		utils.SendError(c, http.StatusBadGateway, "Błąd tutora AI: "+err.Error())
+		return
+	}
+
+	if conv == nil {
+		conv = &models.TutorConversation{UserUsosID: userUsosID, TopicID: topic.ID, Title: snippet(question, 80)}
+		if err := db.UserRepository.CreateTutorConversation(conv); err != nil {
+			utils.SendInternalError(c, err)
+			return
+		}
+	}
+	userMsg := &models.TutorMessage{Role: models.TutorRoleUser, Content: question}
+	assistantMsg := &models.TutorMessage{
+		Role:        models.TutorRoleAssistant,
+		Content:     answer.Content,
+		Citations:   answer.Citations,
+		Refused:     answer.Refused,
+		TotalTokens: answer.Usage.TotalTokens,
+	}
+	if err := db.UserRepository.AppendTutorExchange(conv.ID, userMsg, assistantMsg); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+
+	utils.SendSuccess(c, http.StatusOK, gin.H{
+		"conversation_id": conv.ID,
+		"question":        userMsg,
+		"answer":          assistantMsg,
+	})
+}
+
+// HandleGetTutorConversations zwraca rozmowy użytkownika z tutorem w temacie
+func HandleGetTutorConversations(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	topic := loadAccessibleTopic(c, userUsosID)
+	if topic == nil {
+		return
+	}
+	convs, err := db.UserRepository.GetTutorConversations(userUsosID, topic.ID)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	if convs == nil {
+		convs = []models.TutorConversation{}
+	}
+	utils.SendSuccess(c, http.StatusOK, convs)
+}
+
+// HandleGetTutorConversation zwraca rozmowę wraz z całą historią wiadomości
+func HandleGetTutorConversation(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	conv := loadOwnedTutorConversation(c, userUsosID)
+	if conv == nil {
+		return
+	}
+	messages, err := db.UserRepository.GetTutorMessages(conv.ID, 0)
+	if err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"conversation": conv, "messages": messages})
+}
+
+// HandleDeleteTutorConversation usuwa rozmowę użytkownika wraz z historią
+func HandleDeleteTutorConversation(c *gin.Context) {
+	userUsosID := c.MustGet("user_usos_id").(string)
+	conv := loadOwnedTutorConversation(c, userUsosID)
+	if conv == nil {
+		return
+	}
+	if err := db.UserRepository.DeleteTutorConversation(conv.ID); err != nil {
+		utils.SendInternalError(c, err)
+		return
+	}
+	utils.SendSuccess(c, http.StatusOK, gin.H{"message": "Rozmowa została usunięta"})
+}
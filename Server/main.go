@@ -26,7 +26,17 @@ func main() {
 	defer db.CloseDB()
 
 	services.InitUsosService(cfg)
-	services.InitGeminiService(cfg)
+	services.InitLLMProvider(cfg)
+	services.InitAIQuotas(cfg)
+	services.InitPromptTemplates()
+	services.InitQuestionTypes(cfg)
+	services.InitSourceMaterialStore(cfg)
+	services.InitMediaStore(cfg)
+	services.InitAnkiPackages(cfg)
+	services.InitGenerationWorkers(cfg)
+	services.InitScreeningWorker(cfg)
+	services.InitSearchIndex(cfg)
+	services.InitTutor(cfg)
 
 	router := gin.Default()
 	router.SetTrustedProxies([]string{"127.0.0.1", "::1"})
@@ -48,11 +58,17 @@ func main() {
 		authGroup.POST("/logout", handlers.HandleLogout)
 	}
 
+	// Załączniki pod podpisanymi adresami (bez sesji - dostęp daje podpis)
+	router.GET("/media/:id", handlers.HandleServeMedia)
+
 	// API Protected
 	apiGroup := router.Group("/api")
 	apiGroup.Use(middleware.AuthRequired())
 	{
 		apiGroup.GET("/users/me", handlers.HandleGetUserMe)
+		apiGroup.GET("/users/me/ai-usage", handlers.HandleGetMyAIUsage)
+		apiGroup.GET("/search", handlers.HandleSearch)
+		apiGroup.GET("/search/text", handlers.HandleFullTextSearch)
 
 		// --- DASHBOARD ENDPOINTS ---
 		apiGroup.GET("/dashboard/upcoming", handlers.HandleGetUpcomingEvents)
@@ -64,13 +80,53 @@ func main() {
 		apiGroup.GET("/subjects", handlers.HandleGetSubjects)
 		apiGroup.POST("/subjects/sync", handlers.HandleSyncSubjects)
 		apiGroup.GET("/subjects/:usos_id/topics", handlers.HandleGetTopicsByUsosID)
+		apiGroup.GET("/subjects/:usos_id/topics/tree", handlers.HandleGetTopicTree)
+		apiGroup.GET("/subjects/:usos_id/export/anki", handlers.HandleExportSubjectAnki)
 		apiGroup.POST("/topics", handlers.HandleCreateTopic)
+		apiGroup.PATCH("/topics/:id", handlers.HandleUpdateTopic)
+		apiGroup.DELETE("/topics/:id", handlers.HandleDeleteTopic)
 		apiGroup.GET("/subjects/:usos_id/graph", handlers.HandleGetCourseGraph)
 
 		// Content Generation
 		apiGroup.POST("/topics/upload", handlers.HandleContentUpload)
+		apiGroup.POST("/topics/upload/stream", handlers.HandleContentUploadStream)
 		apiGroup.POST("/flashcards/manual", handlers.HandleManualFlashcard)
+		apiGroup.PUT("/flashcards/:id", handlers.HandleUpdateFlashcard)
+		apiGroup.GET("/flashcards/:id/revisions", handlers.HandleGetFlashcardRevisions)
+		apiGroup.PUT("/flashcards/:id/metadata", handlers.HandleSetFlashcardMetadata)
+		apiGroup.POST("/flashcards/:id/revert", handlers.HandleRevertFlashcard)
+		apiGroup.POST("/content/render", handlers.HandleRenderContent)
+		apiGroup.GET("/tags", handlers.HandleGetTags)
+		apiGroup.POST("/flashcards/:id/media", handlers.HandleUploadFlashcardMedia)
+		apiGroup.GET("/flashcards/:id/media", handlers.HandleGetFlashcardMedia)
+		apiGroup.DELETE("/media/:id", handlers.HandleDeleteMedia)
 		apiGroup.GET("/topics/:id/content", handlers.HandleGetTopicContent)
+		apiGroup.GET("/topics/:id/quiz-session", handlers.HandleStartQuizSession)
+		apiGroup.POST("/topics/:id/import/anki", handlers.HandleImportAnkiDeck)
+		apiGroup.GET("/topics/:id/export/anki", handlers.HandleExportTopicAnki)
+		apiGroup.POST("/topics/:id/tutor", handlers.HandleAskTutor)
+		apiGroup.GET("/topics/:id/tutor/conversations", handlers.HandleGetTutorConversations)
+		apiGroup.GET("/tutor/conversations/:id", handlers.HandleGetTutorConversation)
+		apiGroup.DELETE("/tutor/conversations/:id", handlers.HandleDeleteTutorConversation)
+		apiGroup.POST("/quiz-questions/:id/explain", handlers.HandleExplainQuizQuestion)
+		apiGroup.PUT("/quiz-questions/:id/explanations", handlers.HandleUpdateQuizExplanations)
+		apiGroup.POST("/topics/:id/quiz-questions", handlers.HandleCreateQuizQuestion)
+		apiGroup.PUT("/quiz-questions/:id", handlers.HandleUpdateQuizQuestion)
+		apiGroup.GET("/quiz-questions/:id/revisions", handlers.HandleGetQuizQuestionRevisions)
+		apiGroup.PUT("/quiz-questions/:id/metadata", handlers.HandleSetQuizQuestionMetadata)
+		apiGroup.POST("/quiz-questions/:id/revert", handlers.HandleRevertQuizQuestion)
+		apiGroup.POST("/quiz-questions/:id/media", handlers.HandleUploadQuizQuestionMedia)
+		apiGroup.GET("/quiz-questions/:id/media", handlers.HandleGetQuizQuestionMedia)
+		apiGroup.POST("/quiz-questions/:id/answer", handlers.HandleAnswerQuizQuestion)
+		apiGroup.GET("/quiz-questions/:id/answers", handlers.HandleGetQuizQuestionAnswers)
+		apiGroup.GET("/quiz-answers/:id", handlers.HandleGetQuizAnswer)
+		apiGroup.POST("/quiz-answers/:id/regrade", handlers.HandleRegradeQuizAnswer)
+		apiGroup.GET("/topic-notes/:id", handlers.HandleGetTopicNote)
+		apiGroup.PUT("/topic-notes/:id", handlers.HandleUpdateTopicNote)
+		apiGroup.GET("/source-materials/:id", handlers.HandleGetSourceMaterial)
+		apiGroup.GET("/generation-jobs/:id", handlers.HandleGetGenerationJob)
+		apiGroup.POST("/generation-jobs/:id/cancel", handlers.HandleCancelGenerationJob)
+		apiGroup.POST("/generation-jobs/:id/retry", handlers.HandleRetryGenerationJob)
 
 		apiGroup.GET("/calendar/all-events", handlers.HandleGetAllCalendarEvents)
 		apiGroup.GET("/calendar/usos-groups", handlers.HandleGetUserUsosGroups)
@@ -86,6 +142,29 @@ func main() {
 			adminGroup.GET("/pending-flashcards", handlers.HandleGetPendingFlashcards)
 			adminGroup.POST("/approve-flashcard/:id", handlers.HandleApproveFlashcard)
 			adminGroup.POST("/reject-flashcard/:id", handlers.HandleRejectFlashcard)
+			adminGroup.POST("/merge-flashcard/:id", handlers.HandleMergeFlashcard)
+			adminGroup.GET("/pending-quiz-questions", handlers.HandleGetPendingQuizQuestions)
+			adminGroup.POST("/approve-quiz-question/:id", handlers.HandleApproveQuizQuestion)
+			adminGroup.POST("/reject-quiz-question/:id", handlers.HandleRejectQuizQuestion)
+			adminGroup.POST("/merge-quiz-question/:id", handlers.HandleMergeQuizQuestion)
+			adminGroup.GET("/pending-explanations", handlers.HandleGetPendingExplanations)
+			adminGroup.POST("/approve-explanation/:id", handlers.HandleApproveExplanation)
+			adminGroup.POST("/reject-explanation/:id", handlers.HandleRejectExplanation)
+			adminGroup.PUT("/subjects/:usos_id/auto-reject", handlers.HandleSetSubjectAutoReject)
+			adminGroup.GET("/pending-notes", handlers.HandleGetPendingTopicNotes)
+			adminGroup.POST("/approve-note/:id", handlers.HandleApproveTopicNote)
+			adminGroup.POST("/reject-note/:id", handlers.HandleRejectTopicNote)
+			adminGroup.GET("/ai-usage", handlers.HandleGetAIUsageReport)
+			adminGroup.PUT("/tags/:name", handlers.HandleSaveContentTag)
+			adminGroup.DELETE("/tags/:name", handlers.HandleDeleteContentTag)
+			adminGroup.GET("/prompt-templates", handlers.HandleGetPromptTemplates)
+			adminGroup.GET("/prompt-templates/:name/versions", handlers.HandleGetPromptTemplateVersions)
+			adminGroup.PUT("/prompt-templates/:name", handlers.HandleUpdatePromptTemplate)
+			adminGroup.POST("/prompt-templates/:name/preview", handlers.HandlePreviewPromptTemplate)
+			adminGroup.POST("/subjects/:usos_id/graph/generate", handlers.HandleGenerateCourseGraph)
+			adminGroup.GET("/subjects/:usos_id/graph/draft", handlers.HandleGetCourseGraphDraft)
+			adminGroup.PUT("/subjects/:usos_id/graph/draft", handlers.HandleUpdateCourseGraphDraft)
+			adminGroup.POST("/subjects/:usos_id/graph/publish", handlers.HandlePublishCourseGraph)
 		}
 
 		// Proxy Fallback
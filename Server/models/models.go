@@ -40,52 +40,599 @@ type Subject struct {
 	ID     uint   `gorm:"primarykey"`
 	UsosID string `gorm:"unique;not null"`
 	Name   string `gorm:"not null"`
+	// Treści z oceną wstępną AI poniżej progu są odrzucane automatycznie (nil = wyłączone)
+	AutoRejectBelow *int
 }
 
 func (Subject) TableName() string { return "subjects" }
 
+// UserSubject to zapis użytkownika na przedmiot (uzupełniany przy synchronizacji kursów z USOS)
+type UserSubject struct {
+	UserUsosID string `gorm:"primaryKey"`
+	SubjectID  uint   `gorm:"primaryKey;index"`
+	CreatedAt  time.Time
+}
+
+func (UserSubject) TableName() string { return "user_subjects" }
+
+// Topic to temat przedmiotu. Tematy mogą być zagnieżdżone (ParentTopicID), a Position określa kolejność
+// wśród tematów o tym samym rodzicu. Usunięcie jest miękkie (DeletedAt).
 type Topic struct {
-	ID              uint    `gorm:"primarykey"`
-	SubjectID       uint    `gorm:"not null;index"`
-	Subject         Subject `gorm:"foreignKey:SubjectID"` // <--- NAPRAWIONO: Dodano relację
-	Name            string  `gorm:"not null"`
-	CreatedByUsosID string  `gorm:"not null"`
+	ID              uint           `gorm:"primarykey"`
+	SubjectID       uint           `gorm:"not null;index"`
+	Subject         Subject        `gorm:"foreignKey:SubjectID"` // <--- NAPRAWIONO: Dodano relację
+	ParentTopicID   *uint          `gorm:"index"`
+	Position        int            `gorm:"default:0;not null"`
+	Name            string         `gorm:"not null"`
+	CreatedByUsosID string         `gorm:"not null"`
+	DeletedAt       gorm.DeletedAt `gorm:"index"`
 }
 
 func (Topic) TableName() string { return "topics" }
 
+const (
+	QuizNodeDraft     = "draft"
+	QuizNodePublished = "published"
+)
+
+// QuizNode to węzeł mapy quizów kursu. Dependencies to ID węzłów (tego samego kursu i statusu),
+// które trzeba opanować wcześniej. Graf wygenerowany przez AI trafia do wersji roboczej (draft),
+// a po przejrzeniu przez prowadzącego zastępuje opublikowany graf.
 type QuizNode struct {
 	gorm.Model
 	UsosCourseID string        `gorm:"index;not null"`
 	Title        string        `gorm:"not null"`
 	Dependencies pq.Int64Array `gorm:"type:integer[]"`
+	TopicID      *uint         `gorm:"index"`
+	Status       string        `gorm:"default:'published';not null;index"`
+	Rationale    string        `gorm:"type:text"` // uzasadnienie zależności podane przez AI
 }
 
 func (QuizNode) TableName() string { return "quiz_nodes" }
 
 type Flashcard struct {
+	ID       uint   `gorm:"primarykey"`
+	TopicID  uint   `gorm:"not null;index"`
+	Question string `gorm:"type:text;not null"`
+	Answer   string `gorm:"type:text;not null"`
+	// Zadeklarowany format pytania i odpowiedzi (ContentFormat*)
+	QuestionFormat  FieldFormat `gorm:"embedded;embeddedPrefix:question_"`
+	AnswerFormat    FieldFormat `gorm:"embedded;embeddedPrefix:answer_"`
+	Status          string      `gorm:"default:'pending';not null;index"`
+	CreatedByUsosID string      `gorm:"not null"`
+	ContentMetadata
+	// Źródło wygenerowanej treści (plik i numery stron/slajdów) oraz powiązane materiały źródłowe
+	SourceFile        string
+	SourcePages       pq.Int64Array `gorm:"type:integer[]"`
+	SourceMaterialIDs pq.Int64Array `gorm:"type:integer[]"`
+	// Prawdopodobny duplikat istniejącej fiszki z tego samego tematu (status 'merged' po scaleniu)
+	DuplicateOfID  *uint `gorm:"index"`
+	DuplicateScore float64
+	// Wstępna ocena AI (0-100, nil = jeszcze nie oceniono) i jej uzasadnienie
+	ScreeningScore    *int           `gorm:"index"`
+	ScreeningReasons  pq.StringArray `gorm:"type:text[]"`
+	ScreeningAttempts int            `gorm:"default:0;not null"`
+	// Moment przejęcia do oceny - inny worker nie przejmie elementu, dopóki ocena nie wygaśnie
+	ScreeningClaimedAt *time.Time
+	// Wersja szablonu promptu i parametry, z którymi wygenerowano fiszkę (nil = dodana ręcznie)
+	PromptTemplateID *uint             `gorm:"index"`
+	GenerationParams *GenerationParams `gorm:"type:jsonb;serializer:json"`
+	// Numer wersji zwiększany przy każdej edycji (historia w ContentRevision)
+	Version         int `gorm:"default:1;not null"`
+	UpdatedByUsosID string
+	// HTML pól wyrenderowany przez serwer - wypełniany tylko na żądanie (?render=html)
+	Rendered *RenderedContent `gorm:"-" json:",omitempty"`
+	// Załączniki z podpisanymi adresami URL - wypełniane przy odczycie treści tematu
+	Attachments []MediaAttachment `gorm:"-" json:",omitempty"`
+	// Ustawiane razem z DeletedAt tematu przy jego usunięciu (status fiszki pozostaje bez zmian)
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (Flashcard) TableName() string { return "flashcards" }
+
+// ContentMetadata to metadane fiszki lub pytania, według których można filtrować treści
+type ContentMetadata struct {
+	// Tagi dowolne lub z listy kuratorowanej (ContentTag) - małymi literami, bez spacji
+	Tags pq.StringArray `gorm:"type:text[];index:,type:gin"`
+	// Poziom trudności ustawiony przez autora (Difficulty*, pusty = nieokreślony)
+	Difficulty string `gorm:"index"`
+	// Kategoria taksonomii Blooma (Bloom*, pusta = nieokreślona)
+	BloomLevel string `gorm:"index"`
+}
+
+// Poziomy trudności treści
+const (
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// Kategorie taksonomii Blooma (od najprostszej)
+const (
+	BloomRemember   = "remember"
+	BloomUnderstand = "understand"
+	BloomApply      = "apply"
+	BloomAnalyze    = "analyze"
+	BloomEvaluate   = "evaluate"
+	BloomCreate     = "create"
+)
+
+// ContentTag to tag z listy kuratorowanej przez moderatorów (podpowiadany przy tagowaniu treści).
+// Treści mogą mieć także tagi spoza listy.
+type ContentTag struct {
 	ID              uint   `gorm:"primarykey"`
-	TopicID         uint   `gorm:"not null;index"`
-	Question        string `gorm:"type:text;not null"`
-	Answer          string `gorm:"type:text;not null"`
-	Status          string `gorm:"default:'pending';not null;index"`
+	Name            string `gorm:"not null;uniqueIndex"`
+	Description     string `gorm:"type:text"`
 	CreatedByUsosID string `gorm:"not null"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
 }
 
-func (Flashcard) TableName() string { return "flashcards" }
+func (ContentTag) TableName() string { return "content_tags" }
+
+// Formaty treści pól tekstowych
+const (
+	ContentFormatPlain    = "plain"
+	ContentFormatMarkdown = "markdown" // Markdown ze wzorami KaTeX ($...$, $$...$$) i blokami kodu
+	ContentFormatCode     = "code"     // kod źródłowy w języku FieldFormat.Language
+)
+
+// FieldFormat to zadeklarowany format pola tekstowego
+type FieldFormat struct {
+	Format   string `gorm:"default:'plain';not null" json:"format"`
+	Language string `json:"language,omitempty"` // tylko dla ContentFormatCode
+}
+
+// RenderedContent to oczyszczony HTML pól treści
+type RenderedContent struct {
+	Question        string   `json:"question"`
+	Answer          string   `json:"answer,omitempty"`
+	Options         []string `json:"options,omitempty"`
+	ReferenceAnswer string   `json:"reference_answer,omitempty"`
+}
 
 type QuizQuestion struct {
-	ID                 uint           `gorm:"primarykey"`
-	TopicID            uint           `gorm:"not null;index"`
-	QuestionText       string         `gorm:"type:text;not null"`
+	ID             uint        `gorm:"primarykey"`
+	TopicID        uint        `gorm:"not null;index"`
+	QuestionText   string      `gorm:"type:text;not null"`
+	QuestionFormat FieldFormat `gorm:"embedded;embeddedPrefix:question_"`
+	// QuestionType: jeden z typów QuestionType* (dane potrzebne do sprawdzenia odpowiedzi - opis przy stałych)
+	QuestionType       string         `gorm:"default:'single_choice';not null;index"`
 	Options            pq.StringArray `gorm:"type:text[]"`
+	OptionsFormat      FieldFormat    `gorm:"embedded;embeddedPrefix:options_"` // wspólny dla wszystkich opcji
 	CorrectOptionIndex int            `gorm:"not null"`
+	// Tylko dla pytań otwartych: odpowiedź wzorcowa i punktowane kryteria oceny (niewidoczne dla uczniów)
+	ReferenceAnswer       string        `gorm:"type:text"`
+	ReferenceAnswerFormat FieldFormat   `gorm:"embedded;embeddedPrefix:reference_answer_"`
+	Rubric                []RubricPoint `gorm:"type:jsonb;serializer:json"`
+	// Klucz odpowiedzi pozostałych typów (QuestionPayload); nil dla single_choice, ordering i open
+	Payload *QuestionPayload `gorm:"type:jsonb;serializer:json"`
+	// Wyjaśnienia opcji (w kolejności Options): dlaczego opcja jest poprawna lub błędna.
+	// Uczniowie widzą je tylko ze statusem "approved"; pusty status = brak wyjaśnień.
+	OptionExplanations pq.StringArray `gorm:"type:text[]"`
+	ExplanationStatus  string         `gorm:"index"`
 	Status             string         `gorm:"default:'pending';not null;index"`
 	CreatedByUsosID    string         `gorm:"not null"`
+	ContentMetadata
+	// Poziom trudności obliczony ze statystyk odpowiedzi (pusty, dopóki odpowiedzi jest za mało) -
+	// przy filtrowaniu ma pierwszeństwo przed poziomem ustawionym przez autora
+	ComputedDifficulty string `gorm:"index"`
+	SourceFile         string
+	SourcePages        pq.Int64Array `gorm:"type:integer[]"`
+	SourceMaterialIDs  pq.Int64Array `gorm:"type:integer[]"`
+	DuplicateOfID      *uint         `gorm:"index"`
+	DuplicateScore     float64
+	ScreeningScore     *int           `gorm:"index"`
+	ScreeningReasons   pq.StringArray `gorm:"type:text[]"`
+	ScreeningAttempts  int            `gorm:"default:0;not null"`
+	ScreeningClaimedAt *time.Time
+	PromptTemplateID   *uint             `gorm:"index"`
+	GenerationParams   *GenerationParams `gorm:"type:jsonb;serializer:json"`
+	Version            int               `gorm:"default:1;not null"`
+	UpdatedByUsosID    string
+	Rendered           *RenderedContent  `gorm:"-" json:",omitempty"`
+	Attachments        []MediaAttachment `gorm:"-" json:",omitempty"`
+	DeletedAt          gorm.DeletedAt    `gorm:"index" json:"-"`
 }
 
 func (QuizQuestion) TableName() string { return "quiz_questions" }
 
+// Typy pytań quizowych. Dane potrzebne do sprawdzenia odpowiedzi zależą od typu:
+//   - single_choice: Options + CorrectOptionIndex
+//   - multiple_choice: Options + Payload.CorrectIndices
+//   - true_false: Payload.CorrectBool (treść pytania to stwierdzenie)
+//   - numeric: Payload.Numeric (wartość, tolerancja, jednostka)
+//   - ordering: Options w poprawnej kolejności (uczniowie widzą je przetasowane)
+//   - cloze: luki {{1}}, {{2}}... w QuestionText + Payload.Blanks
+//   - open: ReferenceAnswer + Rubric (ocena przez AI)
+const (
+	QuestionTypeSingleChoice   = "single_choice"
+	QuestionTypeMultipleChoice = "multiple_choice"
+	QuestionTypeTrueFalse      = "true_false"
+	QuestionTypeNumeric        = "numeric"
+	QuestionTypeOrdering       = "ordering"
+	QuestionTypeCloze          = "cloze"
+	QuestionTypeOpen           = "open"
+)
+
+// QuestionPayload to klucz odpowiedzi zależny od typu pytania (pola innych typów pozostają puste)
+type QuestionPayload struct {
+	CorrectIndices []int          `json:"correct_indices,omitempty"` // multiple_choice, rosnąco
+	CorrectBool    *bool          `json:"correct_bool,omitempty"`    // true_false
+	Numeric        *NumericAnswer `json:"numeric,omitempty"`         // numeric
+	Blanks         []ClozeBlank   `json:"blanks,omitempty"`          // cloze, w kolejności numerów luk
+}
+
+// NumericAnswer to poprawna wartość pytania liczbowego. Odpowiedź jest poprawna, gdy różni się od Value
+// najwyżej o Tolerance (po przeliczeniu jednostki alternatywnej na Unit).
+type NumericAnswer struct {
+	Value     float64          `json:"value"`
+	Tolerance float64          `json:"tolerance"`
+	Unit      string           `json:"unit,omitempty"`
+	AltUnits  []UnitConversion `json:"alt_units,omitempty"`
+}
+
+// UnitConversion to jednostka alternatywna: wartość w Unit pomnożona przez Factor daje wartość w jednostce głównej
+type UnitConversion struct {
+	Unit   string  `json:"unit"`
+	Factor float64 `json:"factor"`
+}
+
+// ClozeBlank to jedna luka: akceptowane odpowiedzi (porównywane bez wielkości liter, chyba że CaseSensitive)
+type ClozeBlank struct {
+	Answers       []string `json:"answers"`
+	CaseSensitive bool     `json:"case_sensitive,omitempty"`
+}
+
+// RubricPoint to jedno kryterium oceny odpowiedzi otwartej
+type RubricPoint struct {
+	Text   string `json:"text"`
+	Points int    `json:"points"`
+}
+
+// --- HISTORIA ZMIAN TREŚCI ---
+
+// ContentRevision to jedna edycja fiszki lub pytania quizowego: stan przed i po zmianie,
+// lista zmienionych pól, autor zmiany i jej powód. Version to numer wersji elementu po zmianie.
+type ContentRevision struct {
+	ID                  uint             `gorm:"primarykey"`
+	ItemType            string           `gorm:"not null;index:idx_content_revisions_item"` // SearchItemFlashcard lub SearchItemQuizQuestion
+	ItemID              uint             `gorm:"not null;index:idx_content_revisions_item"`
+	Version             int              `gorm:"not null"`
+	EditorUsosID        string           `gorm:"not null"`
+	Reason              string           `gorm:"type:text"`
+	Changes             []RevisionChange `gorm:"type:jsonb;serializer:json"`
+	Before              RevisionSnapshot `gorm:"type:jsonb;serializer:json"`
+	After               RevisionSnapshot `gorm:"type:jsonb;serializer:json"`
+	RevertedFromVersion *int             // ustawione, gdy zmiana przywraca wcześniejszą wersję
+	CreatedAt           time.Time
+}
+
+func (ContentRevision) TableName() string { return "content_revisions" }
+
+// RevisionSnapshot to edytowalne pola fiszki (Question, Answer) lub pytania quizowego
+type RevisionSnapshot struct {
+	Question        string        `json:"question"`
+	Answer          string        `json:"answer,omitempty"`
+	Options         []string      `json:"options,omitempty"`
+	CorrectIndex    int           `json:"correct_index"`
+	ReferenceAnswer string        `json:"reference_answer,omitempty"`
+	Rubric          []RubricPoint `json:"rubric,omitempty"`
+	// Klucz odpowiedzi pytań typów multiple_choice, true_false, numeric i cloze
+	Payload *QuestionPayload `json:"payload,omitempty"`
+	// Formaty pól (pusty format w starszych wpisach historii oznacza ContentFormatPlain)
+	QuestionFormat        FieldFormat `json:"question_format"`
+	AnswerFormat          FieldFormat `json:"answer_format"`
+	OptionsFormat         FieldFormat `json:"options_format"`
+	ReferenceAnswerFormat FieldFormat `json:"reference_answer_format"`
+}
+
+// RevisionChange to zmiana jednego pola (wartości w postaci tekstowej)
+type RevisionChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// --- ODPOWIEDZI NA PYTANIA ---
+
+// QuizAnswer to odpowiedź ucznia na pytanie quizowe. Pytania zamknięte są sprawdzane od razu,
+// odpowiedzi otwarte ocenia model AI - wynik pochodzi z najnowszej oceny (LatestGradingID).
+type QuizAnswer struct {
+	ID              uint   `gorm:"primarykey"`
+	UserUsosID      string `gorm:"not null;index"`
+	QuestionID      uint   `gorm:"not null;index"`
+	TopicID         uint   `gorm:"not null;index"`
+	QuestionType    string `gorm:"not null"`
+	AnswerText      string `gorm:"type:text"`
+	SelectedOption  *int
+	Response        *AnswerResponse `gorm:"type:jsonb;serializer:json"` // odpowiedź na pytania pozostałych typów
+	Score           int
+	MaxScore        int
+	GradingStatus   string `gorm:"not null;index"` // AnswerGraded lub AnswerGradingFailed
+	LatestGradingID *uint
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+func (QuizAnswer) TableName() string { return "quiz_answers" }
+
+// AnswerResponse to odpowiedź ucznia na pytanie typu multiple_choice, true_false, numeric, ordering lub cloze
+type AnswerResponse struct {
+	SelectedOptions []int    `json:"selected_options,omitempty"`
+	Bool            *bool    `json:"answer_bool,omitempty"`
+	Value           *float64 `json:"value,omitempty"`
+	Unit            string   `json:"unit,omitempty"`
+	Order           []int    `json:"order,omitempty"` // indeksy opcji w kolejności podanej przez ucznia (po tasowaniu)
+	Blanks          []string `json:"blanks,omitempty"`
+}
+
+// Statusy oceny odpowiedzi
+const (
+	AnswerGraded        = "graded"
+	AnswerGradingFailed = "failed"
+)
+
+// AnswerGrading to jedna ocena odpowiedzi otwartej przez model. Zapisuje model i wersję szablonu promptu,
+// aby ocenę dało się skontrolować i powtórzyć; ponowne oceny dodają nowe wiersze.
+type AnswerGrading struct {
+	ID                uint   `gorm:"primarykey"`
+	AnswerID          uint   `gorm:"not null;index"`
+	Provider          string `gorm:"not null"`
+	Model             string `gorm:"not null"`
+	PromptTemplateID  *uint  `gorm:"index"` // nil dla szablonu wbudowanego
+	PromptVersion     int
+	Score             int
+	MaxScore          int
+	Feedback          string        `gorm:"type:text"`
+	MatchedPoints     pq.Int64Array `gorm:"type:integer[]"` // indeksy spełnionych kryteriów (od 0)
+	RawResponse       string        `gorm:"type:text"`
+	RequestedByUsosID string        `gorm:"not null"` // uczeń lub moderator zlecający ponowną ocenę
+	TotalTokens       int
+	CreatedAt         time.Time
+}
+
+func (AnswerGrading) TableName() string { return "answer_gradings" }
+
+// TopicNote to podsumowanie tematu w formacie Markdown. Przechodzi tę samą moderację co fiszki,
+// a każda edycja zwiększa numer wersji.
+type TopicNote struct {
+	ID                uint   `gorm:"primarykey"`
+	TopicID           uint   `gorm:"not null;index"`
+	Body              string `gorm:"type:text;not null"`
+	Status            string `gorm:"default:'pending';not null;index"`
+	Version           int    `gorm:"default:1;not null"`
+	CreatedByUsosID   string `gorm:"not null"`
+	UpdatedByUsosID   string
+	SourceFile        string
+	SourcePages       pq.Int64Array     `gorm:"type:integer[]"`
+	SourceMaterialIDs pq.Int64Array     `gorm:"type:integer[]"`
+	PromptTemplateID  *uint             `gorm:"index"`
+	GenerationParams  *GenerationParams `gorm:"type:jsonb;serializer:json"`
+	// Wstępna ocena AI (0-100, nil = jeszcze nie oceniono) i jej uzasadnienie
+	ScreeningScore     *int           `gorm:"index"`
+	ScreeningReasons   pq.StringArray `gorm:"type:text[]"`
+	ScreeningAttempts  int            `gorm:"default:0;not null"`
+	ScreeningClaimedAt *time.Time
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	DeletedAt          gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (TopicNote) TableName() string { return "topic_notes" }
+
+// PromptTemplate to jedna wersja szablonu promptu (text/template) dla typu generowania.
+// Edycja tworzy nowy wiersz z kolejnym numerem wersji; obowiązuje najnowsza wersja.
+// Szablon z SubjectID nadpisuje szablon globalny (SubjectID = nil) dla danego przedmiotu.
+type PromptTemplate struct {
+	ID              uint   `gorm:"primarykey"`
+	Name            string `gorm:"not null;index:idx_prompt_template_lookup"`
+	SubjectID       *uint  `gorm:"index:idx_prompt_template_lookup"`
+	Version         int    `gorm:"not null"`
+	Body            string `gorm:"type:text;not null"`
+	CreatedByUsosID string
+	CreatedAt       time.Time
+}
+
+func (PromptTemplate) TableName() string { return "prompt_templates" }
+
+// --- MODELE ZADAŃ GENEROWANIA AI ---
+
+const (
+	GenerationJobQueued    = "queued"
+	GenerationJobRunning   = "running"
+	GenerationJobSucceeded = "succeeded"
+	GenerationJobFailed    = "failed"
+	GenerationJobCancelled = "cancelled"
+)
+
+// GenerationJob to trwałe zadanie generowania treści przetwarzane w tle
+type GenerationJob struct {
+	ID              uint          `gorm:"primarykey"`
+	TopicID         uint          `gorm:"not null;index"`
+	Type            string        `gorm:"not null"`
+	Notes           string        `gorm:"type:text"`
+	Status          string        `gorm:"default:'queued';not null;index"`
+	Error           string        `gorm:"type:text"`
+	Attempts        int           `gorm:"default:0;not null"`
+	FlashcardIDs    pq.Int64Array `gorm:"type:integer[]"`
+	QuizQuestionIDs pq.Int64Array `gorm:"type:integer[]"`
+	TopicNoteIDs    pq.Int64Array `gorm:"type:integer[]"`
+	// Liczba porcji materiałów, których treści są już zapisane (i dopisane do list ID powyżej).
+	// Ponowienie zadania zaczyna od następnej porcji.
+	CompletedChunks int `gorm:"default:0;not null"`
+	// Materiały źródłowe przesłane do zadania (w kolejności przesłania)
+	SourceMaterialIDs pq.Int64Array     `gorm:"type:integer[]"`
+	RejectedItems     pq.StringArray    `gorm:"type:text[]"` // Elementy odrzucone przez walidację (z powodami)
+	Params            *GenerationParams `gorm:"type:jsonb;serializer:json"`
+	CreatedByUsosID   string            `gorm:"not null;index"`
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	StartedAt         *time.Time
+	FinishedAt        *time.Time
+}
+
+func (GenerationJob) TableName() string { return "generation_jobs" }
+
+// GenerationParams to parametry generowania wybrane przez użytkownika (zapisywane jako JSON
+// przy zadaniu i przy każdej wygenerowanej treści)
+type GenerationParams struct {
+	Count          int      `json:"count,omitempty"`
+	Difficulty     string   `json:"difficulty"`
+	Language       string   `json:"language"`
+	OptionCount    int      `json:"option_count,omitempty"`
+	QuestionTypes  []string `json:"question_types,omitempty"` // typy pytań quizu (pusty = single_choice)
+	QuestionStyles []string `json:"question_styles,omitempty"`
+	FocusKeywords  []string `json:"focus_keywords,omitempty"`
+}
+
+// --- WYSZUKIWANIE ---
+
+// ContentEmbedding to wektor zatwierdzonej treści (fiszki, pytania, notatki) używany w wyszukiwaniu semantycznym.
+// ContentHash (MD5 indeksowanego tekstu) pozwala wykryć zmianę treści wymagającą ponownego przeliczenia.
+type ContentEmbedding struct {
+	ID          uint   `gorm:"primarykey"`
+	ItemType    string `gorm:"not null;uniqueIndex:idx_content_embedding_item"`
+	ItemID      uint   `gorm:"not null;uniqueIndex:idx_content_embedding_item"`
+	TopicID     uint   `gorm:"not null"`
+	SubjectID   uint   `gorm:"not null;index"`
+	Model       string `gorm:"not null;index"`
+	ContentHash string `gorm:"not null"`
+	// Pusty wektor oznacza treść, której model nie zdołał przeliczyć - ponowna próba po zmianie tekstu lub modelu
+	Vector    pq.Float32Array `gorm:"type:real[]"`
+	UpdatedAt time.Time
+}
+
+func (ContentEmbedding) TableName() string { return "content_embeddings" }
+
+// Typy treści w indeksie wyszukiwania
+const (
+	SearchItemFlashcard    = "flashcard"
+	SearchItemQuizQuestion = "quiz_question"
+	SearchItemTopicNote    = "topic_note"
+	SearchItemTopic        = "topic" // tylko w wyszukiwaniu pełnotekstowym
+)
+
+// --- CZAT Z TUTOREM AI ---
+
+const (
+	TutorRoleUser      = "user"
+	TutorRoleAssistant = "assistant"
+)
+
+// TutorConversation to rozmowa użytkownika z tutorem AI w obrębie jednego tematu
+type TutorConversation struct {
+	ID         uint   `gorm:"primarykey"`
+	UserUsosID string `gorm:"not null;index:idx_tutor_conversation_owner"`
+	TopicID    uint   `gorm:"not null;index:idx_tutor_conversation_owner"`
+	Title      string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (TutorConversation) TableName() string { return "tutor_conversations" }
+
+// TutorMessage to jedna wiadomość rozmowy. Odpowiedzi tutora mają cytowania treści tematu,
+// na których się opierają; Refused oznacza odmowę z powodu braku odpowiedzi w materiałach.
+type TutorMessage struct {
+	ID             uint            `gorm:"primarykey"`
+	ConversationID uint            `gorm:"not null;index"`
+	Role           string          `gorm:"not null"`
+	Content        string          `gorm:"type:text;not null"`
+	Citations      []TutorCitation `gorm:"type:jsonb;serializer:json"`
+	Refused        bool            `gorm:"default:false;not null"`
+	TotalTokens    int
+	CreatedAt      time.Time
+}
+
+func (TutorMessage) TableName() string { return "tutor_messages" }
+
+// TutorCitation wskazuje treść tematu (models.SearchItem* lub "source_material"), na którą powołuje się odpowiedź
+type TutorCitation struct {
+	Type  string `json:"type"`
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+	Page  int    `json:"page,omitempty"`
+}
+
+// --- MATERIAŁY ŹRÓDŁOWE ---
+
+// SourceMaterial to przesłany plik źródłowy (slajdy, PDF, notatki). Zawartość leży w magazynie
+// plików pod kluczem SHA-256, więc ten sam plik przesłany kilka razy jest zapisany tylko raz.
+type SourceMaterial struct {
+	ID uint `gorm:"primarykey"`
+	// Ten sam plik (SHA-256 to klucz zawartości w magazynie) może mieć po jednym rekordzie na przesyłającego
+	SHA256          string `gorm:"column:sha256;size:64;uniqueIndex:idx_source_materials_owner_sha256;not null"`
+	Name            string `gorm:"not null"`
+	MIMEType        string `gorm:"column:mime_type;not null"`
+	Size            int64  `gorm:"not null"`
+	CreatedByUsosID string `gorm:"uniqueIndex:idx_source_materials_owner_sha256;not null"`
+	CreatedAt       time.Time
+}
+
+func (SourceMaterial) TableName() string { return "source_materials" }
+
+// SourceMaterialText to tekst stron pliku źródłowego wyodrębniony raz, przy przesłaniu. Klucz SHA-256
+// jest wspólny dla wszystkich rekordów SourceMaterial wskazujących na tę samą zawartość.
+// Strony bez warstwy tekstowej (skany) są pomijane.
+type SourceMaterialText struct {
+	SHA256    string           `gorm:"column:sha256;size:64;primarykey"`
+	Pages     []SourcePageText `gorm:"type:jsonb;serializer:json"`
+	CreatedAt time.Time
+}
+
+func (SourceMaterialText) TableName() string { return "source_material_texts" }
+
+// SourcePageText to tekst jednej strony (slajdu) materiału źródłowego
+type SourcePageText struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+}
+
+// --- ZAŁĄCZNIKI MULTIMEDIALNE ---
+
+// MediaAttachment to obraz dołączony do fiszki lub pytania (ItemType: SearchItemFlashcard lub SearchItemQuizQuestion).
+// Plik jest przekodowany (bez metadanych) i leży w magazynie plików pod losowym kluczem BlobKey;
+// klient pobiera go przez podpisany adres URL (pole URL, wypełniane przy odczycie).
+type MediaAttachment struct {
+	ID               uint   `gorm:"primarykey"`
+	ItemType         string `gorm:"not null;index:idx_media_attachments_item"`
+	ItemID           uint   `gorm:"not null;index:idx_media_attachments_item"`
+	BlobKey          string `gorm:"size:64;uniqueIndex;not null"`
+	MIMEType         string `gorm:"column:mime_type;not null"`
+	Size             int64  `gorm:"not null"`
+	Width            int
+	Height           int
+	OriginalName     string
+	AltText          string
+	Position         int    `gorm:"default:0;not null"`
+	UploadedByUsosID string `gorm:"not null"`
+	CreatedAt        time.Time
+	URL              string     `gorm:"-"`
+	URLExpiresAt     *time.Time `gorm:"-" json:",omitempty"`
+}
+
+func (MediaAttachment) TableName() string { return "media_attachments" }
+
+// --- ZUŻYCIE AI ---
+
+// AIUsage to zużycie modelu AI przez jedno zapytanie
+type AIUsage struct {
+	ID               uint   `gorm:"primarykey"`
+	UserUsosID       string `gorm:"index"` // pusty dla zadań systemowych (np. wstępnej oceny treści)
+	TopicID          *uint
+	SubjectID        *uint  `gorm:"index"`
+	Task             string `gorm:"not null"`
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	CreatedAt        time.Time `gorm:"index"`
+}
+
+func (AIUsage) TableName() string { return "ai_usage" }
+
 // --- MODELE DASHBOARDU ---
 
 type UserProgress struct {
@@ -238,13 +785,35 @@ type UsosActivity struct {
 }
 
 type GeneratedFlashcard struct {
-	Question string `json:"question"`
-	Answer   string `json:"answer"`
+	Question string  `json:"question"`
+	Answer   string  `json:"answer"`
+	Bloom    string  `json:"bloom,omitempty"` // kategoria taksonomii Blooma (Bloom*)
+	File     string  `json:"file,omitempty"`
+	Pages    []int64 `json:"pages,omitempty"`
 }
 type GeneratedQuizQuestion struct {
+	Type         string   `json:"type,omitempty"` // pusty = single_choice
 	Question     string   `json:"question"`
 	Options      []string `json:"options"`
 	CorrectIndex int      `json:"correctIndex"`
+	Explanations []string `json:"explanations,omitempty"`
+	// Pola pozostałych typów pytań
+	CorrectIndices  []int         `json:"correctIndices,omitempty"`
+	Correct         *bool         `json:"correct,omitempty"`
+	Value           *float64      `json:"value,omitempty"`
+	Tolerance       float64       `json:"tolerance,omitempty"`
+	Unit            string        `json:"unit,omitempty"`
+	Blanks          [][]string    `json:"blanks,omitempty"`
+	ReferenceAnswer string        `json:"referenceAnswer,omitempty"`
+	Rubric          []RubricPoint `json:"rubric,omitempty"`
+	Bloom           string        `json:"bloom,omitempty"`
+	File            string        `json:"file,omitempty"`
+	Pages           []int64       `json:"pages,omitempty"`
+}
+type GeneratedSummary struct {
+	Summary string  `json:"summary"`
+	File    string  `json:"file,omitempty"`
+	Pages   []int64 `json:"pages,omitempty"`
 }
 
 // UsosGroupMember reprezentuje prowadzącego lub uczestnika
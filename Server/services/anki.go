@@ -0,0 +1,339 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // sterownik SQLite dla kolekcji Anki
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- TALIE ANKI (.apkg) ---
+//
+// Paczka .apkg to archiwum zip z kolekcją SQLite w schemacie 11 ("collection.anki2" lub "collection.anki21"),
+// indeksem mediów "media" (JSON: numer pliku w archiwum -> nazwa pliku) i plikami mediów o nazwach "0", "1", ...
+
+const (
+	ankiFieldSeparator = "\x1f"
+	// ankiModelID to stały identyfikator typu notatki eksportowanych fiszek - kolejny eksport
+	// trafia w kolekcji użytkownika do tego samego typu notatki
+	ankiModelID int64 = 1700000000000
+	// Identyfikatory talii eksportu (Anki przy imporcie i tak dopasowuje talie po nazwie)
+	ankiDefaultDeckID int64 = 1
+	ankiDeckIDBase          = ankiModelID + 1
+	// ankiNoteGUIDPrefix poprzedza ID fiszki w GUID notatki - ponowny import w Anki aktualizuje
+	// wcześniej wyeksportowane notatki zamiast je powielać
+	ankiNoteGUIDPrefix = "iqt"
+	// ankiDifficultyTag i ankiBloomTag to przedrostki tagów, w których eksport zapisuje metadane treści
+	ankiDifficultyTag = "difficulty::"
+	ankiBloomTag      = "bloom::"
+)
+
+// AnkiMaxPackageSize to limit rozmiaru importowanej paczki .apkg
+var AnkiMaxPackageSize int64 = 100 << 20
+
+func InitAnkiPackages(cfg config.Config) {
+	if cfg.AnkiMaxFileSizeMB > 0 {
+		AnkiMaxPackageSize = cfg.AnkiMaxFileSizeMB << 20
+	}
+	log.Printf("Import talii Anki: limit paczki %d MB, maksymalnie %d notatek", AnkiMaxPackageSize>>20, MaxAnkiImportNotes)
+}
+
+// ankiSchema to tabele i indeksy kolekcji Anki w schemacie 11 (obsługiwanym przez wszystkie wersje Anki 2.1)
+const ankiSchema = `
+CREATE TABLE col (
+	id integer PRIMARY KEY, crt integer NOT NULL, mod integer NOT NULL, scm integer NOT NULL,
+	ver integer NOT NULL, dty integer NOT NULL, usn integer NOT NULL, ls integer NOT NULL,
+	conf text NOT NULL, models text NOT NULL, decks text NOT NULL, dconf text NOT NULL, tags text NOT NULL
+);
+CREATE TABLE notes (
+	id integer PRIMARY KEY, guid text NOT NULL, mid integer NOT NULL, mod integer NOT NULL, usn integer NOT NULL,
+	tags text NOT NULL, flds text NOT NULL, sfld integer NOT NULL, csum integer NOT NULL, flags integer NOT NULL,
+	data text NOT NULL
+);
+CREATE TABLE cards (
+	id integer PRIMARY KEY, nid integer NOT NULL, did integer NOT NULL, ord integer NOT NULL, mod integer NOT NULL,
+	usn integer NOT NULL, type integer NOT NULL, queue integer NOT NULL, due integer NOT NULL, ivl integer NOT NULL,
+	factor integer NOT NULL, reps integer NOT NULL, lapses integer NOT NULL, left integer NOT NULL,
+	odue integer NOT NULL, odid integer NOT NULL, flags integer NOT NULL, data text NOT NULL
+);
+CREATE TABLE revlog (
+	id integer PRIMARY KEY, cid integer NOT NULL, usn integer NOT NULL, ease integer NOT NULL, ivl integer NOT NULL,
+	lastIvl integer NOT NULL, factor integer NOT NULL, time integer NOT NULL, type integer NOT NULL
+);
+CREATE TABLE graves (usn integer NOT NULL, oid integer NOT NULL, type integer NOT NULL);
+CREATE INDEX ix_notes_usn ON notes (usn);
+CREATE INDEX ix_cards_usn ON cards (usn);
+CREATE INDEX ix_revlog_usn ON revlog (usn);
+CREATE INDEX ix_cards_nid ON cards (nid);
+CREATE INDEX ix_cards_sched ON cards (did, queue, due);
+CREATE INDEX ix_revlog_cid ON revlog (cid);
+CREATE INDEX ix_notes_csum ON notes (csum);
+`
+
+// ankiCardCSS to styl kart eksportu (wzory wyświetla MathJax wbudowany w Anki)
+const ankiCardCSS = `.card { font-family: arial; font-size: 20px; text-align: center; color: black; background-color: white; }
+.card img { max-width: 100%; }
+pre { text-align: left; }`
+
+// AnkiDeck to talia eksportu: nazwa (poziomy oddzielone "::") i jej fiszki
+type AnkiDeck struct {
+	Name       string
+	Flashcards []models.Flashcard
+}
+
+// ankiMediaFile to plik mediów dołączany do paczki
+type ankiMediaFile struct {
+	Name string
+	Data []byte
+}
+
+var (
+	mathInlineHTML  = regexp.MustCompile(`<span class="math math-inline">(.*?)</span>`)
+	mathDisplayHTML = regexp.MustCompile(`<span class="math math-display">(.*?)</span>`)
+	htmlTagRe       = regexp.MustCompile(`<[^>]*>`)
+)
+
+// ankiFieldHTML renderuje pole do HTML karty Anki; wzory trafiają do ograniczników MathJax \(...\) i \[...\]
+func ankiFieldHTML(s string, f models.FieldFormat) string {
+	out := RenderContentHTML(s, f)
+	out = mathInlineHTML.ReplaceAllString(out, `\(${1}\)`)
+	return mathDisplayHTML.ReplaceAllString(out, `\[${1}\]`)
+}
+
+// ankiSortField to tekst pola bez znaczników HTML - Anki sortuje po nim i liczy z niego sumę kontrolną
+func ankiSortField(fieldHTML string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(fieldHTML, " ")))
+}
+
+// ankiChecksum to suma kontrolna pola sortowania: pierwsze 8 cyfr szesnastkowych SHA-1
+func ankiChecksum(sortField string) int64 {
+	sum := sha1.Sum([]byte(sortField))
+	n, _ := strconv.ParseInt(hex.EncodeToString(sum[:4]), 16, 64)
+	return n
+}
+
+// ankiNoteTags zamienia metadane fiszki na tagi Anki (" a b " - ze spacjami na brzegach, jak w kolekcji Anki)
+func ankiNoteTags(m models.ContentMetadata) string {
+	tags := append([]string{}, m.Tags...)
+	if m.Difficulty != "" {
+		tags = append(tags, ankiDifficultyTag+m.Difficulty)
+	}
+	if m.BloomLevel != "" {
+		tags = append(tags, ankiBloomTag+m.BloomLevel)
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(tags, " ") + " "
+}
+
+// ankiMediaName to nazwa pliku załącznika w paczce; wynika z klucza pliku, więc kolejny eksport jej nie zmienia
+func ankiMediaName(m models.MediaAttachment) string {
+	ext := map[string]string{"image/png": ".png", "image/jpeg": ".jpg", "image/gif": ".gif"}[m.MIMEType]
+	return "iqt-" + m.BlobKey + ext
+}
+
+// ankiExportMedia wczytuje załączniki eksportowanych fiszek. Zwraca HTML obrazów dołączany do pytania
+// każdej fiszki oraz pliki do paczki.
+func ankiExportMedia(decks []AnkiDeck) (map[uint]string, []ankiMediaFile, error) {
+	var ids []uint
+	for _, d := range decks {
+		for _, fc := range d.Flashcards {
+			ids = append(ids, fc.ID)
+		}
+	}
+	attachments, err := db.UserRepository.GetMediaAttachments(models.SearchItemFlashcard, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	images := make(map[uint]string)
+	var files []ankiMediaFile
+	for _, m := range attachments {
+		data, err := Media.Read(&m)
+		if err != nil {
+			return nil, nil, fmt.Errorf("błąd odczytu załącznika %d: %w", m.ID, err)
+		}
+		name := ankiMediaName(m)
+		files = append(files, ankiMediaFile{Name: name, Data: data})
+		images[m.ItemID] += fmt.Sprintf(`<div><img src="%s" alt="%s"></div>`, html.EscapeString(name), html.EscapeString(m.AltText))
+	}
+	return images, files, nil
+}
+
+// ExportAnkiPackage tworzy paczkę .apkg z fiszkami podanych talii (notatki typu "pytanie/odpowiedź",
+// po jednej karcie na fiszkę) i ich załącznikami
+func ExportAnkiPackage(decks []AnkiDeck) ([]byte, error) {
+	images, files, err := ankiExportMedia(decks)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "anki-export-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "collection.anki2")
+	if err := writeAnkiCollection(path, decks, images); err != nil {
+		return nil, fmt.Errorf("błąd tworzenia kolekcji Anki: %w", err)
+	}
+	collection, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	index := make(map[string]string, len(files))
+	entries := []ankiMediaFile{{Name: "collection.anki2", Data: collection}}
+	for i, f := range files {
+		index[strconv.Itoa(i)] = f.Name
+		entries = append(entries, ankiMediaFile{Name: strconv.Itoa(i), Data: f.Data})
+	}
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, ankiMediaFile{Name: "media", Data: indexJSON})
+	for _, e := range entries {
+		// Obrazy są już skompresowane - kompresujemy tylko kolekcję i indeks mediów
+		method := zip.Store
+		if e.Name == "collection.anki2" || e.Name == "media" {
+			method = zip.Deflate
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: e.Name, Method: method, Modified: time.Now()})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(e.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAnkiCollection zapisuje kolekcję Anki z taliami i fiszkami do nowego pliku SQLite
+func writeAnkiCollection(path string, decks []AnkiDeck, images map[uint]string) error {
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(ankiSchema); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sec, ms := now.Unix(), now.UnixMilli()
+	// ID notatek i kart to znaczniki czasu w milisekundach, jak w Anki
+	nextID := ms
+	due := 0
+	deckJSON := map[string]interface{}{
+		strconv.FormatInt(ankiDefaultDeckID, 10): ankiDeckJSON(ankiDefaultDeckID, "Default", sec),
+	}
+	for i, d := range decks {
+		did := ankiDeckIDBase + int64(i)
+		deckJSON[strconv.FormatInt(did, 10)] = ankiDeckJSON(did, d.Name, sec)
+		for _, fc := range d.Flashcards {
+			front := ankiFieldHTML(fc.Question, fc.QuestionFormat) + images[fc.ID]
+			back := ankiFieldHTML(fc.Answer, fc.AnswerFormat)
+			sortField := ankiSortField(front)
+			nid, cid := nextID, nextID+1
+			nextID += 2
+			due++
+			if _, err := tx.Exec(`INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+				nid, ankiNoteGUIDPrefix+strconv.FormatUint(uint64(fc.ID), 10), ankiModelID, sec,
+				ankiNoteTags(fc.ContentMetadata), front+ankiFieldSeparator+back, sortField, ankiChecksum(sortField)); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO cards VALUES (?, ?, ?, 0, ?, -1, 0, 0, ?, 0, 0, 0, 0, 0, 0, 0, 0, '')`,
+				cid, nid, did, sec, due); err != nil {
+				return err
+			}
+		}
+	}
+
+	conf := map[string]interface{}{
+		"nextPos": due + 1, "estTimes": true, "activeDecks": []int64{ankiDefaultDeckID}, "sortType": "noteFld",
+		"timeLim": 0, "sortBackwards": false, "addToCur": true, "curDeck": ankiDefaultDeckID, "newSpread": 0,
+		"dueCounts": true, "curModel": ankiModelID, "collapseTime": 1200,
+	}
+	noteTypes := map[string]interface{}{strconv.FormatInt(ankiModelID, 10): ankiModelJSON(sec)}
+	deckConf := map[string]interface{}{"1": map[string]interface{}{
+		"id": 1, "name": "Default", "mod": 0, "usn": 0, "maxTaken": 60, "autoplay": true, "timer": 0, "replayq": true,
+		"dyn":   false,
+		"new":   map[string]interface{}{"delays": []float64{1, 10}, "ints": []int{1, 4, 7}, "initialFactor": 2500, "order": 1, "perDay": 20, "bury": false},
+		"lapse": map[string]interface{}{"delays": []float64{10}, "mult": 0, "minInt": 1, "leechFails": 8, "leechAction": 0},
+		"rev":   map[string]interface{}{"perDay": 200, "ease4": 1.3, "ivlFct": 1, "maxIvl": 36500, "bury": false, "hardFactor": 1.2},
+	}}
+	var values []interface{}
+	for _, v := range []interface{}{conf, noteTypes, deckJSON, deckConf, map[string]interface{}{}} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		values = append(values, string(b))
+	}
+	// Dzień kolekcji zaczyna się o 4:00 czasu lokalnego (domyślne ustawienie Anki)
+	crt := time.Date(now.Year(), now.Month(), now.Day(), 4, 0, 0, 0, now.Location()).Unix()
+	if _, err := tx.Exec(`INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, ?)`,
+		append([]interface{}{crt, ms, ms}, values...)...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func ankiDeckJSON(id int64, name string, mod int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id": id, "name": name, "mod": mod, "usn": -1, "desc": "", "dyn": 0, "conf": 1, "collapsed": false,
+		"browserCollapsed": false, "extendNew": 0, "extendRev": 0,
+		"newToday": []int{0, 0}, "revToday": []int{0, 0}, "lrnToday": []int{0, 0}, "timeToday": []int{0, 0},
+	}
+}
+
+// ankiModelJSON opisuje typ notatki eksportu: pola "Pytanie" i "Odpowiedź", jedna karta
+func ankiModelJSON(mod int64) map[string]interface{} {
+	field := func(name string, ord int) map[string]interface{} {
+		return map[string]interface{}{"name": name, "ord": ord, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}}
+	}
+	return map[string]interface{}{
+		"id": ankiModelID, "name": "InfQuizyTor", "type": 0, "mod": mod, "usn": -1, "sortf": 0, "did": ankiDefaultDeckID,
+		"flds": []interface{}{field("Pytanie", 0), field("Odpowiedź", 1)},
+		"tmpls": []interface{}{map[string]interface{}{
+			"name": "Karta 1", "ord": 0, "did": nil, "bqfmt": "", "bafmt": "",
+			"qfmt": "{{Pytanie}}",
+			"afmt": "{{FrontSide}}\n\n<hr id=answer>\n\n{{Odpowiedź}}",
+		}},
+		"css":       ankiCardCSS,
+		"latexPre":  "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n",
+		"latexPost": "\\end{document}",
+		"latexsvg":  false,
+		"req":       []interface{}{[]interface{}{0, "any", []int{0}}},
+		"tags":      []string{},
+		"vers":      []int{},
+	}
+}
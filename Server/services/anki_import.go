@@ -0,0 +1,854 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"golang.org/x/net/html"
+)
+
+// --- IMPORT TALII ANKI ---
+
+const (
+	// MaxAnkiImportNotes to maksymalna liczba notatek w importowanej talii
+	MaxAnkiImportNotes = 2000
+	// maxAnkiCollectionSize ogranicza rozmiar rozpakowanej kolekcji (ochrona przed "bombami" zip)
+	maxAnkiCollectionSize = 512 << 20
+	maxAnkiMediaIndexSize = 8 << 20
+	// maxAnkiImportWarnings ogranicza liczbę ostrzeżeń w raporcie importu
+	maxAnkiImportWarnings = 200
+	// ankiImageOnlyText zastępuje pole, które w Anki zawierało wyłącznie obraz
+	ankiImageOnlyText = "(zobacz obraz)"
+)
+
+var (
+	ErrInvalidAnkiPackage     = errors.New("plik nie jest poprawną paczką Anki (.apkg)")
+	ErrUnsupportedAnkiPackage = errors.New("paczka zapisana w nowym formacie Anki - wyeksportuj talię z zaznaczoną opcją \"Obsługa starszych wersji Anki\"")
+	ErrTooManyAnkiNotes       = fmt.Errorf("talia może zawierać najwyżej %d notatek", MaxAnkiImportNotes)
+	ErrAnkiImportRateLimited  = fmt.Errorf("kolejny import talii będzie możliwy po zakończeniu poprzedniego i odczekaniu %s", ankiImportInterval)
+)
+
+// ankiImportInterval to minimalny odstęp między rozpoczęciem kolejnych importów jednego użytkownika
+const ankiImportInterval = 30 * time.Second
+
+// ankiImports pilnuje, żeby użytkownik prowadził najwyżej jeden import naraz i nie częściej niż co ankiImportInterval
+// (import rozpakowuje i przetwarza paczkę do 100+ MB, więc seria zapytań łatwo wyczerpałaby dysk i procesor)
+var ankiImports = struct {
+	sync.Mutex
+	running map[string]bool
+	started map[string]time.Time
+}{running: make(map[string]bool), started: make(map[string]time.Time)}
+
+func beginAnkiImport(userUsosID string) error {
+	ankiImports.Lock()
+	defer ankiImports.Unlock()
+	now := time.Now()
+	for user, t := range ankiImports.started {
+		if now.Sub(t) >= ankiImportInterval && !ankiImports.running[user] {
+			delete(ankiImports.started, user)
+		}
+	}
+	if ankiImports.running[userUsosID] {
+		return ErrAnkiImportRateLimited
+	}
+	if _, ok := ankiImports.started[userUsosID]; ok {
+		return ErrAnkiImportRateLimited
+	}
+	ankiImports.running[userUsosID] = true
+	ankiImports.started[userUsosID] = now
+	return nil
+}
+
+func endAnkiImport(userUsosID string) {
+	ankiImports.Lock()
+	defer ankiImports.Unlock()
+	delete(ankiImports.running, userUsosID)
+}
+
+// ankiSystemTags to tagi nadawane automatycznie przez Anki, które nie opisują treści
+var ankiSystemTags = map[string]bool{"leech": true, "marked": true}
+
+var (
+	ankiClozeRe = regexp.MustCompile(`(?s)\{\{c\d+::(.*?)(?:::(.*?))?\}\}`)
+	ankiSoundRe = regexp.MustCompile(`\[sound:[^\]]*\]`)
+	ankiMathRe  = regexp.MustCompile(`(?s)\\\((.+?)\\\)|\\\[(.+?)\\\]`)
+)
+
+// AnkiImportOptions to parametry importu talii do tematu
+type AnkiImportOptions struct {
+	TopicID    uint
+	UserUsosID string
+	FileName   string
+	// Nazwy pól notatki użytych jako pytanie i odpowiedź (puste = pierwsze i drugie pole typu notatki)
+	QuestionField string
+	AnswerField   string
+	// Tagi dodawane do każdej zaimportowanej fiszki
+	Tags []string
+}
+
+// AnkiImportReport to raport importu talii
+type AnkiImportReport struct {
+	Notes           int                  `json:"notes"`
+	Imported        int                  `json:"imported"`
+	Skipped         int                  `json:"skipped"`
+	Duplicates      int                  `json:"duplicates"`
+	MediaImported   int                  `json:"media_imported"`
+	MediaSkipped    int                  `json:"media_skipped"`
+	FlashcardIDs    []uint               `json:"flashcard_ids"`
+	NoteTypes       []AnkiNoteTypeReport `json:"note_types"`
+	Warnings        []AnkiImportWarning  `json:"warnings"`
+	WarningsOmitted int                  `json:"warnings_omitted"`
+}
+
+// AnkiNoteTypeReport opisuje, jak pola typu notatki zostały przypisane do pytania i odpowiedzi
+type AnkiNoteTypeReport struct {
+	Name          string `json:"name"`
+	Cloze         bool   `json:"cloze"`
+	QuestionField string `json:"question_field"`
+	AnswerField   string `json:"answer_field"`
+	Notes         int    `json:"notes"`
+}
+
+// AnkiImportWarning to problem z jedną notatką (NoteID == 0 dotyczy całej paczki)
+type AnkiImportWarning struct {
+	NoteID  int64  `json:"note_id,omitempty"`
+	Message string `json:"message"`
+}
+
+func (r *AnkiImportReport) warn(noteID int64, format string, args ...interface{}) {
+	if len(r.Warnings) >= maxAnkiImportWarnings {
+		r.WarningsOmitted++
+		return
+	}
+	r.Warnings = append(r.Warnings, AnkiImportWarning{NoteID: noteID, Message: fmt.Sprintf(format, args...)})
+}
+
+// ankiNoteType to typ notatki z kolekcji (col.models) wraz z przypisaniem pól
+type ankiNoteType struct {
+	Name string `json:"name"`
+	Type int    `json:"type"` // 0 - zwykły, 1 - luki (cloze)
+	Flds []struct {
+		Name string `json:"name"`
+		Ord  int    `json:"ord"`
+	} `json:"flds"`
+	question, answer int // numery pól (answer == -1: brak pola odpowiedzi)
+	report           *AnkiNoteTypeReport
+}
+
+// fieldIndex zwraca numer pola o podanej nazwie (bez rozróżniania wielkości liter) lub -1
+func (t *ankiNoteType) fieldIndex(name string) int {
+	for _, f := range t.Flds {
+		if strings.EqualFold(strings.TrimSpace(f.Name), strings.TrimSpace(name)) {
+			return f.Ord
+		}
+	}
+	return -1
+}
+
+func (t *ankiNoteType) fieldName(ord int) string {
+	for _, f := range t.Flds {
+		if f.Ord == ord {
+			return f.Name
+		}
+	}
+	return ""
+}
+
+// mapFields przypisuje pola do pytania i odpowiedzi: wskazane w opcjach, a gdy typ ich nie ma - pierwsze i drugie.
+// W notatkach z lukami pytaniem jest tekst z lukami, a odpowiedzią ten sam tekst z odsłoniętymi lukami
+// i ewentualnym polem dodatkowym.
+func (t *ankiNoteType) mapFields(questionField, answerField string) {
+	t.question, t.answer = 0, 1
+	if i := t.fieldIndex(questionField); questionField != "" && i >= 0 {
+		t.question = i
+	}
+	if i := t.fieldIndex(answerField); answerField != "" && i >= 0 {
+		t.answer = i
+	} else if t.question == 1 {
+		t.answer = 0
+	}
+	if t.answer >= len(t.Flds) || t.answer == t.question {
+		t.answer = -1
+	}
+	t.report = &AnkiNoteTypeReport{Name: t.Name, Cloze: t.Type == 1, QuestionField: t.fieldName(t.question), AnswerField: t.fieldName(t.answer)}
+}
+
+// ankiPackage to rozpakowana paczka .apkg
+type ankiPackage struct {
+	zip   *zip.Reader
+	media map[string]*zip.File // nazwa pliku mediów -> plik w archiwum
+}
+
+// openAnkiPackage odczytuje archiwum i zapisuje kolekcję do pliku tymczasowego (SQLite wymaga pliku).
+// Zwraca ścieżkę kolekcji; katalog tymczasowy usuwa wywołujący.
+func openAnkiPackage(data []byte, dir string, report *AnkiImportReport) (*ankiPackage, string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, "", ErrInvalidAnkiPackage
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	// Anki 2.1.50+ zapisuje kolekcję w formacie "anki21b" (zstd), a w "collection.anki2" zostawia
+	// tylko notatkę z prośbą o aktualizację programu
+	collection := files["collection.anki21"]
+	if collection == nil && files["collection.anki21b"] != nil {
+		return nil, "", ErrUnsupportedAnkiPackage
+	}
+	if collection == nil {
+		collection = files["collection.anki2"]
+	}
+	if collection == nil {
+		return nil, "", ErrInvalidAnkiPackage
+	}
+	path := filepath.Join(dir, "collection.anki2")
+	if err := extractZipFile(collection, path, maxAnkiCollectionSize); err != nil {
+		return nil, "", err
+	}
+
+	pkg := &ankiPackage{zip: zr, media: make(map[string]*zip.File)}
+	if f := files["media"]; f != nil {
+		var index map[string]string
+		if b, err := readZipFile(f, maxAnkiMediaIndexSize); err != nil || json.Unmarshal(b, &index) != nil {
+			report.warn(0, "nie udało się odczytać indeksu mediów - obrazy zostaną pominięte")
+		}
+		for entry, name := range index {
+			if f := files[entry]; f != nil {
+				pkg.media[name] = f
+			}
+		}
+	}
+	return pkg, path, nil
+}
+
+// extractZipFile rozpakowuje plik z archiwum na dysk bez wczytywania go do pamięci,
+// odrzucając pliki większe niż limit
+func extractZipFile(f *zip.File, path string, limit int64) error {
+	if f.UncompressedSize64 > uint64(limit) {
+		return fmt.Errorf("%w: plik %s jest za duży", ErrInvalidAnkiPackage, f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return ErrInvalidAnkiPackage
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Nagłówek archiwum może zaniżać rozmiar - kopiujemy najwyżej limit+1 bajtów
+	n, err := io.CopyN(out, rc, limit+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			return err
+		}
+		return ErrInvalidAnkiPackage
+	}
+	if n > limit {
+		return fmt.Errorf("%w: plik %s jest za duży", ErrInvalidAnkiPackage, f.Name)
+	}
+	return out.Close()
+}
+
+// readZipFile odczytuje plik z archiwum, odrzucając pliki większe niż limit
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%w: plik %s jest za duży", ErrInvalidAnkiPackage, f.Name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, ErrInvalidAnkiPackage
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, ErrInvalidAnkiPackage
+	}
+	if int64(len(b)) > limit {
+		return nil, fmt.Errorf("%w: plik %s jest za duży", ErrInvalidAnkiPackage, f.Name)
+	}
+	return b, nil
+}
+
+// ImportAnkiPackage importuje notatki z paczki .apkg jako oczekujące na moderację fiszki tematu.
+// Notatki, których nie da się przekształcić w fiszkę, są pomijane i opisane w raporcie.
+// Błąd oznacza nieprawidłową paczkę albo przerwanie importu - raport opisuje wtedy zapisane już fiszki.
+func ImportAnkiPackage(data []byte, opts AnkiImportOptions) (*AnkiImportReport, error) {
+	report := &AnkiImportReport{FlashcardIDs: []uint{}, NoteTypes: []AnkiNoteTypeReport{}, Warnings: []AnkiImportWarning{}}
+	if int64(len(data)) > AnkiMaxPackageSize {
+		return report, fmt.Errorf("%w: paczka przekracza limit %d MB", ErrInvalidAnkiPackage, AnkiMaxPackageSize>>20)
+	}
+	extraTags, err := normalizeTags(opts.Tags)
+	if err != nil {
+		return report, err
+	}
+	if err := beginAnkiImport(opts.UserUsosID); err != nil {
+		return report, err
+	}
+	defer endAnkiImport(opts.UserUsosID)
+
+	dir, err := os.MkdirTemp("", "anki-import-*")
+	if err != nil {
+		return report, err
+	}
+	defer os.RemoveAll(dir)
+	pkg, path, err := openAnkiPackage(data, dir, report)
+	if err != nil {
+		return report, err
+	}
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return report, err
+	}
+	defer conn.Close()
+	// Kolekcja pochodzi od użytkownika - widoki i wyzwalacze w jej schemacie nie mogą wywoływać funkcji
+	conn.SetMaxOpenConns(1)
+	if _, err := conn.Exec("PRAGMA trusted_schema = OFF"); err != nil {
+		return report, ErrInvalidAnkiPackage
+	}
+
+	noteTypes, err := readAnkiNoteTypes(conn, opts)
+	if err != nil {
+		return report, err
+	}
+	if err := conn.QueryRow("SELECT COUNT(*) FROM notes").Scan(&report.Notes); err != nil {
+		return report, ErrInvalidAnkiPackage
+	}
+	if report.Notes > MaxAnkiImportNotes {
+		return report, ErrTooManyAnkiNotes
+	}
+
+	dupIndex, err := NewDuplicateIndex(db.UserRepository, opts.TopicID)
+	if err != nil {
+		return report, err
+	}
+	rows, err := conn.Query("SELECT id, mid, tags, flds FROM notes ORDER BY id")
+	if err != nil {
+		return report, ErrInvalidAnkiPackage
+	}
+	defer rows.Close()
+	seenTags := make(map[string]bool)
+	for rows.Next() {
+		var noteID, modelID int64
+		var tags, fields string
+		if err := rows.Scan(&noteID, &modelID, &tags, &fields); err != nil {
+			return report, ErrInvalidAnkiPackage
+		}
+		t := noteTypes[modelID]
+		if t == nil {
+			report.Skipped++
+			report.warn(noteID, "nieznany typ notatki %d", modelID)
+			continue
+		}
+		t.report.Notes++
+		if err := importAnkiNote(pkg, t, noteID, strings.Split(fields, ankiFieldSeparator), tags, extraTags, seenTags, dupIndex, opts, report); err != nil {
+			return report, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, ErrInvalidAnkiPackage
+	}
+
+	for _, t := range noteTypes {
+		if t.report.Notes > 0 {
+			report.NoteTypes = append(report.NoteTypes, *t.report)
+		}
+	}
+	sort.Slice(report.NoteTypes, func(i, j int) bool { return report.NoteTypes[i].Name < report.NoteTypes[j].Name })
+	return report, nil
+}
+
+// readAnkiNoteTypes odczytuje typy notatek kolekcji i przypisuje ich pola do pytania i odpowiedzi
+func readAnkiNoteTypes(conn *sql.DB, opts AnkiImportOptions) (map[int64]*ankiNoteType, error) {
+	var raw string
+	if err := conn.QueryRow("SELECT models FROM col").Scan(&raw); err != nil {
+		return nil, ErrInvalidAnkiPackage
+	}
+	var parsed map[string]*ankiNoteType
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, ErrInvalidAnkiPackage
+	}
+	noteTypes := make(map[int64]*ankiNoteType, len(parsed))
+	for id, t := range parsed {
+		mid, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || t == nil {
+			continue
+		}
+		t.mapFields(opts.QuestionField, opts.AnswerField)
+		noteTypes[mid] = t
+	}
+	return noteTypes, nil
+}
+
+// importAnkiNote zapisuje jedną notatkę jako fiszkę. Zwraca błąd tylko wtedy, gdy import trzeba przerwać.
+func importAnkiNote(pkg *ankiPackage, t *ankiNoteType, noteID int64, fields []string, tags string, extraTags []string,
+	seenTags map[string]bool, dupIndex *DuplicateIndex, opts AnkiImportOptions, report *AnkiImportReport) error {
+	field := func(ord int) string {
+		if ord < 0 || ord >= len(fields) {
+			return ""
+		}
+		return fields[ord]
+	}
+
+	questionHTML, answerHTML := field(t.question), field(t.answer)
+	if t.Type == 1 {
+		text := questionHTML
+		questionHTML = ankiClozeRe.ReplaceAllStringFunc(text, func(m string) string {
+			if hint := ankiClozeRe.FindStringSubmatch(m)[2]; hint != "" {
+				return "[" + hint + "]"
+			}
+			return "[...]"
+		})
+		answerHTML = ankiClozeRe.ReplaceAllString(text, "<b>$1</b>")
+		if extra := field(t.answer); strings.TrimSpace(extra) != "" {
+			answerHTML += "<br><br>" + extra
+		}
+	}
+
+	question := convertAnkiField(questionHTML)
+	answer := convertAnkiField(answerHTML)
+	if question.sounds+answer.sounds > 0 {
+		report.warn(noteID, "pominięto nagrania dźwiękowe (nieobsługiwane)")
+	}
+	if question.text == "" && len(question.images) > 0 {
+		question.text = ankiImageOnlyText
+	}
+	if answer.text == "" && len(answer.images) > 0 {
+		answer.text = ankiImageOnlyText
+	}
+	snapshot, err := NormalizeFlashcardSnapshot(models.RevisionSnapshot{
+		Question:       question.text,
+		Answer:         answer.text,
+		QuestionFormat: models.FieldFormat{Format: question.format},
+		AnswerFormat:   models.FieldFormat{Format: answer.format},
+	})
+	if err != nil {
+		report.Skipped++
+		report.warn(noteID, "pominięto notatkę: %v", err)
+		return nil
+	}
+
+	flashcard := &models.Flashcard{
+		TopicID:         opts.TopicID,
+		Status:          "pending",
+		CreatedByUsosID: opts.UserUsosID,
+		ContentMetadata: ankiNoteMetadata(noteID, tags, extraTags, seenTags, report),
+		SourceFile:      opts.FileName,
+	}
+	ApplyFlashcardSnapshot(flashcard, snapshot)
+	if err := CreateFlashcardChecked(dupIndex, flashcard); err != nil {
+		return err
+	}
+	report.Imported++
+	report.FlashcardIDs = append(report.FlashcardIDs, flashcard.ID)
+	if flashcard.DuplicateOfID != nil {
+		report.Duplicates++
+	}
+
+	for _, img := range append(question.images, answer.images...) {
+		importAnkiImage(pkg, flashcard, noteID, img, opts.UserUsosID, report)
+	}
+	return nil
+}
+
+// ankiNoteMetadata zamienia tagi notatki na tagi fiszki. Hierarchia Anki ("a::b") staje się "a.b",
+// a tagi zapisane przez eksport ("difficulty::hard", "bloom::apply") wracają do metadanych.
+func ankiNoteMetadata(noteID int64, tags string, extraTags []string, seenTags map[string]bool, report *AnkiImportReport) models.ContentMetadata {
+	m := models.ContentMetadata{Tags: append([]string{}, extraTags...)}
+	for _, tag := range strings.Fields(tags) {
+		lower := strings.ToLower(tag)
+		if v, ok := strings.CutPrefix(lower, ankiDifficultyTag); ok && checkDifficulty(v) == nil {
+			m.Difficulty = v
+			continue
+		}
+		if v, ok := strings.CutPrefix(lower, ankiBloomTag); ok && checkBloomLevel(v) == nil {
+			m.BloomLevel = v
+			continue
+		}
+		if ankiSystemTags[lower] {
+			continue
+		}
+		normalized, err := NormalizeTag(strings.ReplaceAll(tag, "::", "."))
+		if err != nil {
+			if !seenTags[tag] {
+				seenTags[tag] = true
+				report.warn(noteID, "pominięto tag: %v", err)
+			}
+			continue
+		}
+		if !containsString(m.Tags, normalized) {
+			m.Tags = append(m.Tags, normalized)
+		}
+	}
+	if len(m.Tags) > MaxContentTags {
+		report.warn(noteID, "notatka ma więcej niż %d tagów - pominięto: %s", MaxContentTags, strings.Join(m.Tags[MaxContentTags:], ", "))
+		m.Tags = m.Tags[:MaxContentTags]
+	}
+	m.Tags = nilIfEmpty(m.Tags)
+	return m
+}
+
+// importAnkiImage dołącza obraz z paczki do fiszki; problemy trafiają do raportu
+func importAnkiImage(pkg *ankiPackage, fc *models.Flashcard, noteID int64, img ankiImage, userUsosID string, report *AnkiImportReport) {
+	f := pkg.media[img.src]
+	if f == nil {
+		if name, err := url.PathUnescape(img.src); err == nil {
+			f = pkg.media[name]
+		}
+	}
+	if f == nil {
+		report.MediaSkipped++
+		report.warn(noteID, "brak pliku obrazu %q w paczce", img.src)
+		return
+	}
+	if f.UncompressedSize64 > uint64(Media.MaxSize) {
+		report.MediaSkipped++
+		report.warn(noteID, "pominięto obraz %q: %v (limit %d MB)", img.src, ErrMediaTooLarge, Media.MaxSize>>20)
+		return
+	}
+	data, err := readZipFile(f, Media.MaxSize)
+	if err == nil {
+		_, err = Media.Upload(models.SearchItemFlashcard, fc.ID, img.src, img.alt, data, userUsosID)
+	}
+	if err != nil {
+		report.MediaSkipped++
+		report.warn(noteID, "pominięto obraz %q: %v", img.src, err)
+		return
+	}
+	report.MediaImported++
+}
+
+// --- KONWERSJA PÓL ANKI (HTML) ---
+
+// ankiImage to obraz wskazany w polu notatki
+type ankiImage struct {
+	src string
+	alt string
+}
+
+// ankiField to pole notatki przekształcone w treść fiszki
+type ankiField struct {
+	text   string
+	format string // ContentFormatPlain lub ContentFormatMarkdown
+	images []ankiImage
+	sounds int
+}
+
+// convertAnkiField zamienia HTML pola Anki na zwykły tekst, a gdy pole zawiera formatowanie (pogrubienie,
+// kod, listy, wzory MathJax) - na Markdown. Obrazy są zwracane osobno, nagrania dźwiękowe - pomijane.
+func convertAnkiField(s string) ankiField {
+	var f ankiField
+	f.sounds = len(ankiSoundRe.FindAllString(s, -1))
+	s = ankiSoundRe.ReplaceAllString(s, "")
+
+	// Wzory zastępujemy znacznikami, żeby ich treść nie była modyfikowana jak zwykły tekst
+	var math []string
+	s = ankiMathRe.ReplaceAllStringFunc(s, func(m string) string {
+		sub := ankiMathRe.FindStringSubmatch(m)
+		tex, display := sub[1], false
+		if tex == "" {
+			tex, display = sub[2], true
+		}
+		tex = strings.TrimSpace(html.UnescapeString(htmlTagRe.ReplaceAllString(tex, " ")))
+		if display {
+			tex = "$$" + tex + "$$"
+		} else {
+			tex = "$" + tex + "$"
+		}
+		math = append(math, tex)
+		return "\x00" + strconv.Itoa(len(math)-1) + "\x00"
+	})
+
+	c := &ankiConverter{markdown: len(math) > 0}
+	c.convert(s)
+	if !c.markdown && c.formatted {
+		c = &ankiConverter{markdown: true}
+		c.convert(s)
+	}
+	f.images = c.images
+	f.format = models.ContentFormatPlain
+	if c.markdown {
+		f.format = models.ContentFormatMarkdown
+	}
+	text := c.result()
+	for i, tex := range math {
+		text = strings.ReplaceAll(text, "\x00"+strconv.Itoa(i)+"\x00", tex)
+	}
+	f.text = strings.TrimSpace(text)
+	return f
+}
+
+// ankiConverter przechodzi po znacznikach HTML pola. W trybie zwykłego tekstu tylko odnotowuje formatowanie
+// (formatted); w trybie Markdown je zapisuje i poprzedza znaki specjalne tekstu ukośnikiem.
+type ankiConverter struct {
+	markdown  bool
+	formatted bool
+	out       []byte
+	images    []ankiImage
+	// Otwarte znaczniki wyróżnienia: znacznik Markdown jest zapisywany dopiero przed pierwszym widocznym
+	// znakiem, więc puste <b></b> nie zostawiają śladu
+	emphasis []ankiEmphasis
+	skip     int              // głębokość <script>/<style>
+	code     *strings.Builder // treść bieżącego <code> lub <pre>
+	pre      bool
+}
+
+type ankiEmphasis struct {
+	marker  string
+	written bool
+	nested  bool // ten sam znacznik jest już otwarty - nie zapisujemy go drugi raz
+}
+
+var ankiBlockTags = map[string]bool{"div": true, "p": true, "tr": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "ul": true, "ol": true, "table": true, "blockquote": true}
+
+func (c *ankiConverter) convert(s string) {
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		tok := z.Token()
+		name := tok.Data
+		switch tt {
+		case html.TextToken:
+			c.text(strings.ReplaceAll(tok.Data, "\u00a0", " "))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if c.skip > 0 && name != "script" && name != "style" {
+				continue
+			}
+			switch {
+			case name == "script" || name == "style":
+				if tt == html.StartTagToken {
+					c.skip++
+				}
+			case name == "img":
+				c.image(tok)
+			case name == "br":
+				c.lineBreak()
+			case name == "pre":
+				c.formatted = true
+				c.blockBreak()
+				c.pre, c.code = true, &strings.Builder{}
+			case name == "code" && c.code == nil:
+				c.formatted = true
+				c.code = &strings.Builder{}
+			case name == "b" || name == "strong":
+				c.openEmphasis("**")
+			case name == "i" || name == "em":
+				c.openEmphasis("*")
+			case name == "li":
+				c.formatted = true
+				c.newline()
+				c.write("- ")
+			case ankiBlockTags[name]:
+				c.blockBreak()
+			}
+		case html.EndTagToken:
+			switch {
+			case name == "script" || name == "style":
+				if c.skip > 0 {
+					c.skip--
+				}
+			case c.skip > 0:
+			case name == "pre" && c.pre:
+				c.closeCode()
+				c.blockBreak()
+			case name == "code" && c.code != nil && !c.pre:
+				c.closeCode()
+			case name == "b" || name == "strong" || name == "i" || name == "em":
+				c.closeEmphasis()
+			case name == "li":
+				c.newline()
+			case ankiBlockTags[name]:
+				c.blockBreak()
+			}
+		}
+	}
+}
+
+func (c *ankiConverter) result() string {
+	lines := strings.Split(string(c.out), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t")
+	}
+	s := strings.Join(lines, "\n")
+	for strings.Contains(s, "\n\n\n") {
+		s = strings.ReplaceAll(s, "\n\n\n", "\n\n")
+	}
+	return s
+}
+
+func (c *ankiConverter) write(s string) { c.out = append(c.out, s...) }
+
+// text zapisuje tekst węzła: w kodzie dosłownie, poza nim z wcześniej otwartymi znacznikami wyróżnienia
+func (c *ankiConverter) text(s string) {
+	if c.skip > 0 {
+		return
+	}
+	if c.code != nil {
+		c.code.WriteString(s)
+		return
+	}
+	if strings.TrimSpace(s) == "" {
+		if len(c.out) > 0 {
+			c.write(s)
+		}
+		return
+	}
+	trimmed := strings.TrimLeft(s, " \t\n")
+	c.write(s[:len(s)-len(trimmed)])
+	for i := range c.emphasis {
+		if e := &c.emphasis[i]; !e.written && !e.nested {
+			c.formatted = true
+			if c.markdown {
+				c.write(e.marker)
+			}
+			e.written = true
+		}
+	}
+	if c.markdown {
+		trimmed = escapeMarkdown(trimmed)
+	}
+	c.write(trimmed)
+}
+
+func (c *ankiConverter) openEmphasis(marker string) {
+	nested := false
+	for _, e := range c.emphasis {
+		nested = nested || e.marker == marker
+	}
+	c.emphasis = append(c.emphasis, ankiEmphasis{marker: marker, nested: nested})
+}
+
+// closeEmphasis zamyka ostatnie wyróżnienie; końcowe spacje przenosi za znacznik (inaczej Markdown go nie rozpozna)
+func (c *ankiConverter) closeEmphasis() {
+	if len(c.emphasis) == 0 {
+		return
+	}
+	e := c.emphasis[len(c.emphasis)-1]
+	c.emphasis = c.emphasis[:len(c.emphasis)-1]
+	if !e.written || !c.markdown {
+		return
+	}
+	trimmed := bytes.TrimRight(c.out, " \t\n")
+	tail := string(c.out[len(trimmed):])
+	c.out = append(append(trimmed, e.marker...), tail...)
+}
+
+func (c *ankiConverter) closeCode() {
+	code := c.code.String()
+	c.code = nil
+	if c.pre {
+		c.pre = false
+		if c.markdown {
+			c.write("```\n" + strings.Trim(code, "\n") + "\n```")
+		} else {
+			c.write(strings.Trim(code, "\n"))
+		}
+		return
+	}
+	if strings.TrimSpace(code) == "" {
+		c.write(code)
+		return
+	}
+	if !c.markdown {
+		c.write(code)
+		return
+	}
+	fence := "`"
+	if strings.Contains(code, "`") {
+		fence = "``"
+	}
+	c.write(fence + code + fence)
+}
+
+func (c *ankiConverter) image(tok html.Token) {
+	var img ankiImage
+	for _, a := range tok.Attr {
+		switch a.Key {
+		case "src":
+			img.src = strings.TrimSpace(a.Val)
+		case "alt":
+			img.alt = a.Val
+		}
+	}
+	if img.src != "" {
+		c.images = append(c.images, img)
+	}
+}
+
+// lineBreak to <br>: w kodzie nowa linia, w Markdownie twarde złamanie wiersza ("\" na końcu)
+func (c *ankiConverter) lineBreak() {
+	if c.code != nil {
+		c.code.WriteString("\n")
+		return
+	}
+	if len(c.out) == 0 || c.out[len(c.out)-1] == '\n' {
+		c.write("\n")
+		return
+	}
+	if c.markdown {
+		c.write("\\")
+	}
+	c.write("\n")
+}
+
+func (c *ankiConverter) newline() {
+	if len(c.out) > 0 && c.out[len(c.out)-1] != '\n' {
+		c.write("\n")
+	}
+}
+
+// blockBreak kończy blok (np. <div>): w Markdownie akapit, w zwykłym tekście wiersz
+func (c *ankiConverter) blockBreak() {
+	if c.code != nil {
+		if c.pre {
+			c.code.WriteString("\n")
+		}
+		return
+	}
+	c.newline()
+	if c.markdown && len(c.out) > 0 && !bytes.HasSuffix(c.out, []byte("\n\n")) {
+		c.write("\n")
+	}
+}
+
+// escapeMarkdown poprzedza ukośnikiem znaki, które Markdown zinterpretowałby jako formatowanie
+func escapeMarkdown(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\', '`', '*', '_', '[', ']', '<', '>', '$', '~', '|':
+			b.WriteByte('\\')
+		case '#', '-', '+':
+			// Znaczniki nagłówka i listy mają znaczenie tylko na początku wiersza
+			if i == 0 {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+func TestConvertAnkiField(t *testing.T) {
+	tests := []struct {
+		name   string
+		html   string
+		text   string
+		format string
+		images []ankiImage
+		sounds int
+	}{
+		{"zwykły tekst", "Stolica Polski", "Stolica Polski", models.ContentFormatPlain, nil, 0},
+		{"encje HTML", "A &amp; B &lt;C&gt;", "A & B <C>", models.ContentFormatPlain, nil, 0},
+		{"podziały wierszy", "linia 1<br>linia 2<div>linia 3</div>", "linia 1\nlinia 2\nlinia 3", models.ContentFormatPlain, nil, 0},
+		{"znaki Markdown w zwykłym tekście", "*gwiazdki* bez formatowania", "*gwiazdki* bez formatowania", models.ContentFormatPlain, nil, 0},
+		{"puste wyróżnienie", "<b></b>tekst", "tekst", models.ContentFormatPlain, nil, 0},
+		{"skrypt", "<script>alert(1)</script>tekst", "tekst", models.ContentFormatPlain, nil, 0},
+		{"pogrubienie", "<b>pogrubienie</b> i zwykły", "**pogrubienie** i zwykły", models.ContentFormatMarkdown, nil, 0},
+		{"kursywa", "<i>kursywa</i>", "*kursywa*", models.ContentFormatMarkdown, nil, 0},
+		{"znaki Markdown w formatowanym tekście", "<b>*gwiazdki*</b>", `**\*gwiazdki\***`, models.ContentFormatMarkdown, nil, 0},
+		{"lista", "<ul><li>a</li><li>b</li></ul>", "- a\n- b", models.ContentFormatMarkdown, nil, 0},
+		{"kod w linii", "<code>x := 1</code>", "`x := 1`", models.ContentFormatMarkdown, nil, 0},
+		{"blok kodu", "<pre>func main() {\n}</pre>", "```\nfunc main() {\n}\n```", models.ContentFormatMarkdown, nil, 0},
+		{"wzory MathJax", `wzór \(x^2\) i \[\frac{a}{b}\]`, `wzór $x^2$ i $$\frac{a}{b}$$`, models.ContentFormatMarkdown, nil, 0},
+		{"obraz", `<img src="kot.png" alt="Kot">opis`, "opis", models.ContentFormatPlain, []ankiImage{{src: "kot.png", alt: "Kot"}}, 0},
+		{"nagranie", "[sound:a.mp3]słowo", "słowo", models.ContentFormatPlain, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := convertAnkiField(tt.html)
+			if f.text != tt.text || f.format != tt.format {
+				t.Errorf("wynik %q (%s), oczekiwano %q (%s)", f.text, f.format, tt.text, tt.format)
+			}
+			if !reflect.DeepEqual(f.images, tt.images) || f.sounds != tt.sounds {
+				t.Errorf("obrazy %v, nagrania %d; oczekiwano %v, %d", f.images, f.sounds, tt.images, tt.sounds)
+			}
+		})
+	}
+}
+
+func TestConvertAnkiFieldEscapesHTML(t *testing.T) {
+	// Encje zamienione na znaki nie mogą po konwersji na Markdown stać się znacznikami HTML
+	f := convertAnkiField("<b>uwaga</b> &lt;script&gt;alert(1)&lt;/script&gt;")
+	s, format, err := SanitizeContent(f.text, models.FieldFormat{Format: f.format})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html := RenderContentHTML(s, format); strings.Contains(html, "<script") {
+		t.Errorf("HTML zawiera znacznik <script>: %s", html)
+	}
+}
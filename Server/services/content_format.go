@@ -0,0 +1,367 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// --- FORMATY TREŚCI (MARKDOWN, KATEX, KOD) ---
+
+// MaxContentFieldChars to maksymalna długość jednego pola treści (pytania, odpowiedzi, opcji)
+const MaxContentFieldChars = 10000
+
+// codeLanguages to dozwolone języki bloków kodu (klucz: nazwa lub alias, wartość: nazwa kanoniczna)
+var codeLanguages = map[string]string{
+	"text": "text", "plaintext": "text", "pseudocode": "pseudocode",
+	"c": "c", "cpp": "cpp", "c++": "cpp", "csharp": "csharp", "c#": "csharp", "cs": "csharp",
+	"java": "java", "kotlin": "kotlin", "scala": "scala", "go": "go", "golang": "go", "rust": "rust",
+	"python": "python", "py": "python", "javascript": "javascript", "js": "javascript",
+	"typescript": "typescript", "ts": "typescript", "php": "php", "ruby": "ruby", "swift": "swift",
+	"haskell": "haskell", "ocaml": "ocaml", "fsharp": "fsharp", "lisp": "lisp", "scheme": "scheme",
+	"prolog": "prolog", "erlang": "erlang", "elixir": "elixir", "lua": "lua", "r": "r", "matlab": "matlab",
+	"sql": "sql", "bash": "bash", "sh": "bash", "shell": "bash", "powershell": "powershell",
+	"asm": "asm", "nasm": "asm", "x86asm": "asm", "verilog": "verilog", "vhdl": "vhdl",
+	"html": "html", "css": "css", "xml": "xml", "json": "json", "yaml": "yaml", "yml": "yaml",
+	"latex": "latex", "tex": "latex", "makefile": "makefile", "dockerfile": "dockerfile",
+}
+
+// forbiddenTeXCommands to polecenia KaTeX, które mogą wstawić link, obraz lub surowy HTML albo zdefiniować makro
+var forbiddenTeXCommands = map[string]bool{
+	"href": true, "url": true, "includegraphics": true,
+	"htmlClass": true, "htmlId": true, "htmlStyle": true, "htmlData": true,
+	"def": true, "gdef": true, "edef": true, "xdef": true, "let": true, "futurelet": true, "global": true,
+	"newcommand": true, "renewcommand": true, "providecommand": true,
+}
+
+var (
+	texCommandRe  = regexp.MustCompile(`\\([a-zA-Z]+)`)
+	urlSchemeRe   = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+	allowedSchema = map[string]bool{"http": true, "https": true, "mailto": true}
+)
+
+// NormalizeContentFormat sprawdza zadeklarowany format pola (pusty format oznacza zwykły tekst)
+func NormalizeContentFormat(f models.FieldFormat) (models.FieldFormat, error) {
+	switch f.Format {
+	case "", models.ContentFormatPlain:
+		return models.FieldFormat{Format: models.ContentFormatPlain}, nil
+	case models.ContentFormatMarkdown:
+		return models.FieldFormat{Format: models.ContentFormatMarkdown}, nil
+	case models.ContentFormatCode:
+		lang, ok := codeLanguages[strings.ToLower(strings.TrimSpace(f.Language))]
+		if !ok {
+			return f, fmt.Errorf("nieobsługiwany język kodu %q", f.Language)
+		}
+		return models.FieldFormat{Format: models.ContentFormatCode, Language: lang}, nil
+	default:
+		return f, fmt.Errorf("nieznany format treści %q (dozwolone: %s, %s, %s)", f.Format,
+			models.ContentFormatPlain, models.ContentFormatMarkdown, models.ContentFormatCode)
+	}
+}
+
+// SanitizeContent sprawdza i oczyszcza pole w zadeklarowanym formacie: usuwa znaki sterujące, w Markdownie
+// zamienia surowy HTML na zwykły tekst i odrzuca niebezpieczne linki, nieznane języki kodu oraz niedozwolone
+// polecenia TeX. Zwraca oczyszczony tekst i znormalizowany format.
+func SanitizeContent(s string, f models.FieldFormat) (string, models.FieldFormat, error) {
+	f, err := NormalizeContentFormat(f)
+	if err != nil {
+		return "", f, err
+	}
+	s = stripControlChars(s)
+	if f.Format == models.ContentFormatCode {
+		// Wcięcia w kodzie są znaczące - obcinamy tylko puste wiersze i końcowe białe znaki
+		s = strings.TrimRightFunc(strings.TrimLeft(s, "\n"), unicode.IsSpace)
+	} else {
+		s = strings.TrimSpace(s)
+	}
+	if len([]rune(s)) > MaxContentFieldChars {
+		return "", f, fmt.Errorf("treść jest za długa (maksimum %d znaków)", MaxContentFieldChars)
+	}
+	if f.Format == models.ContentFormatMarkdown {
+		if s, err = sanitizeMarkdown(s); err != nil {
+			return "", f, err
+		}
+	}
+	return s, f, nil
+}
+
+// stripControlChars usuwa niepoprawne UTF-8 i niewidoczne znaki sterujące (poza tabulacją i nową linią)
+func stripControlChars(s string) string {
+	s = strings.ToValidUTF8(strings.ReplaceAll(s, "\r\n", "\n"), "")
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if r == '\r' {
+			return '\n'
+		}
+		// Znaki sterujące, separatory wierszy i znaki zmiany kierunku tekstu (mogą maskować treść)
+		if unicode.IsControl(r) || r == '\u2028' || r == '\u2029' || (r >= '\u202a' && r <= '\u202e') || (r >= '\u2066' && r <= '\u2069') {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// sanitizeMarkdown poprzedza znacznik surowego HTML ukośnikiem (wyświetli się jako tekst) i powtarza analizę,
+// aż dokument nie zawiera HTML - usunięcie bloku HTML może odsłonić znaczniki w jego dalszych wierszach
+func sanitizeMarkdown(s string) (string, error) {
+	src := []byte(s)
+	for attempt := 0; attempt < 50; attempt++ {
+		doc := markdown.Parser().Parse(text.NewReader(src))
+		var escapes []int
+		var verr error
+		ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			switch n := n.(type) {
+			case *ast.RawHTML:
+				if n.Segments.Len() > 0 {
+					escapes = append(escapes, n.Segments.At(0).Start)
+				}
+			case *ast.HTMLBlock:
+				if n.Lines().Len() > 0 {
+					seg := n.Lines().At(0)
+					if i := bytes.IndexByte(seg.Value(src), '<'); i >= 0 {
+						escapes = append(escapes, seg.Start+i)
+					}
+				}
+			case *ast.Link:
+				verr = checkContentURL(n.Destination)
+			case *ast.Image:
+				verr = checkContentURL(n.Destination)
+			case *ast.AutoLink:
+				verr = checkContentURL(n.URL(src))
+			case *ast.FencedCodeBlock:
+				if lang := n.Language(src); lang != nil {
+					if _, ok := codeLanguages[strings.ToLower(string(lang))]; !ok {
+						verr = fmt.Errorf("nieobsługiwany język bloku kodu %q", lang)
+					}
+				}
+			case *mathNode:
+				verr = checkTeX(n.tex)
+			}
+			if verr != nil {
+				return ast.WalkStop, nil
+			}
+			return ast.WalkContinue, nil
+		})
+		if verr != nil {
+			return "", verr
+		}
+		if len(escapes) == 0 {
+			return string(src), nil
+		}
+		out := make([]byte, 0, len(src)+len(escapes))
+		prev := 0
+		for _, pos := range escapes {
+			if pos < prev || pos >= len(src) || src[pos] != '<' {
+				continue
+			}
+			out = append(append(out, src[prev:pos]...), '\\')
+			prev = pos
+		}
+		src = append(out, src[prev:]...)
+	}
+	return "", errors.New("nie udało się usunąć znaczników HTML z treści")
+}
+
+// checkContentURL dopuszcza adresy względne oraz schematy http, https i mailto
+func checkContentURL(dest []byte) error {
+	m := urlSchemeRe.FindSubmatch(bytes.TrimSpace(dest))
+	if m != nil && !allowedSchema[strings.ToLower(string(m[1]))] {
+		return fmt.Errorf("niedozwolony adres linku %q", dest)
+	}
+	return nil
+}
+
+// checkTeX sprawdza zbalansowanie nawiasów klamrowych i odrzuca niedozwolone polecenia
+func checkTeX(tex string) error {
+	depth := 0
+	for i := 0; i < len(tex); i++ {
+		switch tex[i] {
+		case '\\':
+			i++ // znak po ukośniku jest dosłowny (np. \{)
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth < 0 {
+				return fmt.Errorf("niezbalansowane nawiasy we wzorze %q", tex)
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("niezbalansowane nawiasy we wzorze %q", tex)
+	}
+	for _, m := range texCommandRe.FindAllStringSubmatch(tex, -1) {
+		if forbiddenTeXCommands[m[1]] {
+			return fmt.Errorf("niedozwolone polecenie \\%s we wzorze", m[1])
+		}
+	}
+	return nil
+}
+
+// --- RENDEROWANIE HTML ---
+
+// markdown renderuje CommonMark z tabelami, przekreśleniem i wzorami ($...$, $$...$$). Wzory trafiają do
+// elementów <span class="math ..."> jako tekst, który klient przekazuje do KaTeX. Surowy HTML jest pomijany.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.Table, extension.Strikethrough, mathExtension{}))
+
+// contentPolicy to lista dozwolonych elementów wynikowego HTML
+var contentPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^math math-(inline|display)$`)).OnElements("span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[a-z0-9]+$`)).OnElements("code")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	return p
+}()
+
+// RenderContentHTML zwraca bezpieczny HTML pola w podanym formacie
+func RenderContentHTML(s string, f models.FieldFormat) string {
+	switch f.Format {
+	case models.ContentFormatMarkdown:
+		var buf bytes.Buffer
+		if err := markdown.Convert([]byte(s), &buf); err != nil {
+			return "<p>" + html.EscapeString(s) + "</p>"
+		}
+		return contentPolicy.Sanitize(buf.String())
+	case models.ContentFormatCode:
+		lang := codeLanguages[strings.ToLower(f.Language)]
+		if lang == "" {
+			lang = "text"
+		}
+		return `<pre><code class="language-` + lang + `">` + html.EscapeString(s) + "</code></pre>"
+	default:
+		return "<p>" + strings.ReplaceAll(html.EscapeString(s), "\n", "<br>") + "</p>"
+	}
+}
+
+// RenderFlashcard wypełnia fc.Rendered wyrenderowanym HTML pól fiszki
+func RenderFlashcard(fc *models.Flashcard) {
+	fc.Rendered = &models.RenderedContent{
+		Question: RenderContentHTML(fc.Question, fc.QuestionFormat),
+		Answer:   RenderContentHTML(fc.Answer, fc.AnswerFormat),
+	}
+}
+
+// RenderQuizQuestion wypełnia q.Rendered wyrenderowanym HTML pól pytania (ukryta odpowiedź wzorcowa pozostaje pusta)
+func RenderQuizQuestion(q *models.QuizQuestion) {
+	r := &models.RenderedContent{Question: RenderContentHTML(q.QuestionText, q.QuestionFormat)}
+	for _, o := range q.Options {
+		r.Options = append(r.Options, RenderContentHTML(o, q.OptionsFormat))
+	}
+	if q.ReferenceAnswer != "" {
+		r.ReferenceAnswer = RenderContentHTML(q.ReferenceAnswer, q.ReferenceAnswerFormat)
+	}
+	q.Rendered = r
+}
+
+// --- WZORY MATEMATYCZNE W MARKDOWNIE ---
+
+var kindMath = ast.NewNodeKind("Math")
+
+// mathNode to wzór w linii ($...$) lub wyświetlany ($$...$$)
+type mathNode struct {
+	ast.BaseInline
+	tex     string
+	display bool
+}
+
+func (n *mathNode) Kind() ast.NodeKind { return kindMath }
+
+func (n *mathNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"TeX": n.tex}, nil)
+}
+
+type mathExtension struct{}
+
+func (mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(mathParser{}, 150)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(mathRenderer{}, 150)))
+}
+
+// mathParser rozpoznaje $$...$$ (może obejmować kilka wierszy) oraz $...$ w jednym wierszu. Jak w Pandocu,
+// wzór w linii nie może zaczynać się ani kończyć spacją, a zamykający $ nie może poprzedzać cyfry ("$5 i $10").
+type mathParser struct{}
+
+func (mathParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if len(line) > 1 && line[1] == '$' {
+		return parseDisplayMath(block)
+	}
+	if len(line) < 3 || line[1] == ' ' || line[1] == '\t' {
+		return nil
+	}
+	for i := 2; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '$':
+			if line[i-1] == ' ' || line[i-1] == '\t' || (i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9') {
+				continue
+			}
+			block.Advance(i + 1)
+			return &mathNode{tex: string(line[1:i])}
+		}
+	}
+	return nil
+}
+
+func parseDisplayMath(block text.Reader) ast.Node {
+	l, pos := block.Position()
+	block.Advance(2)
+	var tex bytes.Buffer
+	for {
+		line, _ := block.PeekLine()
+		if line == nil {
+			block.SetPosition(l, pos)
+			return nil
+		}
+		if i := bytes.Index(line, []byte("$$")); i >= 0 {
+			tex.Write(line[:i])
+			block.Advance(i + 2)
+			if strings.TrimSpace(tex.String()) == "" {
+				block.SetPosition(l, pos)
+				return nil
+			}
+			return &mathNode{tex: strings.TrimSpace(tex.String()), display: true}
+		}
+		tex.Write(line)
+		block.AdvanceLine()
+	}
+}
+
+type mathRenderer struct{}
+
+func (mathRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindMath, func(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		m := n.(*mathNode)
+		class := "math math-inline"
+		if m.display {
+			class = "math math-display"
+		}
+		_, _ = w.WriteString(`<span class="` + class + `">` + html.EscapeString(m.tex) + "</span>")
+		return ast.WalkSkipChildren, nil
+	})
+}
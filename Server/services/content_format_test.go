@@ -0,0 +1,133 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+func TestCheckContentURL(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com", true},
+		{"http://example.com/a?b=c", true},
+		{"mailto:jan@example.com", true},
+		{"/wzgledny/adres", true},
+		{"obraz.png", true},
+		{"#sekcja", true},
+		{"HTTPS://EXAMPLE.COM", true},
+		{"javascript:alert(1)", false},
+		{" JaVaScript:alert(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"vbscript:msgbox", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, tt := range tests {
+		err := checkContentURL([]byte(tt.url))
+		if (err == nil) != tt.ok {
+			t.Errorf("checkContentURL(%q) = %v, oczekiwano ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckTeX(t *testing.T) {
+	tests := []struct {
+		tex string
+		ok  bool
+	}{
+		{`x^2 + y^2`, true},
+		{`\frac{a}{b}`, true},
+		{`\{ x \}`, true},
+		{`\left\{ \frac{1}{2} \right.`, true},
+		{`\frac{a}{b`, false},
+		{`a}{b`, false},
+		{`\href{javascript:alert(1)}{x}`, false},
+		{`\url{http://example.com}`, false},
+		{`\htmlClass{x}{y}`, false},
+		{`\def\x{y}`, false},
+		{`\newcommand{\x}{y}`, false},
+		{`\includegraphics{a.png}`, false},
+		{`\gdef\x{\href{a}{b}}`, false},
+		{`\let\a\href`, false},
+		{`\htmlData{x=1}{y}`, false},
+	}
+	for _, tt := range tests {
+		err := checkTeX(tt.tex)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkTeX(%q) = %v, oczekiwano ok=%v", tt.tex, err, tt.ok)
+		}
+	}
+}
+
+func TestSanitizeMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // oczekiwany wynik; pusty = oczekiwany błąd
+	}{
+		{"zwykły tekst", "**pogrubienie** i `kod`", "**pogrubienie** i `kod`"},
+		{"HTML w linii", "tekst <b>x</b>", `tekst \<b>x\</b>`},
+		{"blok HTML", "<script>alert(1)</script>", `\<script>alert(1)\</script>`},
+		{"zagnieżdżony blok HTML", "<div>\n<img src=x onerror=alert(1)>\n</div>", "\\<div>\n\\<img src=x onerror=alert(1)>\n\\</div>"},
+		{"poprawny link", "[a](https://example.com)", "[a](https://example.com)"},
+		{"link javascript", "[a](javascript:alert(1))", ""},
+		{"link JaVaScript ze spacją", "[a]( JaVaScript:alert(1))", ""},
+		{"obraz data", "![a](data:image/png;base64,AAAA)", ""},
+		{"autolink javascript", "<javascript:alert(1)>", ""},
+		{"nieznany język kodu", "```brainfuck\n+\n```", ""},
+		{"wzór z \\href", `$\href{javascript:alert(1)}{x}$`, ""},
+		{"wzór z niezbalansowanym nawiasem", `$\frac{a}{b$`, ""},
+		{"poprawny wzór", `$\frac{a}{b}$`, `$\frac{a}{b}$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeMarkdown(tt.in)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("oczekiwano błędu, wynik %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("nieoczekiwany błąd: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("wynik %q, oczekiwano %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizedMarkdownRendersWithoutRawHTML(t *testing.T) {
+	inputs := []string{
+		"<script>alert(1)</script>",
+		"<div>\n<iframe src=x></iframe>\n</div>",
+		"a <img src=x onerror=alert(1)> b",
+		"<!-- komentarz -->\n<style>body{}</style>",
+	}
+	for _, in := range inputs {
+		s, _, err := SanitizeContent(in, models.FieldFormat{Format: models.ContentFormatMarkdown})
+		if err != nil {
+			t.Fatalf("SanitizeContent(%q): %v", in, err)
+		}
+		html := RenderContentHTML(s, models.FieldFormat{Format: models.ContentFormatMarkdown})
+		for _, tag := range []string{"<script", "<img", "<iframe", "<div", "<style"} {
+			if strings.Contains(html, tag) {
+				t.Errorf("HTML dla %q zawiera %s: %s", in, tag, html)
+			}
+		}
+	}
+}
+
+func TestSanitizeContentStripsControlChars(t *testing.T) {
+	got, f, err := SanitizeContent("  a\u202eb\x00c\r\nd  ", models.FieldFormat{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "abc\nd" || f.Format != models.ContentFormatPlain {
+		t.Errorf("wynik %q (%s), oczekiwano %q (plain)", got, f.Format, "abc\nd")
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- METADANE TREŚCI: TAGI, TRUDNOŚĆ, TAKSONOMIA BLOOMA ---
+
+const (
+	// MaxContentTags to maksymalna liczba tagów jednej fiszki lub pytania
+	MaxContentTags = 10
+	// MaxTagChars to maksymalna długość tagu
+	MaxTagChars = 40
+	// MaxTagDescriptionChars to maksymalna długość opisu tagu z listy kuratorowanej
+	MaxTagDescriptionChars = 300
+
+	// Poziom trudności pytania jest obliczany z pierwszych odpowiedzi co najmniej MinDifficultyAnswers uczniów:
+	// średni wynik od difficultyEasyRate to "easy", poniżej difficultyHardRate - "hard"
+	MinDifficultyAnswers = 10
+	difficultyEasyRate   = 0.8
+	difficultyHardRate   = 0.4
+)
+
+// ContentDifficulties to poziomy trudności treści z opisami
+var ContentDifficulties = map[string]string{
+	models.DifficultyEasy:   "łatwy",
+	models.DifficultyMedium: "średni",
+	models.DifficultyHard:   "trudny",
+}
+
+// BloomLevels to kategorie taksonomii Blooma z opisami
+var BloomLevels = map[string]string{
+	models.BloomRemember:   "zapamiętanie",
+	models.BloomUnderstand: "zrozumienie",
+	models.BloomApply:      "zastosowanie",
+	models.BloomAnalyze:    "analiza",
+	models.BloomEvaluate:   "ocena",
+	models.BloomCreate:     "tworzenie",
+}
+
+// NormalizeTag sprowadza tag do postaci kanonicznej: małe litery, słowa połączone myślnikiem
+// (dozwolone litery, cyfry, "-", "_" i ".")
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.Join(strings.Fields(strings.ToLower(tag)), "-")
+	if tag == "" {
+		return "", fmt.Errorf("tag nie może być pusty")
+	}
+	if len([]rune(tag)) > MaxTagChars {
+		return "", fmt.Errorf("tag %q jest za długi (maksimum %d znaków)", tag, MaxTagChars)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.' {
+			return "", fmt.Errorf("tag %q zawiera niedozwolony znak %q", tag, r)
+		}
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizuje listę tagów i usuwa powtórzenia
+func normalizeTags(tags []string) ([]string, error) {
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t, err := NormalizeTag(t)
+		if err != nil {
+			return nil, err
+		}
+		if !containsString(out, t) {
+			out = append(out, t)
+		}
+	}
+	return nilIfEmpty(out), nil
+}
+
+func checkDifficulty(difficulty string) error {
+	if _, ok := ContentDifficulties[difficulty]; difficulty != "" && !ok {
+		return fmt.Errorf("nieznany poziom trudności %q (dozwolone: easy, medium, hard)", difficulty)
+	}
+	return nil
+}
+
+func checkBloomLevel(level string) error {
+	if _, ok := BloomLevels[level]; level != "" && !ok {
+		return fmt.Errorf("nieznana kategoria taksonomii Blooma %q (dozwolone: remember, understand, apply, analyze, evaluate, create)", level)
+	}
+	return nil
+}
+
+// normalizeGeneratedBloomLevel sprowadza kategorię Blooma podaną przez model do postaci kanonicznej.
+// Model bywa niekonsekwentny ("Analyze", "analiza"), a kategoria jest tylko pomocniczą metadaną,
+// więc nierozpoznaną wartość pomijamy zamiast odrzucać cały element.
+func normalizeGeneratedBloomLevel(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if _, ok := BloomLevels[level]; ok {
+		return level
+	}
+	for key, name := range BloomLevels {
+		if level == name {
+			return key
+		}
+	}
+	return ""
+}
+
+// NormalizeContentMetadata normalizuje tagi i sprawdza poziom trudności oraz kategorię Blooma
+func NormalizeContentMetadata(m models.ContentMetadata) (models.ContentMetadata, error) {
+	tags, err := normalizeTags(m.Tags)
+	if err != nil {
+		return m, err
+	}
+	if len(tags) > MaxContentTags {
+		return m, fmt.Errorf("element może mieć najwyżej %d tagów", MaxContentTags)
+	}
+	m.Tags = tags
+	m.Difficulty = strings.TrimSpace(m.Difficulty)
+	m.BloomLevel = strings.TrimSpace(m.BloomLevel)
+	if err := checkDifficulty(m.Difficulty); err != nil {
+		return m, err
+	}
+	if err := checkBloomLevel(m.BloomLevel); err != nil {
+		return m, err
+	}
+	return m, nil
+}
+
+// NormalizeContentFilter normalizuje tagi filtra i sprawdza pozostałe wartości
+func NormalizeContentFilter(f db.ContentFilter) (db.ContentFilter, error) {
+	tags, err := normalizeTags(f.Tags)
+	if err != nil {
+		return f, err
+	}
+	f.Tags = tags
+	if err := checkDifficulty(f.Difficulty); err != nil {
+		return f, err
+	}
+	if err := checkBloomLevel(f.BloomLevel); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// MergeContentMetadata łączy metadane scalanych elementów: sumuje tagi (do limitu), a puste pola celu
+// uzupełnia wartościami duplikatu
+func MergeContentMetadata(target, duplicate models.ContentMetadata) models.ContentMetadata {
+	for _, t := range duplicate.Tags {
+		if len(target.Tags) < MaxContentTags && !containsString(target.Tags, t) {
+			target.Tags = append(target.Tags, t)
+		}
+	}
+	if target.Difficulty == "" {
+		target.Difficulty = duplicate.Difficulty
+	}
+	if target.BloomLevel == "" {
+		target.BloomLevel = duplicate.BloomLevel
+	}
+	return target
+}
+
+// RefreshQuestionDifficulty przelicza poziom trudności pytania po nowej lub ponownie ocenionej odpowiedzi.
+// Błąd jest tylko logowany - nie wpływa na zapis odpowiedzi.
+func RefreshQuestionDifficulty(questionID uint) {
+	if err := db.UserRepository.RefreshQuestionDifficulty(questionID, MinDifficultyAnswers, difficultyEasyRate, difficultyHardRate); err != nil {
+		log.Printf("Błąd przeliczania trudności pytania %d: %v", questionID, err)
+	}
+}
@@ -0,0 +1,493 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	// maxChunkChars ogranicza ilość tekstu wysyłanego w jednym zapytaniu do modelu
+	maxChunkChars = 30000
+	// minPageTextChars - strona z mniejszą ilością tekstu jest traktowana jako skan (bez warstwy tekstowej)
+	minPageTextChars = 20
+	// maxChunkAttachments ogranicza liczbę obrazów (stron skanów) wysyłanych w jednym zapytaniu
+	maxChunkAttachments = 20
+)
+
+const (
+	mimePDF      = "application/pdf"
+	mimePPTX     = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	mimeMarkdown = "text/markdown"
+	mimeText     = "text/plain"
+)
+
+// DocumentPage to tekst jednej strony PDF, slajdu PPTX lub całego pliku tekstowego.
+// Strona PDF bez warstwy tekstowej (skan) ma pusty Text, a w Images obrazy strony do analizy przez model -
+// lub cały PDF, gdy obrazów nie dało się wyodrębnić.
+type DocumentPage struct {
+	Number int
+	Text   string
+	Images []Attachment
+}
+
+// SourceDocument to plik źródłowy rozłożony na strony oraz załączniki binarne (przesłane obrazy
+// lub PDF, z którego nie udało się odczytać stron), które model musi przeanalizować wizualnie.
+type SourceDocument struct {
+	Name        string
+	MIMEType    string
+	Pages       []DocumentPage
+	Attachments []Attachment
+}
+
+// GenerationChunk to porcja materiałów mieszcząca się w limicie jednego zapytania
+type GenerationChunk struct {
+	Text        string
+	Attachments []Attachment
+}
+
+var ErrUnsupportedFormat = errors.New("nieobsługiwany format pliku")
+
+// DetectUploadMIMEType ustala typ pliku na podstawie rozszerzenia i zawartości.
+// Zwraca błąd dla formatów, których nie potrafimy przetworzyć.
+func DetectUploadMIMEType(name string, data []byte) (string, error) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".pdf":
+		return mimePDF, nil
+	case ".pptx":
+		return mimePPTX, nil
+	case ".md", ".markdown":
+		return mimeMarkdown, nil
+	case ".txt":
+		return mimeText, nil
+	case ".png":
+		return "image/png", nil
+	case ".jpg", ".jpeg":
+		return "image/jpeg", nil
+	case ".webp":
+		return "image/webp", nil
+	}
+
+	detected := http.DetectContentType(data)
+	switch {
+	case strings.HasPrefix(detected, "image/png"), strings.HasPrefix(detected, "image/jpeg"), strings.HasPrefix(detected, "image/webp"):
+		return strings.SplitN(detected, ";", 2)[0], nil
+	case strings.HasPrefix(detected, mimePDF):
+		return mimePDF, nil
+	case strings.HasPrefix(detected, "text/plain"):
+		return mimeText, nil
+	}
+	return "", fmt.Errorf("%w %s (%s)", ErrUnsupportedFormat, name, detected)
+}
+
+// ExtractDocument zamienia przesłany plik na strony tekstu i/lub załączniki dla modelu
+func ExtractDocument(a Attachment) (*SourceDocument, error) {
+	return extractDocument(a, true)
+}
+
+// extractDocument działa jak ExtractDocument; bez withImages strony-skany PDF nie dostają obrazów
+// (wystarczy, gdy potrzebny jest tylko tekst)
+func extractDocument(a Attachment, withImages bool) (*SourceDocument, error) {
+	doc := &SourceDocument{Name: a.Name, MIMEType: a.MIMEType}
+
+	switch {
+	case a.MIMEType == mimePDF:
+		pages, err := extractPDFPages(a, withImages)
+		if err != nil {
+			log.Printf("ExtractDocument: nie udało się odczytać tekstu z %s, używam pliku jako załącznika: %v", a.Name, err)
+			doc.Attachments = append(doc.Attachments, a)
+			return doc, nil
+		}
+		doc.Pages = pages
+	case a.MIMEType == mimePPTX:
+		pages, err := extractPPTXSlides(a.Data)
+		if err != nil {
+			return nil, fmt.Errorf("błąd odczytu prezentacji %s: %w", a.Name, err)
+		}
+		doc.Pages = pages
+	case a.MIMEType == mimeText || a.MIMEType == mimeMarkdown:
+		doc.Pages = []DocumentPage{{Number: 1, Text: string(a.Data)}}
+	case strings.HasPrefix(a.MIMEType, "image/"):
+		doc.Attachments = append(doc.Attachments, a)
+	default:
+		return nil, fmt.Errorf("nieobsługiwany format pliku %s (%s)", a.Name, a.MIMEType)
+	}
+	return doc, nil
+}
+
+// extractPDFPages odczytuje tekst z każdej strony PDF. Strony bez warstwy tekstowej (skany, diagramy)
+// dostają zamiast tekstu obrazy strony (o ile withImages). Biblioteka pdf zgłasza część błędów przez panic,
+// więc zamieniamy je na zwykły błąd.
+func extractPDFPages(a Attachment, withImages bool) (pages []DocumentPage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("uszkodzony lub nieobsługiwany PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(a.Data), int64(len(a.Data)))
+	if err != nil {
+		return nil, err
+	}
+	encrypted := !reader.Trailer().Key("Encrypt").IsNull()
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			log.Printf("extractPDFPages: błąd odczytu strony %d: %v", i, err)
+			text = ""
+		}
+		p := DocumentPage{Number: i, Text: strings.TrimSpace(text)}
+		if len([]rune(p.Text)) < minPageTextChars {
+			p.Text = ""
+			if !withImages {
+				pages = append(pages, p)
+				continue
+			}
+			if !encrypted {
+				p.Images = pdfPageImages(a, page, i)
+			}
+			if p.Images == nil {
+				// Obrazów strony nie da się wyodrębnić (np. kompresja JBIG2) - model dostanie cały plik
+				p.Images = []Attachment{a}
+			}
+		}
+		pages = append(pages, p)
+	}
+	return pages, nil
+}
+
+// pdfPageImages wyodrębnia obrazy strony jako pliki JPEG lub PNG. Zwraca nil, gdy strona nie ma obrazów
+// albo któregoś nie da się odczytać.
+func pdfPageImages(a Attachment, page pdf.Page, number int) []Attachment {
+	xobjects := page.Resources().Key("XObject")
+	keys := xobjects.Keys()
+	sort.Strings(keys)
+	var images []Attachment
+	for _, key := range keys {
+		x := xobjects.Key(key)
+		if x.Key("Subtype").Name() != "Image" {
+			continue
+		}
+		data, mimeType, ok := pdfImage(a.Data, x)
+		if !ok {
+			return nil
+		}
+		ext := "png"
+		if mimeType == "image/jpeg" {
+			ext = "jpg"
+		}
+		name := fmt.Sprintf("%s, strona %d, obraz %d.%s", a.Name, number, len(images)+1, ext)
+		images = append(images, Attachment{Name: name, MIMEType: mimeType, Data: data})
+	}
+	return images
+}
+
+// pdfImage odczytuje obraz PDF: strumień DCT to gotowy plik JPEG, a nieskompresowane lub skompresowane
+// Flate piksele RGB/szarości (8 bitów) kodujemy jako PNG. Inne formaty (JBIG2, CCITT, JPX) nie są obsługiwane.
+func pdfImage(data []byte, x pdf.Value) (out []byte, mimeType string, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			out, mimeType, ok = nil, "", false
+		}
+	}()
+
+	filter := x.Key("Filter")
+	if filter.Kind() == pdf.Array && filter.Len() == 1 {
+		filter = filter.Index(0)
+	}
+	switch {
+	case filter.Kind() == pdf.Name && filter.Name() == "DCTDecode":
+		// Biblioteka pdf nie dekoduje DCT ani nie udostępnia surowych danych strumienia - jego położenie
+		// w pliku podaje tylko opis strumienia ("<<...>>@przesunięcie")
+		desc := x.String()
+		at := strings.LastIndexByte(desc, '@')
+		if at < 0 {
+			return nil, "", false
+		}
+		offset, err := strconv.ParseInt(desc[at+1:], 10, 64)
+		length := x.Key("Length").Int64()
+		if err != nil || offset < 0 || length <= 0 || offset+length > int64(len(data)) {
+			return nil, "", false
+		}
+		raw := data[offset : offset+length]
+		if !bytes.HasPrefix(raw, []byte{0xFF, 0xD8}) {
+			return nil, "", false
+		}
+		return raw, "image/jpeg", true
+
+	case filter.Kind() == pdf.Null || filter.Kind() == pdf.Name && filter.Name() == "FlateDecode":
+		width, height := int(x.Key("Width").Int64()), int(x.Key("Height").Int64())
+		if width <= 0 || height <= 0 || width*height > MaxMediaPixels || x.Key("BitsPerComponent").Int64() != 8 {
+			return nil, "", false
+		}
+		var img image.Image
+		switch x.Key("ColorSpace").Name() {
+		case "DeviceGray":
+			gray := image.NewGray(image.Rect(0, 0, width, height))
+			if _, err := io.ReadFull(x.Reader(), gray.Pix); err != nil {
+				return nil, "", false
+			}
+			img = gray
+		case "DeviceRGB":
+			pix := make([]byte, width*height*3)
+			if _, err := io.ReadFull(x.Reader(), pix); err != nil {
+				return nil, "", false
+			}
+			rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+			for i := 0; i < width*height; i++ {
+				copy(rgba.Pix[i*4:i*4+3], pix[i*3:i*3+3])
+				rgba.Pix[i*4+3] = 0xFF
+			}
+			img = rgba
+		default:
+			return nil, "", false
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", false
+		}
+		return buf.Bytes(), "image/png", true
+	}
+	return nil, "", false
+}
+
+var pptxSlideName = regexp.MustCompile(`^ppt/slides/slide(\d+)\.xml$`)
+
+// extractPPTXSlides odczytuje tekst slajdów oraz notatek prelegenta z pliku PPTX
+func extractPPTXSlides(data []byte) ([]DocumentPage, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var slides []DocumentPage
+	for name, f := range files {
+		m := pptxSlideName.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(m[1])
+
+		text, err := readDrawingMLText(f)
+		if err != nil {
+			return nil, fmt.Errorf("slajd %d: %w", number, err)
+		}
+
+		if notesName := pptxNotesFor(files, name); notesName != "" {
+			notes, err := readDrawingMLText(files[notesName])
+			if err == nil && strings.TrimSpace(notes) != "" {
+				text += "\n\nNotatki prelegenta:\n" + notes
+			}
+		}
+
+		slides = append(slides, DocumentPage{Number: number, Text: strings.TrimSpace(text)})
+	}
+
+	sort.Slice(slides, func(i, j int) bool { return slides[i].Number < slides[j].Number })
+	return slides, nil
+}
+
+// pptxNotesFor znajduje plik notatek powiązany ze slajdem przez ppt/slides/_rels/slideN.xml.rels
+func pptxNotesFor(files map[string]*zip.File, slideName string) string {
+	relsName := path.Join(path.Dir(slideName), "_rels", path.Base(slideName)+".rels")
+	relsFile, ok := files[relsName]
+	if !ok {
+		return ""
+	}
+	rc, err := relsFile.Open()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+
+	var rels struct {
+		Relationships []struct {
+			Type   string `xml:"Type,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.NewDecoder(rc).Decode(&rels); err != nil {
+		return ""
+	}
+	for _, r := range rels.Relationships {
+		if strings.HasSuffix(r.Type, "/notesSlide") {
+			target := path.Clean(path.Join(path.Dir(slideName), r.Target))
+			if _, ok := files[target]; ok {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+// readDrawingMLText zbiera tekst z elementów <a:t>, zachowując podział na akapity <a:p>
+func readDrawingMLText(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var sb strings.Builder
+	dec := xml.NewDecoder(rc)
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "t" {
+				inText = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// BuildGenerationChunks rozkłada przesłane pliki na porcje tekstu z oznaczeniami plików i stron,
+// tak aby żadna porcja nie przekroczyła limitu modelu. Obrazy stron skanów oraz pliki analizowane
+// wizualnie trafiają do porcji, w której występuje ich miejsce w materiale.
+func BuildGenerationChunks(attachments []Attachment) ([]GenerationChunk, error) {
+	var chunks []GenerationChunk
+	var current []Attachment
+	var sb strings.Builder
+
+	flush := func() {
+		if sb.Len() > 0 || len(current) > 0 {
+			chunks = append(chunks, GenerationChunk{Text: sb.String(), Attachments: current})
+			sb.Reset()
+			current = nil
+		}
+	}
+	attach := func(images []Attachment) {
+		for _, img := range images {
+			// Ten sam PDF może być załącznikiem kilku stron-skanów w jednej porcji
+			duplicate := false
+			for _, c := range current {
+				if c.Name == img.Name {
+					duplicate = true
+					break
+				}
+			}
+			if !duplicate {
+				current = append(current, img)
+			}
+		}
+	}
+
+	for _, a := range attachments {
+		doc, err := ExtractDocument(a)
+		if err != nil {
+			return nil, err
+		}
+		if len(doc.Attachments) > 0 {
+			if len(current)+len(doc.Attachments) > maxChunkAttachments {
+				flush()
+			}
+			attach(doc.Attachments)
+		}
+
+		for _, p := range doc.Pages {
+			if len(p.Images) > 0 {
+				block := fmt.Sprintf("--- Plik: %s, strona %d (skan - treść strony w załączonym obrazie lub pliku) ---\n\n", doc.Name, p.Number)
+				if sb.Len()+len(block) > maxChunkChars || len(current)+len(p.Images) > maxChunkAttachments {
+					flush()
+				}
+				sb.WriteString(block)
+				attach(p.Images)
+				continue
+			}
+			for _, part := range splitText(p.Text, maxChunkChars) {
+				block := fmt.Sprintf("--- Plik: %s, strona %d ---\n%s\n\n", doc.Name, p.Number, part)
+				if sb.Len()+len(block) > maxChunkChars {
+					flush()
+				}
+				sb.WriteString(block)
+			}
+		}
+	}
+	flush()
+
+	if len(chunks) == 0 {
+		chunks = append(chunks, GenerationChunk{})
+	}
+	return chunks, nil
+}
+
+// splitText dzieli zbyt długi tekst na części po granicach akapitów (lub twardo, gdy akapit jest za długi)
+func splitText(text string, limit int) []string {
+	if len(text) <= limit {
+		return []string{text}
+	}
+
+	var parts []string
+	var sb strings.Builder
+	for _, para := range strings.Split(text, "\n\n") {
+		for len(para) > limit {
+			cut := limit
+			for cut > 0 && !isRuneStart(para[cut]) {
+				cut--
+			}
+			if sb.Len() > 0 {
+				parts = append(parts, sb.String())
+				sb.Reset()
+			}
+			parts = append(parts, para[:cut])
+			para = para[cut:]
+		}
+		if sb.Len()+len(para)+2 > limit && sb.Len() > 0 {
+			parts = append(parts, sb.String())
+			sb.Reset()
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString(para)
+	}
+	if sb.Len() > 0 {
+		parts = append(parts, sb.String())
+	}
+	return parts
+}
+
+func isRuneStart(b byte) bool { return b&0xC0 != 0x80 }
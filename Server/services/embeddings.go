@@ -0,0 +1,232 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"google.golang.org/api/option"
+)
+
+// --- EMBEDDINGI ---
+
+// Embedder zamienia teksty na wektory. query = true oznacza zapytanie wyszukiwania
+// (niektóre modele liczą wektory zapytań i dokumentów inaczej).
+type Embedder interface {
+	EmbeddingModel() string
+	Embed(ctx context.Context, texts []string, query bool) ([][]float32, error)
+}
+
+// embeddingProvider to dostawca AI, który udostępnia też model embeddingów
+type embeddingProvider interface {
+	Embedder(model string) Embedder
+}
+
+// NewEmbedder wybiera model embeddingów: model dostawcy AI z konfiguracji (z zapisem zużycia),
+// a gdy dostawca go nie obsługuje (lub EMBEDDING_MODEL=local) - lokalny model haszujący
+func NewEmbedder(cfg config.Config) Embedder {
+	if strings.EqualFold(cfg.EmbeddingModel, "local") {
+		return NewLocalEmbedder()
+	}
+	provider, err := NewLLMProvider(cfg)
+	if err == nil {
+		if p, ok := provider.(embeddingProvider); ok {
+			return &MeteredEmbedder{Inner: p.Embedder(cfg.EmbeddingModel), UserRepo: db.UserRepository}
+		}
+		err = fmt.Errorf("dostawca %s nie obsługuje embeddingów", provider.Name())
+	}
+	log.Printf("Embeddingi: %v - używam modelu lokalnego.", err)
+	return NewLocalEmbedder()
+}
+
+// normalizeVector skaluje wektor do długości 1 (iloczyn skalarny = podobieństwo cosinusowe)
+func normalizeVector(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	norm := float32(math.Sqrt(sum))
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+func dotProduct(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// --- LOKALNY MODEL ---
+
+const localEmbeddingDims = 512
+
+// LocalEmbedder to prosty model działający bez API: rdzenie słów (jak przy wykrywaniu duplikatów)
+// i ich pary są haszowane do wektora o stałej długości. Wychwytuje wspólne słownictwo, nie synonimy.
+type LocalEmbedder struct {
+	Dims int
+}
+
+func NewLocalEmbedder() *LocalEmbedder { return &LocalEmbedder{Dims: localEmbeddingDims} }
+
+func (e *LocalEmbedder) EmbeddingModel() string { return fmt.Sprintf("local-hash-%d", e.Dims) }
+
+func (e *LocalEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, text := range texts {
+		out[i] = e.embed(text)
+	}
+	return out, nil
+}
+
+func (e *LocalEmbedder) embed(text string) []float32 {
+	v := make([]float32, e.Dims)
+	add := func(feature string, weight float32) {
+		h := fnv.New32a()
+		h.Write([]byte(feature))
+		sum := h.Sum32()
+		sign := float32(1)
+		if sum&(1<<31) != 0 {
+			sign = -1
+		}
+		v[int(sum%uint32(e.Dims))] += sign * weight
+	}
+
+	var prev string
+	for _, word := range similarityTokenList(text) {
+		add(word, 1)
+		if prev != "" {
+			add(prev+" "+word, 0.5)
+		}
+		prev = word
+	}
+	return normalizeVector(v)
+}
+
+// --- GEMINI ---
+
+const defaultGeminiEmbeddingModel = "text-embedding-004"
+
+// geminiEmbeddingBatch to maksymalna liczba tekstów w jednym zapytaniu BatchEmbedContents
+const geminiEmbeddingBatch = 100
+
+type geminiEmbedder struct {
+	APIKey    string
+	ModelName string
+}
+
+func (p *GeminiProvider) Embedder(model string) Embedder {
+	if model == "" {
+		model = defaultGeminiEmbeddingModel
+	}
+	return &geminiEmbedder{APIKey: p.APIKey, ModelName: model}
+}
+
+func (e *geminiEmbedder) EmbeddingModel() string { return "gemini/" + e.ModelName }
+
+func (e *geminiEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(e.APIKey))
+	if err != nil {
+		return nil, fmt.Errorf("błąd tworzenia klienta Gemini: %w", err)
+	}
+	defer client.Close()
+
+	em := client.EmbeddingModel(e.ModelName)
+	em.TaskType = genai.TaskTypeRetrievalDocument
+	if query {
+		em.TaskType = genai.TaskTypeRetrievalQuery
+	}
+
+	out := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += geminiEmbeddingBatch {
+		end := min(start+geminiEmbeddingBatch, len(texts))
+		batch := em.NewBatch()
+		for _, t := range texts[start:end] {
+			batch.AddContent(genai.Text(t))
+		}
+		res, err := em.BatchEmbedContents(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("błąd liczenia embeddingów: %w", err)
+		}
+		if len(res.Embeddings) != end-start {
+			return nil, fmt.Errorf("gemini zwrócił %d embeddingów zamiast %d", len(res.Embeddings), end-start)
+		}
+		for _, emb := range res.Embeddings {
+			out = append(out, normalizeVector(emb.Values))
+		}
+	}
+	return out, nil
+}
+
+// --- OPENAI-COMPATIBLE ---
+
+const defaultOpenAIEmbeddingModel = "text-embedding-3-small"
+
+type openAIEmbedder struct {
+	provider  *OpenAIProvider
+	ModelName string
+}
+
+func (p *OpenAIProvider) Embedder(model string) Embedder {
+	if model == "" {
+		model = defaultOpenAIEmbeddingModel
+	}
+	return &openAIEmbedder{provider: p, ModelName: model}
+}
+
+func (e *openAIEmbedder) EmbeddingModel() string { return "openai/" + e.ModelName }
+
+type openAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	resp, err := e.provider.post(ctx, "/embeddings", openAIEmbeddingRequest{Model: e.ModelName, Input: texts})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var embResp openAIEmbeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embResp); err != nil {
+		return nil, fmt.Errorf("błąd dekodowania odpowiedzi modelu: %w", err)
+	}
+	out := make([][]float32, len(texts))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(out) {
+			return nil, fmt.Errorf("nieprawidłowy indeks embeddingu %d", d.Index)
+		}
+		out[d.Index] = normalizeVector(d.Embedding)
+	}
+	for i := range out {
+		if out[i] == nil {
+			return nil, fmt.Errorf("brak embeddingu dla tekstu %d", i)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- WYJAŚNIENIA OPCJI PYTAŃ QUIZOWYCH ---
+
+const (
+	explanationTask    = "explanation"
+	explanationTimeout = time.Minute
+	// MaxExplanationChars to maksymalna długość wyjaśnienia jednej opcji
+	MaxExplanationChars = 1000
+)
+
+// NormalizeExplanations przycina wyjaśnienia i sprawdza, czy jest ich tyle co opcji.
+// Pusta lista oznacza usunięcie wyjaśnień.
+func NormalizeExplanations(explanations []string, optionCount int) ([]string, error) {
+	if len(explanations) == 0 {
+		return nil, nil
+	}
+	if len(explanations) != optionCount {
+		return nil, fmt.Errorf("liczba wyjaśnień (%d) musi być równa liczbie opcji (%d)", len(explanations), optionCount)
+	}
+	out := make([]string, len(explanations))
+	for i, e := range explanations {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			return nil, fmt.Errorf("wyjaśnienie opcji %d jest puste", i+1)
+		}
+		if len([]rune(e)) > MaxExplanationChars {
+			return nil, fmt.Errorf("wyjaśnienie opcji %d jest za długie (maksimum %d znaków)", i+1, MaxExplanationChars)
+		}
+		out[i] = e
+	}
+	return out, nil
+}
+
+func explanationSchema(optionCount int) *jsonSchema {
+	return &jsonSchema{
+		Type:                 "object",
+		Required:             []string{"explanations"},
+		AdditionalProperties: true,
+		Properties: map[string]*jsonSchema{
+			"explanations": {
+				Type:     "array",
+				MinItems: optionCount,
+				MaxItems: optionCount,
+				Items:    &jsonSchema{Type: "string", MinLength: 1, MaxLength: MaxExplanationChars},
+			},
+		},
+	}
+}
+
+func buildExplanationPrompt(q *models.QuizQuestion, source string) string {
+	var sb strings.Builder
+	sb.WriteString("Jesteś tutorem. Dla poniższego pytania quizowego napisz dla KAŻDEJ opcji krótkie (1-3 zdania) wyjaśnienie: ")
+	sb.WriteString("dla poprawnej opcji - dlaczego jest poprawna, dla błędnych - na czym polega błąd i jakie nieporozumienie może do niej prowadzić. ")
+	sb.WriteString("Pisz w języku pytania.\n\n")
+	fmt.Fprintf(&sb, "Zwróć TYLKO obiekt JSON: {\"explanations\": [...]} z dokładnie %d wyjaśnieniami, w kolejności opcji.\n\n", len(q.Options))
+
+	if source != "" {
+		sb.WriteString("Materiał źródłowy:\n" + source + "\n\n")
+	}
+	sb.WriteString("Pytanie: " + q.QuestionText + "\nOpcje:\n")
+	for i, o := range q.Options {
+		mark := ""
+		if IsCorrectOption(q, i) {
+			mark = " (POPRAWNA)"
+		}
+		fmt.Fprintf(&sb, "%d. %s%s\n", i+1, o, mark)
+	}
+	return sb.String()
+}
+
+// GenerateExplanations prosi model o wyjaśnienia wszystkich opcji pytania
+// (z materiałem źródłowym pytania jako kontekstem, jeśli jest dostępny)
+func GenerateExplanations(ctx context.Context, userUsosID string, q *models.QuizQuestion) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, explanationTimeout)
+	defer cancel()
+	ctx = WithAIUsageOwner(ctx, AIUsageOwner{UserUsosID: userUsosID, TopicID: q.TopicID})
+
+	sources := &screeningSources{docs: make(map[int64]*screeningDocument)}
+	prompt := buildExplanationPrompt(q, sources.context(q.SourceMaterialIDs, q.SourceFile, q.SourcePages))
+	schema := explanationSchema(len(q.Options))
+
+	req := LLMRequest{Task: explanationTask, Prompt: prompt, JSON: true, Hints: ResponseHints{OptionCount: len(q.Options)}}
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := LLM.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := extractJSONObject(resp.Text)
+		if err == nil {
+			if problems := validateJSON(schema, raw); len(problems) > 0 {
+				err = fmt.Errorf("niepoprawne wyjaśnienia: %s", strings.Join(problems, "; "))
+			}
+		}
+		if err != nil {
+			lastErr = err
+			req.Prompt = buildRegeneratePrompt(prompt, resp.Text, err)
+			continue
+		}
+
+		var out struct {
+			Explanations []string `json:"explanations"`
+		}
+		if err := json.Unmarshal(raw, &out); err != nil {
+			return nil, err
+		}
+		return NormalizeExplanations(out.Explanations, len(q.Options))
+	}
+	return nil, lastErr
+}
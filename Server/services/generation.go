@@ -0,0 +1,693 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// GenerationInput opisuje jedno zlecenie wygenerowania treści dla tematu
+type GenerationInput struct {
+	Type        string
+	Notes       string
+	TopicID     uint
+	UserUsosID  string
+	Attachments []Attachment
+	// SourceMaterialIDs[i] to ID materiału źródłowego, z którego pochodzi Attachments[i]
+	SourceMaterialIDs []int64
+	Params            models.GenerationParams
+	// PromptTemplateID to wersja szablonu promptu użyta do generowania (ustawiana przez runGeneration)
+	PromptTemplateID *uint
+	// Dla zadań z kolejki: porcje o numerach mniejszych niż SkipChunks zostały zapisane w poprzedniej próbie,
+	// a ChunkSaved utrwala wynik każdej kolejnej porcji zaraz po zapisaniu jej treści
+	SkipChunks int
+	ChunkSaved func(chunk int, part *GenerationResult) error
+}
+
+// GenerationResult zawiera ID zapisanych (oczekujących na moderację) treści
+// oraz opisy elementów odrzuconych przez walidację
+type GenerationResult struct {
+	FlashcardIDs    []int64
+	QuizQuestionIDs []int64
+	TopicNoteIDs    []int64
+	Rejected        []string
+}
+
+// maxRepairAttempts ogranicza liczbę ponownych zapytań o poprawienie odpowiedzi modelu
+const maxRepairAttempts = 2
+
+// RunGeneration wysyła zapytania do modelu (po jednym na porcję materiałów) i zapisuje wynik w bazie
+func RunGeneration(ctx context.Context, in GenerationInput) (*GenerationResult, error) {
+	return runGeneration(ctx, in, nil)
+}
+
+// StreamGeneration działa jak RunGeneration, ale wywołuje onItem dla każdego poprawnego elementu
+// (fiszki, pytania) odczytanego z częściowej odpowiedzi modelu, zanim całość zostanie zapisana.
+func StreamGeneration(ctx context.Context, in GenerationInput, onItem func(index int, item json.RawMessage) error) (*GenerationResult, error) {
+	index := 0
+	return runGeneration(ctx, in, func(item json.RawMessage) error {
+		err := onItem(index, item)
+		index++
+		return err
+	})
+}
+
+func runGeneration(ctx context.Context, in GenerationInput, onValid func(json.RawMessage) error) (*GenerationResult, error) {
+	ctx = WithAIUsageOwner(ctx, AIUsageOwner{UserUsosID: in.UserUsosID, TopicID: in.TopicID})
+
+	chunks, err := BuildGenerationChunks(in.Attachments)
+	if err != nil {
+		return nil, err
+	}
+	if in.Params, err = NormalizeGenerationParams(in.Type, in.Params); err != nil {
+		return nil, err
+	}
+	prompt, err := prepareGenerationPrompt(in)
+	if err != nil {
+		return nil, err
+	}
+	in.PromptTemplateID = prompt.templateID()
+
+	counts := splitCount(in.Params.Count, len(chunks))
+	result := &GenerationResult{}
+	for i, chunk := range chunks {
+		if i < in.SkipChunks {
+			continue
+		}
+
+		part := &GenerationResult{}
+		// Przy większej liczbie porcji niż zamówionych elementów część porcji nie dostaje żadnego
+		if in.Params.Count == 0 || counts[i] > 0 {
+			// Limit sprawdzany przy zleceniu nie obejmuje kosztu kolejnych porcji - przerywamy, gdy się wyczerpie
+			if in.UserUsosID != "" {
+				if err := CheckAIQuota(in.UserUsosID); err != nil {
+					return nil, chunkError(i, len(chunks), err)
+				}
+			}
+			valid, invalid, err := generateChunk(ctx, in, prompt, chunk, counts[i], onValid)
+			if err != nil {
+				return nil, chunkError(i, len(chunks), err)
+			}
+
+			if part, err = SaveGeneratedItems(in, valid); err != nil {
+				return nil, chunkError(i, len(chunks), fmt.Errorf("błąd zapisu wygenerowanych treści: %w", err))
+			}
+			part.Rejected = describeRejected(invalid)
+		}
+		if in.ChunkSaved != nil {
+			if err := in.ChunkSaved(i, part); err != nil {
+				return nil, chunkError(i, len(chunks), fmt.Errorf("błąd zapisu postępu: %w", err))
+			}
+		}
+		result.merge(part)
+	}
+	return result, nil
+}
+
+// splitCount rozdziela zamówioną liczbę elementów równo między porcje materiałów (nadwyżka trafia
+// do pierwszych porcji). Dla count == 0 (bez limitu, np. podsumowanie) zwraca same zera.
+func splitCount(count, chunks int) []int {
+	counts := make([]int, chunks)
+	for i := range counts {
+		counts[i] = count / chunks
+		if i < count%chunks {
+			counts[i]++
+		}
+	}
+	return counts
+}
+
+// generateChunk pobiera od modelu elementy dla jednej porcji materiałów, waliduje je
+// i ponawia zapytanie (maksymalnie maxRepairAttempts razy), gdy odpowiedź jest nieczytelna
+// lub zawiera błędne elementy. Zwraca poprawne elementy (najwyżej count, gdy count > 0)
+// oraz te, których nie udało się naprawić.
+func generateChunk(ctx context.Context, in GenerationInput, genPrompt *generationPrompt, chunk GenerationChunk, count int, onValid func(json.RawMessage) error) ([]json.RawMessage, []InvalidItem, error) {
+	var valid []json.RawMessage
+	var invalid []InvalidItem
+	full := func() bool { return count > 0 && len(valid) >= count }
+
+	accept := func(item json.RawMessage) error {
+		// Model bywa hojniejszy niż prosiliśmy - nadmiarowe elementy pomijamy
+		if full() {
+			return nil
+		}
+		reasons := ValidateGeneratedItem(in.Type, item)
+		if len(reasons) == 0 {
+			reasons = CheckGeneratedItemParams(in.Type, in.Params, item)
+		}
+		if len(reasons) > 0 {
+			invalid = append(invalid, InvalidItem{Raw: item, Reasons: reasons})
+			return nil
+		}
+		valid = append(valid, item)
+		if onValid != nil {
+			return onValid(item)
+		}
+		return nil
+	}
+
+	prompt, err := genPrompt.render(chunk.Text, count)
+	if err != nil {
+		return nil, nil, fmt.Errorf("błąd wypełniania szablonu promptu: %w", err)
+	}
+	hints := ResponseHints{Count: count, OptionCount: in.Params.OptionCount}
+	if in.Type == "quiz" {
+		hints.QuestionTypes = allowedQuestionTypes(in.Params)
+	}
+	req := LLMRequest{Task: in.Type, Prompt: prompt, Attachments: chunk.Attachments, JSON: true, Hints: hints}
+
+	for attempt := 0; ; attempt++ {
+		text, streamed, err := requestGeneration(ctx, req, in.Type, onValid != nil, accept)
+		if err != nil {
+			return nil, nil, err
+		}
+		if streamed {
+			break
+		}
+
+		items, parseErr := ExtractGeneratedItems(in.Type, text)
+		if parseErr != nil {
+			if attempt >= maxRepairAttempts {
+				return nil, nil, fmt.Errorf("model nie zwrócił poprawnego JSON po %d próbach: %w", attempt+1, parseErr)
+			}
+			log.Printf("Generowanie: nieczytelna odpowiedź modelu (%v), ponawiam zapytanie", parseErr)
+			req.Prompt = buildRegeneratePrompt(prompt, text, parseErr)
+			continue
+		}
+		for _, item := range items {
+			if err := accept(item); err != nil {
+				return nil, nil, err
+			}
+		}
+		break
+	}
+
+	if full() {
+		// Zamówiona liczba elementów jest osiągnięta - błędnych nie trzeba naprawiać
+		invalid = nil
+	}
+	for attempt := 0; attempt < maxRepairAttempts && len(invalid) > 0 && !full(); attempt++ {
+		toRepair := invalid
+		invalid = nil
+		log.Printf("Generowanie: próba naprawy %d elementów (próba %d/%d)", len(toRepair), attempt+1, maxRepairAttempts)
+
+		hints.Count = len(toRepair)
+		resp, err := LLM.Generate(ctx, LLMRequest{Task: in.Type, Prompt: buildRepairPrompt(in.Type, toRepair), JSON: true, Hints: hints})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			log.Printf("Generowanie: błąd zapytania naprawczego: %v", err)
+			invalid = toRepair
+			break
+		}
+
+		repaired, parseErr := ExtractGeneratedItems(in.Type, resp.Text)
+		if parseErr != nil {
+			invalid = toRepair
+			continue
+		}
+		if len(repaired) > len(toRepair) {
+			repaired = repaired[:len(toRepair)]
+		}
+		for _, item := range repaired {
+			if err := accept(item); err != nil {
+				return nil, nil, err
+			}
+		}
+		// Elementy, których model nie zwrócił w odpowiedzi naprawczej, pozostają odrzucone
+		if len(repaired) < len(toRepair) {
+			invalid = append(invalid, toRepair[len(repaired):]...)
+		}
+	}
+
+	return valid, invalid, nil
+}
+
+// requestGeneration wykonuje jedno zapytanie do modelu. Przy strumieniowaniu tablic elementy są
+// przekazywane do accept na bieżąco i streamed == true (o ile odczytano choć jeden element).
+func requestGeneration(ctx context.Context, req LLMRequest, genType string, stream bool, accept func(json.RawMessage) error) (text string, streamed bool, err error) {
+	if !stream || genType == "summary" {
+		resp, err := LLM.Generate(ctx, req)
+		if err != nil {
+			return "", false, err
+		}
+		return resp.Text, false, nil
+	}
+
+	parser := &JSONArrayStream{}
+	count := 0
+	resp, err := GenerateStream(ctx, req, func(chunk string) error {
+		for _, item := range parser.Write(chunk) {
+			count++
+			if err := accept(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return resp.Text, count > 0, nil
+}
+
+func (r *GenerationResult) merge(other *GenerationResult) {
+	r.FlashcardIDs = append(r.FlashcardIDs, other.FlashcardIDs...)
+	r.QuizQuestionIDs = append(r.QuizQuestionIDs, other.QuizQuestionIDs...)
+	r.TopicNoteIDs = append(r.TopicNoteIDs, other.TopicNoteIDs...)
+	r.Rejected = append(r.Rejected, other.Rejected...)
+}
+
+// chunkError dodaje numer porcji do błędu, gdy materiały zostały podzielone
+func chunkError(i, total int, err error) error {
+	if total <= 1 {
+		return err
+	}
+	return fmt.Errorf("porcja %d/%d: %w", i+1, total, err)
+}
+
+// sourceMaterialRefs wskazuje materiały, na których opiera się element: plik wskazany przez model
+// (pole "file"), a gdy go brak lub nie pasuje do żadnego pliku - wszystkie przesłane materiały.
+func sourceMaterialRefs(in GenerationInput, file string) pq.Int64Array {
+	if file != "" {
+		for i, a := range in.Attachments {
+			if a.Name == file && i < len(in.SourceMaterialIDs) {
+				return pq.Int64Array{in.SourceMaterialIDs[i]}
+			}
+		}
+	}
+	return pq.Int64Array(in.SourceMaterialIDs)
+}
+
+// generationParamsRef zwraca kopię parametrów zlecenia do zapisania przy treściach i zadaniu
+func generationParamsRef(in GenerationInput) *models.GenerationParams {
+	params := in.Params
+	return &params
+}
+
+// generatedMetadata zwraca metadane wygenerowanego elementu: poziom trudności z parametrów generowania
+// i kategorię Blooma wskazaną przez model
+func generatedMetadata(in GenerationInput, bloom string) models.ContentMetadata {
+	m := models.ContentMetadata{Difficulty: in.Params.Difficulty, BloomLevel: normalizeGeneratedBloomLevel(bloom)}
+	if checkDifficulty(m.Difficulty) != nil {
+		m.Difficulty = ""
+	}
+	return m
+}
+
+// SaveGeneratedItems zapisuje zwalidowane elementy w DB jako treści oczekujące na moderację
+func SaveGeneratedItems(in GenerationInput, items []json.RawMessage) (*GenerationResult, error) {
+	result := &GenerationResult{}
+	genType, topicID, userUsosID := in.Type, in.TopicID, in.UserUsosID
+
+	var dupIndex *DuplicateIndex
+	if genType == "flashcards" || genType == "quiz" {
+		var err error
+		if dupIndex, err = NewDuplicateIndex(db.UserRepository, topicID); err != nil {
+			return nil, fmt.Errorf("błąd wczytywania treści tematu: %w", err)
+		}
+	}
+
+	switch genType {
+	case "flashcards":
+		for _, raw := range items {
+			var fc models.GeneratedFlashcard
+			if err := json.Unmarshal(raw, &fc); err != nil {
+				return nil, fmt.Errorf("błąd parsowania JSON fiszki: %w", err)
+			}
+			snapshot, err := NormalizeFlashcardSnapshot(models.RevisionSnapshot{Question: fc.Question, Answer: fc.Answer})
+			if err != nil {
+				log.Printf("Pominięto nieprawidłową fiszkę: %v", err)
+				continue
+			}
+			dbModel := models.Flashcard{
+				TopicID:           topicID,
+				Status:            "pending",
+				CreatedByUsosID:   userUsosID,
+				ContentMetadata:   generatedMetadata(in, fc.Bloom),
+				SourceFile:        fc.File,
+				SourcePages:       fc.Pages,
+				SourceMaterialIDs: sourceMaterialRefs(in, fc.File),
+				PromptTemplateID:  in.PromptTemplateID,
+				GenerationParams:  generationParamsRef(in),
+			}
+			ApplyFlashcardSnapshot(&dbModel, snapshot)
+			if err := CreateFlashcardChecked(dupIndex, &dbModel); err != nil {
+				log.Printf("Błąd zapisu fiszki do DB: %v", err)
+				continue
+			}
+			result.FlashcardIDs = append(result.FlashcardIDs, int64(dbModel.ID))
+		}
+
+	case "quiz":
+		for _, raw := range items {
+			var q models.GeneratedQuizQuestion
+			if err := json.Unmarshal(raw, &q); err != nil {
+				return nil, fmt.Errorf("błąd parsowania JSON pytania: %w", err)
+			}
+			questionType, snapshot := GeneratedQuizSnapshot(q)
+			if !containsString(allowedQuestionTypes(in.Params), questionType) {
+				log.Printf("Pominięto pytanie niezamówionego typu %q", questionType)
+				continue
+			}
+			snapshot, err := NormalizeQuizSnapshot(questionType, snapshot)
+			if err != nil {
+				log.Printf("Pominięto nieprawidłowe pytanie: %v", err)
+				continue
+			}
+			dbModel := models.QuizQuestion{
+				TopicID:           topicID,
+				QuestionType:      questionType,
+				Status:            "pending",
+				CreatedByUsosID:   userUsosID,
+				ContentMetadata:   generatedMetadata(in, q.Bloom),
+				SourceFile:        q.File,
+				SourcePages:       q.Pages,
+				SourceMaterialIDs: sourceMaterialRefs(in, q.File),
+				PromptTemplateID:  in.PromptTemplateID,
+				GenerationParams:  generationParamsRef(in),
+			}
+			ApplyQuizSnapshot(&dbModel, snapshot)
+			if HasOptionExplanations(questionType) {
+				dbModel.OptionExplanations = q.Explanations
+			}
+			if len(dbModel.OptionExplanations) > 0 {
+				dbModel.ExplanationStatus = "pending"
+			}
+			if err := CreateQuizQuestionChecked(dupIndex, &dbModel); err != nil {
+				log.Printf("Błąd zapisu pytania do DB: %v", err)
+				continue
+			}
+			result.QuizQuestionIDs = append(result.QuizQuestionIDs, int64(dbModel.ID))
+		}
+
+	case "summary":
+		for _, raw := range items {
+			var s models.GeneratedSummary
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, fmt.Errorf("błąd parsowania JSON podsumowania: %w", err)
+			}
+			dbModel := models.TopicNote{
+				TopicID:           topicID,
+				Body:              strings.TrimSpace(s.Summary),
+				Status:            "pending",
+				Version:           1,
+				CreatedByUsosID:   userUsosID,
+				SourceFile:        s.File,
+				SourcePages:       s.Pages,
+				SourceMaterialIDs: sourceMaterialRefs(in, s.File),
+				PromptTemplateID:  in.PromptTemplateID,
+				GenerationParams:  generationParamsRef(in),
+			}
+			if err := db.UserRepository.CreateTopicNote(&dbModel); err != nil {
+				log.Printf("Błąd zapisu podsumowania do DB: %v", err)
+				continue
+			}
+			result.TopicNoteIDs = append(result.TopicNoteIDs, int64(dbModel.ID))
+			NotifyScreening()
+		}
+	}
+	return result, nil
+}
+
+// --- KOLEJKA ZADAŃ GENEROWANIA ---
+
+var GenerationWorkers *GenerationWorkerPool
+
+const (
+	generationJobTimeout   = 5 * time.Minute
+	generationPollInterval = 10 * time.Second
+	// Trwające zadanie odświeża updated_at co generationHeartbeat; zadanie bez znaku życia
+	// przez generationStaleAfter uznajemy za przerwane i wraca do kolejki
+	generationHeartbeat  = 30 * time.Second
+	generationStaleAfter = 3 * time.Minute
+)
+
+// GenerationWorkerPool przetwarza zadania z tabeli generation_jobs w ograniczonej liczbie goroutine
+type GenerationWorkerPool struct {
+	UserRepo         *db.GormUserRepository
+	Workers          int
+	MaxActivePerUser int
+
+	wake    chan struct{}
+	mu      sync.Mutex
+	running map[uint]context.CancelFunc
+}
+
+func InitGenerationWorkers(cfg config.Config) {
+	workers := cfg.GenerationWorkers
+	if workers < 1 {
+		workers = 2
+	}
+	maxActive := cfg.GenerationMaxActivePerUser
+	if maxActive < 1 {
+		maxActive = 3
+	}
+
+	pool := &GenerationWorkerPool{
+		UserRepo:         db.UserRepository,
+		Workers:          workers,
+		MaxActivePerUser: maxActive,
+		wake:             make(chan struct{}, workers),
+		running:          make(map[uint]context.CancelFunc),
+	}
+
+	for i := 0; i < workers; i++ {
+		go pool.worker(i)
+	}
+	go pool.requeueStale()
+
+	GenerationWorkers = pool
+	log.Printf("Kolejka generowania AI uruchomiona (%d workerów).", workers)
+}
+
+// ErrTooManyActiveJobs oznacza przekroczenie limitu równoległych zadań użytkownika
+var ErrTooManyActiveJobs = errors.New("przekroczono limit aktywnych zadań generowania")
+
+// Submit zapisuje nowe zadanie (o ile użytkownik nie wyczerpał limitu aktywnych zadań) i budzi wolnego workera
+func (p *GenerationWorkerPool) Submit(in GenerationInput) (*models.GenerationJob, error) {
+	job := newGenerationJob(in, models.GenerationJobQueued)
+	created, err := p.UserRepo.CreateGenerationJobWithinLimit(job, p.MaxActivePerUser)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, ErrTooManyActiveJobs
+	}
+	p.notify()
+	return job, nil
+}
+
+// Stream wykonuje generowanie od razu (StreamGeneration), rejestrując je jako trwające zadanie:
+// wlicza się do limitu aktywnych zadań użytkownika, można je anulować jak zadanie z kolejki,
+// a treści zapisane przed przerwaniem zostają w jego wyniku. Zamknięcie połączenia (ctx) anuluje zadanie;
+// zadanie przerwane restartem serwera dokończy worker, jak każde przerwane zadanie z kolejki.
+func (p *GenerationWorkerPool) Stream(ctx context.Context, in GenerationInput, onItem func(index int, item json.RawMessage) error) (*GenerationResult, error) {
+	job := newGenerationJob(in, models.GenerationJobRunning)
+	now := time.Now()
+	job.Attempts, job.StartedAt = 1, &now
+	created, err := p.UserRepo.CreateGenerationJobWithinLimit(job, p.MaxActivePerUser)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, ErrTooManyActiveJobs
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go p.heartbeat(ctx, job.ID)
+	p.mu.Lock()
+	p.running[job.ID] = cancel
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.running, job.ID)
+		p.mu.Unlock()
+	}()
+
+	in.ChunkSaved = func(chunk int, part *GenerationResult) error {
+		return p.UserRepo.RecordGenerationJobChunk(job.ID, chunk+1, part.FlashcardIDs, part.QuizQuestionIDs, part.TopicNoteIDs, part.Rejected)
+	}
+	result, err := StreamGeneration(ctx, in, onItem)
+	if errors.Is(err, context.Canceled) {
+		if _, cerr := p.UserRepo.CancelGenerationJob(job.ID); cerr != nil {
+			log.Printf("Zadanie generowania %d: błąd anulowania: %v", job.ID, cerr)
+		}
+	}
+	p.finish(job.ID, result, err)
+	return result, err
+}
+
+// newGenerationJob tworzy rekord zadania dla zlecenia generowania
+func newGenerationJob(in GenerationInput, status string) *models.GenerationJob {
+	return &models.GenerationJob{
+		TopicID:           in.TopicID,
+		Type:              in.Type,
+		Notes:             in.Notes,
+		Status:            status,
+		CreatedByUsosID:   in.UserUsosID,
+		SourceMaterialIDs: in.SourceMaterialIDs,
+		Params:            generationParamsRef(in),
+	}
+}
+
+// Cancel anuluje zadanie w bazie i przerywa je, jeśli jest właśnie wykonywane
+func (p *GenerationWorkerPool) Cancel(jobID uint) (bool, error) {
+	ok, err := p.UserRepo.CancelGenerationJob(jobID)
+	if err != nil || !ok {
+		return ok, err
+	}
+	p.mu.Lock()
+	if cancel, found := p.running[jobID]; found {
+		cancel()
+	}
+	p.mu.Unlock()
+	return true, nil
+}
+
+// Retry ponownie kolejkuje zadanie zakończone błędem lub anulowane. Ponowione zadanie wlicza się do limitu
+// aktywnych zadań użytkownika tak jak nowe (ErrTooManyActiveJobs).
+func (p *GenerationWorkerPool) Retry(job *models.GenerationJob) (bool, error) {
+	retried, limited, err := p.UserRepo.RetryGenerationJobWithinLimit(job, p.MaxActivePerUser)
+	if err != nil {
+		return false, err
+	}
+	if limited {
+		return false, ErrTooManyActiveJobs
+	}
+	if retried {
+		p.notify()
+	}
+	return retried, nil
+}
+
+// requeueStale co generationStaleAfter przywraca do kolejki zadania przerwane restartem lub awarią
+// (także innej instancji serwera)
+func (p *GenerationWorkerPool) requeueStale() {
+	for {
+		n, err := p.UserRepo.RequeueStaleGenerationJobs(time.Now().Add(-generationStaleAfter))
+		if err != nil {
+			log.Printf("Błąd przywracania przerwanych zadań generowania: %v", err)
+		} else if n > 0 {
+			log.Printf("Przywrócono do kolejki %d przerwanych zadań generowania.", n)
+			p.notify()
+		}
+		time.Sleep(generationStaleAfter)
+	}
+}
+
+// heartbeat odświeża znak życia zadania, dopóki ctx nie zostanie zakończony
+func (p *GenerationWorkerPool) heartbeat(ctx context.Context, jobID uint) {
+	ticker := time.NewTicker(generationHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.UserRepo.TouchGenerationJob(jobID); err != nil {
+				log.Printf("Zadanie generowania %d: błąd odświeżenia: %v", jobID, err)
+			}
+		}
+	}
+}
+
+func (p *GenerationWorkerPool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *GenerationWorkerPool) worker(n int) {
+	for {
+		job, err := p.UserRepo.ClaimNextGenerationJob()
+		if err != nil {
+			log.Printf("Worker generowania %d: błąd pobierania zadania: %v", n, err)
+		}
+		if job == nil {
+			select {
+			case <-p.wake:
+			case <-time.After(generationPollInterval):
+			}
+			continue
+		}
+		p.process(job)
+	}
+}
+
+func (p *GenerationWorkerPool) process(job *models.GenerationJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), generationJobTimeout)
+	defer cancel()
+	go p.heartbeat(ctx, job.ID)
+
+	p.mu.Lock()
+	p.running[job.ID] = cancel
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.running, job.ID)
+		p.mu.Unlock()
+	}()
+
+	log.Printf("Zadanie generowania %d: start (typ: %s, próba %d)", job.ID, job.Type, job.Attempts)
+
+	attachments, err := Materials.LoadAttachments(job.SourceMaterialIDs)
+	if err != nil {
+		p.finish(job.ID, nil, fmt.Errorf("błąd odczytu plików zadania: %w", err))
+		return
+	}
+
+	var params models.GenerationParams
+	if job.Params != nil {
+		params = *job.Params
+	}
+	result, err := RunGeneration(ctx, GenerationInput{
+		Type:              job.Type,
+		Notes:             job.Notes,
+		TopicID:           job.TopicID,
+		UserUsosID:        job.CreatedByUsosID,
+		Attachments:       attachments,
+		SourceMaterialIDs: job.SourceMaterialIDs,
+		Params:            params,
+		SkipChunks:        job.CompletedChunks,
+		ChunkSaved: func(chunk int, part *GenerationResult) error {
+			return p.UserRepo.RecordGenerationJobChunk(job.ID, chunk+1, part.FlashcardIDs, part.QuizQuestionIDs, part.TopicNoteIDs, part.Rejected)
+		},
+	})
+	p.finish(job.ID, result, err)
+}
+
+func (p *GenerationWorkerPool) finish(jobID uint, result *GenerationResult, err error) {
+	job := &models.GenerationJob{ID: jobID, Status: models.GenerationJobSucceeded}
+
+	if err != nil {
+		job.Status = models.GenerationJobFailed
+		job.Error = err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			job.Error = "przekroczono limit czasu generowania"
+		}
+		log.Printf("Zadanie generowania %d: błąd: %v", jobID, err)
+	} else if result != nil {
+		log.Printf("Zadanie generowania %d: sukces (%d fiszek, %d pytań, %d podsumowań, %d odrzuconych)",
+			jobID, len(result.FlashcardIDs), len(result.QuizQuestionIDs), len(result.TopicNoteIDs), len(result.Rejected))
+	}
+
+	// Zadanie anulowane w trakcie ma już status 'cancelled' - FinishGenerationJob go nie nadpisze,
+	// a treści zapisane przed anulowaniem są już dopisane do zadania (RecordGenerationJobChunk)
+	if err := p.UserRepo.FinishGenerationJob(job); err != nil {
+		log.Printf("Zadanie generowania %d: błąd zapisu wyniku: %v", jobID, err)
+	}
+}
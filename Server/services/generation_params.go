@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- PARAMETRY GENEROWANIA ---
+
+const (
+	MaxFlashcardCount    = 30
+	MaxQuizQuestionCount = 20
+	MinOptionCount       = 2
+	MaxOptionCount       = 6
+	MaxFocusKeywords     = 10
+	maxFocusKeywordChars = 60
+)
+
+// Dozwolone wartości parametrów i ich opisy używane w promptach
+var (
+	GenerationDifficulties = map[string]string{
+		"easy":   "łatwy",
+		"medium": "średni",
+		"hard":   "trudny",
+	}
+	GenerationLanguages = map[string]string{
+		"pl": "polski",
+		"en": "angielski",
+	}
+	GenerationQuestionStyles = map[string]string{
+		"definition":  "definicje i pojęcia",
+		"application": "zastosowanie wiedzy w praktyce",
+		"calculation": "zadania obliczeniowe",
+	}
+)
+
+// NormalizeGenerationParams uzupełnia brakujące parametry wartościami domyślnymi i sprawdza ich zakresy.
+// Parametry nieużywane przez dany typ generowania (np. liczba opcji dla fiszek) są zerowane.
+func NormalizeGenerationParams(genType string, p models.GenerationParams) (models.GenerationParams, error) {
+	defaults := DefaultPromptData(genType)
+
+	var maxCount int
+	switch genType {
+	case "flashcards":
+		maxCount = MaxFlashcardCount
+	case "quiz":
+		maxCount = MaxQuizQuestionCount
+	}
+	if maxCount == 0 {
+		p.Count = 0
+	} else if p.Count == 0 {
+		p.Count = defaults.Count
+	} else if p.Count < 1 || p.Count > maxCount {
+		return p, fmt.Errorf("liczba elementów musi być z zakresu 1-%d", maxCount)
+	}
+
+	if p.Difficulty == "" {
+		p.Difficulty = "medium"
+	} else if _, ok := GenerationDifficulties[p.Difficulty]; !ok {
+		return p, fmt.Errorf("nieznany poziom trudności %q (dozwolone: easy, medium, hard)", p.Difficulty)
+	}
+
+	if p.Language == "" {
+		p.Language = "pl"
+	} else if _, ok := GenerationLanguages[p.Language]; !ok {
+		return p, fmt.Errorf("nieobsługiwany język %q (dozwolone: pl, en)", p.Language)
+	}
+
+	if genType != "quiz" {
+		p.OptionCount = 0
+	} else if p.OptionCount == 0 {
+		p.OptionCount = defaults.OptionCount
+	} else if p.OptionCount < MinOptionCount || p.OptionCount > MaxOptionCount {
+		return p, fmt.Errorf("liczba opcji musi być z zakresu %d-%d", MinOptionCount, MaxOptionCount)
+	}
+
+	if genType != "quiz" {
+		p.QuestionTypes = nil
+	}
+	types := make([]string, 0, len(p.QuestionTypes))
+	for _, t := range p.QuestionTypes {
+		if _, ok := QuestionTypes[t]; !ok {
+			return p, unknownQuestionTypeError(t)
+		}
+		if !containsString(types, t) {
+			types = append(types, t)
+		}
+	}
+	p.QuestionTypes = nilIfEmpty(types)
+
+	if genType == "summary" || genType == "grading" {
+		p.QuestionStyles = nil
+	}
+	styles := make([]string, 0, len(p.QuestionStyles))
+	for _, s := range p.QuestionStyles {
+		if _, ok := GenerationQuestionStyles[s]; !ok {
+			return p, fmt.Errorf("nieznany styl pytań %q (dozwolone: definition, application, calculation)", s)
+		}
+		if !containsString(styles, s) {
+			styles = append(styles, s)
+		}
+	}
+	p.QuestionStyles = nilIfEmpty(styles)
+
+	keywords := make([]string, 0, len(p.FocusKeywords))
+	for _, k := range p.FocusKeywords {
+		k = strings.Join(strings.Fields(k), " ")
+		if k == "" || containsString(keywords, k) {
+			continue
+		}
+		if len([]rune(k)) > maxFocusKeywordChars {
+			return p, fmt.Errorf("słowo kluczowe %q jest za długie (maksimum %d znaków)", k, maxFocusKeywordChars)
+		}
+		keywords = append(keywords, k)
+	}
+	if len(keywords) > MaxFocusKeywords {
+		return p, fmt.Errorf("można podać maksymalnie %d słów kluczowych", MaxFocusKeywords)
+	}
+	p.FocusKeywords = nilIfEmpty(keywords)
+
+	return p, nil
+}
+
+// applyGenerationParams przenosi parametry do zmiennych szablonu promptu
+func applyGenerationParams(data *PromptData, p models.GenerationParams) {
+	if p.Count > 0 {
+		data.Count = p.Count
+	}
+	if label, ok := GenerationDifficulties[p.Difficulty]; ok {
+		data.Difficulty = label
+	}
+	if label, ok := GenerationLanguages[p.Language]; ok {
+		data.Language = label
+	}
+	if p.OptionCount > 0 {
+		data.OptionCount = p.OptionCount
+	}
+	if len(p.QuestionTypes) > 0 {
+		data.QuestionTypes = p.QuestionTypes
+	}
+	data.QuestionStyles = nil
+	for _, s := range p.QuestionStyles {
+		data.QuestionStyles = append(data.QuestionStyles, GenerationQuestionStyles[s])
+	}
+	data.FocusKeywords = p.FocusKeywords
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func nilIfEmpty(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- OCENA ODPOWIEDZI OTWARTYCH ---
+
+const (
+	gradingTask    = "grading"
+	gradingTimeout = time.Minute
+	// MaxOpenAnswerChars to maksymalna długość odpowiedzi studenta
+	MaxOpenAnswerChars = 5000
+	// MaxReferenceAnswerChars to maksymalna długość odpowiedzi wzorcowej
+	MaxReferenceAnswerChars = 5000
+	// MaxRubricPoints to maksymalna liczba kryteriów oceny jednego pytania
+	MaxRubricPoints = 20
+	// MaxRubricPointScore to maksymalna liczba punktów za jedno kryterium
+	MaxRubricPointScore = 100
+	maxRubricPointChars = 500
+	maxGradingFeedback  = 2000
+)
+
+// NormalizeRubric przycina kryteria oceny i sprawdza ich liczbę, treść i punktację
+func NormalizeRubric(rubric []models.RubricPoint) ([]models.RubricPoint, error) {
+	if len(rubric) == 0 {
+		return nil, fmt.Errorf("pytanie otwarte musi mieć co najmniej jedno kryterium oceny")
+	}
+	if len(rubric) > MaxRubricPoints {
+		return nil, fmt.Errorf("można podać maksymalnie %d kryteriów oceny", MaxRubricPoints)
+	}
+	out := make([]models.RubricPoint, len(rubric))
+	for i, p := range rubric {
+		p.Text = strings.TrimSpace(p.Text)
+		if p.Text == "" {
+			return nil, fmt.Errorf("kryterium %d jest puste", i+1)
+		}
+		if len([]rune(p.Text)) > maxRubricPointChars {
+			return nil, fmt.Errorf("kryterium %d jest za długie (maksimum %d znaków)", i+1, maxRubricPointChars)
+		}
+		if p.Points < 1 || p.Points > MaxRubricPointScore {
+			return nil, fmt.Errorf("punkty za kryterium %d muszą być z zakresu 1-%d", i+1, MaxRubricPointScore)
+		}
+		out[i] = p
+	}
+	return out, nil
+}
+
+// RubricMaxScore zwraca liczbę punktów możliwych do zdobycia
+func RubricMaxScore(rubric []models.RubricPoint) int {
+	total := 0
+	for _, p := range rubric {
+		total += p.Points
+	}
+	return total
+}
+
+func gradingSchema(pointCount int) *jsonSchema {
+	return &jsonSchema{
+		Type:                 "object",
+		Required:             []string{"matched_points", "feedback"},
+		AdditionalProperties: true,
+		Properties: map[string]*jsonSchema{
+			"matched_points": {Type: "array", MaxItems: pointCount, Items: &jsonSchema{Type: "integer", Minimum: minimum(1), Maximum: maximum(float64(pointCount))}},
+			"feedback":       {Type: "string", MinLength: 1, MaxLength: maxGradingFeedback},
+		},
+	}
+}
+
+// prepareGradingPrompt wypełnia obowiązujący szablon "grading" dla przedmiotu pytania
+func prepareGradingPrompt(q *models.QuizQuestion, answer string) (*models.PromptTemplate, string, error) {
+	data := DefaultPromptData(gradingTask)
+	data.QuestionText, data.ReferenceAnswer, data.StudentAnswer = q.QuestionText, q.ReferenceAnswer, answer
+	data.Rubric, data.MaxScore = q.Rubric, RubricMaxScore(q.Rubric)
+
+	var subjectID uint
+	if topic, err := db.UserRepository.GetTopicByID(q.TopicID); err == nil {
+		subjectID = topic.SubjectID
+		data.TopicName = topic.Name
+		if subject, err := db.UserRepository.GetSubjectByID(subjectID); err == nil {
+			data.SubjectName = subject.Name
+		}
+	}
+
+	t, err := ResolvePromptTemplate(gradingTask, subjectID)
+	if err != nil {
+		return nil, "", err
+	}
+	compiled, err := ParsePromptTemplate(t.Name, t.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("błąd szablonu promptu %s (wersja %d): %w", t.Name, t.Version, err)
+	}
+	prompt, err := RenderPromptTemplate(compiled, data)
+	if err != nil {
+		return nil, "", fmt.Errorf("błąd wypełniania szablonu promptu %s (wersja %d): %w", t.Name, t.Version, err)
+	}
+	return t, prompt, nil
+}
+
+// GradeOpenAnswer ocenia odpowiedź na pytanie otwarte według kryteriów pytania. Wynik to suma punktów
+// spełnionych kryteriów. Zwracana ocena zawiera model i wersję szablonu promptu (do zapisania przez wywołującego).
+func GradeOpenAnswer(ctx context.Context, requestedBy string, q *models.QuizQuestion, answer string) (*models.AnswerGrading, error) {
+	if q.QuestionType != models.QuestionTypeOpen || len(q.Rubric) == 0 {
+		return nil, fmt.Errorf("pytanie %d nie jest pytaniem otwartym z kryteriami oceny", q.ID)
+	}
+	t, prompt, err := prepareGradingPrompt(q, answer)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, gradingTimeout)
+	defer cancel()
+	ctx = WithAIUsageOwner(ctx, AIUsageOwner{UserUsosID: requestedBy, TopicID: q.TopicID})
+
+	schema := gradingSchema(len(q.Rubric))
+	req := LLMRequest{Task: gradingTask, Prompt: prompt, JSON: true, Hints: ResponseHints{RubricPoints: len(q.Rubric)}}
+	tokens := 0
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := LLM.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		tokens += resp.Usage.TotalTokens
+
+		raw, err := extractJSONObject(resp.Text)
+		if err == nil {
+			if problems := validateJSON(schema, raw); len(problems) > 0 {
+				err = fmt.Errorf("niepoprawna ocena: %s", strings.Join(problems, "; "))
+			}
+		}
+		if err != nil {
+			lastErr = err
+			req.Prompt = buildRegeneratePrompt(prompt, resp.Text, err)
+			continue
+		}
+
+		var out struct {
+			MatchedPoints []int  `json:"matched_points"`
+			Feedback      string `json:"feedback"`
+		}
+		if err := json.Unmarshal(raw, &out); err != nil {
+			return nil, err
+		}
+
+		grading := &models.AnswerGrading{
+			Provider:          LLM.Name(),
+			Model:             resp.Model,
+			PromptVersion:     t.Version,
+			MaxScore:          RubricMaxScore(q.Rubric),
+			Feedback:          strings.TrimSpace(out.Feedback),
+			MatchedPoints:     pq.Int64Array{},
+			RawResponse:       resp.Text,
+			RequestedByUsosID: requestedBy,
+			TotalTokens:       tokens,
+		}
+		if grading.Model == "" {
+			grading.Model = LLM.Model()
+		}
+		if t.ID != 0 {
+			id := t.ID
+			grading.PromptTemplateID = &id
+		}
+		// Numery kryteriów od 1 w prompcie -> indeksy od 0, bez powtórzeń
+		seen := make(map[int]bool, len(out.MatchedPoints))
+		for _, n := range out.MatchedPoints {
+			if i := n - 1; !seen[i] {
+				seen[i] = true
+				grading.MatchedPoints = append(grading.MatchedPoints, int64(i))
+				grading.Score += q.Rubric[i].Points
+			}
+		}
+		return grading, nil
+	}
+	return nil, lastErr
+}
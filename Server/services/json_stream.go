@@ -0,0 +1,74 @@
+package services
+
+import "encoding/json"
+
+// JSONArrayStream wyodrębnia kolejne kompletne elementy tablicy JSON najwyższego poziomu
+// z tekstu dopisywanego kawałkami (np. ze strumienia modelu). Tekst przed pierwszym '['
+// (np. znacznik "```json") jest pomijany, a elementy skalarne są ignorowane.
+type JSONArrayStream struct {
+	started  bool
+	done     bool
+	depth    int
+	inString bool
+	escaped  bool
+	current  []byte
+}
+
+// Write dopisuje fragment tekstu i zwraca elementy, które zostały w nim domknięte
+func (s *JSONArrayStream) Write(chunk string) []json.RawMessage {
+	var items []json.RawMessage
+
+	for i := 0; i < len(chunk); i++ {
+		ch := chunk[i]
+		if s.done {
+			break
+		}
+		if !s.started {
+			if ch == '[' {
+				s.started = true
+				s.depth = 1
+			}
+			continue
+		}
+
+		if s.depth > 1 {
+			s.current = append(s.current, ch)
+		}
+
+		if s.inString {
+			switch {
+			case s.escaped:
+				s.escaped = false
+			case ch == '\\':
+				s.escaped = true
+			case ch == '"':
+				s.inString = false
+			}
+			continue
+		}
+
+		switch ch {
+		case '"':
+			s.inString = true
+		case '{', '[':
+			if s.depth == 1 {
+				s.current = append(s.current[:0], ch)
+			}
+			s.depth++
+		case '}', ']':
+			s.depth--
+			if s.depth == 1 {
+				item := make([]byte, len(s.current))
+				copy(item, s.current)
+				if json.Valid(item) {
+					items = append(items, json.RawMessage(item))
+				}
+				s.current = s.current[:0]
+			}
+			if s.depth == 0 {
+				s.done = true
+			}
+		}
+	}
+	return items
+}
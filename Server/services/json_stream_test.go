@@ -0,0 +1,55 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJSONArrayStream(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"tablica obiektów", `[{"a":1},{"b":2}]`, []string{`{"a":1}`, `{"b":2}`}},
+		{"znacznik bloku kodu", "```json\n[{\"a\":1}]\n```", []string{`{"a":1}`}},
+		{"nawiasy i cudzysłowy w tekście", `[{"a":"}]\"{["},{"b":[1,{"c":2}]}]`, []string{`{"a":"}]\"{["}`, `{"b":[1,{"c":2}]}`}},
+		{"elementy skalarne są pomijane", `[1, "x", {"a":1}, true, [2]]`, []string{`{"a":1}`, `[2]`}},
+		{"ucięta odpowiedź", `[{"a":1},{"b":`, []string{`{"a":1}`}},
+		{"niepoprawny element", `[{"a":1},{"b":tak},{"c":3}]`, []string{`{"a":1}`, `{"c":3}`}},
+		{"tekst po końcu tablicy", `[{"a":1}] [{"b":2}]`, []string{`{"a":1}`}},
+		{"brak tablicy", `{"a":1}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &JSONArrayStream{}
+			var got []string
+			for _, item := range s.Write(tt.text) {
+				got = append(got, string(item))
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("elementy %q, oczekiwano %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONArrayStreamChunked(t *testing.T) {
+	text := "Oto wynik:\n```json\n[{\"q\":\"a\\\"b\"}, {\"q\":\"[c]\"}, {\"q\":{\"d\":[1,2]}}]\n```"
+	want := []string{`{"q":"a\"b"}`, `{"q":"[c]"}`, `{"q":{"d":[1,2]}}`}
+
+	// Wynik nie może zależeć od podziału tekstu na fragmenty
+	for size := 1; size <= len(text); size++ {
+		s := &JSONArrayStream{}
+		var got []string
+		for start := 0; start < len(text); start += size {
+			end := min(start+size, len(text))
+			for _, item := range s.Write(text[start:end]) {
+				got = append(got, string(item))
+			}
+		}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Fatalf("fragmenty po %d B: elementy %q, oczekiwano %q", size, got, want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- GRAF WIEDZY KURSU ---
+
+const (
+	graphTask    = "knowledge_graph"
+	graphTimeout = 2 * time.Minute
+	// MaxSyllabusChars ogranicza długość sylabusa dołączanego do zapytania
+	MaxSyllabusChars = 20000
+	// graphMaxTopics ogranicza liczbę tematów w jednym zapytaniu
+	graphMaxTopics = 200
+)
+
+// GraphEdgeProposal to węzeł grafu zaproponowany przez model (lub poprawiony przez prowadzącego)
+type GraphEdgeProposal struct {
+	TopicID   uint   `json:"topic_id"`
+	DependsOn []uint `json:"depends_on"`
+	Reason    string `json:"reason"`
+}
+
+// GraphValidationError opisuje problemy z grafem (nieznane tematy, cykle)
+type GraphValidationError struct {
+	Problems []string
+}
+
+func (e *GraphValidationError) Error() string {
+	return "niepoprawny graf zależności: " + strings.Join(e.Problems, "; ")
+}
+
+// BuildGraphDrafts sprawdza zależności między tematami przedmiotu i zwraca węzły w kolejności
+// topologicznej (tematy bez wymagań najpierw). Tematy pominięte w propozycji dostają węzeł bez zależności.
+// Zwraca *GraphValidationError dla nieznanych tematów, zależności od samego siebie i cykli.
+func BuildGraphDrafts(topics []models.Topic, proposal []GraphEdgeProposal) ([]db.GraphNodeDraft, error) {
+	topicByID := make(map[uint]models.Topic, len(topics))
+	for _, t := range topics {
+		topicByID[t.ID] = t
+	}
+
+	var problems []string
+	deps := make(map[uint][]uint, len(topics))
+	reasons := make(map[uint]string, len(topics))
+	for _, p := range proposal {
+		if _, ok := topicByID[p.TopicID]; !ok {
+			problems = append(problems, fmt.Sprintf("nieznany temat %d", p.TopicID))
+			continue
+		}
+		if _, dup := deps[p.TopicID]; dup {
+			problems = append(problems, fmt.Sprintf("temat %d występuje więcej niż raz", p.TopicID))
+			continue
+		}
+		seen := make(map[uint]bool, len(p.DependsOn))
+		list := make([]uint, 0, len(p.DependsOn))
+		for _, d := range p.DependsOn {
+			switch {
+			case d == p.TopicID:
+				problems = append(problems, fmt.Sprintf("temat %d zależy od samego siebie", d))
+			case topicByID[d].ID == 0:
+				problems = append(problems, fmt.Sprintf("temat %d zależy od nieznanego tematu %d", p.TopicID, d))
+			case !seen[d]:
+				seen[d] = true
+				list = append(list, d)
+			}
+		}
+		deps[p.TopicID] = list
+		reasons[p.TopicID] = strings.TrimSpace(p.Reason)
+	}
+	if len(problems) > 0 {
+		return nil, &GraphValidationError{Problems: problems}
+	}
+
+	order, cycle := topologicalOrder(topics, deps)
+	if cycle != nil {
+		names := make([]string, len(cycle))
+		for i, id := range cycle {
+			names[i] = fmt.Sprintf("%q (%d)", topicByID[id].Name, id)
+		}
+		return nil, &GraphValidationError{Problems: []string{"cykl: " + strings.Join(names, " → ")}}
+	}
+
+	drafts := make([]db.GraphNodeDraft, 0, len(order))
+	for _, id := range order {
+		drafts = append(drafts, db.GraphNodeDraft{
+			TopicID:   id,
+			Title:     topicByID[id].Name,
+			DependsOn: deps[id],
+			Rationale: reasons[id],
+		})
+	}
+	return drafts, nil
+}
+
+// topologicalOrder porządkuje tematy algorytmem Kahna (przy remisie - według ID).
+// Gdy graf ma cykl, zwraca jeden z cykli (pierwszy temat powtórzony na końcu).
+func topologicalOrder(topics []models.Topic, deps map[uint][]uint) (order []uint, cycle []uint) {
+	indegree := make(map[uint]int, len(topics))
+	dependents := make(map[uint][]uint, len(topics))
+	ids := make([]uint, 0, len(topics))
+	for _, t := range topics {
+		ids = append(ids, t.ID)
+		indegree[t.ID] = len(deps[t.ID])
+		for _, d := range deps[t.ID] {
+			dependents[d] = append(dependents[d], t.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var ready []uint
+	for _, id := range ids {
+		if indegree[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return ready[i] < ready[j] })
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+		for _, next := range dependents[id] {
+			if indegree[next]--; indegree[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+	if len(order) == len(ids) {
+		return order, nil
+	}
+
+	// Pozostałe tematy leżą na cyklu lub zależą od cyklu - idziemy po zależnościach, aż któryś temat się powtórzy
+	var start uint
+	for _, id := range ids {
+		if indegree[id] > 0 {
+			start = id
+			break
+		}
+	}
+	visitedAt := make(map[uint]int)
+	var path []uint
+	for id := start; ; {
+		if at, ok := visitedAt[id]; ok {
+			return nil, append(path[at:], id)
+		}
+		visitedAt[id] = len(path)
+		path = append(path, id)
+		for _, d := range deps[id] {
+			if indegree[d] > 0 {
+				id = d
+				break
+			}
+		}
+	}
+}
+
+// graphProposalSchema opisuje odpowiedź modelu
+var graphProposalSchema = &jsonSchema{
+	Type:                 "object",
+	Required:             []string{"nodes"},
+	AdditionalProperties: true,
+	Properties: map[string]*jsonSchema{
+		"nodes": {Type: "array", Items: &jsonSchema{
+			Type:                 "object",
+			Required:             []string{"topic_id", "depends_on"},
+			AdditionalProperties: true,
+			Properties: map[string]*jsonSchema{
+				"topic_id":   {Type: "integer", Minimum: minimum(1)},
+				"depends_on": {Type: "array", Items: &jsonSchema{Type: "integer", Minimum: minimum(1)}},
+				"reason":     {Type: "string", MaxLength: 1000},
+			},
+		}},
+	},
+}
+
+func buildGraphPrompt(subject *models.Subject, topics []models.Topic, syllabus string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Jesteś prowadzącym przedmiot „%s”. Ułóż graf wymagań wstępnych między tematami kursu: ", subject.Name)
+	sb.WriteString("temat A zależy od tematu B, jeśli do zrozumienia A trzeba najpierw opanować B. ")
+	sb.WriteString("Graf musi być acykliczny. Podawaj tylko bezpośrednie zależności (bez przechodnich) i tylko między tematami z listy. ")
+	sb.WriteString("Tematy niezależne mają pustą listę \"depends_on\".\n\n")
+	sb.WriteString("Zwróć TYLKO obiekt JSON: {\"nodes\": [{\"topic_id\": ID, \"depends_on\": [ID, ...], \"reason\": \"krótkie uzasadnienie\"}]} - po jednym wpisie dla każdego tematu.\n\n")
+
+	sb.WriteString("Tematy (ID: nazwa):\n")
+	for _, t := range topics {
+		fmt.Fprintf(&sb, "%d: %s\n", t.ID, t.Name)
+	}
+	if syllabus != "" {
+		sb.WriteString("\nSylabus przedmiotu:\n" + syllabus + "\n")
+	}
+	return sb.String()
+}
+
+// GenerateKnowledgeGraph prosi model o graf zależności między tematami przedmiotu i sprawdza go.
+// Przy błędnym formacie lub cyklu model dostaje jedną szansę na poprawkę.
+func GenerateKnowledgeGraph(ctx context.Context, userUsosID string, subject *models.Subject, topics []models.Topic, syllabus string) ([]db.GraphNodeDraft, error) {
+	if len(topics) < 2 {
+		return nil, fmt.Errorf("przedmiot musi mieć co najmniej 2 tematy")
+	}
+	if len(topics) > graphMaxTopics {
+		return nil, fmt.Errorf("przedmiot ma za dużo tematów (%d, limit %d)", len(topics), graphMaxTopics)
+	}
+	syllabus = truncateRunes(strings.TrimSpace(syllabus), MaxSyllabusChars)
+
+	ctx, cancel := context.WithTimeout(ctx, graphTimeout)
+	defer cancel()
+	ctx = WithAIUsageOwner(ctx, AIUsageOwner{UserUsosID: userUsosID})
+
+	prompt := buildGraphPrompt(subject, topics, syllabus)
+	hints := ResponseHints{TopicIDs: make([]uint, len(topics))}
+	for i, t := range topics {
+		hints.TopicIDs[i] = t.ID
+	}
+	req := LLMRequest{Task: graphTask, Prompt: prompt, JSON: true, Hints: hints}
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := LLM.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		drafts, err := parseGraphProposal(resp.Text, topics)
+		if err == nil {
+			return drafts, nil
+		}
+		lastErr = err
+		req.Prompt = buildRegeneratePrompt(prompt, resp.Text, err)
+	}
+	return nil, lastErr
+}
+
+func parseGraphProposal(text string, topics []models.Topic) ([]db.GraphNodeDraft, error) {
+	raw, err := extractJSONObject(text)
+	if err != nil {
+		return nil, err
+	}
+	if problems := validateJSON(graphProposalSchema, raw); len(problems) > 0 {
+		return nil, fmt.Errorf("niepoprawna odpowiedź: %s", strings.Join(problems, "; "))
+	}
+	var proposal struct {
+		Nodes []GraphEdgeProposal `json:"nodes"`
+	}
+	if err := json.Unmarshal(raw, &proposal); err != nil {
+		return nil, err
+	}
+	return BuildGraphDrafts(topics, proposal.Nodes)
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+func TestTopologicalOrder(t *testing.T) {
+	topics := func(ids ...uint) []models.Topic {
+		out := make([]models.Topic, len(ids))
+		for i, id := range ids {
+			out[i] = models.Topic{ID: id}
+		}
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		topics []models.Topic
+		deps   map[uint][]uint
+		order  []uint
+		cycle  []uint
+	}{
+		{"brak zależności - według ID", topics(3, 1, 2), nil, []uint{1, 2, 3}, nil},
+		{"łańcuch", topics(1, 2, 3), map[uint][]uint{1: {2}, 2: {3}}, []uint{3, 2, 1}, nil},
+		{"remis rozstrzyga ID", topics(1, 2, 3, 4), map[uint][]uint{4: {1}, 2: {3}}, []uint{1, 3, 2, 4}, nil},
+		{"wiele zależności", topics(1, 2, 3), map[uint][]uint{3: {1, 2}}, []uint{1, 2, 3}, nil},
+		{"cykl", topics(1, 2, 3), map[uint][]uint{1: {3}, 2: {1}, 3: {2}}, nil, []uint{1, 3, 2, 1}},
+		{"zależność od cyklu", topics(1, 2, 3), map[uint][]uint{1: {2}, 2: {3}, 3: {2}}, nil, []uint{2, 3, 2}},
+		{"pętla własna", topics(1, 2), map[uint][]uint{2: {2}}, nil, []uint{2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, cycle := topologicalOrder(tt.topics, tt.deps)
+			if !slices.Equal(order, tt.order) || !slices.Equal(cycle, tt.cycle) {
+				t.Errorf("kolejność %v, cykl %v; oczekiwano %v, %v", order, cycle, tt.order, tt.cycle)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFakeProviderQuizFollowsHints(t *testing.T) {
+	p := NewFakeProvider()
+	types := []string{"single_choice", "multiple_choice", "true_false", "numeric", "ordering", "cloze", "open"}
+	resp, err := p.Generate(context.Background(), LLMRequest{
+		Task:   "quiz",
+		Prompt: "dowolna treść promptu",
+		Hints:  ResponseHints{Count: 9, QuestionTypes: types, OptionCount: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := ExtractGeneratedItems("quiz", resp.Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 9 {
+		t.Fatalf("liczba pytań %d, oczekiwano 9", len(items))
+	}
+	for i, item := range items {
+		if errs := ValidateGeneratedItem("quiz", item); len(errs) > 0 {
+			t.Errorf("pytanie %d: %q", i, errs)
+		}
+		var q struct {
+			Type    string   `json:"type"`
+			Options []string `json:"options"`
+		}
+		if err := json.Unmarshal(item, &q); err != nil {
+			t.Fatal(err)
+		}
+		if q.Type != types[i%len(types)] {
+			t.Errorf("pytanie %d: typ %s, oczekiwano %s", i, q.Type, types[i%len(types)])
+		}
+		if (q.Type == "single_choice" || q.Type == "multiple_choice") && len(q.Options) != 3 {
+			t.Errorf("pytanie %d: %d opcji, oczekiwano 3", i, len(q.Options))
+		}
+	}
+}
+
+func TestFakeProviderStructuredTasks(t *testing.T) {
+	p := NewFakeProvider()
+	ctx := context.Background()
+
+	resp, err := p.Generate(ctx, LLMRequest{Task: "flashcards", Prompt: "x", Hints: ResponseHints{Count: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, err := ExtractGeneratedItems("flashcards", resp.Text); err != nil || len(items) != 5 {
+		t.Errorf("fiszki: %d elementów, błąd %v", len(items), err)
+	}
+
+	resp, err = p.Generate(ctx, LLMRequest{Task: "explanation", Prompt: "x", Hints: ResponseHints{OptionCount: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var explanation struct{ Explanations []string }
+	if err := json.Unmarshal([]byte(resp.Text), &explanation); err != nil || len(explanation.Explanations) != 4 {
+		t.Errorf("wyjaśnienia: %s (błąd %v)", resp.Text, err)
+	}
+
+	resp, err = p.Generate(ctx, LLMRequest{Task: "grading", Prompt: "x", Hints: ResponseHints{RubricPoints: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grading struct {
+		MatchedPoints []int `json:"matched_points"`
+	}
+	if err := json.Unmarshal([]byte(resp.Text), &grading); err != nil || len(grading.MatchedPoints) != 2 || grading.MatchedPoints[1] != 3 {
+		t.Errorf("ocena: %s (błąd %v)", resp.Text, err)
+	}
+
+	resp, err = p.Generate(ctx, LLMRequest{Task: "knowledge_graph", Prompt: "x", Hints: ResponseHints{TopicIDs: []uint{7, 3, 9}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var graph struct {
+		Nodes []struct {
+			TopicID   uint   `json:"topic_id"`
+			DependsOn []uint `json:"depends_on"`
+		}
+	}
+	if err := json.Unmarshal([]byte(resp.Text), &graph); err != nil || len(graph.Nodes) != 3 {
+		t.Fatalf("graf: %s (błąd %v)", resp.Text, err)
+	}
+	if n := graph.Nodes[2]; n.TopicID != 9 || len(n.DependsOn) != 1 || n.DependsOn[0] != 3 {
+		t.Errorf("graf: ostatni temat %+v, oczekiwano 9 zależnego od 3", n)
+	}
+}
+
+func TestFakeProviderIsDeterministic(t *testing.T) {
+	p := NewFakeProvider()
+	req := LLMRequest{Task: "flashcards", Prompt: "materiał A"}
+	a, _ := p.Generate(context.Background(), req)
+	b, _ := p.Generate(context.Background(), req)
+	c, _ := p.Generate(context.Background(), LLMRequest{Task: "flashcards", Prompt: "materiał B"})
+	if a.Text != b.Text {
+		t.Error("ta sama prośba dała różne odpowiedzi")
+	}
+	if a.Text == c.Text {
+		t.Error("różne prośby dały tę samą odpowiedź")
+	}
+	if a.Usage.TotalTokens == 0 || a.Usage.TotalTokens != a.Usage.PromptTokens+a.Usage.CompletionTokens {
+		t.Errorf("niepoprawne zużycie tokenów: %+v", a.Usage)
+	}
+}
+
+func TestFakeProviderStream(t *testing.T) {
+	p := NewFakeProvider()
+	req := LLMRequest{Task: "quiz", Prompt: "materiał"}
+	var sb strings.Builder
+	chunks := 0
+	resp, err := p.GenerateStream(context.Background(), req, func(s string) error {
+		sb.WriteString(s)
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != resp.Text || chunks < 2 {
+		t.Errorf("strumień (%d fragmentów) różni się od odpowiedzi", chunks)
+	}
+
+	stop := errors.New("stop")
+	if _, err := p.GenerateStream(context.Background(), req, func(string) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("błąd odbiorcy fragmentów: %v, oczekiwano %v", err, stop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.GenerateStream(ctx, req, func(string) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Errorf("anulowany kontekst: %v", err)
+	}
+}
+
+func TestFakeProviderRespond(t *testing.T) {
+	fail := errors.New("błąd modelu")
+	p := &FakeProvider{Respond: func(req LLMRequest) (string, error) {
+		if req.Task == "fail" {
+			return "", fail
+		}
+		return "odpowiedź: " + req.Prompt, nil
+	}}
+	resp, err := p.Generate(context.Background(), LLMRequest{Prompt: "x"})
+	if err != nil || resp.Text != "odpowiedź: x" {
+		t.Errorf("odpowiedź %v, błąd %v", resp, err)
+	}
+	if _, err := p.Generate(context.Background(), LLMRequest{Task: "fail"}); !errors.Is(err, fail) {
+		t.Errorf("błąd %v, oczekiwano %v", err, fail)
+	}
+}
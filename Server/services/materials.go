@@ -0,0 +1,219 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	"gorm.io/gorm"
+)
+
+// BlobStore przechowuje zawartość plików pod kluczem (u nas: SHA-256 zawartości)
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// LocalBlobStore zapisuje pliki w katalogu na dysku, rozkładając je na podkatalogi
+// według dwóch pierwszych znaków klucza (ab/abcdef...).
+type LocalBlobStore struct {
+	Root string
+}
+
+func (s *LocalBlobStore) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(s.Root, key)
+	}
+	return filepath.Join(s.Root, key[:2], key)
+}
+
+// Put zapisuje plik atomowo (przez plik tymczasowy). Istniejący plik o tym samym kluczu jest pomijany.
+func (s *LocalBlobStore) Put(key string, data []byte) error {
+	target := s.path(key)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *LocalBlobStore) Get(key string) ([]byte, error) {
+	return os.ReadFile(s.path(key))
+}
+
+// Delete usuwa plik; brak pliku nie jest błędem
+func (s *LocalBlobStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+var Materials *SourceMaterialStore
+
+var ErrMaterialTooLarge = errors.New("plik przekracza dopuszczalny rozmiar")
+
+// SourceMaterialStore zapisuje przesłane pliki w BlobStore i rejestruje je w tabeli source_materials
+type SourceMaterialStore struct {
+	Blobs    BlobStore
+	UserRepo *db.GormUserRepository
+	MaxSize  int64
+}
+
+func InitSourceMaterialStore(cfg config.Config) {
+	maxSize := cfg.SourceMaxFileSizeMB << 20
+	if maxSize <= 0 {
+		maxSize = 20 << 20
+	}
+	dir := cfg.StorageDir
+	if dir == "" {
+		dir = "./storage"
+	}
+
+	Materials = &SourceMaterialStore{
+		Blobs:    &LocalBlobStore{Root: filepath.Join(dir, "materials")},
+		UserRepo: db.UserRepository,
+		MaxSize:  maxSize,
+	}
+	log.Printf("Magazyn materiałów źródłowych: %s (limit %d MB)", dir, maxSize>>20)
+}
+
+// Save sprawdza rozmiar i format pliku, zapisuje jego zawartość i zwraca rekord materiału.
+// Plik o tej samej zawartości co wcześniej przesłany nie jest zapisywany ponownie, ale każdy przesyłający
+// dostaje własny rekord (z własną nazwą pliku) wskazujący na wspólną zawartość.
+func (s *SourceMaterialStore) Save(name string, data []byte, userUsosID string) (*models.SourceMaterial, error) {
+	if int64(len(data)) > s.MaxSize {
+		return nil, fmt.Errorf("%w (%s, limit %d MB)", ErrMaterialTooLarge, name, s.MaxSize>>20)
+	}
+	mimeType, err := DetectUploadMIMEType(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	if err := s.Blobs.Put(hash, data); err != nil {
+		return nil, fmt.Errorf("błąd zapisu pliku %s: %w", name, err)
+	}
+
+	m := &models.SourceMaterial{
+		SHA256:          hash,
+		Name:            name,
+		MIMEType:        mimeType,
+		Size:            int64(len(data)),
+		CreatedByUsosID: userUsosID,
+	}
+	if err := s.UserRepo.CreateSourceMaterial(m); err != nil {
+		return nil, err
+	}
+	// Tekst wyodrębniamy od razu, żeby tutor i ocena AI nie parsowały pliku przy każdym użyciu
+	if _, err := s.PageTexts(m, data); err != nil {
+		log.Printf("Nie udało się zapisać tekstu materiału %s: %v", name, err)
+	}
+	return m, nil
+}
+
+// PageTexts zwraca tekst stron materiału. Tekst jest wyodrębniany z pliku tylko raz (przy przesłaniu,
+// a dla materiałów sprzed wprowadzenia tej tabeli - przy pierwszym użyciu) i zapisywany w bazie.
+// data to zawartość pliku, jeśli jest już wczytana (nil - odczyt z magazynu).
+func (s *SourceMaterialStore) PageTexts(m *models.SourceMaterial, data []byte) ([]models.SourcePageText, error) {
+	t, err := s.UserRepo.GetSourceMaterialText(m.SHA256)
+	if err == nil {
+		return t.Pages, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if data == nil {
+		if data, err = s.Read(m); err != nil {
+			return nil, fmt.Errorf("błąd odczytu materiału %s: %w", m.Name, err)
+		}
+	}
+	doc, err := extractDocument(Attachment{Name: m.Name, MIMEType: m.MIMEType, Data: data}, false)
+	if err != nil {
+		return nil, err
+	}
+	t = &models.SourceMaterialText{SHA256: m.SHA256, Pages: []models.SourcePageText{}}
+	for _, p := range doc.Pages {
+		if p.Text != "" {
+			t.Pages = append(t.Pages, models.SourcePageText{Number: p.Number, Text: p.Text})
+		}
+	}
+	if err := s.UserRepo.SaveSourceMaterialText(t); err != nil {
+		return nil, err
+	}
+	return t.Pages, nil
+}
+
+// Discard usuwa materiały przesłane do zlecenia, które nie zostało przyjęte (np. z powodu limitu zadań).
+// Materiały, do których odwołuje się już zadanie lub treść, zostają; plik jest usuwany z magazynu,
+// gdy nie wskazuje na niego żaden inny materiał.
+func (s *SourceMaterialStore) Discard(ids []int64) {
+	hashes, err := s.UserRepo.DeleteUnreferencedSourceMaterials(ids)
+	if err != nil {
+		log.Printf("Nie udało się usunąć nieużytych materiałów %v: %v", ids, err)
+		return
+	}
+	for _, hash := range hashes {
+		if err := s.Blobs.Delete(hash); err != nil {
+			log.Printf("Nie udało się usunąć pliku materiału %s: %v", hash, err)
+		}
+	}
+}
+
+// Read zwraca zawartość materiału
+func (s *SourceMaterialStore) Read(m *models.SourceMaterial) ([]byte, error) {
+	return s.Blobs.Get(m.SHA256)
+}
+
+// LoadAttachments wczytuje materiały o podanych ID jako załączniki dla modelu, zachowując kolejność ids
+func (s *SourceMaterialStore) LoadAttachments(ids []int64) ([]Attachment, error) {
+	materials, err := s.UserRepo.GetSourceMaterials(ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]models.SourceMaterial, len(materials))
+	for _, m := range materials {
+		byID[int64(m.ID)] = m
+	}
+
+	attachments := make([]Attachment, 0, len(ids))
+	for _, id := range ids {
+		m, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("nie znaleziono materiału źródłowego %d", id)
+		}
+		data, err := s.Read(&m)
+		if err != nil {
+			return nil, fmt.Errorf("błąd odczytu materiału %s: %w", m.Name, err)
+		}
+		attachments = append(attachments, Attachment{Name: m.Name, MIMEType: m.MIMEType, Data: data})
+	}
+	return attachments, nil
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+	_ "golang.org/x/image/webp" // dekoder WebP dla image.Decode
+)
+
+// --- ZAŁĄCZNIKI MULTIMEDIALNE ---
+
+const (
+	// MaxAttachmentsPerItem to maksymalna liczba załączników jednej fiszki lub pytania
+	MaxAttachmentsPerItem = 8
+	// MaxMediaPixels ogranicza rozmiar obrazu po zdekodowaniu (ochrona przed "bombami" dekompresji)
+	MaxMediaPixels = 40_000_000
+	// MaxMediaAltTextChars to maksymalna długość tekstu alternatywnego
+	MaxMediaAltTextChars = 300
+)
+
+var (
+	ErrMediaTooLarge       = errors.New("plik przekracza dopuszczalny rozmiar")
+	ErrUnsupportedMedia    = errors.New("nieobsługiwany format pliku (dozwolone: PNG, JPEG, GIF, WebP)")
+	ErrTooManyAttachments  = fmt.Errorf("element może mieć najwyżej %d załączników", MaxAttachmentsPerItem)
+	ErrInvalidMediaContent = errors.New("plik jest uszkodzony lub obraz jest za duży")
+)
+
+// mediaTypes to dozwolone typy MIME rozpoznane po zawartości pliku i odpowiadające im formaty image.Decode
+var mediaTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpeg",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+var Media *MediaStore
+
+// MediaStore zapisuje załączniki fiszek i pytań w BlobStore i wystawia do nich podpisane adresy URL
+type MediaStore struct {
+	Blobs    BlobStore
+	UserRepo *db.GormUserRepository
+	MaxSize  int64
+	BaseURL  string // adres serwera dołączany do podpisanych adresów (pusty = adresy względne)
+	Secret   []byte
+	URLTTL   time.Duration
+}
+
+func InitMediaStore(cfg config.Config) {
+	maxSize := cfg.MediaMaxFileSizeMB << 20
+	if maxSize <= 0 {
+		maxSize = 5 << 20
+	}
+	dir := cfg.StorageDir
+	if dir == "" {
+		dir = "./storage"
+	}
+	ttl := time.Duration(cfg.MediaURLTTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	secret := cfg.MediaURLSecret
+	if secret == "" {
+		secret = cfg.SessionSecret
+	}
+	key := []byte(secret)
+	if secret == "" {
+		// Bez klucza w konfiguracji adresy tracą ważność po restarcie serwera
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Nie można wygenerować klucza podpisu adresów mediów: %v", err)
+		}
+		log.Println("OSTRZEŻENIE: brak MEDIA_URL_SECRET i SESSION_SECRET - użyto losowego klucza podpisu adresów mediów")
+	}
+
+	Media = &MediaStore{
+		Blobs:    &LocalBlobStore{Root: filepath.Join(dir, "media")},
+		UserRepo: db.UserRepository,
+		MaxSize:  maxSize,
+		BaseURL:  strings.TrimRight(cfg.AppBaseURL, "/"),
+		Secret:   key,
+		URLTTL:   ttl,
+	}
+	log.Printf("Magazyn załączników: %s (limit %d MB, adresy ważne %s)", filepath.Join(dir, "media"), maxSize>>20, ttl)
+}
+
+// Upload sprawdza typ i rozmiar obrazu, przekodowuje go (usuwając metadane, np. EXIF z lokalizacją)
+// i zapisuje jako kolejny załącznik elementu
+func (s *MediaStore) Upload(itemType string, itemID uint, name, altText string, data []byte, userUsosID string) (*models.MediaAttachment, error) {
+	if int64(len(data)) > s.MaxSize {
+		return nil, fmt.Errorf("%w (%s, limit %d MB)", ErrMediaTooLarge, name, s.MaxSize>>20)
+	}
+	if n, err := s.UserRepo.CountMediaAttachments(itemType, itemID); err != nil {
+		return nil, err
+	} else if n >= MaxAttachmentsPerItem {
+		return nil, ErrTooManyAttachments
+	}
+
+	clean, mimeType, width, height, err := reencodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := randomBlobKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Blobs.Put(key, clean); err != nil {
+		return nil, fmt.Errorf("błąd zapisu pliku %s: %w", name, err)
+	}
+
+	m := &models.MediaAttachment{
+		ItemType:         itemType,
+		ItemID:           itemID,
+		BlobKey:          key,
+		MIMEType:         mimeType,
+		Size:             int64(len(clean)),
+		Width:            width,
+		Height:           height,
+		OriginalName:     truncateRunes(filepath.Base(name), 255),
+		AltText:          truncateRunes(strings.TrimSpace(stripControlChars(altText)), MaxMediaAltTextChars),
+		UploadedByUsosID: userUsosID,
+	}
+	if err := s.UserRepo.CreateMediaAttachment(m); err != nil {
+		if derr := s.Blobs.Delete(key); derr != nil {
+			log.Printf("MediaStore: nie udało się usunąć pliku %s: %v", key, derr)
+		}
+		return nil, err
+	}
+	s.Sign(m)
+	return m, nil
+}
+
+// reencodeImage rozpoznaje typ obrazu po zawartości (nie po nazwie ani nagłówku klienta) i zapisuje go ponownie.
+// Zapisany plik zawiera wyłącznie piksele: PNG i JPEG zachowują format, GIF - wszystkie klatki, WebP staje się PNG.
+func reencodeImage(data []byte) (out []byte, mimeType string, width, height int, err error) {
+	sniffed := strings.SplitN(http.DetectContentType(data), ";", 2)[0]
+	format, ok := mediaTypes[sniffed]
+	if !ok {
+		return nil, "", 0, 0, fmt.Errorf("%w: %s", ErrUnsupportedMedia, sniffed)
+	}
+	cfg, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || decoded != format {
+		return nil, "", 0, 0, ErrInvalidMediaContent
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxMediaPixels {
+		return nil, "", 0, 0, ErrInvalidMediaContent
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "gif":
+		// Klatki liczymy przed dekodowaniem - gif.DecodeAll alokuje wszystkie naraz
+		frames, err := gifFrameCount(data)
+		if err != nil || frames == 0 || frames > MaxMediaPixels/(cfg.Width*cfg.Height) {
+			return nil, "", 0, 0, ErrInvalidMediaContent
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(g.Image) != frames {
+			return nil, "", 0, 0, ErrInvalidMediaContent
+		}
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			return nil, "", 0, 0, err
+		}
+		return buf.Bytes(), "image/gif", cfg.Width, cfg.Height, nil
+	case "jpeg":
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", 0, 0, ErrInvalidMediaContent
+		}
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, "", 0, 0, err
+		}
+		return buf.Bytes(), "image/jpeg", cfg.Width, cfg.Height, nil
+	default: // png, webp
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", 0, 0, ErrInvalidMediaContent
+		}
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := enc.Encode(&buf, img); err != nil {
+			return nil, "", 0, 0, err
+		}
+		return buf.Bytes(), "image/png", cfg.Width, cfg.Height, nil
+	}
+}
+
+// gifFrameCount liczy klatki GIF-a, przechodząc po blokach pliku bez dekompresji danych obrazu.
+// Każda klatka mieści się w obszarze logicznego ekranu (sprawdza to gif.DecodeAll).
+func gifFrameCount(data []byte) (int, error) {
+	errTruncated := errors.New("gif: uszkodzona struktura pliku")
+	// Nagłówek (6 B) i opis ekranu (7 B), opcjonalnie z globalną paletą
+	if len(data) < 13 {
+		return 0, errTruncated
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+	// skipSubBlocks przeskakuje ciąg podbloków zakończony blokiem o długości 0
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos++
+			if n == 0 {
+				return true
+			}
+			pos += n
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // rozszerzenie: etykieta i podbloki
+			pos += 2
+			if !skipSubBlocks() {
+				return 0, errTruncated
+			}
+		case 0x2C: // klatka: opis (9 B + separator), opcjonalna paleta, rozmiar kodu LZW i podbloki danych
+			if pos+10 > len(data) {
+				return 0, errTruncated
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			if !skipSubBlocks() {
+				return 0, errTruncated
+			}
+			frames++
+		case 0x3B: // koniec pliku
+			return frames, nil
+		default:
+			return 0, errTruncated
+		}
+	}
+	return 0, errTruncated
+}
+
+func randomBlobKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Read zwraca zawartość załącznika
+func (s *MediaStore) Read(m *models.MediaAttachment) ([]byte, error) {
+	return s.Blobs.Get(m.BlobKey)
+}
+
+// Delete usuwa załączniki i ich pliki
+func (s *MediaStore) Delete(attachments ...models.MediaAttachment) error {
+	ids := make([]uint, len(attachments))
+	for i, m := range attachments {
+		ids[i] = m.ID
+	}
+	if err := s.UserRepo.DeleteMediaAttachments(ids); err != nil {
+		return err
+	}
+	for _, m := range attachments {
+		if err := s.Blobs.Delete(m.BlobKey); err != nil {
+			log.Printf("MediaStore: nie udało się usunąć pliku załącznika %d: %v", m.ID, err)
+		}
+	}
+	return nil
+}
+
+// Sign wypełnia m.URL podpisanym adresem pliku. Termin ważności jest zaokrąglany do okna URLTTL,
+// więc w jego obrębie adres się nie zmienia i przeglądarka może korzystać z pamięci podręcznej.
+func (s *MediaStore) Sign(m *models.MediaAttachment) {
+	expires := time.Now().Truncate(s.URLTTL).Add(2 * s.URLTTL)
+	m.URL = fmt.Sprintf("%s/media/%d?expires=%d&sig=%s", s.BaseURL, m.ID, expires.Unix(), s.signature(m, expires.Unix()))
+	m.URLExpiresAt = &expires
+}
+
+// SignAll podpisuje adresy wszystkich załączników
+func (s *MediaStore) SignAll(attachments []models.MediaAttachment) {
+	for i := range attachments {
+		s.Sign(&attachments[i])
+	}
+}
+
+// Verify sprawdza podpis i termin ważności adresu załącznika
+func (s *MediaStore) Verify(m *models.MediaAttachment, expires, sig string) bool {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(s.signature(m, exp)))
+}
+
+func (s *MediaStore) signature(m *models.MediaAttachment, expires int64) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	fmt.Fprintf(mac, "%d:%s:%d", m.ID, m.BlobKey, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
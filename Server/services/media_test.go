@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 16), uint8(y * 16), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodeTestGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		p := image.NewPaletted(image.Rect(0, 0, 4, 3), palette.Plan9)
+		p.SetColorIndex(i%4, 0, uint8(i))
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReencodeImage(t *testing.T) {
+	var pngData, jpegData bytes.Buffer
+	if err := png.Encode(&pngData, testImage(5, 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegData, testImage(5, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	// Dane dopisane za końcem pliku (np. skrypt lub archiwum) nie mogą trafić do zapisanego obrazu
+	trailer := []byte("<script>alert(1)</script>")
+	pngWithTrailer := append(append([]byte(nil), pngData.Bytes()...), trailer...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		mimeType string
+		err      error
+	}{
+		{"PNG", pngData.Bytes(), "image/png", nil},
+		{"PNG z dopisanymi danymi", pngWithTrailer, "image/png", nil},
+		{"JPEG", jpegData.Bytes(), "image/jpeg", nil},
+		{"GIF", encodeTestGIF(t, 3), "image/gif", nil},
+		{"HTML", []byte("<html><script>alert(1)</script></html>"), "", ErrUnsupportedMedia},
+		{"SVG", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`), "", ErrUnsupportedMedia},
+		{"ucięty PNG", pngData.Bytes()[:20], "", ErrInvalidMediaContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, mimeType, w, h, err := reencodeImage(tt.data)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("oczekiwano %v, jest %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("nieoczekiwany błąd: %v", err)
+			}
+			if mimeType != tt.mimeType {
+				t.Errorf("typ %s, oczekiwano %s", mimeType, tt.mimeType)
+			}
+			if bytes.Contains(out, trailer) {
+				t.Error("zapisany obraz zawiera dane spoza obrazu")
+			}
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("zapisany obraz nie daje się odczytać: %v", err)
+			}
+			if cfg.Width != w || cfg.Height != h {
+				t.Errorf("rozmiar %dx%d, zwrócono %dx%d", cfg.Width, cfg.Height, w, h)
+			}
+		})
+	}
+}
+
+func TestReencodeImageKeepsGIFFrames(t *testing.T) {
+	out, _, _, _, err := reencodeImage(encodeTestGIF(t, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 4 {
+		t.Errorf("liczba klatek %d, oczekiwano 4", len(g.Image))
+	}
+}
+
+func TestGIFFrameCount(t *testing.T) {
+	valid := encodeTestGIF(t, 5)
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+		ok     bool
+	}{
+		{"jedna klatka", encodeTestGIF(t, 1), 1, true},
+		{"pięć klatek", valid, 5, true},
+		{"za krótki nagłówek", valid[:10], 0, false},
+		{"brak znacznika końca", valid[:len(valid)-1], 0, false},
+		{"ucięte dane klatki", valid[:len(valid)/2], 0, false},
+		{"nieznany blok", append(append([]byte(nil), valid[:len(valid)-1]...), 0x99), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := gifFrameCount(tt.data)
+			if (err == nil) != tt.ok {
+				t.Fatalf("błąd %v, oczekiwano ok=%v", err, tt.ok)
+			}
+			if frames != tt.frames {
+				t.Errorf("liczba klatek %d, oczekiwano %d", frames, tt.frames)
+			}
+		})
+	}
+}
@@ -0,0 +1,489 @@
+package services
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- TYPY PYTAŃ QUIZOWYCH ---
+
+const (
+	// MaxOrderingItems to maksymalna liczba kroków w pytaniu o kolejność
+	MaxOrderingItems = 10
+	// MaxClozeBlanks to maksymalna liczba luk w pytaniu z lukami
+	MaxClozeBlanks = 10
+	// MaxClozeAnswers to maksymalna liczba akceptowanych wariantów odpowiedzi jednej luki
+	MaxClozeAnswers     = 5
+	maxClozeAnswerChars = 200
+	maxUnitChars        = 20
+	maxAltUnits         = 5
+)
+
+// QuestionTypes to obsługiwane typy pytań z opisami używanymi w komunikatach i promptach
+var QuestionTypes = map[string]string{
+	models.QuestionTypeSingleChoice:   "jednokrotnego wyboru",
+	models.QuestionTypeMultipleChoice: "wielokrotnego wyboru",
+	models.QuestionTypeTrueFalse:      "prawda/fałsz",
+	models.QuestionTypeNumeric:        "z odpowiedzią liczbową",
+	models.QuestionTypeOrdering:       "ułożenie kroków w kolejności",
+	models.QuestionTypeCloze:          "uzupełnianie luk",
+	models.QuestionTypeOpen:           "otwarte",
+}
+
+// questionTypeOrder to kolejność typów w komunikatach i szablonach
+var questionTypeOrder = []string{
+	models.QuestionTypeSingleChoice, models.QuestionTypeMultipleChoice, models.QuestionTypeTrueFalse,
+	models.QuestionTypeNumeric, models.QuestionTypeOrdering, models.QuestionTypeCloze, models.QuestionTypeOpen,
+}
+
+// clozeMarker to luka w treści pytania: {{1}}, {{2}}...
+var clozeMarker = regexp.MustCompile(`\{\{\s*(\d+)\s*\}\}`)
+
+// HasOptions mówi, czy pytanie danego typu ma listę opcji (Options)
+func HasOptions(questionType string) bool {
+	switch questionType {
+	case models.QuestionTypeSingleChoice, models.QuestionTypeMultipleChoice, models.QuestionTypeOrdering:
+		return true
+	}
+	return false
+}
+
+// HasOptionExplanations mówi, czy opcje pytania mogą mieć wyjaśnienia (opcje poprawne i błędne)
+func HasOptionExplanations(questionType string) bool {
+	return questionType == models.QuestionTypeSingleChoice || questionType == models.QuestionTypeMultipleChoice
+}
+
+// IsCorrectOption mówi, czy opcja i jest poprawną odpowiedzią pytania wyboru
+func IsCorrectOption(q *models.QuizQuestion, i int) bool {
+	if q.QuestionType == models.QuestionTypeMultipleChoice {
+		return q.Payload != nil && containsInt(q.Payload.CorrectIndices, i)
+	}
+	return q.QuestionType == models.QuestionTypeSingleChoice && i == q.CorrectOptionIndex
+}
+
+func unknownQuestionTypeError(questionType string) error {
+	return fmt.Errorf("nieznany typ pytania %q (dozwolone: %s)", questionType, strings.Join(questionTypeOrder, ", "))
+}
+
+// normalizeOptions oczyszcza opcje odpowiedzi w formacie s.OptionsFormat i sprawdza ich liczbę
+func normalizeOptions(s models.RevisionSnapshot, out *models.RevisionSnapshot, minCount, maxCount int, unique bool) error {
+	var err error
+	if out.OptionsFormat, err = NormalizeContentFormat(s.OptionsFormat); err != nil {
+		return fmt.Errorf("opcje: %w", err)
+	}
+	seen := make(map[string]bool, len(s.Options))
+	for i, o := range s.Options {
+		if o, _, err = sanitizeField(fmt.Sprintf("opcja %d", i+1), o, out.OptionsFormat); err != nil {
+			return err
+		}
+		if o == "" {
+			return errors.New("opcje odpowiedzi nie mogą być puste")
+		}
+		if unique && seen[strings.ToLower(o)] {
+			return fmt.Errorf("opcja %q się powtarza", o)
+		}
+		seen[strings.ToLower(o)] = true
+		out.Options = append(out.Options, o)
+	}
+	if len(out.Options) < minCount || len(out.Options) > maxCount {
+		return fmt.Errorf("pytanie musi mieć od %d do %d opcji", minCount, maxCount)
+	}
+	return nil
+}
+
+// normalizeQuestionPayload sprawdza dane specyficzne dla typów z kluczem odpowiedzi w Payload
+func normalizeQuestionPayload(questionType string, s models.RevisionSnapshot, out *models.RevisionSnapshot) error {
+	p := s.Payload
+	if p == nil {
+		p = &models.QuestionPayload{}
+	}
+	switch questionType {
+	case models.QuestionTypeMultipleChoice:
+		if err := normalizeOptions(s, out, MinOptionCount, MaxOptionCount, true); err != nil {
+			return err
+		}
+		indices := append([]int(nil), p.CorrectIndices...)
+		sort.Ints(indices)
+		for i, idx := range indices {
+			if idx < 0 || idx >= len(out.Options) {
+				return fmt.Errorf("indeks poprawnej odpowiedzi %d poza zakresem opcji", idx)
+			}
+			if i > 0 && indices[i-1] == idx {
+				return fmt.Errorf("indeks poprawnej odpowiedzi %d się powtarza", idx)
+			}
+		}
+		if len(indices) == 0 {
+			return errors.New("pytanie wielokrotnego wyboru wymaga co najmniej jednej poprawnej odpowiedzi")
+		}
+		out.Payload = &models.QuestionPayload{CorrectIndices: indices}
+
+	case models.QuestionTypeTrueFalse:
+		if p.CorrectBool == nil {
+			return errors.New("pytanie prawda/fałsz wymaga poprawnej odpowiedzi (correct_bool)")
+		}
+		v := *p.CorrectBool
+		out.Payload = &models.QuestionPayload{CorrectBool: &v}
+
+	case models.QuestionTypeNumeric:
+		n := p.Numeric
+		if n == nil {
+			return errors.New("pytanie liczbowe wymaga poprawnej wartości (numeric.value)")
+		}
+		if math.IsNaN(n.Value) || math.IsInf(n.Value, 0) || math.IsNaN(n.Tolerance) || math.IsInf(n.Tolerance, 0) || n.Tolerance < 0 {
+			return errors.New("nieprawidłowa wartość lub tolerancja (tolerancja nie może być ujemna)")
+		}
+		clean := &models.NumericAnswer{Value: n.Value, Tolerance: n.Tolerance, Unit: strings.TrimSpace(n.Unit)}
+		if len([]rune(clean.Unit)) > maxUnitChars {
+			return fmt.Errorf("jednostka jest za długa (maksimum %d znaków)", maxUnitChars)
+		}
+		if len(n.AltUnits) > 0 && clean.Unit == "" {
+			return errors.New("jednostki alternatywne wymagają jednostki głównej")
+		}
+		if len(n.AltUnits) > maxAltUnits {
+			return fmt.Errorf("można podać najwyżej %d jednostek alternatywnych", maxAltUnits)
+		}
+		seen := map[string]bool{clean.Unit: true}
+		for _, u := range n.AltUnits {
+			u.Unit = strings.TrimSpace(u.Unit)
+			if u.Unit == "" || len([]rune(u.Unit)) > maxUnitChars || seen[u.Unit] {
+				return fmt.Errorf("nieprawidłowa lub powtórzona jednostka alternatywna %q", u.Unit)
+			}
+			if !(u.Factor > 0) || math.IsInf(u.Factor, 0) {
+				return fmt.Errorf("przelicznik jednostki %q musi być dodatni", u.Unit)
+			}
+			seen[u.Unit] = true
+			clean.AltUnits = append(clean.AltUnits, u)
+		}
+		out.Payload = &models.QuestionPayload{Numeric: clean}
+
+	case models.QuestionTypeCloze:
+		counts := make(map[int]int)
+		for _, m := range clozeMarker.FindAllStringSubmatch(out.Question, -1) {
+			n, _ := strconv.Atoi(m[1])
+			counts[n]++
+		}
+		if len(counts) == 0 {
+			return errors.New("pytanie z lukami wymaga luk oznaczonych {{1}}, {{2}}... w treści")
+		}
+		if len(counts) > MaxClozeBlanks {
+			return fmt.Errorf("pytanie może mieć najwyżej %d luk", MaxClozeBlanks)
+		}
+		for n := 1; n <= len(counts); n++ {
+			if counts[n] != 1 {
+				return fmt.Errorf("luki muszą być ponumerowane kolejno od 1, każda użyta raz (problem z {{%d}})", n)
+			}
+		}
+		if len(p.Blanks) != len(counts) {
+			return fmt.Errorf("liczba luk w treści (%d) różni się od liczby odpowiedzi (%d)", len(counts), len(p.Blanks))
+		}
+		blanks := make([]models.ClozeBlank, len(p.Blanks))
+		for i, b := range p.Blanks {
+			blanks[i].CaseSensitive = b.CaseSensitive
+			for _, a := range b.Answers {
+				a = strings.Join(strings.Fields(stripControlChars(a)), " ")
+				if a == "" || len([]rune(a)) > maxClozeAnswerChars {
+					return fmt.Errorf("luka %d: odpowiedź musi mieć od 1 do %d znaków", i+1, maxClozeAnswerChars)
+				}
+				if !containsString(blanks[i].Answers, a) {
+					blanks[i].Answers = append(blanks[i].Answers, a)
+				}
+			}
+			if len(blanks[i].Answers) == 0 || len(blanks[i].Answers) > MaxClozeAnswers {
+				return fmt.Errorf("luka %d musi mieć od 1 do %d akceptowanych odpowiedzi", i+1, MaxClozeAnswers)
+			}
+		}
+		out.Payload = &models.QuestionPayload{Blanks: blanks}
+	}
+	return nil
+}
+
+// --- OCENA ODPOWIEDZI ---
+
+// ErrInvalidAnswer oznacza odpowiedź niepasującą do typu pytania (np. indeks spoza zakresu opcji)
+var ErrInvalidAnswer = errors.New("nieprawidłowa odpowiedź")
+
+// ScoreQuizResponse ocenia odpowiedź na pytanie typu multiple_choice, true_false, numeric, ordering lub cloze.
+// Zwraca znormalizowaną odpowiedź do zapisu, wynik i maksymalny wynik:
+//   - multiple_choice: punkt za każdą poprawną zaznaczoną opcję minus punkt za każdą błędną (nie mniej niż 0)
+//   - ordering: punkt za każdy krok na właściwym miejscu
+//   - cloze: punkt za każdą poprawnie uzupełnioną lukę
+//   - true_false, numeric: 1 albo 0
+func ScoreQuizResponse(q *models.QuizQuestion, r models.AnswerResponse) (*models.AnswerResponse, int, int, error) {
+	p := q.Payload
+	if p == nil && q.QuestionType != models.QuestionTypeOrdering {
+		return nil, 0, 0, fmt.Errorf("pytanie %d nie ma klucza odpowiedzi", q.ID)
+	}
+	switch q.QuestionType {
+	case models.QuestionTypeMultipleChoice:
+		selected := append([]int(nil), r.SelectedOptions...)
+		sort.Ints(selected)
+		for i, idx := range selected {
+			if idx < 0 || idx >= len(q.Options) || (i > 0 && selected[i-1] == idx) {
+				return nil, 0, 0, fmt.Errorf("%w: zaznacz różne opcje z zakresu 0-%d", ErrInvalidAnswer, len(q.Options)-1)
+			}
+		}
+		score := 0
+		for _, idx := range selected {
+			if containsInt(p.CorrectIndices, idx) {
+				score++
+			} else {
+				score--
+			}
+		}
+		return &models.AnswerResponse{SelectedOptions: selected}, max(score, 0), len(p.CorrectIndices), nil
+
+	case models.QuestionTypeTrueFalse:
+		if r.Bool == nil {
+			return nil, 0, 0, fmt.Errorf("%w: podaj answer_bool (true lub false)", ErrInvalidAnswer)
+		}
+		return &models.AnswerResponse{Bool: r.Bool}, boolScore(*r.Bool == *p.CorrectBool), 1, nil
+
+	case models.QuestionTypeNumeric:
+		if r.Value == nil || math.IsNaN(*r.Value) || math.IsInf(*r.Value, 0) {
+			return nil, 0, 0, fmt.Errorf("%w: podaj wartość liczbową (value)", ErrInvalidAnswer)
+		}
+		n := p.Numeric
+		unit := strings.TrimSpace(r.Unit)
+		factor, ok := 1.0, unit == "" || unit == n.Unit
+		for _, u := range n.AltUnits {
+			if unit == u.Unit {
+				factor, ok = u.Factor, true
+			}
+		}
+		if !ok {
+			return nil, 0, 0, fmt.Errorf("%w: nieznana jednostka %q", ErrInvalidAnswer, unit)
+		}
+		// Margines na błędy zaokrągleń przy przeliczaniu jednostek
+		diff := math.Abs(*r.Value*factor - n.Value)
+		correct := diff <= n.Tolerance+1e-9*math.Max(1, math.Abs(n.Value))
+		return &models.AnswerResponse{Value: r.Value, Unit: unit}, boolScore(correct), 1, nil
+
+	case models.QuestionTypeOrdering:
+		perm := OrderingPermutation(q)
+		if len(r.Order) != len(perm) {
+			return nil, 0, 0, fmt.Errorf("%w: podaj kolejność wszystkich %d kroków", ErrInvalidAnswer, len(perm))
+		}
+		seen := make(map[int]bool, len(perm))
+		score := 0
+		for pos, shown := range r.Order {
+			if shown < 0 || shown >= len(perm) || seen[shown] {
+				return nil, 0, 0, fmt.Errorf("%w: kolejność musi zawierać każdy krok (0-%d) dokładnie raz", ErrInvalidAnswer, len(perm)-1)
+			}
+			seen[shown] = true
+			if perm[shown] == pos {
+				score++
+			}
+		}
+		return &models.AnswerResponse{Order: r.Order}, score, len(perm), nil
+
+	case models.QuestionTypeCloze:
+		if len(r.Blanks) != len(p.Blanks) {
+			return nil, 0, 0, fmt.Errorf("%w: uzupełnij wszystkie %d luki", ErrInvalidAnswer, len(p.Blanks))
+		}
+		blanks := make([]string, len(r.Blanks))
+		score := 0
+		for i, b := range r.Blanks {
+			blanks[i] = truncateRunes(strings.Join(strings.Fields(b), " "), maxClozeAnswerChars)
+			for _, a := range p.Blanks[i].Answers {
+				if blanks[i] == a || (!p.Blanks[i].CaseSensitive && strings.EqualFold(blanks[i], a)) {
+					score++
+					break
+				}
+			}
+		}
+		return &models.AnswerResponse{Blanks: blanks}, score, len(p.Blanks), nil
+	}
+	return nil, 0, 0, fmt.Errorf("%w: pytania typu %s nie ocenia się w ten sposób", ErrInvalidAnswer, q.QuestionType)
+}
+
+func boolScore(ok bool) int {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+// orderingKey to klucz HMAC, z którego wyprowadzane jest tasowanie kroków pytań o kolejność
+var orderingKey []byte
+
+// InitQuestionTypes wyprowadza klucz tasowania pytań o kolejność z SESSION_SECRET
+func InitQuestionTypes(cfg config.Config) {
+	if cfg.SessionSecret == "" {
+		// Bez klucza w konfiguracji tasowanie zmienia się po restarcie serwera
+		orderingKey = make([]byte, 32)
+		if _, err := crand.Read(orderingKey); err != nil {
+			log.Fatalf("Nie można wygenerować klucza tasowania pytań: %v", err)
+		}
+		log.Println("OSTRZEŻENIE: brak SESSION_SECRET - użyto losowego klucza tasowania pytań o kolejność")
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.SessionSecret))
+	mac.Write([]byte("ordering-permutation"))
+	orderingKey = mac.Sum(nil)
+}
+
+// OrderingPermutation zwraca kolejność, w jakiej uczniowie widzą kroki pytania o kolejność:
+// perm[i] to indeks (w Options) kroku pokazanego na pozycji i. Tasowanie wyprowadzamy z HMAC (klucz serwera)
+// po ID i liczbie kroków: jest takie samo przy wyświetlaniu i przy sprawdzaniu odpowiedzi,
+// a bez klucza nie da się go odtworzyć z publicznego ID pytania.
+func OrderingPermutation(q *models.QuizQuestion) []int {
+	mac := hmac.New(sha256.New, orderingKey)
+	var msg [16]byte
+	binary.BigEndian.PutUint64(msg[:8], uint64(q.ID))
+	binary.BigEndian.PutUint64(msg[8:], uint64(len(q.Options)))
+	mac.Write(msg[:])
+	seed := int64(binary.BigEndian.Uint64(mac.Sum(nil)[:8]))
+	perm := rand.New(rand.NewSource(seed)).Perm(len(q.Options))
+	identity := true
+	for i, v := range perm {
+		identity = identity && i == v
+	}
+	if identity && len(perm) > 1 {
+		perm = append(perm[1:], perm[0])
+	}
+	return perm
+}
+
+// ShuffleForStudent ukrywa kolejność kroków pytania o kolejność, układając opcje według OrderingPermutation
+func ShuffleForStudent(q *models.QuizQuestion) {
+	if q.QuestionType != models.QuestionTypeOrdering {
+		return
+	}
+	perm := OrderingPermutation(q)
+	shown := make([]string, len(perm))
+	for i, idx := range perm {
+		shown[i] = q.Options[idx]
+	}
+	q.Options = shown
+}
+
+// --- OPIS KLUCZA ODPOWIEDZI ---
+
+// AnswerKeyText opisuje poprawną odpowiedź pytania tekstem (dla promptów oceny wstępnej i tutora)
+func AnswerKeyText(q *models.QuizQuestion) string {
+	p := q.Payload
+	switch q.QuestionType {
+	case models.QuestionTypeSingleChoice:
+		if q.CorrectOptionIndex >= 0 && q.CorrectOptionIndex < len(q.Options) {
+			return "Poprawna odpowiedź: " + q.Options[q.CorrectOptionIndex]
+		}
+	case models.QuestionTypeMultipleChoice:
+		var correct []string
+		for i, o := range q.Options {
+			if IsCorrectOption(q, i) {
+				correct = append(correct, o)
+			}
+		}
+		return "Poprawne odpowiedzi: " + strings.Join(correct, " | ")
+	case models.QuestionTypeTrueFalse:
+		if p != nil && p.CorrectBool != nil {
+			if *p.CorrectBool {
+				return "Poprawna odpowiedź: prawda"
+			}
+			return "Poprawna odpowiedź: fałsz"
+		}
+	case models.QuestionTypeNumeric:
+		if p != nil && p.Numeric != nil {
+			n := p.Numeric
+			text := strings.TrimSpace(fmt.Sprintf("Poprawna odpowiedź: %s %s", formatNumber(n.Value), n.Unit))
+			if n.Tolerance > 0 {
+				text += fmt.Sprintf(" (±%s)", formatNumber(n.Tolerance))
+			}
+			return text
+		}
+	case models.QuestionTypeOrdering:
+		steps := make([]string, len(q.Options))
+		for i, o := range q.Options {
+			steps[i] = fmt.Sprintf("%d. %s", i+1, o)
+		}
+		return "Poprawna kolejność: " + strings.Join(steps, "; ")
+	case models.QuestionTypeCloze:
+		if p != nil {
+			blanks := make([]string, len(p.Blanks))
+			for i, b := range p.Blanks {
+				blanks[i] = fmt.Sprintf("{{%d}} = %s", i+1, strings.Join(b.Answers, " / "))
+			}
+			return "Uzupełnienie luk: " + strings.Join(blanks, "; ")
+		}
+	case models.QuestionTypeOpen:
+		return "Odpowiedź wzorcowa: " + q.ReferenceAnswer
+	}
+	return ""
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// payloadText zapisuje klucz odpowiedzi jako tekst (do historii zmian)
+func payloadText(p *models.QuestionPayload) string {
+	if p == nil {
+		return ""
+	}
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// payloadColumn zwraca wartość kolumny payload dla aktualizacji mapą (która pomija serializer pola)
+func payloadColumn(p *models.QuestionPayload) interface{} {
+	if p == nil {
+		return nil
+	}
+	return payloadText(p)
+}
+
+// GeneratedQuizSnapshot zamienia pytanie wygenerowane przez model na typ i stan pytania (przed NormalizeQuizSnapshot)
+func GeneratedQuizSnapshot(g models.GeneratedQuizQuestion) (string, models.RevisionSnapshot) {
+	questionType := g.Type
+	if questionType == "" {
+		questionType = models.QuestionTypeSingleChoice
+	}
+	s := models.RevisionSnapshot{
+		Question:        g.Question,
+		Options:         g.Options,
+		CorrectIndex:    g.CorrectIndex,
+		ReferenceAnswer: g.ReferenceAnswer,
+		Rubric:          g.Rubric,
+	}
+	switch questionType {
+	case models.QuestionTypeMultipleChoice:
+		s.Payload = &models.QuestionPayload{CorrectIndices: g.CorrectIndices}
+	case models.QuestionTypeTrueFalse:
+		s.Payload = &models.QuestionPayload{CorrectBool: g.Correct}
+	case models.QuestionTypeNumeric:
+		if g.Value != nil {
+			s.Payload = &models.QuestionPayload{Numeric: &models.NumericAnswer{Value: *g.Value, Tolerance: g.Tolerance, Unit: g.Unit}}
+		}
+	case models.QuestionTypeCloze:
+		blanks := make([]models.ClozeBlank, len(g.Blanks))
+		for i, answers := range g.Blanks {
+			blanks[i] = models.ClozeBlank{Answers: answers}
+		}
+		s.Payload = &models.QuestionPayload{Blanks: blanks}
+	}
+	return questionType, s
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+func boolPtr(b bool) *bool        { return &b }
+func floatPtr(f float64) *float64 { return &f }
+
+func TestScoreQuizResponse(t *testing.T) {
+	InitQuestionTypes(config.Config{SessionSecret: "test"})
+
+	multiple := &models.QuizQuestion{
+		ID: 1, QuestionType: models.QuestionTypeMultipleChoice,
+		Options: []string{"A", "B", "C", "D"},
+		Payload: &models.QuestionPayload{CorrectIndices: []int{0, 2}},
+	}
+	trueFalse := &models.QuizQuestion{
+		ID: 2, QuestionType: models.QuestionTypeTrueFalse,
+		Payload: &models.QuestionPayload{CorrectBool: boolPtr(true)},
+	}
+	numeric := &models.QuizQuestion{
+		ID: 3, QuestionType: models.QuestionTypeNumeric,
+		Payload: &models.QuestionPayload{Numeric: &models.NumericAnswer{
+			Value: 1500, Tolerance: 10, Unit: "m",
+			AltUnits: []models.UnitConversion{{Unit: "km", Factor: 1000}},
+		}},
+	}
+	cloze := &models.QuizQuestion{
+		ID: 4, QuestionType: models.QuestionTypeCloze,
+		Payload: &models.QuestionPayload{Blanks: []models.ClozeBlank{
+			{Answers: []string{"Warszawa"}},
+			{Answers: []string{"NaCl"}, CaseSensitive: true},
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		q          *models.QuizQuestion
+		r          models.AnswerResponse
+		score, max int
+		invalid    bool
+	}{
+		{"wielokrotny: wszystkie poprawne", multiple, models.AnswerResponse{SelectedOptions: []int{2, 0}}, 2, 2, false},
+		{"wielokrotny: poprawna i błędna", multiple, models.AnswerResponse{SelectedOptions: []int{0, 1}}, 0, 2, false},
+		{"wielokrotny: wynik nie spada poniżej zera", multiple, models.AnswerResponse{SelectedOptions: []int{1, 3}}, 0, 2, false},
+		{"wielokrotny: jedna poprawna", multiple, models.AnswerResponse{SelectedOptions: []int{2}}, 1, 2, false},
+		{"wielokrotny: powtórzona opcja", multiple, models.AnswerResponse{SelectedOptions: []int{0, 0}}, 0, 0, true},
+		{"wielokrotny: opcja spoza zakresu", multiple, models.AnswerResponse{SelectedOptions: []int{4}}, 0, 0, true},
+		{"prawda/fałsz: poprawna", trueFalse, models.AnswerResponse{Bool: boolPtr(true)}, 1, 1, false},
+		{"prawda/fałsz: błędna", trueFalse, models.AnswerResponse{Bool: boolPtr(false)}, 0, 1, false},
+		{"prawda/fałsz: brak odpowiedzi", trueFalse, models.AnswerResponse{}, 0, 0, true},
+		{"liczbowe: w tolerancji", numeric, models.AnswerResponse{Value: floatPtr(1505)}, 1, 1, false},
+		{"liczbowe: poza tolerancją", numeric, models.AnswerResponse{Value: floatPtr(1520)}, 0, 1, false},
+		{"liczbowe: jednostka alternatywna", numeric, models.AnswerResponse{Value: floatPtr(1.5), Unit: "km"}, 1, 1, false},
+		{"liczbowe: nieznana jednostka", numeric, models.AnswerResponse{Value: floatPtr(1.5), Unit: "mila"}, 0, 0, true},
+		{"liczbowe: brak wartości", numeric, models.AnswerResponse{}, 0, 0, true},
+		{"luki: bez wielkości liter", cloze, models.AnswerResponse{Blanks: []string{"  warszawa ", "NaCl"}}, 2, 2, false},
+		{"luki: z wielkością liter", cloze, models.AnswerResponse{Blanks: []string{"Warszawa", "nacl"}}, 1, 2, false},
+		{"luki: za mało odpowiedzi", cloze, models.AnswerResponse{Blanks: []string{"Warszawa"}}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, score, max, err := ScoreQuizResponse(tt.q, tt.r)
+			if tt.invalid {
+				if !errors.Is(err, ErrInvalidAnswer) {
+					t.Fatalf("oczekiwano ErrInvalidAnswer, jest %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("nieoczekiwany błąd: %v", err)
+			}
+			if score != tt.score || max != tt.max {
+				t.Errorf("wynik %d/%d, oczekiwano %d/%d", score, max, tt.score, tt.max)
+			}
+		})
+	}
+}
+
+func TestScoreQuizResponseOrdering(t *testing.T) {
+	InitQuestionTypes(config.Config{SessionSecret: "test"})
+	q := &models.QuizQuestion{ID: 7, QuestionType: models.QuestionTypeOrdering, Options: []string{"1", "2", "3", "4"}}
+	perm := OrderingPermutation(q)
+
+	// Poprawna odpowiedź: na pozycji perm[shown] stoi krok pokazany jako shown
+	correct := make([]int, len(perm))
+	for shown, pos := range perm {
+		correct[pos] = shown
+	}
+	if _, score, max, err := ScoreQuizResponse(q, models.AnswerResponse{Order: correct}); err != nil || score != 4 || max != 4 {
+		t.Errorf("poprawna kolejność: wynik %d/%d, błąd %v", score, max, err)
+	}
+
+	invalid := [][]int{{0, 1, 2}, {0, 1, 2, 2}, {0, 1, 2, 4}, {-1, 0, 1, 2}}
+	for _, order := range invalid {
+		if _, _, _, err := ScoreQuizResponse(q, models.AnswerResponse{Order: order}); !errors.Is(err, ErrInvalidAnswer) {
+			t.Errorf("kolejność %v: oczekiwano ErrInvalidAnswer, jest %v", order, err)
+		}
+	}
+}
+
+func TestOrderingPermutation(t *testing.T) {
+	InitQuestionTypes(config.Config{SessionSecret: "test"})
+	for id := uint(1); id <= 50; id++ {
+		for n := 1; n <= 8; n++ {
+			q := &models.QuizQuestion{ID: id, Options: make([]string, n)}
+			perm := OrderingPermutation(q)
+
+			if again := OrderingPermutation(q); !slices.Equal(perm, again) {
+				t.Fatalf("id %d, n %d: permutacja nie jest deterministyczna (%v, %v)", id, n, perm, again)
+			}
+			sorted := append([]int(nil), perm...)
+			sort.Ints(sorted)
+			identity := true
+			for i := range sorted {
+				if sorted[i] != i {
+					t.Fatalf("id %d, n %d: %v nie jest permutacją", id, n, perm)
+				}
+				identity = identity && perm[i] == i
+			}
+			if identity && n > 1 {
+				t.Errorf("id %d, n %d: permutacja identycznościowa", id, n)
+			}
+		}
+	}
+}
+
+func TestOrderingPermutationDependsOnKey(t *testing.T) {
+	q := &models.QuizQuestion{ID: 3, Options: make([]string, 8)}
+	InitQuestionTypes(config.Config{SessionSecret: "a"})
+	a := OrderingPermutation(q)
+	InitQuestionTypes(config.Config{SessionSecret: "b"})
+	b := OrderingPermutation(q)
+	if slices.Equal(a, b) {
+		t.Errorf("permutacja nie zależy od klucza: %v", a)
+	}
+}
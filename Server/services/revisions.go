@@ -0,0 +1,342 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- EDYCJA TREŚCI I HISTORIA ZMIAN ---
+
+// MaxRevisionReasonChars to maksymalna długość powodu zmiany
+const MaxRevisionReasonChars = 500
+
+var (
+	// ErrNoRevisionChanges oznacza edycję, która niczego nie zmienia
+	ErrNoRevisionChanges = errors.New("brak zmian do zapisania")
+	// ErrRevisionConflict oznacza, że element zmienił się od wersji, którą edytował użytkownik
+	ErrRevisionConflict = errors.New("element został zmieniony w międzyczasie")
+)
+
+// FlashcardSnapshot zwraca edytowalne pola fiszki
+func FlashcardSnapshot(fc *models.Flashcard) models.RevisionSnapshot {
+	return models.RevisionSnapshot{
+		Question:       fc.Question,
+		Answer:         fc.Answer,
+		QuestionFormat: fc.QuestionFormat,
+		AnswerFormat:   fc.AnswerFormat,
+	}
+}
+
+// QuizQuestionSnapshot zwraca edytowalne pola pytania quizowego
+func QuizQuestionSnapshot(q *models.QuizQuestion) models.RevisionSnapshot {
+	return models.RevisionSnapshot{
+		Question:        q.QuestionText,
+		Options:         q.Options,
+		CorrectIndex:    q.CorrectOptionIndex,
+		ReferenceAnswer: q.ReferenceAnswer,
+		Rubric:          q.Rubric,
+
+		QuestionFormat:        q.QuestionFormat,
+		OptionsFormat:         q.OptionsFormat,
+		ReferenceAnswerFormat: q.ReferenceAnswerFormat,
+		Payload:               q.Payload,
+	}
+}
+
+// sanitizeField oczyszcza pole zgodnie z jego formatem (SanitizeContent), dodając nazwę pola do błędu
+func sanitizeField(name, s string, f models.FieldFormat) (string, models.FieldFormat, error) {
+	out, f, err := SanitizeContent(s, f)
+	if err != nil {
+		return "", f, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, f, nil
+}
+
+// NormalizeFlashcardSnapshot oczyszcza pola fiszki zgodnie z ich formatem i sprawdza, czy nie są puste
+func NormalizeFlashcardSnapshot(s models.RevisionSnapshot) (models.RevisionSnapshot, error) {
+	var out models.RevisionSnapshot
+	var err error
+	if out.Question, out.QuestionFormat, err = sanitizeField("pytanie", s.Question, s.QuestionFormat); err != nil {
+		return out, err
+	}
+	if out.Answer, out.AnswerFormat, err = sanitizeField("odpowiedź", s.Answer, s.AnswerFormat); err != nil {
+		return out, err
+	}
+	if out.Question == "" || out.Answer == "" {
+		return out, errors.New("pytanie i odpowiedź fiszki nie mogą być puste")
+	}
+	return out, nil
+}
+
+// NormalizeQuizSnapshot oczyszcza pola pytania zgodnie z ich formatem i sprawdza je zgodnie z typem pytania:
+// pytania wyboru wymagają opcji i poprawnych odpowiedzi, pytanie o kolejność - kroków w poprawnej kolejności,
+// pozostałe typy - klucza odpowiedzi w Payload, a otwarte - odpowiedzi wzorcowej i kryteriów oceny
+func NormalizeQuizSnapshot(questionType string, s models.RevisionSnapshot) (models.RevisionSnapshot, error) {
+	var out models.RevisionSnapshot
+	var err error
+	if _, ok := QuestionTypes[questionType]; !ok {
+		return out, unknownQuestionTypeError(questionType)
+	}
+	if out.Question, out.QuestionFormat, err = sanitizeField("pytanie", s.Question, s.QuestionFormat); err != nil {
+		return out, err
+	}
+	if out.Question == "" {
+		return out, errors.New("treść pytania nie może być pusta")
+	}
+	switch questionType {
+	case models.QuestionTypeSingleChoice:
+		if err := normalizeOptions(s, &out, MinOptionCount, MaxOptionCount, false); err != nil {
+			return out, err
+		}
+		if s.CorrectIndex < 0 || s.CorrectIndex >= len(out.Options) {
+			return out, errors.New("nieprawidłowy indeks poprawnej odpowiedzi")
+		}
+		out.CorrectIndex = s.CorrectIndex
+	case models.QuestionTypeOrdering:
+		// Opcje są zapisane w poprawnej kolejności - uczniowie widzą je przetasowane (OrderingPermutation)
+		if err := normalizeOptions(s, &out, 2, MaxOrderingItems, true); err != nil {
+			return out, err
+		}
+	case models.QuestionTypeOpen:
+		out.ReferenceAnswer, out.ReferenceAnswerFormat, err = sanitizeField("odpowiedź wzorcowa", s.ReferenceAnswer, s.ReferenceAnswerFormat)
+		if err != nil {
+			return out, err
+		}
+		if out.ReferenceAnswer == "" {
+			return out, errors.New("pytanie otwarte wymaga odpowiedzi wzorcowej")
+		}
+		if len([]rune(out.ReferenceAnswer)) > MaxReferenceAnswerChars {
+			return out, fmt.Errorf("odpowiedź wzorcowa jest za długa (maksimum %d znaków)", MaxReferenceAnswerChars)
+		}
+		rubric, err := NormalizeRubric(s.Rubric)
+		if err != nil {
+			return out, err
+		}
+		out.Rubric = rubric
+	default:
+		if err := normalizeQuestionPayload(questionType, s, &out); err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// ApplyQuizSnapshot przepisuje pola ze stanu do pytania
+func ApplyQuizSnapshot(q *models.QuizQuestion, s models.RevisionSnapshot) {
+	q.QuestionText = s.Question
+	q.Options = pq.StringArray(s.Options)
+	q.CorrectOptionIndex = s.CorrectIndex
+	q.ReferenceAnswer = s.ReferenceAnswer
+	q.Rubric = s.Rubric
+	q.QuestionFormat = s.QuestionFormat
+	q.OptionsFormat = s.OptionsFormat
+	q.ReferenceAnswerFormat = s.ReferenceAnswerFormat
+	q.Payload = s.Payload
+}
+
+// ApplyFlashcardSnapshot przepisuje pola ze stanu do fiszki
+func ApplyFlashcardSnapshot(fc *models.Flashcard, s models.RevisionSnapshot) {
+	fc.Question = s.Question
+	fc.Answer = s.Answer
+	fc.QuestionFormat = s.QuestionFormat
+	fc.AnswerFormat = s.AnswerFormat
+}
+
+func rubricText(rubric []models.RubricPoint) string {
+	lines := make([]string, len(rubric))
+	for i, p := range rubric {
+		lines[i] = fmt.Sprintf("%s (%d pkt)", p.Text, p.Points)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatText opisuje format pola w historii zmian ("code:python"); pusty format to zwykły tekst
+func formatText(f models.FieldFormat) string {
+	if f.Format == "" {
+		return models.ContentFormatPlain
+	}
+	if f.Language != "" {
+		return f.Format + ":" + f.Language
+	}
+	return f.Format
+}
+
+// formatUpdates zwraca kolumny formatu pola z przedrostkiem prefix (np. "question_")
+func formatUpdates(updates map[string]interface{}, prefix string, f models.FieldFormat) {
+	f, _ = NormalizeContentFormat(f)
+	updates[prefix+"format"] = f.Format
+	updates[prefix+"language"] = f.Language
+}
+
+// DiffSnapshots zwraca zmienione pola (listy jako tekst - po jednym elemencie w wierszu)
+func DiffSnapshots(before, after models.RevisionSnapshot) []models.RevisionChange {
+	var changes []models.RevisionChange
+	add := func(field, old, new string) {
+		if old != new {
+			changes = append(changes, models.RevisionChange{Field: field, Old: old, New: new})
+		}
+	}
+	add("question", before.Question, after.Question)
+	add("answer", before.Answer, after.Answer)
+	add("options", strings.Join(before.Options, "\n"), strings.Join(after.Options, "\n"))
+	if len(before.Options) > 0 || len(after.Options) > 0 {
+		add("correct_index", strconv.Itoa(before.CorrectIndex), strconv.Itoa(after.CorrectIndex))
+	}
+	add("reference_answer", before.ReferenceAnswer, after.ReferenceAnswer)
+	add("rubric", rubricText(before.Rubric), rubricText(after.Rubric))
+	add("payload", payloadText(before.Payload), payloadText(after.Payload))
+	add("question_format", formatText(before.QuestionFormat), formatText(after.QuestionFormat))
+	if before.Answer != "" || after.Answer != "" {
+		add("answer_format", formatText(before.AnswerFormat), formatText(after.AnswerFormat))
+	}
+	if len(before.Options) > 0 || len(after.Options) > 0 {
+		add("options_format", formatText(before.OptionsFormat), formatText(after.OptionsFormat))
+	}
+	if before.ReferenceAnswer != "" || after.ReferenceAnswer != "" {
+		add("reference_answer_format", formatText(before.ReferenceAnswerFormat), formatText(after.ReferenceAnswerFormat))
+	}
+	return changes
+}
+
+// SnapshotAtVersion odtwarza stan elementu w podanej wersji na podstawie historii zmian
+func SnapshotAtVersion(revs []models.ContentRevision, version int) (models.RevisionSnapshot, bool) {
+	for _, r := range revs {
+		if r.Version == version {
+			return withDefaultFormats(r.After), true
+		}
+		if r.Version-1 == version {
+			return withDefaultFormats(r.Before), true
+		}
+	}
+	return models.RevisionSnapshot{}, false
+}
+
+// withDefaultFormats uzupełnia formaty brakujące we wpisach historii sprzed wprowadzenia formatów
+func withDefaultFormats(s models.RevisionSnapshot) models.RevisionSnapshot {
+	for _, f := range []*models.FieldFormat{&s.QuestionFormat, &s.AnswerFormat, &s.OptionsFormat, &s.ReferenceAnswerFormat} {
+		if f.Format == "" {
+			f.Format = models.ContentFormatPlain
+		}
+	}
+	return s
+}
+
+// ContentEdit opisuje jedną zmianę elementu
+type ContentEdit struct {
+	EditorUsosID        string
+	Reason              string
+	ExpectedVersion     int
+	RevertedFromVersion *int
+}
+
+func (e ContentEdit) revision(itemType string, before, after models.RevisionSnapshot) (*models.ContentRevision, error) {
+	changes := DiffSnapshots(before, after)
+	if len(changes) == 0 {
+		return nil, ErrNoRevisionChanges
+	}
+	return &models.ContentRevision{
+		ItemType:            itemType,
+		EditorUsosID:        e.EditorUsosID,
+		Reason:              truncateRunes(strings.TrimSpace(e.Reason), MaxRevisionReasonChars),
+		Changes:             changes,
+		Before:              before,
+		After:               after,
+		RevertedFromVersion: e.RevertedFromVersion,
+	}, nil
+}
+
+// MergeRevision tworzy wpis historii celu scalenia, który przejął sformułowanie duplikatu
+// (nil, gdy treść celu się nie zmieniła)
+func MergeRevision(itemType string, before, after models.RevisionSnapshot, editorUsosID string, duplicateID uint) *models.ContentRevision {
+	e := ContentEdit{EditorUsosID: editorUsosID, Reason: fmt.Sprintf("Scalenie z duplikatem %d - zachowano jego sformułowanie", duplicateID)}
+	rev, err := e.revision(itemType, before, after)
+	if err != nil {
+		return nil
+	}
+	return rev
+}
+
+// screeningResetUpdates zeruje wstępną ocenę AI - zmieniona treść oczekująca na moderację jest oceniana od nowa
+var screeningResetUpdates = map[string]interface{}{
+	"screening_score":      nil,
+	"screening_reasons":    nil,
+	"screening_attempts":   0,
+	"screening_claimed_at": nil,
+}
+
+func saveContentEdit(model interface{}, id uint, pending bool, updates map[string]interface{}, e ContentEdit, rev *models.ContentRevision) error {
+	if pending {
+		for k, v := range screeningResetUpdates {
+			updates[k] = v
+		}
+	}
+	ok, err := db.UserRepository.SaveContentEdit(model, id, e.ExpectedVersion, updates, rev)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrRevisionConflict
+	}
+	if pending {
+		NotifyScreening()
+	}
+	NotifySearchIndex()
+	return nil
+}
+
+// EditFlashcard zapisuje nowy stan fiszki wraz z wpisem historii
+func EditFlashcard(fc *models.Flashcard, after models.RevisionSnapshot, e ContentEdit) (*models.ContentRevision, error) {
+	rev, err := e.revision(models.SearchItemFlashcard, FlashcardSnapshot(fc), after)
+	if err != nil {
+		return nil, err
+	}
+	updates := map[string]interface{}{"question": after.Question, "answer": after.Answer}
+	formatUpdates(updates, "question_", after.QuestionFormat)
+	formatUpdates(updates, "answer_", after.AnswerFormat)
+	if err := saveContentEdit(&models.Flashcard{}, fc.ID, fc.Status == "pending", updates, e, rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// EditQuizQuestion zapisuje nowy stan pytania wraz z wpisem historii. Zmiana opcji lub poprawnej odpowiedzi
+// usuwa wyjaśnienia opcji, które przestały do nich pasować.
+func EditQuizQuestion(q *models.QuizQuestion, after models.RevisionSnapshot, e ContentEdit) (*models.ContentRevision, error) {
+	before := QuizQuestionSnapshot(q)
+	rev, err := e.revision(models.SearchItemQuizQuestion, before, after)
+	if err != nil {
+		return nil, err
+	}
+	// Aktualizacja mapą pomija serializer pola, więc kryteria kodujemy do JSON ręcznie
+	rubric, err := json.Marshal(after.Rubric)
+	if err != nil {
+		return nil, err
+	}
+	updates := map[string]interface{}{
+		"question_text":        after.Question,
+		"options":              pq.StringArray(after.Options),
+		"correct_option_index": after.CorrectIndex,
+		"reference_answer":     after.ReferenceAnswer,
+		"rubric":               string(rubric),
+		"payload":              payloadColumn(after.Payload),
+	}
+	formatUpdates(updates, "question_", after.QuestionFormat)
+	formatUpdates(updates, "options_", after.OptionsFormat)
+	formatUpdates(updates, "reference_answer_", after.ReferenceAnswerFormat)
+	if strings.Join(before.Options, "\n") != strings.Join(after.Options, "\n") || before.CorrectIndex != after.CorrectIndex ||
+		payloadText(before.Payload) != payloadText(after.Payload) {
+		updates["option_explanations"] = nil
+		updates["explanation_status"] = ""
+	}
+	if err := saveContentEdit(&models.QuizQuestion{}, q.ID, q.Status == "pending", updates, e, rev); err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+func TestDiffSnapshotsUnchanged(t *testing.T) {
+	quiz := models.RevisionSnapshot{Question: "Pytanie", Options: []string{"A", "B"}}
+	if changes := DiffSnapshots(quiz, quiz); changes != nil {
+		t.Errorf("identyczne wersje: %+v", changes)
+	}
+
+	// Wpisy historii sprzed wprowadzenia formatów mają pusty format - to to samo co zwykły tekst
+	legacy := models.RevisionSnapshot{Question: "Pytanie"}
+	current := models.RevisionSnapshot{Question: "Pytanie", QuestionFormat: models.FieldFormat{Format: models.ContentFormatPlain}}
+	if changes := DiffSnapshots(legacy, current); changes != nil {
+		t.Errorf("pusty format a plain: %+v", changes)
+	}
+}
+
+func TestDiffSnapshotsFlashcard(t *testing.T) {
+	before := models.RevisionSnapshot{Question: "Stare", Answer: "a"}
+	after := models.RevisionSnapshot{Question: "Nowe", Answer: "b", AnswerFormat: models.FieldFormat{Format: models.ContentFormatMarkdown}}
+	want := []models.RevisionChange{
+		{Field: "question", Old: "Stare", New: "Nowe"},
+		{Field: "answer", Old: "a", New: "b"},
+		{Field: "answer_format", Old: "plain", New: "markdown"},
+	}
+	if got := DiffSnapshots(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("zmiany %+v, oczekiwano %+v", got, want)
+	}
+}
+
+func TestDiffSnapshotsQuizQuestion(t *testing.T) {
+	before := models.RevisionSnapshot{
+		Question: "P", Options: []string{"A", "B"}, CorrectIndex: 0,
+		Rubric:  []models.RubricPoint{{Text: "a", Points: 1}},
+		Payload: &models.QuestionPayload{CorrectIndices: []int{0}},
+	}
+	after := models.RevisionSnapshot{
+		Question: "P", Options: []string{"A", "C"}, CorrectIndex: 1,
+		Rubric:         []models.RubricPoint{{Text: "a", Points: 2}},
+		Payload:        &models.QuestionPayload{CorrectIndices: []int{0, 1}},
+		QuestionFormat: models.FieldFormat{Format: models.ContentFormatCode, Language: "go"},
+	}
+	got := DiffSnapshots(before, after)
+	want := map[string][2]string{
+		"options":         {"A\nB", "A\nC"},
+		"correct_index":   {"0", "1"},
+		"rubric":          {"a (1 pkt)", "a (2 pkt)"},
+		"payload":         {`{"correct_indices":[0]}`, `{"correct_indices":[0,1]}`},
+		"question_format": {"plain", "code:go"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("zmiany %+v, oczekiwano pól %v", got, want)
+	}
+	for _, c := range got {
+		if w, ok := want[c.Field]; !ok || w != [2]string{c.Old, c.New} {
+			t.Errorf("pole %s: %q -> %q, oczekiwano %q", c.Field, c.Old, c.New, w)
+		}
+	}
+}
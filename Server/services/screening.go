@@ -0,0 +1,394 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- WSTĘPNA OCENA TREŚCI PRZEZ AI ---
+
+var Screening *ScreeningWorker
+
+const (
+	screeningBatchSize    = 10
+	screeningMaxAttempts  = 3
+	screeningPollInterval = 30 * time.Second
+	screeningTimeout      = time.Minute
+	// screeningClaimLease to czas, po którym element przejęty przez worker, który go nie ocenił
+	// (np. zatrzymany serwer), może przejąć inny worker
+	screeningClaimLease = 30 * time.Minute
+	// screeningSourceChars ogranicza ilość tekstu źródłowego dołączanego do jednej oceny
+	screeningSourceChars = 8000
+)
+
+// ScreeningWorker w tle ocenia oczekujące fiszki, pytania i podsumowania, zanim trafią do moderatora
+type ScreeningWorker struct {
+	UserRepo *db.GormUserRepository
+	wake     chan struct{}
+}
+
+func InitScreeningWorker(cfg config.Config) {
+	if !cfg.ScreeningEnabled {
+		log.Println("Wstępna ocena treści przez AI jest wyłączona (SCREENING_ENABLED=false).")
+		return
+	}
+	w := &ScreeningWorker{
+		UserRepo: db.UserRepository,
+		wake:     make(chan struct{}, 1),
+	}
+	go w.run()
+	Screening = w
+	log.Println("Wstępna ocena treści przez AI uruchomiona.")
+}
+
+// NotifyScreening budzi workera oceny po dodaniu nowych treści (bez efektu, gdy ocena jest wyłączona)
+func NotifyScreening() {
+	if Screening == nil {
+		return
+	}
+	select {
+	case Screening.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *ScreeningWorker) run() {
+	for {
+		if w.screenBatch() > 0 {
+			continue
+		}
+		select {
+		case <-w.wake:
+		case <-time.After(screeningPollInterval):
+		}
+	}
+}
+
+// screenBatch ocenia jedną partię oczekujących elementów i zwraca liczbę przejętych elementów
+func (w *ScreeningWorker) screenBatch() int {
+	sources := &screeningSources{docs: make(map[int64]*screeningDocument)}
+	thresholds := make(map[uint]*int)
+
+	flashcards, err := w.UserRepo.ClaimUnscreenedFlashcards(screeningBatchSize, screeningMaxAttempts, screeningClaimLease)
+	if err != nil {
+		log.Printf("Ocena AI: błąd pobierania fiszek: %v", err)
+	}
+	for _, fc := range flashcards {
+		source := sources.context(fc.SourceMaterialIDs, fc.SourceFile, fc.SourcePages)
+		prompt := buildScreeningPrompt(screeningFlashcard, source,
+			fmt.Sprintf("Pytanie: %s\nOdpowiedź: %s", fc.Question, fc.Answer))
+		verdict, err := requestScreening(prompt, screeningFlashcard, fc.TopicID)
+		if err != nil {
+			log.Printf("Ocena AI: fiszka %d: %v", fc.ID, err)
+			w.release(&models.Flashcard{}, fc.ID)
+			continue
+		}
+		w.save(&models.Flashcard{}, fc.ID, fc.TopicID, verdict, thresholds)
+	}
+
+	questions, err := w.UserRepo.ClaimUnscreenedQuizQuestions(screeningBatchSize, screeningMaxAttempts, screeningClaimLease)
+	if err != nil {
+		log.Printf("Ocena AI: błąd pobierania pytań: %v", err)
+	}
+	for _, q := range questions {
+		var sb strings.Builder
+		// Tylko pytania wyboru mają dystraktory - pozostałe oceniamy jak fiszki (pytanie + klucz odpowiedzi)
+		choice := HasOptionExplanations(q.QuestionType)
+		switch {
+		case choice:
+			fmt.Fprintf(&sb, "Pytanie: %s\nOpcje:\n", q.QuestionText)
+			for i, opt := range q.Options {
+				marker := ""
+				if IsCorrectOption(&q, i) {
+					marker = " (poprawna)"
+				}
+				fmt.Fprintf(&sb, "%d. %s%s\n", i+1, opt, marker)
+			}
+		case q.QuestionType != models.QuestionTypeOpen:
+			fmt.Fprintf(&sb, "Pytanie (%s): %s\n%s\n", QuestionTypes[q.QuestionType], q.QuestionText, AnswerKeyText(&q))
+		default:
+			fmt.Fprintf(&sb, "Pytanie otwarte: %s\nOdpowiedź wzorcowa: %s\nKryteria oceny:\n", q.QuestionText, q.ReferenceAnswer)
+			for i, p := range q.Rubric {
+				fmt.Fprintf(&sb, "%d. %s (%d pkt)\n", i+1, p.Text, p.Points)
+			}
+		}
+		kind := screeningFlashcard
+		if choice {
+			kind = screeningChoiceQuestion
+		}
+		source := sources.context(q.SourceMaterialIDs, q.SourceFile, q.SourcePages)
+		verdict, err := requestScreening(buildScreeningPrompt(kind, source, sb.String()), kind, q.TopicID)
+		if err != nil {
+			log.Printf("Ocena AI: pytanie %d: %v", q.ID, err)
+			w.release(&models.QuizQuestion{}, q.ID)
+			continue
+		}
+		w.save(&models.QuizQuestion{}, q.ID, q.TopicID, verdict, thresholds)
+	}
+
+	notes, err := w.UserRepo.ClaimUnscreenedTopicNotes(screeningBatchSize, screeningMaxAttempts, screeningClaimLease)
+	if err != nil {
+		log.Printf("Ocena AI: błąd pobierania podsumowań: %v", err)
+	}
+	for _, n := range notes {
+		source := sources.context(n.SourceMaterialIDs, n.SourceFile, n.SourcePages)
+		verdict, err := requestScreening(buildScreeningPrompt(screeningNote, source, n.Body), screeningNote, n.TopicID)
+		if err != nil {
+			log.Printf("Ocena AI: podsumowanie %d: %v", n.ID, err)
+			w.release(&models.TopicNote{}, n.ID)
+			continue
+		}
+		w.save(&models.TopicNote{}, n.ID, n.TopicID, verdict, thresholds)
+	}
+
+	return len(flashcards) + len(questions) + len(notes)
+}
+
+// release zwalnia przejęcie elementu po nieudanej ocenie, żeby kolejna próba odbyła się w następnej partii
+func (w *ScreeningWorker) release(model interface{}, id uint) {
+	if err := w.UserRepo.ReleaseScreeningClaim(model, id); err != nil {
+		log.Printf("Ocena AI: błąd zwolnienia elementu %d: %v", id, err)
+	}
+}
+
+// save zapisuje ocenę i odrzuca element, jeśli przedmiot ma ustawiony próg, a ocena jest poniżej niego
+func (w *ScreeningWorker) save(model interface{}, id, topicID uint, v *screeningVerdict, thresholds map[uint]*int) {
+	threshold, ok := thresholds[topicID]
+	if !ok {
+		var err error
+		threshold, err = w.UserRepo.GetAutoRejectThresholdForTopic(topicID)
+		if err != nil {
+			log.Printf("Ocena AI: błąd odczytu progu dla tematu %d: %v", topicID, err)
+		}
+		thresholds[topicID] = threshold
+	}
+
+	score := v.score()
+	reasons := v.describe()
+	reject := threshold != nil && score < *threshold
+	if reject {
+		reasons = append(reasons, fmt.Sprintf("Automatycznie odrzucono: ocena %d poniżej progu przedmiotu (%d)", score, *threshold))
+	}
+
+	if err := w.UserRepo.SaveScreening(model, id, score, reasons, reject); err != nil {
+		log.Printf("Ocena AI: błąd zapisu oceny elementu %d: %v", id, err)
+	}
+}
+
+// screeningKind to rodzaj ocenianego elementu - decyduje o kryteriach oceny
+type screeningKind int
+
+const (
+	screeningFlashcard      screeningKind = iota // fiszka lub pytanie bez opcji do wyboru
+	screeningChoiceQuestion                      // pytanie wyboru - oceniamy też dystraktory
+	screeningNote                                // podsumowanie tematu - nie ma odpowiedzi, którą mogłoby zdradzić
+)
+
+// screeningVerdict to odpowiedź modelu: oceny 0-5 dla kryteriów oraz uzasadnienie
+type screeningVerdict struct {
+	Factual     int      `json:"factual"`
+	Clarity     int      `json:"clarity"`
+	Leakage     *int     `json:"leakage"`
+	Distractors *int     `json:"distractors"`
+	Reasons     []string `json:"reasons"`
+}
+
+func screeningSchema(kind screeningKind) *jsonSchema {
+	criterion := func() *jsonSchema {
+		return &jsonSchema{Type: "integer", Minimum: minimum(0), Maximum: maximum(5)}
+	}
+	s := &jsonSchema{
+		Type:                 "object",
+		Required:             []string{"factual", "clarity", "reasons"},
+		AdditionalProperties: true,
+		Properties: map[string]*jsonSchema{
+			"factual": criterion(),
+			"clarity": criterion(),
+			"reasons": {Type: "array", Items: &jsonSchema{Type: "string"}},
+		},
+	}
+	if kind != screeningNote {
+		s.Required = append(s.Required, "leakage")
+		s.Properties["leakage"] = criterion()
+	}
+	if kind == screeningChoiceQuestion {
+		s.Required = append(s.Required, "distractors")
+		s.Properties["distractors"] = criterion()
+	}
+	return s
+}
+
+// score przelicza średnią ocen kryteriów (0-5) na skalę 0-100
+func (v *screeningVerdict) score() int {
+	sum, n := v.Factual+v.Clarity, 2
+	for _, c := range []*int{v.Leakage, v.Distractors} {
+		if c != nil {
+			sum += *c
+			n++
+		}
+	}
+	return int(math.Round(float64(sum) / float64(n) * 20))
+}
+
+func (v *screeningVerdict) describe() []string {
+	out := []string{
+		fmt.Sprintf("Zgodność ze źródłem: %d/5", v.Factual),
+		fmt.Sprintf("Jasność: %d/5", v.Clarity),
+	}
+	if v.Leakage != nil {
+		out = append(out, fmt.Sprintf("Pytanie nie zdradza odpowiedzi: %d/5", *v.Leakage))
+	}
+	if v.Distractors != nil {
+		out = append(out, fmt.Sprintf("Jakość błędnych odpowiedzi: %d/5", *v.Distractors))
+	}
+	for _, r := range v.Reasons {
+		if r = strings.TrimSpace(r); r != "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func buildScreeningPrompt(kind screeningKind, source, item string) string {
+	var sb strings.Builder
+	name := "fiszkę"
+	switch kind {
+	case screeningChoiceQuestion:
+		name = "pytanie quizowe"
+	case screeningNote:
+		name = "podsumowanie tematu"
+	}
+	fmt.Fprintf(&sb, "Jesteś recenzentem materiałów do nauki. Oceń poniższe %s wygenerowane przez AI w skali 0-5 dla każdego kryterium:\n", name)
+	sb.WriteString("- \"factual\": zgodność z materiałem źródłowym (gdy go brak - poprawność merytoryczna),\n")
+	sb.WriteString("- \"clarity\": jasność i jednoznaczność sformułowania,\n")
+	switch kind {
+	case screeningFlashcard:
+		sb.WriteString("- \"leakage\": czy pytanie NIE zdradza odpowiedzi (5 - wcale nie zdradza, 0 - odpowiedź jest w pytaniu),\n")
+		sb.WriteString("\nZwróć TYLKO obiekt JSON: {\"factual\": 0-5, \"clarity\": 0-5, \"leakage\": 0-5, \"reasons\": [\"krótkie uzasadnienie\"]}\n\n")
+	case screeningChoiceQuestion:
+		sb.WriteString("- \"leakage\": czy pytanie NIE zdradza odpowiedzi (5 - wcale nie zdradza, 0 - odpowiedź jest w pytaniu),\n")
+		sb.WriteString("- \"distractors\": jakość błędnych odpowiedzi (wiarygodne, ale jednoznacznie niepoprawne),\n")
+		sb.WriteString("\nZwróć TYLKO obiekt JSON: {\"factual\": 0-5, \"clarity\": 0-5, \"leakage\": 0-5, \"distractors\": 0-5, \"reasons\": [\"krótkie uzasadnienie\"]}\n\n")
+	case screeningNote:
+		sb.WriteString("\nZwróć TYLKO obiekt JSON: {\"factual\": 0-5, \"clarity\": 0-5, \"reasons\": [\"krótkie uzasadnienie\"]}\n\n")
+	}
+
+	if source != "" {
+		sb.WriteString("Materiał źródłowy:\n" + source + "\n\n")
+	} else {
+		sb.WriteString("Materiał źródłowy: brak.\n\n")
+	}
+	sb.WriteString("Oceniany element:\n" + item)
+	return sb.String()
+}
+
+// requestScreening wysyła zapytanie o ocenę i waliduje odpowiedź (z jedną ponowną próbą przy błędnym JSON)
+func requestScreening(prompt string, kind screeningKind, topicID uint) (*screeningVerdict, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), screeningTimeout)
+	defer cancel()
+	// Ocena jest zadaniem systemowym - zużycie nie obciąża limitu autora treści
+	ctx = WithAIUsageOwner(ctx, AIUsageOwner{TopicID: topicID})
+
+	req := LLMRequest{Task: "screening", Prompt: prompt, JSON: true}
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := LLM.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := extractJSONObject(resp.Text)
+		if err == nil {
+			if problems := validateJSON(screeningSchema(kind), raw); len(problems) > 0 {
+				err = fmt.Errorf("niepoprawna ocena: %s", strings.Join(problems, "; "))
+			}
+		}
+		if err != nil {
+			lastErr = err
+			req.Prompt = buildRegeneratePrompt(prompt, resp.Text, err)
+			continue
+		}
+
+		var v screeningVerdict
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		if kind == screeningNote {
+			v.Leakage = nil
+		}
+		if kind != screeningChoiceQuestion {
+			v.Distractors = nil
+		}
+		return &v, nil
+	}
+	return nil, lastErr
+}
+
+// screeningSources buforuje tekst materiałów źródłowych w obrębie jednej partii ocen
+type screeningSources struct {
+	docs map[int64]*screeningDocument
+}
+
+type screeningDocument struct {
+	name  string
+	pages []models.SourcePageText
+}
+
+func (s *screeningSources) document(id int64) *screeningDocument {
+	if doc, ok := s.docs[id]; ok {
+		return doc
+	}
+	var doc *screeningDocument
+	m, err := db.UserRepository.GetSourceMaterial(uint(id))
+	if err == nil {
+		var pages []models.SourcePageText
+		if pages, err = Materials.PageTexts(m, nil); err == nil {
+			doc = &screeningDocument{name: m.Name, pages: pages}
+		}
+	}
+	if err != nil {
+		log.Printf("Ocena AI: nie udało się odczytać materiału %d: %v", id, err)
+	}
+	s.docs[id] = doc
+	return doc
+}
+
+// context zwraca tekst stron, na które powołuje się element (lub początek materiałów, gdy strony nie są znane)
+func (s *screeningSources) context(materialIDs []int64, file string, pages []int64) string {
+	if Materials == nil {
+		return ""
+	}
+	wanted := make(map[int]bool, len(pages))
+	for _, p := range pages {
+		wanted[int(p)] = true
+	}
+
+	var sb strings.Builder
+	for _, id := range materialIDs {
+		doc := s.document(id)
+		if doc == nil {
+			continue
+		}
+		filterPages := len(wanted) > 0 && (file == "" || file == doc.name)
+		for _, p := range doc.pages {
+			if filterPages && !wanted[p.Number] {
+				continue
+			}
+			block := fmt.Sprintf("--- Plik: %s, strona %d ---\n%s\n\n", doc.name, p.Number, p.Text)
+			if sb.Len()+len(block) > screeningSourceChars {
+				return sb.String()
+			}
+			sb.WriteString(block)
+		}
+	}
+	return sb.String()
+}
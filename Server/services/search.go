@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- WYSZUKIWANIE SEMANTYCZNE ---
+
+var Search *SearchIndex
+
+const (
+	searchIndexBatchSize    = 32
+	searchIndexPollInterval = time.Minute
+	searchEmbedTimeout      = time.Minute
+	// searchMaxTextChars ogranicza długość tekstu przekazywanego do modelu embeddingów (długie notatki)
+	searchMaxTextChars = 8000
+)
+
+type searchEntry struct {
+	itemType  string
+	itemID    uint
+	topicID   uint
+	subjectID uint
+	vector    []float32
+}
+
+// SearchHit to jeden wynik wyszukiwania (treść do wczytania z bazy po ItemType i ItemID)
+type SearchHit struct {
+	ItemType  string
+	ItemID    uint
+	TopicID   uint
+	SubjectID uint
+	Score     float64
+}
+
+// SearchIndex trzyma w pamięci wektory zatwierdzonych treści (kopia tabeli content_embeddings
+// dla bieżącego modelu) i w tle liczy wektory nowych lub zmienionych treści.
+type SearchIndex struct {
+	UserRepo *db.GormUserRepository
+	Embedder Embedder
+
+	mu      sync.RWMutex
+	entries map[string]*searchEntry
+	wake    chan struct{}
+}
+
+func searchKey(itemType string, itemID uint) string {
+	return itemType + ":" + strconv.FormatUint(uint64(itemID), 10)
+}
+
+func InitSearchIndex(cfg config.Config) {
+	idx := &SearchIndex{
+		UserRepo: db.UserRepository,
+		Embedder: NewEmbedder(cfg),
+		entries:  make(map[string]*searchEntry),
+		wake:     make(chan struct{}, 1),
+	}
+	if err := idx.reload(); err != nil {
+		log.Printf("Wyszukiwanie: błąd wczytywania indeksu: %v", err)
+	}
+	go idx.run()
+	Search = idx
+	log.Printf("Indeks wyszukiwania uruchomiony (model: %s, wpisów: %d).", idx.Embedder.EmbeddingModel(), idx.size())
+}
+
+// NotifySearchIndex budzi indeksowanie po zatwierdzeniu, odrzuceniu lub edycji treści
+func NotifySearchIndex() {
+	if Search == nil {
+		return
+	}
+	select {
+	case Search.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (idx *SearchIndex) size() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.entries)
+}
+
+// reload wczytuje z bazy wszystkie wektory bieżącego modelu
+func (idx *SearchIndex) reload() error {
+	rows, err := idx.UserRepo.GetContentEmbeddings(idx.Embedder.EmbeddingModel())
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]*searchEntry, len(rows))
+	for _, r := range rows {
+		if len(r.Vector) == 0 {
+			continue
+		}
+		entries[searchKey(r.ItemType, r.ItemID)] = &searchEntry{
+			itemType: r.ItemType, itemID: r.ItemID, topicID: r.TopicID, subjectID: r.SubjectID, vector: r.Vector,
+		}
+	}
+	idx.mu.Lock()
+	idx.entries = entries
+	idx.mu.Unlock()
+	return nil
+}
+
+func (idx *SearchIndex) run() {
+	for {
+		if idx.indexBatch() > 0 {
+			continue
+		}
+		select {
+		case <-idx.wake:
+		case <-time.After(searchIndexPollInterval):
+		}
+	}
+}
+
+// indexBatch usuwa wektory treści, które przestały być zatwierdzone, i liczy wektory jednej partii
+// nowych lub zmienionych treści. Zwraca liczbę zaindeksowanych treści.
+func (idx *SearchIndex) indexBatch() int {
+	removed, err := idx.UserRepo.DeleteStaleContentEmbeddings()
+	if err != nil {
+		log.Printf("Wyszukiwanie: błąd usuwania nieaktualnych wpisów: %v", err)
+	} else if removed > 0 {
+		if err := idx.reload(); err != nil {
+			log.Printf("Wyszukiwanie: błąd wczytywania indeksu: %v", err)
+		}
+	}
+
+	model := idx.Embedder.EmbeddingModel()
+	candidates, err := idx.UserRepo.GetStaleEmbeddingCandidates(model, searchIndexBatchSize)
+	if err != nil {
+		log.Printf("Wyszukiwanie: błąd pobierania treści do indeksowania: %v", err)
+		return 0
+	}
+	if len(candidates) == 0 {
+		return 0
+	}
+
+	texts := make([]string, len(candidates))
+	for i, c := range candidates {
+		texts[i] = truncateRunes(c.Text, searchMaxTextChars)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), searchEmbedTimeout)
+	defer cancel()
+	vectors, err := idx.Embedder.Embed(ctx, texts, false)
+	if err != nil {
+		// Jedna wadliwa treść nie może blokować całej partii - liczymy wektory pojedynczo
+		log.Printf("Wyszukiwanie: błąd liczenia wektorów partii, próba pojedynczo: %v", err)
+		vectors = make([][]float32, len(texts))
+		for i, text := range texts {
+			if ctx.Err() != nil {
+				return 0
+			}
+			v, err := idx.Embedder.Embed(ctx, []string{text}, false)
+			if err != nil {
+				log.Printf("Wyszukiwanie: pominięto %s %d: %v", candidates[i].ItemType, candidates[i].ItemID, err)
+				continue
+			}
+			vectors[i] = v[0]
+		}
+	}
+
+	for i, c := range candidates {
+		// Treść bez wektora zapisujemy z pustym wektorem, aby nie wracała w kolejnych partiach,
+		// dopóki nie zmieni się jej tekst
+		sum := md5.Sum([]byte(c.Text))
+		row := &models.ContentEmbedding{
+			ItemType:    c.ItemType,
+			ItemID:      c.ItemID,
+			TopicID:     c.TopicID,
+			SubjectID:   c.SubjectID,
+			Model:       model,
+			ContentHash: hex.EncodeToString(sum[:]),
+			Vector:      vectors[i],
+		}
+		if err := idx.UserRepo.UpsertContentEmbedding(row); err != nil {
+			log.Printf("Wyszukiwanie: błąd zapisu wektora %s %d: %v", c.ItemType, c.ItemID, err)
+			return 0
+		}
+		idx.mu.Lock()
+		if vectors[i] == nil {
+			delete(idx.entries, searchKey(c.ItemType, c.ItemID))
+		} else {
+			idx.entries[searchKey(c.ItemType, c.ItemID)] = &searchEntry{
+				itemType: c.ItemType, itemID: c.ItemID, topicID: c.TopicID, subjectID: c.SubjectID, vector: vectors[i],
+			}
+		}
+		idx.mu.Unlock()
+	}
+	return len(candidates)
+}
+
+// UsesAI mówi, czy wyszukiwanie korzysta z płatnego modelu embeddingów (wlicza się do limitów AI)
+func (idx *SearchIndex) UsesAI() bool {
+	_, ok := idx.Embedder.(*MeteredEmbedder)
+	return ok
+}
+
+// Query zwraca do limit treści najbardziej podobnych do zapytania. subjectIDs ogranicza wyniki
+// do podanych przedmiotów (nil = wszystkie przedmioty).
+func (idx *SearchIndex) Query(ctx context.Context, query string, subjectIDs []uint, limit int) ([]SearchHit, error) {
+	vectors, err := idx.Embedder.Embed(ctx, []string{query}, true)
+	if err != nil {
+		return nil, err
+	}
+	q := vectors[0]
+
+	var allowed map[uint]bool
+	if subjectIDs != nil {
+		allowed = make(map[uint]bool, len(subjectIDs))
+		for _, id := range subjectIDs {
+			allowed[id] = true
+		}
+	}
+
+	idx.mu.RLock()
+	hits := make([]SearchHit, 0, 64)
+	for _, e := range idx.entries {
+		if allowed != nil && !allowed[e.subjectID] {
+			continue
+		}
+		score := dotProduct(q, e.vector)
+		if score <= 0 {
+			continue
+		}
+		hits = append(hits, SearchHit{ItemType: e.itemType, ItemID: e.itemID, TopicID: e.topicID, SubjectID: e.subjectID, Score: score})
+	}
+	idx.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+func truncateRunes(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// DuplicateThreshold to minimalne podobieństwo, od którego element jest oznaczany jako prawdopodobny duplikat
+const DuplicateThreshold = 0.65
+
+// stemLength - słowa są skracane do tylu znaków, co w przybliżeniu usuwa polską odmianę
+// ("struktura", "strukturą", "struktury" -> "struk")
+const stemLength = 5
+
+var polishFold = strings.NewReplacer(
+	"ą", "a", "ć", "c", "ę", "e", "ł", "l", "ń", "n", "ó", "o", "ś", "s", "ź", "z", "ż", "z",
+)
+
+// similarityStopwords to częste słowa bez znaczenia dla treści (po usunięciu polskich znaków)
+var similarityStopwords = map[string]bool{
+	"a": true, "aby": true, "albo": true, "ale": true, "az": true, "bez": true, "by": true, "byc": true,
+	"co": true, "czy": true, "czym": true, "dla": true, "do": true, "gdy": true, "i": true, "ich": true,
+	"jak": true, "jaka": true, "jaki": true, "jakie": true, "jest": true, "jego": true, "jej": true,
+	"ktora": true, "ktore": true, "ktory": true, "lub": true, "ma": true, "na": true, "nie": true,
+	"o": true, "od": true, "oraz": true, "po": true, "pod": true, "przez": true, "przy": true, "sa": true,
+	"sie": true, "ta": true, "tak": true, "te": true, "ten": true, "to": true, "tym": true, "typu": true,
+	"w": true, "we": true, "z": true, "za": true, "ze": true,
+	"the": true, "of": true, "is": true, "an": true, "and": true, "or": true, "in": true,
+}
+
+// similarityTokenList zwraca znormalizowane rdzenie słów treściowych w kolejności występowania:
+// małe litery, bez polskich znaków, interpunkcji i słów pomijalnych
+func similarityTokenList(text string) []string {
+	text = polishFold.Replace(strings.ToLower(text))
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		if similarityStopwords[w] {
+			continue
+		}
+		if r := []rune(w); len(r) > stemLength {
+			w = string(r[:stemLength])
+		}
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
+
+// similarityTokens zwraca zbiór rdzeni słów tekstu (patrz similarityTokenList)
+func similarityTokens(text string) map[string]struct{} {
+	tokens := similarityTokenList(text)
+	set := make(map[string]struct{}, len(tokens))
+	for _, w := range tokens {
+		set[w] = struct{}{}
+	}
+	return set
+}
+
+// similarity łączy współczynnik Jaccarda (kara za różną długość) ze współczynnikiem nakładania
+// (przeformułowanie z dodatkowymi słowami nadal jest duplikatem)
+func similarity(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	common := 0
+	for s := range a {
+		if _, ok := b[s]; ok {
+			common++
+		}
+	}
+	jaccard := float64(common) / float64(len(a)+len(b)-common)
+	overlap := float64(common) / float64(len(a))
+	return (jaccard + overlap) / 2
+}
+
+func flashcardSimilarityText(question, answer string) string {
+	return question + " " + answer
+}
+
+func quizSimilarityText(question string, options []string) string {
+	sorted := append([]string(nil), options...)
+	sort.Strings(sorted)
+	return question + " " + strings.Join(sorted, " ")
+}
+
+type indexedItem struct {
+	id     uint
+	tokens map[string]struct{}
+}
+
+// DuplicateIndex przechowuje znormalizowane słowa istniejących (oczekujących i zatwierdzonych) treści tematu.
+// Tworzony raz na partię zapisów; nowo zapisane elementy należy dodawać przez Add*.
+type DuplicateIndex struct {
+	flashcards []indexedItem
+	questions  []indexedItem
+}
+
+// NewDuplicateIndex wczytuje treści tematu, z którymi porównywane są nowe elementy
+func NewDuplicateIndex(repo *db.GormUserRepository, topicID uint) (*DuplicateIndex, error) {
+	flashcards, err := repo.GetActiveFlashcardsByTopic(topicID)
+	if err != nil {
+		return nil, err
+	}
+	questions, err := repo.GetActiveQuizQuestionsByTopic(topicID)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := &DuplicateIndex{}
+	for _, fc := range flashcards {
+		idx.AddFlashcard(&fc)
+	}
+	for _, q := range questions {
+		idx.AddQuizQuestion(&q)
+	}
+	return idx, nil
+}
+
+func bestMatch(items []indexedItem, s map[string]struct{}) (*uint, float64) {
+	var bestID *uint
+	best := 0.0
+	for i := range items {
+		if score := similarity(s, items[i].tokens); score > best {
+			best = score
+			bestID = &items[i].id
+		}
+	}
+	if best < DuplicateThreshold {
+		return nil, best
+	}
+	id := *bestID
+	return &id, best
+}
+
+// FlagFlashcard ustawia DuplicateOfID i DuplicateScore, jeśli fiszka jest podobna do istniejącej
+func (idx *DuplicateIndex) FlagFlashcard(fc *models.Flashcard) {
+	fc.DuplicateOfID, fc.DuplicateScore = bestMatch(idx.flashcards, similarityTokens(flashcardSimilarityText(fc.Question, fc.Answer)))
+	if fc.DuplicateOfID == nil {
+		fc.DuplicateScore = 0
+	}
+}
+
+// FlagQuizQuestion ustawia DuplicateOfID i DuplicateScore, jeśli pytanie jest podobne do istniejącego
+func (idx *DuplicateIndex) FlagQuizQuestion(q *models.QuizQuestion) {
+	q.DuplicateOfID, q.DuplicateScore = bestMatch(idx.questions, similarityTokens(quizSimilarityText(q.QuestionText, q.Options)))
+	if q.DuplicateOfID == nil {
+		q.DuplicateScore = 0
+	}
+}
+
+func (idx *DuplicateIndex) AddFlashcard(fc *models.Flashcard) {
+	idx.flashcards = append(idx.flashcards, indexedItem{id: fc.ID, tokens: similarityTokens(flashcardSimilarityText(fc.Question, fc.Answer))})
+}
+
+func (idx *DuplicateIndex) AddQuizQuestion(q *models.QuizQuestion) {
+	idx.questions = append(idx.questions, indexedItem{id: q.ID, tokens: similarityTokens(quizSimilarityText(q.QuestionText, q.Options))})
+}
+
+// CreateFlashcardChecked zapisuje fiszkę po sprawdzeniu podobieństwa z istniejącymi w temacie
+func CreateFlashcardChecked(idx *DuplicateIndex, fc *models.Flashcard) error {
+	idx.FlagFlashcard(fc)
+	if err := db.UserRepository.CreateFlashcard(fc); err != nil {
+		return err
+	}
+	idx.AddFlashcard(fc)
+	NotifyScreening()
+	return nil
+}
+
+// CreateQuizQuestionChecked zapisuje pytanie po sprawdzeniu podobieństwa z istniejącymi w temacie
+func CreateQuizQuestionChecked(idx *DuplicateIndex, q *models.QuizQuestion) error {
+	idx.FlagQuizQuestion(q)
+	if err := db.UserRepository.CreateQuizQuestion(q); err != nil {
+		return err
+	}
+	idx.AddQuizQuestion(q)
+	NotifyScreening()
+	return nil
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- TUTOR AI ---
+
+const (
+	tutorTask = "tutor"
+	// tutorMaxPassages i tutorContextChars ograniczają kontekst przekazywany modelowi w jednym pytaniu
+	tutorMaxPassages  = 8
+	tutorContextChars = 12000
+	// tutorPassageChars to maksymalna długość fragmentu podsumowania lub strony materiału
+	tutorPassageChars = 1500
+	// TutorHistoryMessages to liczba ostatnich wiadomości rozmowy dołączanych do zapytania
+	TutorHistoryMessages = 6
+	tutorHistoryChars    = 1000
+	// TutorMaxQuestionChars to maksymalna długość pytania użytkownika
+	TutorMaxQuestionChars = 2000
+	tutorTimeout          = 90 * time.Second
+)
+
+// TutorRefusal to odpowiedź tutora, gdy materiały tematu nie zawierają odpowiedzi na pytanie
+const TutorRefusal = "Nie znalazłem odpowiedzi na to pytanie w zatwierdzonych materiałach tego tematu, więc nie odpowiem, żeby nie wprowadzić Cię w błąd. Spróbuj zapytać inaczej albo poproś prowadzącego o uzupełnienie materiałów."
+
+// CitationSourceMaterial to typ cytowania strony materiału źródłowego
+const CitationSourceMaterial = "source_material"
+
+var tutorDailyTokens int64
+
+func InitTutor(cfg config.Config) {
+	tutorDailyTokens = cfg.TutorDailyTokens
+	log.Printf("Tutor AI: dzienny limit tokenów na użytkownika: %d (0 = bez osobnego limitu)", tutorDailyTokens)
+}
+
+// CheckTutorQuota sprawdza limity AI roli użytkownika oraz dzienny limit tokenów czatu z tutorem
+func CheckTutorQuota(userUsosID string) error {
+	if err := CheckAIQuota(userUsosID); err != nil {
+		return err
+	}
+	if tutorDailyTokens <= 0 {
+		return nil
+	}
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	used, err := db.UserRepository.SumAIUsageByTask(userUsosID, tutorTask, dayStart)
+	if err != nil {
+		return err
+	}
+	if used >= tutorDailyTokens {
+		return &QuotaExceededError{Period: "day", Kind: "tutor_tokens", Limit: tutorDailyTokens, Used: used, ResetsAt: dayStart.AddDate(0, 0, 1)}
+	}
+	return nil
+}
+
+// TutorPassage to fragment treści tematu, który może trafić do kontekstu tutora
+type TutorPassage struct {
+	Citation models.TutorCitation
+	Text     string
+}
+
+// TutorAnswer to odpowiedź tutora gotowa do zapisania jako wiadomość
+type TutorAnswer struct {
+	Content   string
+	Citations []models.TutorCitation
+	Refused   bool
+	Usage     LLMUsage
+}
+
+// CollectTutorPassages zbiera zatwierdzone fiszki, pytania, podsumowania tematu oraz strony
+// materiałów źródłowych, z których je wygenerowano
+func CollectTutorPassages(topicID uint) ([]TutorPassage, error) {
+	repo := db.UserRepository
+	var passages []TutorPassage
+	materialSet := make(map[int64]bool)
+	var materialIDs []int64
+	addMaterials := func(ids []int64) {
+		for _, id := range ids {
+			if !materialSet[id] {
+				materialSet[id] = true
+				materialIDs = append(materialIDs, id)
+			}
+		}
+	}
+
+	flashcards, err := repo.GetApprovedFlashcardsByTopic(topicID, db.ContentFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, fc := range flashcards {
+		passages = append(passages, TutorPassage{
+			Citation: models.TutorCitation{Type: models.SearchItemFlashcard, ID: fc.ID, Title: truncateRunes(fc.Question, 120)},
+			Text:     "Fiszka. Pytanie: " + fc.Question + "\nOdpowiedź: " + fc.Answer,
+		})
+		addMaterials(fc.SourceMaterialIDs)
+	}
+
+	questions, err := repo.GetApprovedQuizQuestionsByTopic(topicID, db.ContentFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if q.QuestionType == models.QuestionTypeOpen {
+			passages = append(passages, TutorPassage{
+				Citation: models.TutorCitation{Type: models.SearchItemQuizQuestion, ID: q.ID, Title: truncateRunes(q.QuestionText, 120)},
+				// Odpowiedź wzorcowa i kryteria oceny to klucz odpowiedzi - nie trafiają do kontekstu tutora
+				Text: "Pytanie otwarte: " + q.QuestionText,
+			})
+			addMaterials(q.SourceMaterialIDs)
+			continue
+		}
+		text := "Pytanie quizowe: " + q.QuestionText
+		if HasOptionExplanations(q.QuestionType) {
+			text += "\nOpcje: " + strings.Join(q.Options, " | ")
+		}
+		// Klucza odpowiedzi ani wyjaśnień opcji (zdradzających poprawność) nie podajemy - tutor mógłby
+		// je zacytować uczniowi, który jeszcze nie odpowiedział na pytanie
+		passages = append(passages, TutorPassage{
+			Citation: models.TutorCitation{Type: models.SearchItemQuizQuestion, ID: q.ID, Title: truncateRunes(q.QuestionText, 120)},
+			Text:     text,
+		})
+		addMaterials(q.SourceMaterialIDs)
+	}
+
+	notes, err := repo.GetApprovedTopicNotesByTopic(topicID)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range notes {
+		title := noteHeading(n.Body)
+		for _, part := range splitText(n.Body, tutorPassageChars) {
+			passages = append(passages, TutorPassage{
+				Citation: models.TutorCitation{Type: models.SearchItemTopicNote, ID: n.ID, Title: title},
+				Text:     "Podsumowanie tematu:\n" + part,
+			})
+		}
+		addMaterials(n.SourceMaterialIDs)
+	}
+
+	passages = append(passages, materialPassages(materialIDs)...)
+	return passages, nil
+}
+
+// materialPassages rozkłada materiały źródłowe na strony (pomija materiały, których nie da się odczytać)
+func materialPassages(ids []int64) []TutorPassage {
+	if Materials == nil {
+		return nil
+	}
+	materials, err := db.UserRepository.GetSourceMaterials(ids)
+	if err != nil {
+		log.Printf("Tutor AI: nie udało się wczytać materiałów: %v", err)
+		return nil
+	}
+	byID := make(map[int64]models.SourceMaterial, len(materials))
+	for _, m := range materials {
+		byID[int64(m.ID)] = m
+	}
+
+	var passages []TutorPassage
+	for _, id := range ids {
+		m, ok := byID[id]
+		if !ok {
+			continue
+		}
+		pages, err := Materials.PageTexts(&m, nil)
+		if err != nil {
+			log.Printf("Tutor AI: nie udało się odczytać materiału %d: %v", id, err)
+			continue
+		}
+		for _, p := range pages {
+			for _, part := range splitText(p.Text, tutorPassageChars) {
+				passages = append(passages, TutorPassage{
+					Citation: models.TutorCitation{Type: CitationSourceMaterial, ID: uint(id), Title: m.Name, Page: p.Number},
+					Text:     fmt.Sprintf("Materiał %s, strona %d:\n%s", m.Name, p.Number, part),
+				})
+			}
+		}
+	}
+	return passages
+}
+
+// noteHeading zwraca pierwszą niepustą linię podsumowania bez znaczników nagłówka Markdown
+func noteHeading(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		if line = strings.TrimSpace(strings.TrimLeft(line, "# ")); line != "" {
+			return truncateRunes(line, 120)
+		}
+	}
+	return "Podsumowanie"
+}
+
+// RankTutorPassages wybiera fragmenty najbardziej zbliżone do pytania (lokalny model słownictwa - bez zapytań
+// do API), mieszczące się w limicie kontekstu. Fragmenty bez wspólnych słów z pytaniem są pomijane.
+func RankTutorPassages(query string, passages []TutorPassage) []TutorPassage {
+	embedder := NewLocalEmbedder()
+	q := embedder.embed(query)
+
+	type scored struct {
+		passage TutorPassage
+		score   float64
+	}
+	ranked := make([]scored, 0, len(passages))
+	for _, p := range passages {
+		if s := dotProduct(q, embedder.embed(p.Text)); s > 0.05 {
+			ranked = append(ranked, scored{p, s})
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	var out []TutorPassage
+	size := 0
+	for _, r := range ranked {
+		if len(out) == tutorMaxPassages || size+len(r.passage.Text) > tutorContextChars {
+			break
+		}
+		out = append(out, r.passage)
+		size += len(r.passage.Text)
+	}
+	return out
+}
+
+// tutorReply to odpowiedź modelu: grounded = false oznacza, że fragmenty nie zawierają odpowiedzi
+type tutorReply struct {
+	Grounded  bool   `json:"grounded"`
+	Answer    string `json:"answer"`
+	Citations []int  `json:"citations"`
+}
+
+var tutorReplySchema = &jsonSchema{
+	Type:                 "object",
+	Required:             []string{"grounded", "answer", "citations"},
+	AdditionalProperties: true,
+	Properties: map[string]*jsonSchema{
+		"grounded":  {Type: "boolean"},
+		"answer":    {Type: "string"},
+		"citations": {Type: "array", Items: &jsonSchema{Type: "integer", Minimum: minimum(1)}},
+	},
+}
+
+func buildTutorPrompt(topic *models.Topic, passages []TutorPassage, history []models.TutorMessage, question string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Jesteś tutorem pomagającym studentowi zrozumieć temat „%s”", topic.Name)
+	if topic.Subject.Name != "" {
+		fmt.Fprintf(&sb, " z przedmiotu „%s”", topic.Subject.Name)
+	}
+	sb.WriteString(".\nOdpowiadasz WYŁĄCZNIE na podstawie ponumerowanych fragmentów materiałów poniżej. ")
+	sb.WriteString("Nie korzystaj z wiedzy spoza fragmentów. Jeśli fragmenty nie pozwalają odpowiedzieć na pytanie, ")
+	sb.WriteString("ustaw \"grounded\": false i nie odpowiadaj. Wyjaśniaj zrozumiale, odwołując się w tekście do fragmentów w postaci [n]. ")
+	sb.WriteString("Odpowiadaj w języku pytania.\n\n")
+	sb.WriteString("Zwróć TYLKO obiekt JSON: {\"grounded\": true/false, \"answer\": \"odpowiedź (Markdown)\", \"citations\": [numery wykorzystanych fragmentów]}\n\n")
+
+	sb.WriteString("Fragmenty materiałów:\n")
+	for i, p := range passages {
+		fmt.Fprintf(&sb, "[%d] %s\n\n", i+1, p.Text)
+	}
+
+	if len(history) > 0 {
+		sb.WriteString("Dotychczasowa rozmowa:\n")
+		for _, m := range history {
+			who := "Student"
+			if m.Role == models.TutorRoleAssistant {
+				who = "Tutor"
+			}
+			fmt.Fprintf(&sb, "%s: %s\n", who, truncateRunes(m.Content, tutorHistoryChars))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("Pytanie studenta:\n" + question)
+	return sb.String()
+}
+
+// AskTutor odpowiada na pytanie o temat na podstawie jego zatwierdzonych treści i materiałów.
+// Gdy materiały nie zawierają odpowiedzi (brak pasujących fragmentów, model zgłasza brak podstaw
+// lub nie wskazuje cytowań), zwraca odmowę TutorRefusal.
+func AskTutor(ctx context.Context, userUsosID string, topic *models.Topic, history []models.TutorMessage, question string) (*TutorAnswer, error) {
+	passages, err := CollectTutorPassages(topic.ID)
+	if err != nil {
+		return nil, err
+	}
+	// Pytania typu "dlaczego?" odnoszą się do poprzedniej wymiany, więc dokładamy ją do wyszukiwania
+	query := question
+	for i := len(history) - 1; i >= 0 && i >= len(history)-2; i-- {
+		query += "\n" + history[i].Content
+	}
+	passages = RankTutorPassages(query, passages)
+	if len(passages) == 0 {
+		return &TutorAnswer{Content: TutorRefusal, Refused: true}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tutorTimeout)
+	defer cancel()
+	ctx = WithAIUsageOwner(ctx, AIUsageOwner{UserUsosID: userUsosID, TopicID: topic.ID})
+
+	if len(history) > TutorHistoryMessages {
+		history = history[len(history)-TutorHistoryMessages:]
+	}
+	prompt := buildTutorPrompt(topic, passages, history, question)
+	req := LLMRequest{Task: tutorTask, Prompt: prompt, JSON: true}
+
+	var usage LLMUsage
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		resp, err := LLM.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		usage.PromptTokens += resp.Usage.PromptTokens
+		usage.CompletionTokens += resp.Usage.CompletionTokens
+		usage.TotalTokens += resp.Usage.TotalTokens
+
+		raw, err := extractJSONObject(resp.Text)
+		if err == nil {
+			if problems := validateJSON(tutorReplySchema, raw); len(problems) > 0 {
+				err = fmt.Errorf("niepoprawna odpowiedź: %s", strings.Join(problems, "; "))
+			}
+		}
+		if err != nil {
+			lastErr = err
+			req.Prompt = buildRegeneratePrompt(prompt, resp.Text, err)
+			continue
+		}
+
+		var reply tutorReply
+		if err := json.Unmarshal(raw, &reply); err != nil {
+			return nil, err
+		}
+		answer := tutorAnswerFrom(reply, passages)
+		answer.Usage = usage
+		return answer, nil
+	}
+	return nil, lastErr
+}
+
+// tutorAnswerFrom zamienia numery fragmentów na cytowania; odpowiedź bez poprawnych cytowań jest odmową
+func tutorAnswerFrom(reply tutorReply, passages []TutorPassage) *TutorAnswer {
+	seen := make(map[models.TutorCitation]bool)
+	var citations []models.TutorCitation
+	for _, n := range reply.Citations {
+		if n < 1 || n > len(passages) {
+			continue
+		}
+		c := passages[n-1].Citation
+		if !seen[c] {
+			seen[c] = true
+			citations = append(citations, c)
+		}
+	}
+
+	answer := strings.TrimSpace(reply.Answer)
+	if !reply.Grounded || len(citations) == 0 || answer == "" {
+		return &TutorAnswer{Content: TutorRefusal, Refused: true}
+	}
+	return &TutorAnswer{Content: answer, Citations: citations}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skni-kod/InfQuizyTor/Server/config"
+	"github.com/skni-kod/InfQuizyTor/Server/db"
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// --- ZUŻYCIE AI I LIMITY ---
+
+type aiUsageOwnerKey struct{}
+
+// AIUsageOwner wskazuje, komu przypisać zużycie zapytań wykonanych w danym kontekście
+type AIUsageOwner struct {
+	UserUsosID string // pusty dla zadań systemowych
+	TopicID    uint
+}
+
+// WithAIUsageOwner dołącza do kontekstu właściciela zużycia AI
+func WithAIUsageOwner(ctx context.Context, owner AIUsageOwner) context.Context {
+	return context.WithValue(ctx, aiUsageOwnerKey{}, owner)
+}
+
+func aiUsageOwnerFrom(ctx context.Context) AIUsageOwner {
+	owner, _ := ctx.Value(aiUsageOwnerKey{}).(AIUsageOwner)
+	return owner
+}
+
+// MeteredProvider zapisuje zużycie tokenów każdego zapytania w tabeli ai_usage - także nieudanego
+// lub przerwanego, bo dostawca mógł już przetworzyć prompt i część odpowiedzi
+type MeteredProvider struct {
+	Inner    LLMProvider
+	UserRepo *db.GormUserRepository
+}
+
+func (p *MeteredProvider) Name() string  { return p.Inner.Name() }
+func (p *MeteredProvider) Model() string { return p.Inner.Model() }
+
+func (p *MeteredProvider) Generate(ctx context.Context, req LLMRequest) (*LLMResponse, error) {
+	resp, err := p.Inner.Generate(ctx, req)
+	if err != nil {
+		p.recordFailed(ctx, req, 0)
+		return nil, err
+	}
+	p.record(ctx, req, resp)
+	return resp, nil
+}
+
+func (p *MeteredProvider) GenerateStream(ctx context.Context, req LLMRequest, onChunk func(string) error) (*LLMResponse, error) {
+	streamer, ok := p.Inner.(LLMStreamer)
+	if !ok {
+		resp, err := p.Generate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if err := onChunk(resp.Text); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	received := 0
+	resp, err := streamer.GenerateStream(ctx, req, func(text string) error {
+		received += len(text)
+		return onChunk(text)
+	})
+	if err != nil {
+		p.recordFailed(ctx, req, received)
+		return nil, err
+	}
+	p.record(ctx, req, resp)
+	return resp, nil
+}
+
+func (p *MeteredProvider) record(ctx context.Context, req LLMRequest, resp *LLMResponse) {
+	recordAIUsage(ctx, p.UserRepo, req.Task, p.Inner.Name(), resp.Model, resp.Usage)
+}
+
+// recordFailed zapisuje zapytanie zakończone błędem. Dostawca nie podaje wtedy zużycia, więc szacujemy je:
+// prompt liczymy, gdy model zaczął odpowiadać albo zapytanie przerwano (mógł zostać przetworzony),
+// a odpowiedź - według długości odebranej części.
+func (p *MeteredProvider) recordFailed(ctx context.Context, req LLMRequest, received int) {
+	var u LLMUsage
+	if received > 0 || ctx.Err() != nil {
+		u.PromptTokens = estimateTokens(len(req.Prompt))
+	}
+	if received > 0 {
+		u.CompletionTokens = estimateTokens(received)
+	}
+	u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	recordAIUsage(ctx, p.UserRepo, req.Task, p.Inner.Name(), p.Inner.Model(), u)
+}
+
+// estimateTokens szacuje liczbę tokenów tekstu o podanej długości w bajtach
+func estimateTokens(n int) int {
+	return n/4 + 1
+}
+
+// recordAIUsage zapisuje zużycie jednego zapytania, przypisując je właścicielowi z kontekstu
+func recordAIUsage(ctx context.Context, repo *db.GormUserRepository, task, provider, model string, u LLMUsage) {
+	if repo == nil {
+		return
+	}
+	owner := aiUsageOwnerFrom(ctx)
+	usage := &models.AIUsage{
+		UserUsosID:       owner.UserUsosID,
+		Task:             task,
+		Provider:         provider,
+		Model:            model,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if owner.TopicID != 0 {
+		topicID := owner.TopicID
+		usage.TopicID = &topicID
+		if topic, err := repo.GetTopicByID(topicID); err == nil {
+			usage.SubjectID = &topic.SubjectID
+		}
+	}
+	if err := repo.CreateAIUsage(usage); err != nil {
+		log.Printf("Błąd zapisu zużycia AI: %v", err)
+	}
+}
+
+// MeteredEmbedder zapisuje zużycie każdego udanego zapytania o embeddingi (zadanie "embedding").
+// Dostawcy nie zwracają jednolicie liczby tokenów, więc szacujemy ją z długości tekstów.
+type MeteredEmbedder struct {
+	Inner    Embedder
+	UserRepo *db.GormUserRepository
+}
+
+func (e *MeteredEmbedder) EmbeddingModel() string { return e.Inner.EmbeddingModel() }
+
+func (e *MeteredEmbedder) Embed(ctx context.Context, texts []string, query bool) ([][]float32, error) {
+	vectors, err := e.Inner.Embed(ctx, texts, query)
+	if err != nil {
+		return nil, err
+	}
+	tokens := 0
+	for _, t := range texts {
+		tokens += estimateTokens(len(t))
+	}
+	provider, model, _ := strings.Cut(e.Inner.EmbeddingModel(), "/")
+	recordAIUsage(ctx, e.UserRepo, "embedding", provider, model, LLMUsage{PromptTokens: tokens, TotalTokens: tokens})
+	return vectors, nil
+}
+
+// AIQuota to limity zużycia AI dla roli; 0 oznacza brak limitu
+type AIQuota struct {
+	DailyRequests   int64 `json:"daily_requests"`
+	DailyTokens     int64 `json:"daily_tokens"`
+	MonthlyRequests int64 `json:"monthly_requests"`
+	MonthlyTokens   int64 `json:"monthly_tokens"`
+}
+
+var aiQuotas = map[string]AIQuota{}
+
+// defaultQuotaRole to rola, której limity obowiązują role nieopisane w AI_QUOTAS
+const defaultQuotaRole = "student"
+
+func InitAIQuotas(cfg config.Config) {
+	quotas, err := ParseAIQuotas(cfg.AIQuotas)
+	if err != nil {
+		log.Fatalf("Błędna konfiguracja AI_QUOTAS: %v", err)
+	}
+	aiQuotas = quotas
+	log.Printf("Limity AI: %+v", aiQuotas)
+}
+
+// ParseAIQuotas odczytuje specyfikację w formacie
+// "rola:klucz=wartość,klucz=wartość;rola2:unlimited"
+func ParseAIQuotas(spec string) (map[string]AIQuota, error) {
+	quotas := make(map[string]AIQuota)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, limits, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("brak ':' we wpisie %q", entry)
+		}
+		role = strings.TrimSpace(role)
+
+		var q AIQuota
+		limits = strings.TrimSpace(limits)
+		if limits != "unlimited" {
+			for _, kv := range strings.Split(limits, ",") {
+				key, value, ok := strings.Cut(strings.TrimSpace(kv), "=")
+				if !ok {
+					return nil, fmt.Errorf("niepoprawny limit %q dla roli %s", kv, role)
+				}
+				n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("niepoprawna wartość limitu %q dla roli %s", kv, role)
+				}
+				switch strings.TrimSpace(key) {
+				case "daily_requests":
+					q.DailyRequests = n
+				case "daily_tokens":
+					q.DailyTokens = n
+				case "monthly_requests":
+					q.MonthlyRequests = n
+				case "monthly_tokens":
+					q.MonthlyTokens = n
+				default:
+					return nil, fmt.Errorf("nieznany limit %q dla roli %s", key, role)
+				}
+			}
+		}
+		quotas[role] = q
+	}
+	return quotas, nil
+}
+
+// QuotaForRole zwraca limity roli (lub limity domyślne dla ról nieopisanych w konfiguracji)
+func QuotaForRole(role string) AIQuota {
+	if q, ok := aiQuotas[role]; ok {
+		return q
+	}
+	return aiQuotas[defaultQuotaRole]
+}
+
+// QuotaExceededError oznacza wyczerpanie limitu zużycia AI
+type QuotaExceededError struct {
+	Period   string // "day" lub "month"
+	Kind     string // "requests", "tokens" lub "tutor_tokens"
+	Limit    int64
+	Used     int64
+	ResetsAt time.Time
+}
+
+func (e *QuotaExceededError) Error() string {
+	period := "dzienny"
+	if e.Period == "month" {
+		period = "miesięczny"
+	}
+	kind := "zapytań"
+	switch e.Kind {
+	case "tokens":
+		kind = "tokenów"
+	case "tutor_tokens":
+		kind = "tokenów czatu z tutorem"
+	}
+	return fmt.Sprintf("Wykorzystano %s limit %s AI (%d/%d). Limit odnowi się %s.",
+		period, kind, e.Used, e.Limit, e.ResetsAt.Format("2006-01-02 15:04"))
+}
+
+// AIUsagePeriod to zużycie w jednym okresie rozliczeniowym
+type AIUsagePeriod struct {
+	Since    time.Time `json:"since"`
+	ResetsAt time.Time `json:"resets_at"`
+	Requests int64     `json:"requests"`
+	Tokens   int64     `json:"tokens"`
+}
+
+// AIUsageSummary to zużycie użytkownika w bieżącym dniu i miesiącu wraz z limitami jego roli
+type AIUsageSummary struct {
+	Role  string        `json:"role"`
+	Quota AIQuota       `json:"quota"`
+	Today AIUsagePeriod `json:"today"`
+	Month AIUsagePeriod `json:"month"`
+}
+
+// GetAIUsageSummary zlicza zużycie użytkownika w bieżącym dniu i miesiącu
+func GetAIUsageSummary(userUsosID string) (*AIUsageSummary, error) {
+	user, err := db.UserRepository.GetUserByUsosID(userUsosID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+
+	summary := &AIUsageSummary{
+		Role:  user.Role,
+		Quota: QuotaForRole(user.Role),
+		Today: AIUsagePeriod{Since: dayStart, ResetsAt: dayStart.AddDate(0, 0, 1)},
+		Month: AIUsagePeriod{Since: monthStart, ResetsAt: monthStart.AddDate(0, 1, 0)},
+	}
+	if summary.Today.Requests, summary.Today.Tokens, err = db.UserRepository.SumAIUsage(userUsosID, dayStart); err != nil {
+		return nil, err
+	}
+	if summary.Month.Requests, summary.Month.Tokens, err = db.UserRepository.SumAIUsage(userUsosID, monthStart); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// CheckAIQuota zwraca *QuotaExceededError, jeśli użytkownik wyczerpał któryś z limitów swojej roli
+func CheckAIQuota(userUsosID string) error {
+	s, err := GetAIUsageSummary(userUsosID)
+	if err != nil {
+		return err
+	}
+
+	checks := []struct {
+		period, kind string
+		limit, used  int64
+		resetsAt     time.Time
+	}{
+		{"day", "requests", s.Quota.DailyRequests, s.Today.Requests, s.Today.ResetsAt},
+		{"day", "tokens", s.Quota.DailyTokens, s.Today.Tokens, s.Today.ResetsAt},
+		{"month", "requests", s.Quota.MonthlyRequests, s.Month.Requests, s.Month.ResetsAt},
+		{"month", "tokens", s.Quota.MonthlyTokens, s.Month.Tokens, s.Month.ResetsAt},
+	}
+	for _, c := range checks {
+		if c.limit > 0 && c.used >= c.limit {
+			return &QuotaExceededError{Period: c.period, Kind: c.kind, Limit: c.limit, Used: c.used, ResetsAt: c.resetsAt}
+		}
+	}
+	return nil
+}
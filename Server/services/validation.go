@@ -0,0 +1,347 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skni-kod/InfQuizyTor/Server/models"
+)
+
+// jsonSchema to podzbiór JSON Schema wystarczający do opisania odpowiedzi modelu
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties bool                   `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	MinItems             int                    `json:"minItems,omitempty"`
+	MaxItems             int                    `json:"maxItems,omitempty"`
+	MinLength            int                    `json:"minLength,omitempty"`
+	MaxLength            int                    `json:"maxLength,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Maximum              *float64               `json:"maximum,omitempty"`
+	UniqueItems          bool                   `json:"uniqueItems,omitempty"`
+}
+
+func minimum(v float64) *float64 { return &v }
+func maximum(v float64) *float64 { return &v }
+
+// sourceProperties to opcjonalne pola źródła dodawane przez model do każdego elementu
+var sourceProperties = map[string]*jsonSchema{
+	"file":  {Type: "string"},
+	"pages": {Type: "array", Items: &jsonSchema{Type: "integer", Minimum: minimum(1)}},
+}
+
+func withSource(props map[string]*jsonSchema) map[string]*jsonSchema {
+	for k, v := range sourceProperties {
+		props[k] = v
+	}
+	return props
+}
+
+// generationItemSchemas opisuje pojedynczy element odpowiedzi dla każdego typu generowania
+var generationItemSchemas = map[string]*jsonSchema{
+	"flashcards": {
+		Type:     "object",
+		Required: []string{"question", "answer"},
+		Properties: withSource(map[string]*jsonSchema{
+			"question": {Type: "string", MinLength: 3, MaxLength: 2000},
+			"answer":   {Type: "string", MinLength: 1, MaxLength: 5000},
+			"bloom":    {Type: "string"},
+		}),
+	},
+	// Pola wymagane przez poszczególne typy pytań sprawdza ValidateGeneratedItem (NormalizeQuizSnapshot)
+	"quiz": {
+		Type:     "object",
+		Required: []string{"question"},
+		Properties: withSource(map[string]*jsonSchema{
+			"type":            {Type: "string", MinLength: 1, MaxLength: 30},
+			"question":        {Type: "string", MinLength: 3, MaxLength: 2000},
+			"options":         {Type: "array", MinItems: 2, MaxItems: MaxOrderingItems, UniqueItems: true, Items: &jsonSchema{Type: "string", MinLength: 1, MaxLength: 1000}},
+			"correctIndex":    {Type: "integer", Minimum: minimum(0)},
+			"correctIndices":  {Type: "array", MinItems: 1, MaxItems: 8, UniqueItems: true, Items: &jsonSchema{Type: "integer", Minimum: minimum(0)}},
+			"explanations":    {Type: "array", MaxItems: 8, Items: &jsonSchema{Type: "string", MaxLength: MaxExplanationChars}},
+			"correct":         {Type: "boolean"},
+			"value":           {Type: "number"},
+			"tolerance":       {Type: "number", Minimum: minimum(0)},
+			"unit":            {Type: "string", MaxLength: maxUnitChars},
+			"blanks":          {Type: "array", MinItems: 1, MaxItems: MaxClozeBlanks, Items: &jsonSchema{Type: "array", MinItems: 1, MaxItems: MaxClozeAnswers, Items: &jsonSchema{Type: "string", MinLength: 1, MaxLength: maxClozeAnswerChars}}},
+			"referenceAnswer": {Type: "string", MinLength: 1, MaxLength: MaxReferenceAnswerChars},
+			"bloom":           {Type: "string"},
+			"rubric": {Type: "array", MinItems: 1, Items: &jsonSchema{
+				Type:       "object",
+				Required:   []string{"text", "points"},
+				Properties: map[string]*jsonSchema{"text": {Type: "string", MinLength: 1}, "points": {Type: "integer", Minimum: minimum(1)}},
+			}},
+		}),
+	},
+	"summary": {
+		Type:     "object",
+		Required: []string{"summary"},
+		Properties: withSource(map[string]*jsonSchema{
+			"summary": {Type: "string", MinLength: 20, MaxLength: 50000},
+		}),
+	},
+}
+
+// validate sprawdza wartość (zdekodowaną do interface{}) ze schematem i zwraca listę problemów
+func (s *jsonSchema) validate(path string, v interface{}) []string {
+	var errs []string
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fail("oczekiwano obiektu")
+			return errs
+		}
+		for _, key := range s.Required {
+			if _, ok := obj[key]; !ok {
+				fail("brak wymaganego pola %q", key)
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := s.Properties[k]
+			if !ok {
+				if !s.AdditionalProperties {
+					fail("nieoczekiwane pole %q", k)
+				}
+				continue
+			}
+			errs = append(errs, prop.validate(path+"."+k, obj[k])...)
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			fail("oczekiwano tablicy")
+			return errs
+		}
+		if s.MinItems > 0 && len(arr) < s.MinItems {
+			fail("za mało elementów (%d, minimum %d)", len(arr), s.MinItems)
+		}
+		if s.MaxItems > 0 && len(arr) > s.MaxItems {
+			fail("za dużo elementów (%d, maksimum %d)", len(arr), s.MaxItems)
+		}
+		seen := make(map[string]bool)
+		for i, item := range arr {
+			if s.Items != nil {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+			if s.UniqueItems {
+				key := strings.ToLower(strings.TrimSpace(fmt.Sprint(item)))
+				if seen[key] {
+					fail("powtórzony element %q", fmt.Sprint(item))
+				}
+				seen[key] = true
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("oczekiwano tekstu")
+			return errs
+		}
+		length := len([]rune(strings.TrimSpace(str)))
+		if length < s.MinLength {
+			if length == 0 {
+				fail("pole jest puste")
+			} else {
+				fail("tekst jest za krótki (minimum %d znaków)", s.MinLength)
+			}
+		}
+		if s.MaxLength > 0 && length > s.MaxLength {
+			fail("tekst jest za długi (maksimum %d znaków)", s.MaxLength)
+		}
+	case "integer", "number":
+		num, ok := v.(float64)
+		if !ok {
+			fail("oczekiwano liczby")
+			return errs
+		}
+		if s.Type == "integer" && num != float64(int64(num)) {
+			fail("oczekiwano liczby całkowitej")
+			return errs
+		}
+		if s.Minimum != nil && num < *s.Minimum {
+			fail("wartość %v jest mniejsza niż %v", num, *s.Minimum)
+		}
+		if s.Maximum != nil && num > *s.Maximum {
+			fail("wartość %v jest większa niż %v", num, *s.Maximum)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("oczekiwano wartości logicznej")
+		}
+	}
+	return errs
+}
+
+// InvalidItem to element odrzucony przez walidację wraz z powodami
+type InvalidItem struct {
+	Raw     json.RawMessage
+	Reasons []string
+}
+
+// ValidateGeneratedItem sprawdza pojedynczy element wygenerowany przez model.
+// Poza schematem sprawdza zależności między polami (np. zakres correctIndex, pola wymagane przez typ pytania).
+func ValidateGeneratedItem(genType string, raw json.RawMessage) []string {
+	schema, ok := generationItemSchemas[genType]
+	if !ok {
+		return []string{fmt.Sprintf("nieznany typ generowania %q", genType)}
+	}
+
+	var value interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if err := dec.Decode(&value); err != nil {
+		return []string{"niepoprawny JSON: " + err.Error()}
+	}
+
+	errs := schema.validate("$", value)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	if genType == "quiz" {
+		var q models.GeneratedQuizQuestion
+		if err := json.Unmarshal(raw, &q); err != nil {
+			return []string{"niepoprawny JSON: " + err.Error()}
+		}
+		questionType, snapshot := GeneratedQuizSnapshot(q)
+		if _, err := NormalizeQuizSnapshot(questionType, snapshot); err != nil {
+			errs = append(errs, fmt.Sprintf("$ (%s): %v", questionType, err))
+		}
+		if len(q.Explanations) > 0 && HasOptionExplanations(questionType) && len(q.Explanations) != len(q.Options) {
+			errs = append(errs, fmt.Sprintf("$.explanations: liczba wyjaśnień (%d) różni się od liczby opcji (%d)", len(q.Explanations), len(q.Options)))
+		}
+	}
+	return errs
+}
+
+// CheckGeneratedItemParams sprawdza, czy poprawny element zgadza się z parametrami zlecenia:
+// typ pytania musi być jednym z zamówionych (domyślnie tylko jednokrotnego wyboru), a pytanie wyboru
+// musi mieć zamówioną liczbę opcji
+func CheckGeneratedItemParams(genType string, params models.GenerationParams, raw json.RawMessage) []string {
+	if genType != "quiz" {
+		return nil
+	}
+	var q models.GeneratedQuizQuestion
+	if err := json.Unmarshal(raw, &q); err != nil {
+		return []string{"niepoprawny JSON: " + err.Error()}
+	}
+	questionType, _ := GeneratedQuizSnapshot(q)
+	if allowed := allowedQuestionTypes(params); !containsString(allowed, questionType) {
+		return []string{fmt.Sprintf("$.type: typ pytania %q nie był zamówiony (dozwolone: %s)", questionType, strings.Join(allowed, ", "))}
+	}
+	isChoice := questionType == models.QuestionTypeSingleChoice || questionType == models.QuestionTypeMultipleChoice
+	if isChoice && params.OptionCount > 0 && len(q.Options) != params.OptionCount {
+		return []string{fmt.Sprintf("$.options: oczekiwano %d opcji, jest %d", params.OptionCount, len(q.Options))}
+	}
+	return nil
+}
+
+// allowedQuestionTypes zwraca typy pytań zamówione w parametrach generowania
+func allowedQuestionTypes(params models.GenerationParams) []string {
+	if len(params.QuestionTypes) == 0 {
+		return []string{models.QuestionTypeSingleChoice}
+	}
+	return params.QuestionTypes
+}
+
+// ExtractGeneratedItems wyodrębnia elementy z odpowiedzi modelu. Dla tablic korzysta z JSONArrayStream,
+// dzięki czemu odzyskuje poprawne elementy także z uciętej lub częściowo błędnej odpowiedzi.
+// Zwraca błąd, jeśli nie udało się odczytać żadnego elementu.
+func ExtractGeneratedItems(genType, text string) ([]json.RawMessage, error) {
+	if genType == "summary" {
+		raw, err := extractJSONObject(text)
+		if err != nil {
+			return nil, err
+		}
+		return []json.RawMessage{raw}, nil
+	}
+
+	if !strings.Contains(text, "[") {
+		return nil, fmt.Errorf("odpowiedź nie zawiera tablicy JSON")
+	}
+	stream := &JSONArrayStream{}
+	items := stream.Write(text)
+	if len(items) == 0 {
+		return nil, fmt.Errorf("odpowiedź nie zawiera żadnego kompletnego elementu")
+	}
+	return items, nil
+}
+
+// extractJSONObject wycina z odpowiedzi modelu obiekt JSON (od pierwszego "{" do ostatniego "}")
+func extractJSONObject(text string) (json.RawMessage, error) {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("odpowiedź nie zawiera obiektu JSON")
+	}
+	raw := json.RawMessage(text[start : end+1])
+	if !json.Valid(raw) {
+		return nil, fmt.Errorf("odpowiedź nie jest poprawnym obiektem JSON")
+	}
+	return raw, nil
+}
+
+// validateJSON sprawdza surowy JSON ze schematem
+func validateJSON(schema *jsonSchema, raw json.RawMessage) []string {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return []string{"niepoprawny JSON: " + err.Error()}
+	}
+	return schema.validate("$", value)
+}
+
+// buildRepairPrompt prosi model o poprawienie odrzuconych elementów
+func buildRepairPrompt(genType string, invalid []InvalidItem) string {
+	schema, _ := json.MarshalIndent(generationItemSchemas[genType], "", "  ")
+
+	var sb strings.Builder
+	sb.WriteString("Poniższe elementy wygenerowane wcześniej nie przeszły walidacji. Popraw je, zachowując ich sens. ")
+	if genType == "summary" {
+		sb.WriteString("Zwróć TYLKO poprawiony obiekt JSON spełniający schemat:\n")
+	} else {
+		sb.WriteString("Zwróć TYLKO tablicę JSON z poprawionymi elementami (w tej samej kolejności), z których każdy spełnia schemat:\n")
+	}
+	sb.Write(schema)
+	sb.WriteString("\n\n")
+	for i, item := range invalid {
+		fmt.Fprintf(&sb, "Element %d:\n%s\nBłędy:\n- %s\n\n", i+1, string(item.Raw), strings.Join(item.Reasons, "\n- "))
+	}
+	return sb.String()
+}
+
+// buildRegeneratePrompt ponawia pełne zapytanie, gdy odpowiedź nie dała się w ogóle odczytać
+func buildRegeneratePrompt(originalPrompt, badOutput string, parseErr error) string {
+	const maxEcho = 2000
+	if len(badOutput) > maxEcho {
+		badOutput = badOutput[:maxEcho] + "..."
+	}
+	return fmt.Sprintf("%s\n\nTwoja poprzednia odpowiedź była niepoprawna (%v):\n%s\n\nOdpowiedz ponownie, zwracając WYŁĄCZNIE poprawny JSON w wymaganym formacie.",
+		originalPrompt, parseErr, badOutput)
+}
+
+// describeRejected zamienia odrzucone elementy na czytelne komunikaty dla użytkownika
+func describeRejected(invalid []InvalidItem) []string {
+	out := make([]string, 0, len(invalid))
+	for _, item := range invalid {
+		preview := string(item.Raw)
+		if len([]rune(preview)) > 120 {
+			preview = string([]rune(preview)[:120]) + "..."
+		}
+		out = append(out, fmt.Sprintf("%s → %s", preview, strings.Join(item.Reasons, "; ")))
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestValidateGeneratedItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		genType string
+		raw     string
+		ok      bool
+	}{
+		{"fiszka", "flashcards", `{"question":"Co to jest?","answer":"To"}`, true},
+		{"fiszka bez odpowiedzi", "flashcards", `{"question":"Co to jest?"}`, false},
+		{"fiszka z za krótkim pytaniem", "flashcards", `{"question":"Co","answer":"To"}`, false},
+		{"fiszka z nieznanym poziomem Blooma", "flashcards", `{"question":"Co to jest?","answer":"To","bloom":"memorize"}`, true},
+		{"jednokrotny wybór", "quiz", `{"question":"Ile to 2+2?","options":["3","4"],"correctIndex":1}`, true},
+		{"jednokrotny wybór: indeks poza zakresem", "quiz", `{"question":"Ile to 2+2?","options":["3","4"],"correctIndex":2}`, false},
+		{"jednokrotny wybór: powtórzone opcje", "quiz", `{"question":"Ile to 2+2?","options":["4","4"],"correctIndex":0}`, false},
+		{"jednokrotny wybór: zła liczba wyjaśnień", "quiz", `{"question":"Ile to 2+2?","options":["3","4"],"correctIndex":1,"explanations":["a"]}`, false},
+		{"wielokrotny wybór", "quiz", `{"type":"multiple_choice","question":"Które są parzyste?","options":["1","2","4"],"correctIndices":[1,2]}`, true},
+		{"wielokrotny wybór bez odpowiedzi", "quiz", `{"type":"multiple_choice","question":"Które są parzyste?","options":["1","2","4"]}`, false},
+		{"prawda/fałsz", "quiz", `{"type":"true_false","question":"Czy 2 jest parzyste?","correct":true}`, true},
+		{"prawda/fałsz bez odpowiedzi", "quiz", `{"type":"true_false","question":"Czy 2 jest parzyste?"}`, false},
+		{"liczbowe", "quiz", `{"type":"numeric","question":"Ile metrów ma kilometr?","value":1000,"unit":"m"}`, true},
+		{"liczbowe bez wartości", "quiz", `{"type":"numeric","question":"Ile metrów ma kilometr?"}`, false},
+		{"liczbowe z ujemną tolerancją", "quiz", `{"type":"numeric","question":"Ile metrów ma kilometr?","value":1000,"tolerance":-1}`, false},
+		{"kolejność", "quiz", `{"type":"ordering","question":"Uporządkuj kroki","options":["a","b","c"]}`, true},
+		{"luki", "quiz", `{"type":"cloze","question":"Stolicą Polski jest {{1}}","blanks":[["Warszawa"]]}`, true},
+		{"luki: liczba luk różna od znaczników", "quiz", `{"type":"cloze","question":"Stolicą Polski jest {{1}}","blanks":[["Warszawa"],["Kraków"]]}`, false},
+		{"otwarte", "quiz", `{"type":"open","question":"Opisz algorytm Dijkstry","referenceAnswer":"Algorytm...","rubric":[{"text":"Kolejka","points":1}]}`, true},
+		{"otwarte bez kryteriów", "quiz", `{"type":"open","question":"Opisz algorytm Dijkstry","referenceAnswer":"Algorytm..."}`, false},
+		{"nieznany typ pytania", "quiz", `{"type":"essay","question":"Opisz algorytm"}`, false},
+		{"podsumowanie", "summary", `{"summary":"Podsumowanie materiału o długości ponad dwudziestu znaków."}`, true},
+		{"za krótkie podsumowanie", "summary", `{"summary":"Krótko"}`, false},
+		{"nieznany typ generowania", "notes", `{}`, false},
+		{"niepoprawny JSON", "flashcards", `{"question":`, false},
+		{"tablica zamiast obiektu", "flashcards", `["a"]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidateGeneratedItem(tt.genType, json.RawMessage(tt.raw))
+			if (len(errs) == 0) != tt.ok {
+				t.Errorf("błędy %q, oczekiwano ok=%v", errs, tt.ok)
+			}
+		})
+	}
+}